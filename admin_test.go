@@ -1,7 +1,10 @@
 package admin_test
 
 import (
+	"context"
+	"encoding/json"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/go-packs/go-admin"
@@ -10,6 +13,12 @@ import (
 	"gorm.io/gorm"
 )
 
+// Error shares its name with the error schema of the OpenAPI document.
+type Error struct {
+	ID   uint `gorm:"primaryKey"`
+	Code string
+}
+
 type TestModel struct {
 	ID   uint `gorm:"primaryKey"`
 	Name string
@@ -62,7 +71,7 @@ func TestCore(t *testing.T) {
 			t.Fatalf("create item: %v", err)
 		}
 
-		fetched, err := internal.Get(reg, "TestModel", item.ID)
+		fetched, err := internal.Get(context.Background(), reg, "TestModel", item.ID, nil)
 		if err != nil {
 			t.Fatalf("get item: %v", err)
 		}
@@ -74,7 +83,7 @@ func TestCore(t *testing.T) {
 		if err := internal.Update(reg, item); err != nil {
 			t.Fatalf("update item: %v", err)
 		}
-		fetched, err = internal.Get(reg, "TestModel", item.ID)
+		fetched, err = internal.Get(context.Background(), reg, "TestModel", item.ID, nil)
 		if err != nil {
 			t.Fatalf("get item: %v", err)
 		}
@@ -82,7 +91,7 @@ func TestCore(t *testing.T) {
 			t.Error("Update failed")
 		}
 
-		if err := internal.Delete(reg, "TestModel", item.ID); err != nil {
+		if err := internal.Delete(context.Background(), reg, "TestModel", item.ID, nil); err != nil {
 			t.Fatalf("delete item: %v", err)
 		}
 		list, err := internal.List(reg, "TestModel")
@@ -111,4 +120,44 @@ func TestCore(t *testing.T) {
 			t.Error("Config load failed")
 		}
 	})
+
+	t.Run("ValidatePermission", func(t *testing.T) {
+		reg.Register(TestModel{}).RegisterField("Name", "Name", false)
+		if err := reg.ValidatePermission(&admin.Permission{ResourceName: "TestModel", FieldName: "Name", Action: "hide"}); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		if err := reg.ValidatePermission(&admin.Permission{ResourceName: "TestModel", FieldName: "Nope", Action: "hide"}); err == nil {
+			t.Error("Expected an error for an unknown field")
+		}
+		if err := (admin.Permission{FieldName: "Name", Action: "delete"}).Validate(); err == nil {
+			t.Error("Field rules should only allow hide or readonly")
+		}
+	})
+
+	t.Run("OpenAPI", func(t *testing.T) {
+		reg.Register(TestModel{}).
+			RegisterField("ID", "ID", true).
+			RegisterField("Name", "Name", false).
+			SetFieldType("Name", "select", "a", "b").
+			AddScope("recent", "Recent", func(db *gorm.DB) *gorm.DB { return db })
+		doc := internal.OpenAPI(reg, nil)
+		if doc["openapi"] != "3.1.0" {
+			t.Errorf("Unexpected version %v", doc["openapi"])
+		}
+		raw, err := json.Marshal(doc)
+		if err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+		body := string(raw)
+		for _, want := range []string{`"/admin/api/TestModel/{id}"`, `"enum":["a","b"]`, `"readOnly":true`, `"name":"scope"`, `"name":"q_Name"`} {
+			if !strings.Contains(body, want) {
+				t.Errorf("OpenAPI document missing %s", want)
+			}
+		}
+		reg.Register(Error{}).RegisterField("ID", "ID", true).RegisterField("Code", "Code", false)
+		schemas := internal.OpenAPI(reg, nil)["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+		if _, ok := schemas["Error"].(map[string]interface{})["properties"].(map[string]interface{})["Code"]; !ok || schemas["admin.Error"] == nil {
+			t.Error("A resource named Error should not replace the error schema")
+		}
+	})
 }
@@ -1,9 +1,16 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
 	"strings"
+
+	"github.com/go-packs/go-admin"
+	"github.com/go-packs/go-admin/internal"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
 )
 
 const helpText = `Go Admin CLI - Scaffolding Tool
@@ -11,6 +18,13 @@ const helpText = `Go Admin CLI - Scaffolding Tool
 Usage:
   go-admin init              Scaffold a new admin project
   go-admin generate <name>   Generate boilerplate for a resource
+  go-admin verify-audit      Verify the audit log hash chain
+  go-admin prune-audit       Apply the audit retention policy now
+                             (-config admin.yml, -days and -archive override it)
+  go-admin import-audit <file.jsonl.gz>...
+                             Load audit archives, e.g. into a scratch database
+
+  The audit commands take -db, the SQLite database path (default admin.db).
 `
 
 const mainTemplate = `package main
@@ -56,6 +70,12 @@ func main() {
 			return
 		}
 		handleGenerate(os.Args[2])
+	case "verify-audit":
+		os.Exit(handleVerifyAudit(os.Args[2:]))
+	case "prune-audit":
+		os.Exit(handlePruneAudit(os.Args[2:]))
+	case "import-audit":
+		os.Exit(handleImportAudit(os.Args[2:]))
 	default:
 		fmt.Print(helpText)
 	}
@@ -90,3 +110,119 @@ adm.Register(%s{}).
 	fmt.Println("-------------------")
 	fmt.Println("Copy the above code into your main.go registration block.")
 }
+
+// handleVerifyAudit walks the audit log hash chain and returns the process
+// exit code: 0 when intact, 1 when broken and 2 when it could not be checked.
+func handleVerifyAudit(args []string) int {
+	fs := flag.NewFlagSet("verify-audit", flag.ExitOnError)
+	dsn := fs.String("db", "admin.db", "path to the SQLite database")
+	_ = fs.Parse(args)
+
+	reg, err := openRegistry(*dsn)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 2
+	}
+	report, err := internal.VerifyAuditChain(reg)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 2
+	}
+	fmt.Println(report)
+	if !report.OK() {
+		return 1
+	}
+	return 0
+}
+
+// handlePruneAudit runs the audit retention policy once, as the server's
+// background pruner does.
+func handlePruneAudit(args []string) int {
+	fs := flag.NewFlagSet("prune-audit", flag.ExitOnError)
+	dsn := fs.String("db", "admin.db", "path to the SQLite database")
+	confPath := fs.String("config", "admin.yml", "configuration file with the retention policy")
+	days := fs.Int("days", 0, "prune entries older than this many days (overrides audit_retention_days)")
+	archiveDir := fs.String("archive", "", "archive pruned entries to this directory (overrides audit_archive_dir)")
+	_ = fs.Parse(args)
+
+	reg, err := openRegistry(*dsn)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 2
+	}
+	if conf, err := admin.LoadConfig(*confPath); err == nil {
+		reg.SetConfig(conf)
+	} else if !os.IsNotExist(err) {
+		fmt.Printf("Error: load %s: %v\n", *confPath, err)
+		return 2
+	}
+	if *days > 0 {
+		reg.Config.AuditRetentionDays = *days
+	}
+	if *archiveDir != "" {
+		reg.Config.AuditArchiveDir = *archiveDir
+	}
+	if reg.Config.AuditRetentionDays <= 0 {
+		fmt.Println("Error: no retention configured. Set audit_retention_days or pass -days.")
+		return 2
+	}
+	if err := reg.DB.AutoMigrate(&admin.AuditLog{}, &admin.AuditPrune{}, &admin.AuditHead{}); err != nil {
+		fmt.Printf("Error: migrate: %v\n", err)
+		return 2
+	}
+	n, archive, err := internal.ApplyAuditRetention(reg)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Pruned %d audit entries.\n", n)
+	if archive != "" {
+		fmt.Printf("Archived to %s\n", archive)
+	}
+	return 0
+}
+
+// handleImportAudit loads audit archives into a database, creating the
+// audit tables if needed.
+func handleImportAudit(args []string) int {
+	fs := flag.NewFlagSet("import-audit", flag.ExitOnError)
+	dsn := fs.String("db", "admin.db", "path to the SQLite database")
+	_ = fs.Parse(args)
+	if fs.NArg() == 0 {
+		fmt.Println("Error: Missing archive file. Usage: go-admin import-audit [-db path] <file.jsonl.gz>...")
+		return 2
+	}
+
+	reg, err := openRegistry(*dsn)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 2
+	}
+	if err := reg.DB.AutoMigrate(&admin.AuditLog{}, &admin.AuditPrune{}); err != nil {
+		fmt.Printf("Error: migrate: %v\n", err)
+		return 2
+	}
+	for _, path := range fs.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return 1
+		}
+		n, err := internal.ImportAuditArchive(reg, f)
+		_ = f.Close()
+		if err != nil {
+			fmt.Printf("Error: import %s: %v\n", path, err)
+			return 1
+		}
+		fmt.Printf("Imported %d entries from %s\n", n, path)
+	}
+	return 0
+}
+
+func openRegistry(dsn string) (*admin.Registry, error) {
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", dsn, err)
+	}
+	return admin.NewRegistry(db), nil
+}
@@ -15,6 +15,38 @@ type Config struct {
 	SessionTTL      int    `yaml:"session_ttl_hours"`
 	SearchThreshold int64  `yaml:"search_threshold"`
 	UploadDir       string `yaml:"upload_dir"`
+	// LoginMaxAttempts is the number of failed logins per email before it is locked.
+	LoginMaxAttempts int `yaml:"login_max_attempts"`
+	// LoginIPMaxAttempts is the number of failed logins per client IP before it is locked.
+	LoginIPMaxAttempts int `yaml:"login_ip_max_attempts"`
+	// LockoutSeconds is the first lockout; each further failure doubles it up to MaxLockoutSeconds.
+	LockoutSeconds    int `yaml:"lockout_seconds"`
+	MaxLockoutSeconds int `yaml:"max_lockout_seconds"`
+	// Require2FARoles lists roles that must complete TOTP enrollment before logging in.
+	Require2FARoles []string `yaml:"require_2fa_roles"`
+	// AuditRetentionDays prunes audit entries older than this many days; 0 keeps them forever.
+	AuditRetentionDays int `yaml:"audit_retention_days"`
+	// AuditArchiveDir, when set, receives pruned entries as gzipped JSON Lines before they are deleted.
+	AuditArchiveDir string `yaml:"audit_archive_dir"`
+	// AuditPruneIntervalMinutes is how often the server applies the retention policy.
+	AuditPruneIntervalMinutes int `yaml:"audit_prune_interval_minutes"`
+	// JobWorkers is the number of background jobs the server runs at once.
+	JobWorkers int `yaml:"job_workers"`
+	// JobDir receives the files produced by background exports.
+	JobDir string `yaml:"job_dir"`
+	// WebhookAllowPrivate lets webhooks reach loopback, link-local and
+	// private addresses, for receivers inside the same network.
+	WebhookAllowPrivate bool `yaml:"webhook_allow_private"`
+}
+
+// Requires2FA reports whether users with role must use two-factor authentication.
+func (c *Config) Requires2FA(role string) bool {
+	for _, r := range c.Require2FARoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
 }
 
 // DefaultConfig returns a sane default configuration.
@@ -26,6 +58,16 @@ func DefaultConfig() *Config {
 		SessionTTL:      24,
 		SearchThreshold: 50,
 		UploadDir:       "uploads",
+
+		LoginMaxAttempts:   5,
+		LoginIPMaxAttempts: 20,
+		LockoutSeconds:     60,
+		MaxLockoutSeconds:  3600,
+
+		AuditPruneIntervalMinutes: 60,
+
+		JobWorkers: 2,
+		JobDir:     "jobs",
 	}
 }
 
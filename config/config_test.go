@@ -37,4 +37,21 @@ default_per_page: 10
 			t.Errorf("Expected 10, got %d", c.DefaultPerPage)
 		}
 	})
+	t.Run("AuditRetention", func(t *testing.T) {
+		if c := DefaultConfig(); c.AuditRetentionDays != 0 || c.AuditPruneIntervalMinutes != 60 {
+			t.Errorf("Retention should be off by default with an hourly interval, got %+v", c)
+		}
+		yaml := "audit_retention_days: 90\naudit_archive_dir: archive\n"
+		if err := os.WriteFile("test_retention.yml", []byte(yaml), 0644); err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = os.Remove("test_retention.yml") }()
+		c, err := LoadConfig("test_retention.yml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.AuditRetentionDays != 90 || c.AuditArchiveDir != "archive" || c.AuditPruneIntervalMinutes != 60 {
+			t.Errorf("Unexpected retention config %+v", c)
+		}
+	})
 }
@@ -0,0 +1,182 @@
+// Package cron parses cron expressions and computes when they next fire.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron expression.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+	// A day matches when both day fields match, or when either matches if
+	// neither is "*", as in Vixie cron.
+	domStar, dowStar bool
+	every            time.Duration
+}
+
+type bounds struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minutes = bounds{"minute", 0, 59, nil}
+	hours   = bounds{"hour", 0, 23, nil}
+	days    = bounds{"day of month", 1, 31, nil}
+	months  = bounds{"month", 1, 12, map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	weekdays = bounds{"day of week", 0, 7, map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse parses a standard five-field expression (minute, hour, day of month,
+// month, day of week). Fields accept "*", numbers, ranges "a-b", steps "/n"
+// and comma-separated lists; months and weekdays may be given by their
+// three-letter English names, and 7 is also Sunday. The macros @yearly,
+// @monthly, @weekly, @daily and @hourly are supported, as is "@every <d>" for
+// a fixed interval such as "@every 15m".
+func Parse(spec string) (*Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if d, ok := strings.CutPrefix(spec, "@every "); ok {
+		every, err := time.ParseDuration(strings.TrimSpace(d))
+		if err != nil || every < time.Second {
+			return nil, fmt.Errorf("cron: %q: invalid interval", spec)
+		}
+		return &Schedule{every: every}, nil
+	}
+	expr := spec
+	if m, ok := macros[strings.ToLower(spec)]; ok {
+		expr = m
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron: %q: expected 5 fields, got %d", spec, len(fields))
+	}
+	s := &Schedule{domStar: isStar(fields[2]), dowStar: isStar(fields[4])}
+	targets := []*uint64{&s.minute, &s.hour, &s.dom, &s.month, &s.dow}
+	for i, b := range []bounds{minutes, hours, days, months, weekdays} {
+		bits, err := parseField(fields[i], b)
+		if err != nil {
+			return nil, fmt.Errorf("cron: %q: %w", spec, err)
+		}
+		*targets[i] = bits
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+func isStar(field string) bool {
+	return field == "*" || field == "?" || strings.HasPrefix(field, "*/")
+}
+
+// parseField returns the values a field matches as a bit set.
+func parseField(field string, b bounds) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepText)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("%s field: invalid step %q", b.name, stepText)
+			}
+			step = n
+		}
+		lo, hi := b.min, b.max
+		switch {
+		case rng == "*" || rng == "?":
+		case strings.Contains(rng, "-"):
+			from, to, _ := strings.Cut(rng, "-")
+			var err error
+			if lo, err = b.value(from); err != nil {
+				return 0, err
+			}
+			if hi, err = b.value(to); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("%s field: empty range %q", b.name, rng)
+			}
+		default:
+			v, err := b.value(rng)
+			if err != nil {
+				return 0, err
+			}
+			lo, hi = v, v
+			if hasStep {
+				hi = b.max
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (b bounds) value(text string) (int, error) {
+	if v, ok := b.names[strings.ToLower(text)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(text)
+	if err != nil || v < b.min || v > b.max {
+		return 0, fmt.Errorf("%s field: %q is not between %d and %d", b.name, text, b.min, b.max)
+	}
+	return v, nil
+}
+
+// Next returns the first time after t at which the schedule fires, in the
+// location of t. It returns the zero time if the expression never matches,
+// such as "0 0 30 2 *". Intervals set with @every fire at multiples of the
+// interval, so every process computes the same times.
+func (s *Schedule) Next(t time.Time) time.Time {
+	if s.every > 0 {
+		return t.Truncate(s.every).Add(s.every)
+	}
+	loc := t.Location()
+	t = t.Add(time.Minute - time.Duration(t.Second())*time.Second - time.Duration(t.Nanosecond()))
+	limit := t.Year() + 5
+	for t.Year() <= limit {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
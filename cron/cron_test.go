@@ -0,0 +1,88 @@
+package cron
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	for _, spec := range []string{"* * * * *", "*/5 0-6,22 1,15 jan-mar mon-fri", "0 12 * * 7", "30 2 ? * SUN", "@daily", "@Hourly", "@every 90s", "5/15 * * * *"} {
+		if _, err := Parse(spec); err != nil {
+			t.Errorf("Parse(%q): %v", spec, err)
+		}
+	}
+	for spec, want := range map[string]string{
+		"* * * *":       "expected 5 fields",
+		"60 * * * *":    "minute field",
+		"* 24 * * *":    "hour field",
+		"* * 0 * *":     "day of month field",
+		"* * * 13 *":    "month field",
+		"* * * * 8":     "day of week field",
+		"* * * * mon/0": "invalid step",
+		"5-1 * * * *":   "empty range",
+		"@every soon":   "invalid interval",
+		"@sometimes":    "expected 5 fields",
+	} {
+		if _, err := Parse(spec); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Parse(%q) = %v, want an error about %s", spec, err, want)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	at := func(s string) time.Time {
+		v, err := time.Parse("2006-01-02 15:04:05", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	for _, c := range []struct{ spec, from, want string }{
+		{"* * * * *", "2026-03-01 10:15:30", "2026-03-01 10:16:00"},
+		{"* * * * *", "2026-03-01 10:15:00", "2026-03-01 10:16:00"},
+		{"*/15 * * * *", "2026-03-01 10:50:00", "2026-03-01 11:00:00"},
+		{"0 3 * * *", "2026-03-01 03:00:00", "2026-03-02 03:00:00"},
+		{"@monthly", "2026-12-15 08:00:00", "2027-01-01 00:00:00"},
+		{"0 9 * * mon-fri", "2026-03-06 09:00:00", "2026-03-09 09:00:00"},
+		{"0 0 * * 7", "2026-03-02 00:00:00", "2026-03-08 00:00:00"},
+		// Both day fields restricted: either may match.
+		{"0 0 13 * fri", "2026-03-01 00:00:00", "2026-03-06 00:00:00"},
+		{"0 0 13 * fri", "2026-03-10 00:00:00", "2026-03-13 00:00:00"},
+		{"0 0 29 2 *", "2026-03-01 00:00:00", "2028-02-29 00:00:00"},
+		{"5/20 1 * * *", "2026-03-01 01:30:00", "2026-03-01 01:45:00"},
+		{"@every 15m", "2026-03-01 10:07:12", "2026-03-01 10:15:00"},
+	} {
+		s, err := Parse(c.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := s.Next(at(c.from)); !got.Equal(at(c.want)) {
+			t.Errorf("%q from %s: got %s, want %s", c.spec, c.from, got, c.want)
+		}
+	}
+
+	s, _ := Parse("0 0 30 2 *")
+	if next := s.Next(at("2026-01-01 00:00:00")); !next.IsZero() {
+		t.Errorf("An impossible date should never fire, got %s", next)
+	}
+
+	t.Run("DST", func(t *testing.T) {
+		loc, err := time.LoadLocation("America/New_York")
+		if err != nil {
+			t.Skip("no time zone data")
+		}
+		s, _ := Parse("30 * * * *")
+		// Clocks go back from 02:00 to 01:00 on 2026-11-01.
+		first := time.Date(2026, 11, 1, 0, 45, 0, 0, loc)
+		var runs []time.Time
+		for next := first; len(runs) < 4; runs = append(runs, next) {
+			next = s.Next(next)
+		}
+		for i := 1; i < len(runs); i++ {
+			if runs[i].Sub(runs[i-1]) != time.Hour {
+				t.Errorf("Expected hourly runs across the change, got %v", runs)
+			}
+		}
+	})
+}
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/go-packs/go-admin"
+	"github.com/go-packs/go-admin/handlers"
+	"github.com/go-packs/go-admin/resource"
 	"github.com/go-packs/go-admin/server"
 	"github.com/go-packs/go-admin/view"
 	"gorm.io/driver/sqlite"
@@ -15,7 +19,7 @@ import (
 
 type Role struct {
 	ID   uint   `gorm:"primaryKey"`
-	Name string `gorm:"uniqueIndex"`
+	Name string `gorm:"uniqueIndex" admin:"label=Role Name"`
 }
 
 type User struct {
@@ -25,15 +29,34 @@ type User struct {
 }
 
 type Product struct {
-	ID    uint `gorm:"primaryKey"`
-	Name  string
-	Price float64
-	Image string
+	ID    uint    `gorm:"primaryKey"`
+	Name  string  `admin:"label=Product Name"`
+	Price float64 `admin:"label=Price"`
+	Image string  `admin:"label=Product Image,type=image,sortable=false"`
+	Tags  []Tag   `gorm:"many2many:product_tags"`
+}
+
+type Tag struct {
+	ID   uint   `gorm:"primaryKey"`
+	Name string `gorm:"uniqueIndex"`
+}
+
+type Profile struct {
+	ID     uint `gorm:"primaryKey"`
+	UserID uint `gorm:"uniqueIndex" admin:"label=User,searchable=User"`
+	Bio    string
+}
+
+type Comment struct {
+	ID              uint `gorm:"primaryKey"`
+	Body            string
+	CommentableType string `admin:"label=About"`
+	CommentableID   uint
 }
 
 type ProductInfo struct {
 	ID           uint `gorm:"primaryKey"`
-	ProductID    uint
+	ProductID    uint `admin:"label=Product,searchable=Product"`
 	Description  string
 	Manufacturer string
 }
@@ -44,7 +67,7 @@ func main() {
 		log.Fatal("failed to connect database")
 	}
 
-	db.AutoMigrate(&User{}, &Product{}, &ProductInfo{}, &admin.Permission{}, &Role{}, &admin.AdminUser{}, &admin.Session{}, &admin.AuditLog{})
+	db.AutoMigrate(&User{}, &Product{}, &ProductInfo{}, &Tag{}, &Profile{}, &Comment{}, &admin.Permission{}, &Role{}, &admin.AdminUser{}, &admin.Session{}, &admin.AuditLog{}, &admin.APIToken{}, &admin.LoginChallenge{}, &admin.LoginThrottle{}, &admin.AuditPrune{}, &admin.AuditHead{}, &admin.Job{}, &admin.ScheduleRun{}, &admin.ScheduleLease{}, &admin.Webhook{}, &admin.WebhookDelivery{})
 
 	adm := admin.NewRegistry(db)
 	conf, _ := admin.LoadConfig("admin.yml")
@@ -62,10 +85,10 @@ func main() {
 	}
 
 	// Administration Group
-	adm.Register(admin.AdminUser{}).SetGroup("Administration").RegisterField("ID", "ID", true).RegisterField("Email", "Email", false).RegisterField("Role", "Role", false).SetFieldType("Role", "select", roles...)
-	adm.Register(admin.AuditLog{}).SetGroup("Administration").RegisterField("ID", "ID", true).RegisterField("CreatedAt", "Time", true).RegisterField("UserEmail", "User", true).RegisterField("ResourceName", "Resource", true).RegisterField("RecordID", "Record ID", true).RegisterField("Action", "Action", true).RegisterField("Changes", "Changes", true)
-	adm.Register(Role{}).SetGroup("Administration").RegisterField("ID", "ID", true).RegisterField("Name", "Role Name", false)
-	adm.Register(admin.Permission{}).SetGroup("Administration").RegisterField("ID", "ID", true).RegisterField("Role", "Role Name", false).RegisterField("ResourceName", "Resource", false).RegisterField("Action", "Action", false).SetFieldType("Role", "select", roles...).SetFieldType("ResourceName", "select", adm.ResourceNames()...).SetFieldType("Action", "select", "list", "show", "new", "edit", "save", "delete")
+	adm.Register(admin.AdminUser{}).SetGroup("Administration").RegisterField("ID", "ID", true).RegisterField("Email", "Email", false).RegisterField("Role", "Role", false).SetFieldType("Role", "select", roles...).AddMemberAction("unlock", "Unlock Account", handlers.UnlockAction(adm))
+	adm.Register(admin.AuditLog{}).SetGroup("Administration").RegisterField("ID", "ID", true).RegisterField("CreatedAt", "Time", true).RegisterField("UserEmail", "User", true).RegisterField("ResourceName", "Resource", true).RegisterField("RecordID", "Record ID", true).RegisterField("Action", "Action", true).RegisterField("Changes", "Summary", true).RegisterField("Diff", "Changes", true).SetDecorator("Diff", view.DiffTable).SetIndexFields("CreatedAt", "UserEmail", "ResourceName", "RecordID", "Action", "Changes").AddMemberAction("revert", "Revert to this version", handlers.RevertAction(adm))
+	adm.RegisterAuto(Role{}).SetGroup("Administration")
+	adm.Register(admin.Permission{}).SetGroup("Administration").RegisterField("ID", "ID", true).RegisterField("Role", "Role Name", false).RegisterField("ResourceName", "Resource", false).RegisterField("FieldName", "Field", false).RegisterField("Action", "Action", false).SetFieldType("Role", "select", roles...).SetFieldType("ResourceName", "select", adm.ResourceNames()...).SetFieldType("FieldName", "select", append([]string{""}, adm.FieldNames()...)...).SetFieldType("Action", "select", "list", "show", "new", "edit", "save", "delete", "export", "import", "action", "collection_action", "batch_action", "revert", "hide", "readonly").AddResourceValidator(adm.ValidatePermission)
 
 	// Users
 	uRes := adm.Register(User{}).
@@ -73,6 +96,8 @@ func main() {
 		RegisterField("Email", "Email Address", false).
 		RegisterField("Role", "User Role", false).
 		SetFieldType("Role", "select", roles...).
+		SetImportKey("Email").
+		HasOne("Profile", "Profile", "Profile", "UserID").
 		SetDecorator("Role", func(val interface{}) template.HTML {
 			role := val.(string)
 			color := "#64748b"
@@ -90,15 +115,8 @@ func main() {
 	addActivityAction(uRes)
 
 	// Products
-	pRes := adm.Register(Product{}).
+	pRes := adm.RegisterAuto(Product{}).
 		SetGroup("Products").
-		RegisterField("ID", "ID", true).
-		RegisterField("Name", "Product Name", false).
-		RegisterField("Price", "Price", false).
-		RegisterField("Image", "Product Image", false).
-		SetFieldType("Price", "number").
-		SetFieldType("Image", "image").
-		SetSortable("Image", false).
 		SetDecorator("Price", func(val interface{}) template.HTML {
 			return template.HTML(fmt.Sprintf("<strong>$%.2f</strong>", val.(float64)))
 		}).
@@ -106,6 +124,8 @@ func main() {
 			return template.HTML(`<div style="font-size: 0.8125rem; color: #475569;"><p>Competitor Avg: $145.00</p><p style="color: #10b981; margin-top: 0.25rem;">+12%% vs last month</p></div>`)
 		}).
 		HasMany("ProductInfo", "Technical Specifications", "ProductInfo", "ProductID").
+		SetInline("ProductInfo").
+		ManyToMany("Tags", "Tags", "Tag", "product_tags").
 		AddCollectionAction("discount", "Apply 10% Bulk Discount", func(res *admin.Resource, w http.ResponseWriter, r *http.Request) {
 			db.Model(&Product{}).Where("price > ?", 0).Update("price", gorm.Expr("price * 0.9"))
 			http.Redirect(w, r, "/admin/Product", 303)
@@ -113,10 +133,35 @@ func main() {
 		AddBatchAction("batch_delete", "Delete Selected", func(res *admin.Resource, ids []string, w http.ResponseWriter, r *http.Request) {
 			db.Where("id IN ?", ids).Delete(&Product{})
 			http.Redirect(w, r, "/admin/Product", 303)
+		}).
+		AddBatchAction("reprice", "Round Prices", func(res *admin.Resource, ids []string, w http.ResponseWriter, r *http.Request) {
+			for i, id := range ids {
+				db.Model(&Product{}).Where("id = ?", id).Update("price", gorm.Expr("ROUND(price)"))
+				admin.ReportProgress(r.Context(), i+1, len(ids))
+			}
+			adm.SetFlash(w, fmt.Sprintf("Rounded %d prices", len(ids)))
+		}).
+		SetBackground("discount", "reprice").
+		SetDeleting("batch_delete").
+		BeforeDelete(func(hc *admin.HookContext, item interface{}) error {
+			var specs int64
+			hc.DB.Model(&ProductInfo{}).Where("product_id = ?", item.(*Product).ID).Count(&specs)
+			if specs > 0 {
+				return resource.Abort("Delete the technical specifications of %s first", item.(*Product).Name)
+			}
+			return nil
 		})
 	addActivityAction(pRes)
 
-	adm.Register(ProductInfo{}).SetGroup("Products").RegisterField("ID", "ID", true).RegisterField("ProductID", "Product", false).RegisterField("Description", "Description", false).RegisterField("Manufacturer", "Manufacturer", false).BelongsTo("ProductID", "Parent Product", "Product", "ID").SetSearchable("ProductID", "Product")
+	adm.RegisterAuto(ProductInfo{}).SetGroup("Products").BelongsTo("ProductID", "Parent Product", "Product", "ID").SetIndexFields("ID", "Product.Name", "Manufacturer")
+	adm.RegisterAuto(Tag{}).SetGroup("Products")
+	adm.RegisterAuto(Profile{})
+	adm.RegisterAuto(Comment{}).
+		Polymorphic("Commentable", "About", "CommentableType", "CommentableID", "Product", "User").
+		SetIndexFields("Body", "Commentable")
+
+	// Webhooks, registered last so every resource can be picked
+	adm.Register(admin.Webhook{}).SetGroup("Administration").RegisterField("ID", "ID", true).RegisterField("Name", "Name", false).RegisterField("URL", "URL", false).RegisterField("ResourceName", "Resource", false).RegisterField("Events", "Events (create, update, delete, action)", false).RegisterField("Secret", "Signing Secret", false).RegisterField("Active", "Active", false).SetFieldType("ResourceName", "select", append([]string{""}, adm.ResourceNames()...)...).SetFieldType("Active", "checkbox").SetIndexFields("Name", "URL", "ResourceName", "Events", "Active").SetRequired("Name")
 
 	// Charts
 	adm.AddChart("Users by Role", "pie", func(db *gorm.DB) ([]string, []float64) {
@@ -133,6 +178,13 @@ func main() {
 		return labels, values
 	})
 
+	// Scheduled Tasks
+	if err := adm.AddSchedule("PurgeExpiredSessions", "0 3 * * *", func(ctx context.Context, db *gorm.DB) error {
+		return db.Where("expires_at < ?", time.Now()).Delete(&admin.Session{}).Error
+	}); err != nil {
+		log.Fatal(err)
+	}
+
 	// Custom Pages
 	adm.AddPage("SystemStatus", "Administration", func(w http.ResponseWriter, r *http.Request) {
 		content := template.HTML(`<div style="background: white; border-radius: 0.5rem; overflow: hidden;"><table style="width: 100%; border-collapse: collapse;"><tr style="border-bottom: 1px solid #e2e8f0;"><td style="padding: 1rem; font-weight: 600;">Server Status</td><td style="padding: 1rem; color: #10b981;">Online</td></tr></table></div>`)
@@ -150,13 +202,13 @@ func main() {
 		db.Create(&Role{Name: "editor"})
 		db.Create(&Role{Name: "viewer"})
 		db.Create(&admin.Permission{Role: "editor", ResourceName: "Product", Action: "list"})
-		p1 := &Product{Name: "Mechanical Keyboard", Price: 150.00}
+		db.Create(&admin.Permission{Role: "editor", ResourceName: "User", FieldName: "Role", Action: "readonly"})
+		p1 := &Product{Name: "Mechanical Keyboard", Price: 150.00, Tags: []Tag{{Name: "Peripherals"}, {Name: "Gaming"}}}
 		db.Create(p1)
 		db.Create(&ProductInfo{ProductID: p1.ID, Description: "Blue Switches", Manufacturer: "Razer"})
 		db.Create(&User{Email: "user@example.com", Role: "editor"})
 	}
 
 	fmt.Printf("\n🚀 Admin running at http://localhost:8080/admin\n")
-	http.Handle("/admin/", server.NewRouter(adm))
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	log.Fatal(server.NewServer(adm, ":8080").Start())
 }
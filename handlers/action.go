@@ -5,14 +5,20 @@ import (
 	"net/http"
 
 	"github.com/go-packs/go-admin"
+	"github.com/go-packs/go-admin/internal"
+	"github.com/go-packs/go-admin/models"
 	"github.com/go-packs/go-admin/resource"
 )
 
-func HandleBatchAction(reg *admin.Registry, res *resource.Resource, w http.ResponseWriter, r *http.Request) {
+func HandleBatchAction(reg *admin.Registry, res *resource.Resource, w http.ResponseWriter, r *http.Request, user *models.AdminUser) {
 	if r.Method != "POST" {
 		http.Error(w, "Method not allowed", 405)
 		return
 	}
+	if res.IsReadOnly() {
+		http.Error(w, res.Name+" is read-only", 403)
+		return
+	}
 	if err := r.ParseForm(); err != nil {
 		http.Error(w, "Bad Request", 400)
 		return
@@ -24,24 +30,84 @@ func HandleBatchAction(reg *admin.Registry, res *resource.Resource, w http.Respo
 	}
 	for _, a := range res.BatchActions {
 		if a.Name == actionName {
-			a.Handler(res, ids, w, r)
+			ids, err := internal.AuthorizeBatch(reg, res, ids, user, a.Deletes)
+			if err != nil {
+				http.Error(w, "Forbidden", 403)
+				return
+			}
+			if a.Background {
+				enqueueJob(reg, res, w, r, user, "batch_action", a.Label, jobPayload{Action: a.Name, IDs: ids})
+				return
+			}
+			sw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+			runAudited(reg, res, user, ids, a.Label, func() int {
+				a.Handler(res, ids, sw, r)
+				return sw.code
+			})
 			return
 		}
 	}
 }
 
-func HandleCustomAction(reg *admin.Registry, res *resource.Resource, w http.ResponseWriter, r *http.Request, isCollection bool) {
+func HandleCustomAction(reg *admin.Registry, res *resource.Resource, w http.ResponseWriter, r *http.Request, user *models.AdminUser, isCollection bool) {
 	actionName := r.URL.Query().Get("name")
 	var actions []resource.Action
+	var ids []string
 	if isCollection {
 		actions = res.CollectionActions
 	} else {
-		actions = res.MemberActions
+		item, err := internal.Find(reg, res.Name, r.URL.Query().Get("id"), user)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if !res.CanEdit(item, user) {
+			http.Error(w, "Forbidden", 403)
+			return
+		}
+		actions, ids = res.MemberActions, []string{r.URL.Query().Get("id")}
 	}
 	for _, a := range actions {
 		if a.Name == actionName {
-			a.Handler(res, w, r)
+			if a.Background && isCollection {
+				enqueueJob(reg, res, w, r, user, "collection_action", a.Label, jobPayload{Action: a.Name})
+				return
+			}
+			sw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+			runAudited(reg, res, user, ids, a.Label, func() int {
+				a.Handler(res, sw, r)
+				return sw.code
+			})
 			return
 		}
 	}
 }
+
+// runAudited runs an action over the records ids of res and, if it succeeds,
+// records an audit entry with the diff of each record. Collection actions
+// pass no ids and get a single entry. run returns the status the action wrote.
+func runAudited(reg *admin.Registry, res *resource.Resource, user *models.AdminUser, ids []string, summary string, run func() int) {
+	before := internal.Snapshots(reg, res, ids)
+	if run() >= 400 {
+		return
+	}
+	if len(ids) == 0 {
+		internal.RecordAction(reg, user, res.Name, "", "Action", summary)
+		return
+	}
+	after := internal.Snapshots(reg, res, ids)
+	for _, id := range ids {
+		internal.RecordChange(reg, user, res, id, "Action", summary, before[id], after[id])
+	}
+}
+
+// statusWriter remembers the status code written by an action handler.
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (s *statusWriter) WriteHeader(code int) {
+	s.code = code
+	s.ResponseWriter.WriteHeader(code)
+}
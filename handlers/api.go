@@ -0,0 +1,416 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/go-packs/go-admin"
+	"github.com/go-packs/go-admin/internal"
+	"github.com/go-packs/go-admin/models"
+	"github.com/go-packs/go-admin/resource"
+	"gorm.io/gorm"
+)
+
+// maxAPIPerPage caps the per_page parameter of API list requests.
+const maxAPIPerPage = 100
+
+// APIError is the body of every failed API response, wrapped as {"error": ...}.
+type APIError struct {
+	Status  int               `json:"status"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// HandleAPI serves the JSON REST API mounted at /admin/api. upath is the path
+// below /api, e.g. "/Product/5" or "/Product/actions/discount".
+func HandleAPI(reg *admin.Registry, w http.ResponseWriter, r *http.Request, upath string, user *models.AdminUser) {
+	if user == nil {
+		writeAPIError(w, http.StatusUnauthorized, "Authentication required", nil)
+		return
+	}
+	if !internal.SafeMethod(r.Method) && !internal.VerifyCSRF(r, user) {
+		writeAPIError(w, http.StatusForbidden, "Missing or invalid "+internal.CSRFHeader+" header", nil)
+		return
+	}
+	parts := strings.Split(strings.Trim(upath, "/"), "/")
+	if parts[0] == "" {
+		var names []string
+		for _, n := range reg.ResourceNames() {
+			if internal.Can(reg, user, n, "list") {
+				names = append(names, n)
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"resources": names})
+		return
+	}
+	if parts[0] == "openapi.json" && len(parts) == 1 {
+		writeJSON(w, http.StatusOK, internal.OpenAPI(reg, user))
+		return
+	}
+	res, ok := reg.GetResource(parts[0])
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "Unknown resource "+parts[0], nil)
+		return
+	}
+
+	var perm string
+	var handle func()
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		perm, handle = "list", func() { apiList(reg, res, w, r, user) }
+	case len(parts) == 1 && r.Method == http.MethodPost:
+		perm, handle = "save", func() { apiSave(reg, res, "", false, w, r, user) }
+	case len(parts) == 3 && parts[1] == "actions" && r.Method == http.MethodPost:
+		perm, handle = "collection_action", func() { apiAction(reg, res, parts[2], "", true, w, r, user) }
+	case len(parts) == 3 && parts[1] == "batch_actions" && r.Method == http.MethodPost:
+		perm, handle = "batch_action", func() { apiBatchAction(reg, res, parts[2], w, r, user) }
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		perm, handle = "show", func() { apiShow(reg, res, parts[1], w, r, user) }
+	case len(parts) == 2 && (r.Method == http.MethodPut || r.Method == http.MethodPatch):
+		perm, handle = "save", func() { apiSave(reg, res, parts[1], r.Method == http.MethodPatch, w, r, user) }
+	case len(parts) == 2 && r.Method == http.MethodDelete:
+		perm, handle = "delete", func() { apiDelete(reg, res, parts[1], w, r, user) }
+	case len(parts) == 4 && parts[2] == "actions" && r.Method == http.MethodPost:
+		perm, handle = "action", func() { apiAction(reg, res, parts[3], parts[1], false, w, r, user) }
+	case len(parts) <= 4:
+		writeAPIError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	default:
+		writeAPIError(w, http.StatusNotFound, "Not found", nil)
+		return
+	}
+	if !internal.Can(reg, user, res.Name, perm) {
+		writeAPIError(w, http.StatusForbidden, "Forbidden", nil)
+		return
+	}
+	if res.IsReadOnly() && (perm == "save" || perm == "delete" || perm == "batch_action") {
+		writeAPIError(w, http.StatusMethodNotAllowed, res.Name+" is read-only", nil)
+		return
+	}
+	handle()
+}
+
+func apiList(reg *admin.Registry, res *resource.Resource, w http.ResponseWriter, r *http.Request, user *models.AdminUser) {
+	fr := internal.FieldRestrictions(reg, user, res.Name)
+	lq, err := buildListQuery(reg, res, user, fr, r)
+	if err != nil {
+		var filterErr *internal.FilterError
+		if errors.As(err, &filterErr) {
+			writeAPIError(w, http.StatusBadRequest, "Invalid query", map[string]string{filterErr.Param: filterErr.Message})
+		} else {
+			writeAPIError(w, http.StatusBadRequest, err.Error(), nil)
+		}
+		return
+	}
+	perPage := lq.PerPage
+	if n, err := strconv.Atoi(r.URL.Query().Get("per_page")); err == nil && n > 0 {
+		perPage = min(n, maxAPIPerPage)
+	}
+	var totalCount int64
+	if err := lq.Query.Count(&totalCount).Error; err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	dest := reflect.New(reflect.SliceOf(reflect.TypeOf(res.Model)))
+	if err := lq.Query.Offset((lq.Page - 1) * perPage).Limit(perPage).Find(dest.Interface()).Error; err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	if err := internal.Loaded(reg, res, lq.Hook, dest.Elem()); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error(), nil)
+		return
+	}
+	fields := res.GetFieldsFor("show", fr)
+	items := dest.Elem()
+	data := make([]map[string]interface{}, 0, items.Len())
+	for i := 0; i < items.Len(); i++ {
+		data = append(data, apiRecord(res, fields, items.Index(i)))
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data": data,
+		"meta": map[string]interface{}{
+			"page": lq.Page, "per_page": perPage, "total_count": totalCount,
+			"total_pages": int(math.Ceil(float64(totalCount) / float64(perPage))),
+		},
+	})
+}
+
+func apiShow(reg *admin.Registry, res *resource.Resource, id string, w http.ResponseWriter, r *http.Request, user *models.AdminUser) {
+	item, err := internal.Get(r.Context(), reg, res.Name, id, user)
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, fmt.Sprintf("%s %s not found", res.Name, id), nil)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": apiRecord(res, res.GetFieldsFor("show", internal.FieldRestrictions(reg, user, res.Name)), reflect.ValueOf(item))})
+}
+
+// apiSave creates (id == "") or updates a record from a JSON object keyed by
+// field name. partial leaves fields missing from the body untouched.
+func apiSave(reg *admin.Registry, res *resource.Resource, id string, partial bool, w http.ResponseWriter, r *http.Request, user *models.AdminUser) {
+	var body map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "Request body must be a JSON object", nil)
+		return
+	}
+	model := reflect.New(reflect.TypeOf(res.Model)).Interface()
+	var before map[string]interface{}
+	isUpdate := id != ""
+	if isUpdate {
+		item, err := internal.Find(reg, res.Name, id, user)
+		if err != nil {
+			writeAPIError(w, http.StatusNotFound, fmt.Sprintf("%s %s not found", res.Name, id), nil)
+			return
+		}
+		if !res.CanEdit(item, user) {
+			writeAPIError(w, http.StatusForbidden, internal.ErrForbidden.Error(), nil)
+			return
+		}
+		model, before = item, res.Snapshot(item)
+	}
+	elem := reflect.ValueOf(model).Elem()
+	fr := internal.FieldRestrictions(reg, user, res.Name)
+	errs := bindJSON(res, fr.Apply(res.Fields), elem, body, partial || !isUpdate)
+	errs.Merge(res.Validate(model))
+	if len(errs) > 0 {
+		writeAPIError(w, http.StatusUnprocessableEntity, "Validation failed", errs)
+		return
+	}
+	if err := internal.Save(r.Context(), reg, res, model, user); errors.Is(err, internal.ErrForbidden) {
+		writeAPIError(w, http.StatusForbidden, err.Error(), nil)
+		return
+	} else if msg, ok := resource.AbortMessage(err); ok {
+		writeAPIError(w, http.StatusUnprocessableEntity, msg, nil)
+		return
+	} else if err != nil {
+		writeAPIError(w, http.StatusConflict, err.Error(), nil)
+		return
+	}
+	newID := fmt.Sprintf("%v", elem.FieldByName("ID").Interface())
+	act, status := "Create", http.StatusCreated
+	if isUpdate {
+		act, status = "Update", http.StatusOK
+	}
+	internal.RecordChange(reg, user, res, newID, act, "Saved from API", before, res.Snapshot(elem.Addr().Interface()))
+	writeJSON(w, status, map[string]interface{}{"data": apiRecord(res, res.GetFieldsFor("show", fr), elem)})
+}
+
+func apiDelete(reg *admin.Registry, res *resource.Resource, id string, w http.ResponseWriter, r *http.Request, user *models.AdminUser) {
+	item, _ := internal.Find(reg, res.Name, id, user)
+	if err := internal.Delete(r.Context(), reg, res.Name, id, user); errors.Is(err, gorm.ErrRecordNotFound) {
+		writeAPIError(w, http.StatusNotFound, fmt.Sprintf("%s %s not found", res.Name, id), nil)
+		return
+	} else if errors.Is(err, internal.ErrForbidden) {
+		writeAPIError(w, http.StatusForbidden, err.Error(), nil)
+		return
+	} else if msg, ok := resource.AbortMessage(err); ok {
+		writeAPIError(w, http.StatusUnprocessableEntity, msg, nil)
+		return
+	} else if err != nil {
+		writeAPIError(w, http.StatusConflict, err.Error(), nil)
+		return
+	}
+	internal.RecordChange(reg, user, res, id, "Delete", "Deleted from API", res.Snapshot(item), nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiAction runs a member (id != "") or collection action and reports what the
+// handler wrote as JSON.
+func apiAction(reg *admin.Registry, res *resource.Resource, name, id string, isCollection bool, w http.ResponseWriter, r *http.Request, user *models.AdminUser) {
+	actions := res.MemberActions
+	if isCollection {
+		actions = res.CollectionActions
+	} else if item, err := internal.Find(reg, res.Name, id, user); err != nil {
+		writeAPIError(w, http.StatusNotFound, fmt.Sprintf("%s %s not found", res.Name, id), nil)
+		return
+	} else if !res.CanEdit(item, user) {
+		writeAPIError(w, http.StatusForbidden, internal.ErrForbidden.Error(), nil)
+		return
+	}
+	for _, a := range actions {
+		if a.Name != name {
+			continue
+		}
+		if a.Background && isCollection {
+			apiEnqueue(reg, res, w, user, "collection_action", a.Label, jobPayload{Action: a.Name})
+			return
+		}
+		ar := r.Clone(r.Context())
+		q := ar.URL.Query()
+		q.Set("name", name)
+		if id != "" {
+			q.Set("id", id)
+		}
+		ar.URL.RawQuery = q.Encode()
+		var ids []string
+		if id != "" {
+			ids = []string{id}
+		}
+		rec := newActionRecorder()
+		runAudited(reg, res, user, ids, a.Label+" via API", func() int {
+			a.Handler(res, rec, ar)
+			return rec.code
+		})
+		writeActionResult(w, rec)
+		return
+	}
+	writeAPIError(w, http.StatusNotFound, "Unknown action "+name, nil)
+}
+
+func apiBatchAction(reg *admin.Registry, res *resource.Resource, name string, w http.ResponseWriter, r *http.Request, user *models.AdminUser) {
+	var body struct {
+		IDs []json.Number `json:"ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.IDs) == 0 {
+		writeAPIError(w, http.StatusBadRequest, `Request body must be {"ids": [...]}`, nil)
+		return
+	}
+	ids := make([]string, len(body.IDs))
+	for i, id := range body.IDs {
+		ids[i] = id.String()
+	}
+	for _, a := range res.BatchActions {
+		if a.Name != name {
+			continue
+		}
+		ids, err := internal.AuthorizeBatch(reg, res, ids, user, a.Deletes)
+		if err != nil {
+			writeAPIError(w, http.StatusForbidden, "Some records are not accessible", nil)
+			return
+		}
+		if a.Background {
+			apiEnqueue(reg, res, w, user, "batch_action", a.Label, jobPayload{Action: a.Name, IDs: ids})
+			return
+		}
+		rec := newActionRecorder()
+		runAudited(reg, res, user, ids, a.Label+" via API", func() int {
+			a.Handler(res, ids, rec, r)
+			return rec.code
+		})
+		writeActionResult(w, rec)
+		return
+	}
+	writeAPIError(w, http.StatusNotFound, "Unknown batch action "+name, nil)
+}
+
+// apiEnqueue queues a background action as a job for user and answers 202
+// with the job, which can be followed on the Jobs page.
+func apiEnqueue(reg *admin.Registry, res *resource.Resource, w http.ResponseWriter, user *models.AdminUser, kind, label string, payload jobPayload) {
+	job, err := internal.EnqueueJob(reg, user, kind, res.Name, label, payload)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "Could not queue the job", nil)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{"job": map[string]interface{}{"id": job.ID, "status": job.Status, "label": job.Label}})
+}
+
+// bindJSON copies body values onto the editable fields of elem, records of
+// res. Unless partial, editable fields missing from body are reset to their
+// zero value. Sensitive fields are never read back, so they keep their value
+// when missing or sent back as resource.Redacted.
+func bindJSON(res *resource.Resource, fields []resource.Field, elem reflect.Value, body map[string]json.RawMessage, partial bool) resource.ValidationErrors {
+	errs := resource.ValidationErrors{}
+	known := map[string]bool{"ID": true}
+	for _, f := range fields {
+		known[f.Name] = true
+		if f.Readonly {
+			continue
+		}
+		field := elem.FieldByName(f.Name)
+		if !field.IsValid() || !field.CanSet() {
+			continue
+		}
+		raw, ok := body[f.Name]
+		if sensitive := res.IsSensitive(f.Name); sensitive && ok && string(raw) == strconv.Quote(resource.Redacted) {
+			continue
+		} else if !ok {
+			if !partial && !sensitive {
+				field.Set(reflect.Zero(field.Type()))
+			}
+			continue
+		}
+		ptr := reflect.New(field.Type())
+		if err := json.Unmarshal(raw, ptr.Interface()); err != nil {
+			errs.Add(f.Name, "has an invalid type")
+			continue
+		}
+		field.Set(ptr.Elem())
+	}
+	for k := range body {
+		if !known[k] {
+			errs.Add(k, "is not a known field")
+		}
+	}
+	return errs
+}
+
+// apiRecord converts a model value of res into a JSON object of raw
+// (undecorated) field values, with sensitive values masked as in the panel.
+func apiRecord(res *resource.Resource, fields []resource.Field, item reflect.Value) map[string]interface{} {
+	item = reflect.Indirect(item)
+	m := make(map[string]interface{})
+	if idv := item.FieldByName("ID"); idv.IsValid() {
+		m["ID"] = idv.Interface()
+	}
+	for _, f := range fields {
+		if fv := item.FieldByName(f.Name); fv.IsValid() {
+			m[f.Name] = fv.Interface()
+		}
+	}
+	maskSensitive(res, m)
+	return m
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Printf("json encode error: %v\n", err)
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, status int, msg string, fields map[string]string) {
+	writeJSON(w, status, map[string]APIError{"error": {Status: status, Message: msg, Fields: fields}})
+}
+
+// actionRecorder captures the response of an ActionHandler so it can be
+// reported back as JSON.
+type actionRecorder struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func newActionRecorder() *actionRecorder {
+	return &actionRecorder{header: make(http.Header), code: http.StatusOK}
+}
+
+func (a *actionRecorder) Header() http.Header         { return a.header }
+func (a *actionRecorder) Write(b []byte) (int, error) { return a.body.Write(b) }
+func (a *actionRecorder) WriteHeader(code int)        { a.code = code }
+
+func writeActionResult(w http.ResponseWriter, rec *actionRecorder) {
+	body := strings.TrimSpace(rec.body.String())
+	if rec.code >= 400 {
+		writeAPIError(w, rec.code, body, nil)
+		return
+	}
+	out := map[string]interface{}{"status": rec.code}
+	if loc := rec.header.Get("Location"); loc != "" {
+		out["location"] = loc
+	}
+	if body != "" {
+		if strings.HasPrefix(rec.header.Get("Content-Type"), "application/json") && json.Valid([]byte(body)) {
+			out["result"] = json.RawMessage(body)
+		} else if rec.code < 300 {
+			out["output"] = body
+		}
+	}
+	writeJSON(w, http.StatusOK, out)
+}
@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"fmt"
+	"html/template"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/go-packs/go-admin"
+	"github.com/go-packs/go-admin/internal"
+	"github.com/go-packs/go-admin/models"
+	"github.com/go-packs/go-admin/resource"
+	"github.com/go-packs/go-admin/view"
+)
+
+// polymorphicTarget resolves the resource named by typ, the type value of a
+// record's Polymorphic association assoc, if user may see its records. Only
+// the Targets of assoc are accepted; without Targets, any resource user may
+// list.
+func polymorphicTarget(reg *admin.Registry, assoc resource.Association, typ string, user *models.AdminUser) (*resource.Resource, bool) {
+	if typ == "" || (len(assoc.Targets) > 0 && !slices.Contains(assoc.Targets, typ)) {
+		return nil, false
+	}
+	if (len(assoc.Targets) == 0 && !internal.Can(reg, user, typ, "list")) || !internal.Can(reg, user, typ, "show") {
+		return nil, false
+	}
+	return reg.GetResource(typ)
+}
+
+// polymorphicTargets returns the names polymorphicTarget accepts for assoc
+// and user, in the order of Targets or sorted.
+func polymorphicTargets(reg *admin.Registry, assoc resource.Association, user *models.AdminUser) []string {
+	names := assoc.Targets
+	if len(names) == 0 {
+		names = reg.ResourceNames()
+		slices.Sort(names)
+	}
+	return slices.DeleteFunc(slices.Clone(names), func(n string) bool {
+		_, ok := polymorphicTarget(reg, assoc, n, user)
+		return !ok
+	})
+}
+
+// loadKeyed loads the records of res whose column is one of keys, within its
+// policy for user, keyed by the value of field. The first record wins when
+// several share a key.
+func loadKeyed(reg *admin.Registry, res *resource.Resource, field string, keys []string, user *models.AdminUser) map[string]reflect.Value {
+	found := make(map[string]reflect.Value)
+	col, ok := internal.ColumnName(reg, res, field)
+	if !ok || len(keys) == 0 {
+		return found
+	}
+	dest := reflect.New(reflect.SliceOf(reflect.TypeOf(res.Model)))
+	if err := res.ApplyPolicy(reg.DB, user).Where(col+" IN ?", keys).Order("id").Find(dest.Interface()).Error; err != nil {
+		fmt.Printf("Error loading %s: %v\n", res.Name, err)
+		return found
+	}
+	items := dest.Elem()
+	for i := 0; i < items.Len(); i++ {
+		key := fmt.Sprint(items.Index(i).FieldByName(field).Interface())
+		if _, dup := found[key]; !dup {
+			found[key] = items.Index(i)
+		}
+	}
+	return found
+}
+
+// recordLink links to the show page of item, a record of res, labelled like
+// search results.
+func recordLink(reg *admin.Registry, res *resource.Resource, item reflect.Value, user *models.AdminUser) template.HTML {
+	return showLink(res, item, searchText(item, internal.FieldRestrictions(reg, user, res.Name)))
+}
+
+// showLink links to the show page of item, a record of res, with text.
+func showLink(res *resource.Resource, item reflect.Value, text interface{}) template.HTML {
+	return template.HTML(fmt.Sprintf(`<a href="/admin/%s/show?id=%v" style="color: var(--primary); text-decoration: none;">%s</a>`,
+		template.HTMLEscapeString(res.Name), item.FieldByName("ID").Interface(), template.HTMLEscapeString(fmt.Sprint(text))))
+}
+
+// indexFields returns the list columns of res for user: its index fields,
+// with association paths such as "Product.Name" in their place. The paths
+// are also returned on their own.
+func indexFields(reg *admin.Registry, res *resource.Resource, fr resource.FieldRestrictions, user *models.AdminUser) ([]resource.Field, []internal.AssociationPath) {
+	fields := res.GetFieldsFor("index", fr)
+	var paths []internal.AssociationPath
+	for _, name := range res.IndexFields {
+		if p, ok := internal.ResolvePath(reg, res, name, user); ok {
+			paths = append(paths, p)
+		}
+	}
+	if len(paths) == 0 {
+		return fields, nil
+	}
+	var result []resource.Field
+	for _, name := range res.IndexFields {
+		if i := slices.IndexFunc(paths, func(p internal.AssociationPath) bool { return p.Path == name }); i >= 0 {
+			result = append(result, resource.Field{Name: name, Label: paths[i].Field.Label, Type: "association", Sortable: paths[i].Field.Sortable})
+		} else if i := slices.IndexFunc(fields, func(f resource.Field) bool { return f.Name == name }); i >= 0 {
+			result = append(result, fields[i])
+		}
+	}
+	return result, paths
+}
+
+// pathColumns fills the columns of paths in data, the maps of
+// items, with a link to the target record showing the field's value. Each
+// association's records are loaded with one query, within its policy.
+func pathColumns(reg *admin.Registry, paths []internal.AssociationPath, items reflect.Value, data []map[string]interface{}, user *models.AdminUser) {
+	loaded := make(map[string]map[string]reflect.Value)
+	for _, p := range paths {
+		keys := make([]string, items.Len())
+		for i := range keys {
+			keys[i] = fmt.Sprint(reflect.Indirect(items.Index(i)).FieldByName(p.Association.Name).Interface())
+		}
+		found, ok := loaded[p.Association.Name]
+		if !ok {
+			found = loadKeyed(reg, p.Target, p.Association.ForeignKey, keys, user)
+			loaded[p.Association.Name] = found
+		}
+		for i, m := range data {
+			m[p.Path] = template.HTML("-")
+			if rec, ok := found[keys[i]]; ok {
+				m[p.Path] = showLink(p.Target, rec, rec.FieldByName(p.Field.Name).Interface())
+			}
+		}
+	}
+}
+
+// associationColumns adds a list column for each of the IndexAssociations of
+// res to fields, filling it in data, the maps of items, with a link to the
+// associated record. Records are loaded with one query per target resource.
+func associationColumns(reg *admin.Registry, res *resource.Resource, fields []resource.Field, items reflect.Value, data []map[string]interface{}, user *models.AdminUser) []resource.Field {
+	for _, assoc := range res.IndexAssociations() {
+		fields = append(fields, resource.Field{Name: assoc.Name, Label: assoc.Label, Type: "association"})
+		for _, m := range data {
+			m[assoc.Name] = template.HTML("-")
+		}
+		switch assoc.Type {
+		case "HasOne":
+			ids := make([]string, len(data))
+			for i, m := range data {
+				ids[i] = fmt.Sprint(m["ID"])
+			}
+			target, ok := reg.GetResource(assoc.ResourceName)
+			if !ok || !internal.Can(reg, user, target.Name, "show") {
+				continue
+			}
+			found := loadKeyed(reg, target, assoc.ForeignKey, ids, user)
+			for i, m := range data {
+				if rec, ok := found[ids[i]]; ok {
+					m[assoc.Name] = recordLink(reg, target, rec, user)
+				}
+			}
+		case "Polymorphic":
+			refs := make(map[string][]string)
+			for i := 0; i < items.Len(); i++ {
+				item := reflect.Indirect(items.Index(i))
+				typ, id := fmt.Sprint(item.FieldByName(assoc.TypeField).Interface()), fmt.Sprint(item.FieldByName(assoc.ForeignKey).Interface())
+				refs[typ] = append(refs[typ], id)
+			}
+			for typ, ids := range refs {
+				target, ok := polymorphicTarget(reg, assoc, typ, user)
+				if !ok {
+					continue
+				}
+				found := loadKeyed(reg, target, "ID", ids, user)
+				for i := 0; i < items.Len(); i++ {
+					item := reflect.Indirect(items.Index(i))
+					if fmt.Sprint(item.FieldByName(assoc.TypeField).Interface()) != typ {
+						continue
+					}
+					if rec, ok := found[fmt.Sprint(item.FieldByName(assoc.ForeignKey).Interface())]; ok {
+						data[i][assoc.Name] = recordLink(reg, target, rec, user)
+					}
+				}
+			}
+		}
+	}
+	return fields
+}
+
+// singleData describes the record of the HasOne or Polymorphic association
+// assoc of item. HasOne records come with their show fields, without the
+// foreign key. Item is nil when there is no record or user may not see it.
+func singleData(reg *admin.Registry, assoc resource.Association, item interface{}, user *models.AdminUser) *view.AssociationData {
+	data := &view.AssociationData{Label: assoc.Label, Single: true}
+	elem := reflect.Indirect(reflect.ValueOf(item))
+	var target *resource.Resource
+	var found map[string]reflect.Value
+	var key string
+	switch assoc.Type {
+	case "HasOne":
+		var ok bool
+		if target, ok = reg.GetResource(assoc.ResourceName); !ok || !internal.Can(reg, user, target.Name, "show") {
+			return data
+		}
+		key = fmt.Sprint(elem.FieldByName("ID").Interface())
+		found = loadKeyed(reg, target, assoc.ForeignKey, []string{key}, user)
+		data.Fields = slices.DeleteFunc(slices.Clone(target.GetFieldsFor("show", internal.FieldRestrictions(reg, user, target.Name))), func(f resource.Field) bool {
+			return f.Name == assoc.ForeignKey
+		})
+	case "Polymorphic":
+		data.Targets, data.IDField = polymorphicTargets(reg, assoc, user), assoc.ForeignKey
+		var ok bool
+		if target, ok = polymorphicTarget(reg, assoc, fmt.Sprint(elem.FieldByName(assoc.TypeField).Interface()), user); !ok {
+			return data
+		}
+		key = fmt.Sprint(elem.FieldByName(assoc.ForeignKey).Interface())
+		found = loadKeyed(reg, target, "ID", []string{key}, user)
+	}
+	data.Resource = target
+	if rec, ok := found[key]; ok {
+		data.Item = view.ItemToMap(target, data.Fields, rec)
+		data.Text = searchText(rec, internal.FieldRestrictions(reg, user, target.Name))
+	}
+	return data
+}
+
+// checkPolymorphic verifies that every Polymorphic association of model, a
+// record of res, points to one of its targets and to a record user may see.
+// Errors are keyed by the association's type field.
+func checkPolymorphic(reg *admin.Registry, res *resource.Resource, model interface{}, user *models.AdminUser) resource.ValidationErrors {
+	errs := resource.ValidationErrors{}
+	elem := reflect.Indirect(reflect.ValueOf(model))
+	for _, assoc := range res.Associations {
+		if assoc.Type != "Polymorphic" {
+			continue
+		}
+		typeField, idField := elem.FieldByName(assoc.TypeField), elem.FieldByName(assoc.ForeignKey)
+		if !typeField.IsValid() || !idField.IsValid() {
+			continue
+		}
+		typ := fmt.Sprint(typeField.Interface())
+		if typ == "" && idField.IsZero() {
+			continue
+		}
+		target, ok := polymorphicTarget(reg, assoc, typ, user)
+		if targets := polymorphicTargets(reg, assoc, user); !ok && len(targets) > 0 {
+			errs.Add(assoc.TypeField, fmt.Sprintf("must be one of %s", strings.Join(targets, ", ")))
+			continue
+		} else if !ok {
+			errs.Add(assoc.TypeField, "must name a resource you may see")
+			continue
+		}
+		id := fmt.Sprint(idField.Interface())
+		if _, ok := loadKeyed(reg, target, "ID", []string{id}, user)[id]; !ok {
+			errs.Add(assoc.TypeField, fmt.Sprintf("pick an existing %s", target.Name))
+		}
+	}
+	return errs
+}
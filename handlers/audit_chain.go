@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/go-packs/go-admin"
+	"github.com/go-packs/go-admin/internal"
+	"github.com/go-packs/go-admin/models"
+	"github.com/go-packs/go-admin/view"
+)
+
+// HandleAuditVerify verifies the audit log hash chain and renders the result.
+// It requires the "verify" permission on AuditLog.
+func HandleAuditVerify(reg *admin.Registry, w http.ResponseWriter, r *http.Request, user *models.AdminUser) {
+	if !internal.Can(reg, user, "AuditLog", "verify") {
+		http.Error(w, "Forbidden", 403)
+		return
+	}
+	report, err := internal.VerifyAuditChain(reg)
+	if err != nil {
+		http.Error(w, "Could not read the audit log", 500)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	styleContent, _ := admin.TemplateFS.ReadFile("templates/style.css")
+	tmpl := view.LoadTemplates("templates/audit_chain.html")
+	pd := view.PageData{
+		SiteTitle: reg.Config.SiteTitle, GroupedResources: reg.GetGroupedResources(), GroupedPages: reg.GetGroupedPages(),
+		User: user, CSRFToken: user.CSRFToken, CSS: template.CSS(styleContent), Flash: reg.GetFlash(w, r),
+		AuditChain: &view.AuditChainData{
+			OK: report.OK(), Checked: report.Checked, Unchained: report.Unchained,
+			BrokenID: report.BrokenID, Reason: report.Reason, Head: report.Head,
+		},
+	}
+	if err := tmpl.ExecuteTemplate(w, "audit_chain.html", pd); err != nil {
+		http.Error(w, "Template error", 500)
+		return
+	}
+}
@@ -1,12 +1,15 @@
 package handlers
 
 import (
+	"fmt"
 	"html/template"
 	"net/http"
 	"time"
 
 	"github.com/go-packs/go-admin"
+	"github.com/go-packs/go-admin/internal"
 	"github.com/go-packs/go-admin/models"
+	"github.com/go-packs/go-admin/resource"
 	"github.com/go-packs/go-admin/view"
 	"github.com/google/uuid"
 )
@@ -14,27 +17,72 @@ import (
 func Login(reg *admin.Registry) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		email, password := r.FormValue("email"), r.FormValue("password")
+		ip, now := internal.ClientIP(r), time.Now()
+		if until, locked := internal.LoginLockedUntil(reg, email, ip, now); locked {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.WriteHeader(http.StatusTooManyRequests)
+			RenderLogin(reg, w, r, fmt.Sprintf("Too many failed attempts. Try again in %s.", until.Sub(now).Round(time.Second)))
+			return
+		}
 		var user models.AdminUser
 		if err := reg.DB.Where("email = ?", email).First(&user).Error; err != nil {
+			internal.RecordLoginFailure(reg, email, ip, now)
 			RenderLogin(reg, w, r, "Invalid credentials")
 			return
 		}
 		if !user.CheckPassword(password) {
+			internal.RecordLoginFailure(reg, email, ip, now)
 			RenderLogin(reg, w, r, "Invalid credentials")
 			return
 		}
-		sessionID := uuid.New().String()
-		reg.DB.Create(&models.Session{
-			ID:        sessionID,
-			UserID:    user.ID,
-			ExpiresAt: time.Now().Add(time.Duration(reg.Config.SessionTTL) * time.Hour),
-		})
-		http.SetCookie(w, &http.Cookie{Name: "admin_session", Value: sessionID, Path: "/admin", HttpOnly: true})
+		// With 2FA the failure count is kept until the second step succeeds,
+		// so wrong codes keep counting toward the lockout.
+		if user.TOTPEnabled || reg.Config.Requires2FA(user.Role) {
+			startChallenge(reg, w, r, &user)
+			return
+		}
+		internal.ResetLoginFailures(reg, email)
+		startSession(reg, w, &user)
 		reg.SetFlash(w, "Login successful! Welcome back.")
 		http.Redirect(w, r, "/admin", 303)
 	}
 }
 
+// startSession issues a new Session for user and sets the session cookie.
+func startSession(reg *admin.Registry, w http.ResponseWriter, user *models.AdminUser) {
+	sessionID := uuid.New().String()
+	reg.DB.Create(&models.Session{
+		ID:        sessionID,
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(time.Duration(reg.Config.SessionTTL) * time.Hour),
+		CSRFToken: internal.NewCSRFToken(),
+	})
+	http.SetCookie(w, &http.Cookie{Name: "admin_session", Value: sessionID, Path: "/admin", HttpOnly: true})
+}
+
+// UnlockAction returns a member action for the AdminUser resource that lifts a
+// login lockout. Register it with AddMemberAction("unlock", "Unlock Account", ...).
+func UnlockAction(reg *admin.Registry) resource.ActionHandler {
+	return func(res *resource.Resource, w http.ResponseWriter, r *http.Request) {
+		actor, _ := internal.GetUserFromRequest(reg, r)
+		if !internal.Can(reg, actor, res.Name, "edit") {
+			http.Error(w, "Forbidden", 403)
+			return
+		}
+		var user models.AdminUser
+		if err := reg.DB.First(&user, "id = ?", r.URL.Query().Get("id")).Error; err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if err := internal.UnlockAccount(reg, actor, &user); err != nil {
+			http.Error(w, "Unlock failed", 500)
+			return
+		}
+		reg.SetFlash(w, fmt.Sprintf("%s unlocked", user.Email))
+		http.Redirect(w, r, fmt.Sprintf("/admin/%s/show?id=%d", res.Name, user.ID), 303)
+	}
+}
+
 func Logout(reg *admin.Registry) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		cookie, _ := r.Cookie("admin_session")
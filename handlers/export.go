@@ -1,46 +1,307 @@
 package handlers
 
 import (
+	"database/sql/driver"
 	"encoding/csv"
+	"encoding/json"
 	"fmt"
+	"html"
+	"html/template"
+	"io"
 	"net/http"
 	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-packs/go-admin"
+	"github.com/go-packs/go-admin/internal"
+	"github.com/go-packs/go-admin/models"
 	"github.com/go-packs/go-admin/resource"
+	"gorm.io/gorm"
 )
 
-func HandleExport(reg *admin.Registry, res *resource.Resource, w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/csv")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment;filename=%s_export.csv", res.Name))
-	writer := csv.NewWriter(w)
-	defer writer.Flush()
-	var h []string
-	for _, f := range res.Fields {
-		h = append(h, f.Label)
-	}
-	if err := writer.Write(h); err != nil {
-		http.Error(w, "Internal error", 500)
+// exportBatchSize is the number of records an export loads per query.
+const exportBatchSize = 500
+
+// exportFormats maps the format parameter of an export to its file extension
+// and content type.
+var exportFormats = map[string]struct{ Ext, ContentType string }{
+	"csv":   {"csv", "text/csv; charset=utf-8"},
+	"jsonl": {"jsonl", "application/x-ndjson"},
+	"xlsx":  {"xlsx", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+}
+
+// exportWriter encodes the rows of an export. The first row holds the labels.
+type exportWriter interface {
+	WriteRow(values []interface{}) error
+	Close() error
+}
+
+// HandleExport streams the records of the list view of res as CSV (the
+// default), JSON Lines or XLSX, chosen by the format parameter. The export
+// uses the same scope, filters and sort as the list, is limited to the
+// selected ids when any are given, and has the list's columns. The batch bar
+// posts the ids, so its CSRF token never ends up in a URL. Decorators are
+// applied, as plain text, when decorate=1. Records are loaded in batches, in
+// ID order unless a sort is given. A POST with background=1 queues the export
+// as a job instead.
+func HandleExport(reg *admin.Registry, res *resource.Resource, w http.ResponseWriter, r *http.Request, user *models.AdminUser) {
+	format := r.FormValue("format")
+	if format == "" {
+		format = "csv"
+	}
+	kind, ok := exportFormats[format]
+	if !ok {
+		http.Error(w, "Unknown export format", 400)
+		return
+	}
+	fr := internal.FieldRestrictions(reg, user, res.Name)
+	fields := res.GetFieldsFor("index", fr)
+	lq, err := buildListQuery(reg, res, user, fr, r)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
 		return
 	}
-	query := reg.DB.Model(res.Model)
-	modelType := reflect.TypeOf(res.Model)
-	destSlice := reflect.MakeSlice(reflect.SliceOf(modelType), 0, 0)
-	dest := reflect.New(destSlice.Type())
-	if err := query.Find(dest.Interface()).Error; err != nil {
+	if r.Method == "POST" && r.FormValue("background") == "1" {
+		q := r.URL.Query()
+		q.Set("format", format)
+		enqueueJob(reg, res, w, r, user, "export", fmt.Sprintf("Export %s as %s", res.Name, strings.ToUpper(format)), jobPayload{Query: q.Encode()})
+		return
+	}
+	if ids := r.Form["ids"]; len(ids) > 0 {
+		lq.Query, lq.Filtered = lq.Query.Where("id IN ?", ids), lq.Filtered.Where("id IN ?", ids)
+	}
+	decorate := r.URL.Query().Get("decorate") == "1"
+
+	// The response is only started once the first batch has loaded, so a
+	// failing query can still be reported as an error.
+	var out exportWriter
+	start := func() error {
+		w.Header().Set("Content-Type", kind.ContentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment;filename=%s_export.%s", res.Name, kind.Ext))
+		var err error
+		if out, err = newExportWriter(format, w, res.Name, fields); err != nil {
+			return err
+		}
+		header := make([]interface{}, len(fields))
+		for i, f := range fields {
+			header[i] = f.Label
+		}
+		return out.WriteRow(header)
+	}
+	// Inside a job the total is counted up front so progress can be reported.
+	var total int64
+	report, tracked := admin.ProgressFrom(r.Context())
+	if tracked {
+		lq.Filtered.Count(&total)
+	}
+	row, n := make([]interface{}, len(fields)), 0
+	err = eachRecord(reg, res, lq, func(item reflect.Value) error {
+		if n++; tracked && n%exportBatchSize == 0 {
+			report(n, int(total))
+		}
+		if out == nil {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		item = reflect.Indirect(item)
+		for i, f := range fields {
+			row[i] = exportValue(f, item.FieldByName(f.Name), decorate)
+			// Sensitive values are masked as on the list page.
+			if row[i] != nil && row[i] != "" && res.IsSensitive(f.Name) {
+				row[i] = resource.Redacted
+			}
+		}
+		return out.WriteRow(row)
+	})
+	if err != nil && out == nil {
 		http.Error(w, "Database error", 500)
 		return
 	}
-	items := dest.Elem()
-	for i := 0; i < items.Len(); i++ {
-		item := reflect.Indirect(items.Index(i))
-		var row []string
-		for _, f := range res.Fields {
-			row = append(row, fmt.Sprintf("%v", item.FieldByName(f.Name).Interface()))
+	if err == nil && out == nil {
+		err = start()
+	}
+	if err == nil {
+		err = out.Close()
+	}
+	if err != nil {
+		// Part of the file is already sent, so the status cannot change.
+		// Aborting drops the connection and the client sees a failed
+		// download instead of a truncated file.
+		fmt.Printf("Export of %s failed: %v\n", res.Name, err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// eachRecord calls fn for every record matched by lq, exportBatchSize records
+// at a time, after their AfterLoad hooks. Unsorted queries are walked with FindInBatches in primary key
+// order; FindInBatches can only follow the primary key, so sorted queries are
+// paged through with offsets, using the ID to break ties.
+func eachRecord(reg *admin.Registry, res *resource.Resource, lq listQuery, fn func(item reflect.Value) error) error {
+	dest := reflect.New(reflect.SliceOf(reflect.TypeOf(res.Model)))
+	each := func() error {
+		items := dest.Elem()
+		if err := internal.Loaded(reg, res, lq.Hook, items); err != nil {
+			return err
+		}
+		for i := 0; i < items.Len(); i++ {
+			if err := fn(items.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if lq.SortField == "" {
+		return lq.Filtered.FindInBatches(dest.Interface(), exportBatchSize, func(tx *gorm.DB, batch int) error {
+			return each()
+		}).Error
+	}
+	ordered := lq.Query.Order("id").Session(&gorm.Session{})
+	for offset := 0; ; offset += exportBatchSize {
+		dest.Elem().SetLen(0)
+		if err := ordered.Offset(offset).Limit(exportBatchSize).Find(dest.Interface()).Error; err != nil {
+			return err
+		}
+		if err := each(); err != nil {
+			return err
+		}
+		if dest.Elem().Len() < exportBatchSize {
+			return nil
+		}
+	}
+}
+
+func newExportWriter(format string, w io.Writer, sheet string, fields []resource.Field) (exportWriter, error) {
+	switch format {
+	case "jsonl":
+		return &jsonlWriter{w: w, fields: fields}, nil
+	case "xlsx":
+		return newXLSXWriter(w, sheet)
+	default:
+		return &csvWriter{w: csv.NewWriter(w)}, nil
+	}
+}
+
+// exportValue returns the value of field f for an export: nil when empty, a
+// string, bool or number otherwise. Times are formatted as RFC 3339.
+func exportValue(f resource.Field, v reflect.Value, decorate bool) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	if decorate && f.Decorator != nil {
+		return plainText(f.Decorator(v.Interface()))
+	}
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	switch val := v.Interface().(type) {
+	case time.Time:
+		if val.IsZero() {
+			return nil
 		}
-		if err := writer.Write(row); err != nil {
-			http.Error(w, "Internal error", 500)
-			return
+		return val.Format(time.RFC3339)
+	case driver.Valuer:
+		dv, err := val.Value()
+		if err != nil || dv == nil {
+			return nil
 		}
+		return exportValue(resource.Field{}, reflect.ValueOf(dv), false)
 	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint()
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return string(v.Bytes())
+		}
+	}
+	return fmt.Sprintf("%v", v.Interface())
+}
+
+var htmlTag = regexp.MustCompile(`<[^>]*>`)
+
+// plainText reduces decorator output to its text.
+func plainText(h template.HTML) string {
+	return html.UnescapeString(htmlTag.ReplaceAllString(string(h), ""))
 }
+
+// exportString formats an export value for text output.
+func exportString(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+// WriteRow writes values as one CSV record. Text starting with a character
+// spreadsheets read as a formula gets a leading quote, so opening the file
+// cannot run what users typed into a field.
+func (c *csvWriter) WriteRow(values []interface{}) error {
+	rec := make([]string, len(values))
+	for i, v := range values {
+		rec[i] = exportString(v)
+		if _, text := v.(string); text && rec[i] != "" && strings.ContainsRune("=+-@\t\r", rune(rec[i][0])) {
+			rec[i] = "'" + rec[i]
+		}
+	}
+	return c.w.Write(rec)
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// jsonlWriter writes one JSON object per record, keyed by field name in
+// field order. The label row is skipped.
+type jsonlWriter struct {
+	w      io.Writer
+	fields []resource.Field
+	header bool
+}
+
+func (j *jsonlWriter) WriteRow(values []interface{}) error {
+	if !j.header {
+		j.header = true
+		return nil
+	}
+	buf := []byte{'{'}
+	for i, f := range j.fields {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		key, _ := json.Marshal(f.Name)
+		val, err := json.Marshal(values[i])
+		if err != nil {
+			return err
+		}
+		buf = append(append(append(buf, key...), ':'), val...)
+	}
+	_, err := j.w.Write(append(buf, '}', '\n'))
+	return err
+}
+
+func (j *jsonlWriter) Close() error { return nil }
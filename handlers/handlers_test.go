@@ -1,24 +1,91 @@
 package handlers
 
 import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-packs/go-admin"
+	"github.com/go-packs/go-admin/internal"
 	"github.com/go-packs/go-admin/models"
+	"github.com/go-packs/go-admin/resource"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
+type Widget struct {
+	ID    uint `gorm:"primaryKey"`
+	Name  string
+	Email string
+	Qty   int
+}
+
+func (w *Widget) Validate() error {
+	if w.Name == "forbidden" {
+		return errors.New("this name is reserved")
+	}
+	return nil
+}
+
+type Gadget struct {
+	ID    uint `gorm:"primaryKey"`
+	Name  string
+	Parts []Part `gorm:"many2many:gadget_parts"`
+}
+
+type Spec struct {
+	ID       uint `gorm:"primaryKey"`
+	GadgetID uint
+	Label    string
+	Qty      int
+}
+
+func (s Spec) Validate() error {
+	if s.Label == "" {
+		return resource.ValidationErrors{"Label": "label is required"}
+	}
+	return nil
+}
+
+type Manual struct {
+	ID       uint `gorm:"primaryKey"`
+	GadgetID uint
+	Title    string
+}
+
+type Note struct {
+	ID          uint `gorm:"primaryKey"`
+	Body        string
+	NotableType string
+	NotableID   uint
+}
+
+type Part struct {
+	ID     uint `gorm:"primaryKey"`
+	Name   string
+	Secret bool
+}
+
 func setupTestDB() (*gorm.DB, *admin.Registry) {
 	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
 	if err != nil {
 		panic(err)
 	}
-	if err := db.AutoMigrate(&models.AdminUser{}, &models.Session{}, &models.Permission{}); err != nil {
+	if err := db.AutoMigrate(&models.AdminUser{}, &models.Session{}, &models.Permission{}, &models.AuditLog{}, &models.APIToken{}, &models.LoginChallenge{}, &models.LoginThrottle{}, &Widget{}); err != nil {
 		panic(err)
 	}
 	reg := admin.NewRegistry(db)
@@ -71,3 +138,1543 @@ func TestAuthHandlers(t *testing.T) {
 		}
 	})
 }
+
+func postForm(path string, data url.Values) *http.Request {
+	req := httptest.NewRequest("POST", path, strings.NewReader(data.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+func TestHandleSaveValidation(t *testing.T) {
+	db, reg := setupTestDB()
+	user := &models.AdminUser{ID: 1, Email: "admin@example.com", Role: "admin"}
+	res := reg.Register(Widget{}).
+		RegisterField("ID", "ID", true).
+		RegisterField("Name", "Name", false).
+		RegisterField("Email", "Email", false).
+		RegisterField("Qty", "Quantity", false).
+		SetFieldType("Qty", "number").
+		SetRequired("Name").
+		SetEmail("Email").
+		SetRange("Qty", 1, 100)
+
+	t.Run("InvalidRerendersForm", func(t *testing.T) {
+		data := url.Values{"Name": {""}, "Email": {"not-an-email"}, "Qty": {"abc"}}
+		w := httptest.NewRecorder()
+		HandleSave(reg, res, w, postForm("/admin/Widget/save", data), user)
+
+		if w.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("Expected 422, got %d", w.Code)
+		}
+		body := w.Body.String()
+		for _, want := range []string{"is required", "is not a valid email address", "must be a whole number", `value="not-an-email"`, `value="abc"`} {
+			if !strings.Contains(body, want) {
+				t.Errorf("Expected form to contain %q", want)
+			}
+		}
+		var count int64
+		db.Model(&Widget{}).Count(&count)
+		if count != 0 {
+			t.Error("Invalid record should not be saved")
+		}
+	})
+
+	t.Run("ModelValidate", func(t *testing.T) {
+		data := url.Values{"Name": {"forbidden"}, "Qty": {"5"}}
+		w := httptest.NewRecorder()
+		HandleSave(reg, res, w, postForm("/admin/Widget/save", data), user)
+		if w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), "this name is reserved") {
+			t.Errorf("Expected model Validate error, got %d", w.Code)
+		}
+	})
+
+	t.Run("ValidSaves", func(t *testing.T) {
+		data := url.Values{"Name": {"Gear"}, "Email": {"gear@example.com"}, "Qty": {"5"}}
+		w := httptest.NewRecorder()
+		HandleSave(reg, res, w, postForm("/admin/Widget/save", data), user)
+		if w.Code != 303 {
+			t.Fatalf("Expected 303, got %d", w.Code)
+		}
+		var saved Widget
+		if err := db.First(&saved).Error; err != nil || saved.Qty != 5 {
+			t.Errorf("Record not saved: %+v %v", saved, err)
+		}
+	})
+}
+
+func TestAPI(t *testing.T) {
+	db, reg := setupTestDB()
+	user := &models.AdminUser{ID: 1, Email: "admin@example.com", Role: "admin"}
+	reg.Register(Widget{}).
+		RegisterField("ID", "ID", true).
+		RegisterField("Name", "Name", false).
+		RegisterField("Qty", "Quantity", false).
+		SetRequired("Name").
+		AddCollectionAction("restock", "Restock", func(res *admin.Resource, w http.ResponseWriter, r *http.Request) {
+			db.Model(&Widget{}).Where("1 = 1").Update("qty", 99)
+			http.Redirect(w, r, "/admin/Widget", 303)
+		})
+
+	call := func(method, path, body string, u *models.AdminUser) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/admin/api"+path, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		HandleAPI(reg, w, req, req.URL.Path[len("/admin/api"):], u)
+		return w
+	}
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		if w := call("GET", "/Widget", "", nil); w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected 401, got %d", w.Code)
+		}
+	})
+
+	t.Run("CreateValidation", func(t *testing.T) {
+		w := call("POST", "/Widget", `{"Qty": 3}`, user)
+		if w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), `"Name":"is required"`) {
+			t.Errorf("Expected validation error, got %d %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("Lifecycle", func(t *testing.T) {
+		w := call("POST", "/Widget", `{"Name": "Gear", "Qty": 3}`, user)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected 201, got %d %s", w.Code, w.Body.String())
+		}
+		w = call("PATCH", "/Widget/1", `{"Qty": 7}`, user)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"Name":"Gear"`) {
+			t.Errorf("Patch should keep Name, got %s", w.Body.String())
+		}
+		w = call("GET", "/Widget?q_Name=Ge&sort=Qty&order=desc", "", user)
+		if !strings.Contains(w.Body.String(), `"Qty":7`) || !strings.Contains(w.Body.String(), `"total_count":1`) {
+			t.Errorf("Unexpected list body: %s", w.Body.String())
+		}
+		w = call("POST", "/Widget/actions/restock", "", user)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"location":"/admin/Widget"`) {
+			t.Errorf("Unexpected action result: %d %s", w.Code, w.Body.String())
+		}
+		w = call("DELETE", "/Widget/1", "", user)
+		if w.Code != http.StatusNoContent {
+			t.Errorf("Expected 204, got %d", w.Code)
+		}
+		if w := call("GET", "/Widget/1", "", user); w.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 after delete, got %d", w.Code)
+		}
+		var logs int64
+		db.Model(&models.AuditLog{}).Count(&logs)
+		if logs != 4 {
+			t.Errorf("Expected 4 audit entries, got %d", logs)
+		}
+	})
+
+	t.Run("InvalidQuery", func(t *testing.T) {
+		for path, want := range map[string]string{
+			"/Widget?q_Email=x":               `"q_Email":"unknown filter"`,
+			"/Widget?min_Qty=many":            `"min_Qty":"\"many\" is not a number"`,
+			"/Widget?sort=qty%20desc%2C%20id": `"sort":"cannot sort on qty desc, id"`,
+			"/Widget?q_Name%3D1%20OR%201=1":   `"q_Name=1 OR 1":"unknown filter"`,
+		} {
+			if w := call("GET", path, "", user); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), want) {
+				t.Errorf("%s: expected 400 with %s, got %d %s", path, want, w.Code, w.Body.String())
+			}
+		}
+	})
+}
+
+func TestAPIRestrictions(t *testing.T) {
+	db, reg := setupTestDB()
+	_ = db.AutoMigrate(&models.Job{})
+	user := &models.AdminUser{ID: 1, Email: "admin@example.com", Role: "admin"}
+	var ran []string
+	res := reg.Register(Widget{}).
+		RegisterField("ID", "ID", true).
+		RegisterField("Name", "Name", false).
+		RegisterField("Email", "Email", false).
+		SetSensitive("Email").
+		SetCanEdit(func(item interface{}, user *models.AdminUser) bool { return item.(*Widget).Name != "Locked" }).
+		AddMemberAction("touch", "Touch", func(res *admin.Resource, w http.ResponseWriter, r *http.Request) {
+			ran = append(ran, "touch "+r.URL.Query().Get("id"))
+		}).
+		AddCollectionAction("rebuild", "Rebuild", func(res *admin.Resource, w http.ResponseWriter, r *http.Request) {
+			ran = append(ran, "rebuild")
+		}).
+		SetBackground("rebuild")
+	db.Create(&Widget{Name: "Gear", Email: "topsecretvalue"})
+	db.Create(&Widget{Name: "Locked"})
+	call := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/admin/api"+path, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		HandleAPI(reg, w, req, req.URL.Path[len("/admin/api"):], user)
+		return w
+	}
+
+	t.Run("Sensitive", func(t *testing.T) {
+		for _, w := range []*httptest.ResponseRecorder{
+			call("GET", "/Widget", ""), call("GET", "/Widget/1", ""),
+			call("PUT", "/Widget/1", `{"Name": "Cog"}`), call("PATCH", "/Widget/1", `{"Name": "Cog", "Email": "[REDACTED]"}`),
+		} {
+			if body := w.Body.String(); w.Code != http.StatusOK || strings.Contains(body, "topsecretvalue") || !strings.Contains(body, `"Email":"[REDACTED]"`) {
+				t.Errorf("The sensitive value should be masked, got %d %s", w.Code, body)
+			}
+		}
+		var saved Widget
+		if db.First(&saved, 1); saved.Name != "Cog" || saved.Email != "topsecretvalue" {
+			t.Errorf("PUT should keep the sensitive value, got %+v", saved)
+		}
+		call("PUT", "/Widget/1", `{"Name": "Cog", "Email": "rotated"}`)
+		if db.First(&saved, 1); saved.Email != "rotated" {
+			t.Errorf("PUT should set a sensitive value it sends, got %+v", saved)
+		}
+		for _, path := range []string{"/Widget?q_Email=rot", "/Widget?sort=Email"} {
+			if w := call("GET", path, ""); w.Code != http.StatusBadRequest {
+				t.Errorf("%s: sensitive fields should not be filtered or sorted on, got %d %s", path, w.Code, w.Body.String())
+			}
+		}
+		if w := call("GET", "/Widget?q=rot", ""); strings.Contains(w.Body.String(), `"total_count":1`) {
+			t.Errorf("Search should not match sensitive fields, got %s", w.Body.String())
+		}
+	})
+
+	t.Run("Actions", func(t *testing.T) {
+		if w := call("POST", "/Widget/2/actions/touch", ""); w.Code != http.StatusForbidden || len(ran) != 0 {
+			t.Errorf("Member actions should check CanEdit, got %d %v", w.Code, ran)
+		}
+		w := httptest.NewRecorder()
+		HandleCustomAction(reg, res, w, postForm("/admin/Widget/action?name=touch&id=2", nil), user, false)
+		if w.Code != http.StatusForbidden || len(ran) != 0 {
+			t.Errorf("The panel should check CanEdit too, got %d %v", w.Code, ran)
+		}
+		if w := call("POST", "/Widget/1/actions/touch", ""); w.Code != http.StatusOK || len(ran) != 1 {
+			t.Errorf("Expected the action to run, got %d %v", w.Code, ran)
+		}
+		w = call("POST", "/Widget/actions/rebuild", "")
+		var job models.Job
+		if db.First(&job); w.Code != http.StatusAccepted || len(ran) != 1 || job.Kind != "collection_action" || !strings.Contains(w.Body.String(), fmt.Sprintf(`"id":%d`, job.ID)) {
+			t.Errorf("Background actions should be queued, got %d %s %v", w.Code, w.Body.String(), ran)
+		}
+	})
+}
+
+func TestLifecycleHooks(t *testing.T) {
+	db, reg := setupTestDB()
+	user := &models.AdminUser{ID: 1, Email: "admin@example.com", Role: "admin"}
+	res := reg.Register(Widget{}).
+		RegisterField("ID", "ID", true).
+		RegisterField("Name", "Name", false).
+		RegisterField("Qty", "Quantity", false).
+		BeforeSave(func(hc *resource.HookContext, item interface{}) error {
+			if item.(*Widget).Qty > 10 && hc.User.Role != "admin" {
+				return resource.Abort("Only admins may stock more than 10")
+			}
+			if item.(*Widget).Qty < 0 {
+				return resource.Abort("Stock cannot be negative")
+			}
+			return nil
+		}).
+		BeforeDelete(func(hc *resource.HookContext, item interface{}) error {
+			if item.(*Widget).Qty > 0 {
+				return resource.Abort("%s is still in stock", item.(*Widget).Name)
+			}
+			return nil
+		}).
+		BeforeList(func(hc *resource.HookContext, db *gorm.DB) *gorm.DB {
+			return db.Where("name <> ?", "Sprocket")
+		})
+	db.Create(&Widget{Name: "Gear", Qty: 3})
+	db.Create(&Widget{Name: "Sprocket"})
+
+	t.Run("SaveAbort", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		HandleSave(reg, res, w, postForm("/admin/Widget/save", url.Values{"Name": {"Bolt"}, "Qty": {"-1"}}), user)
+		if w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), "Stock cannot be negative") || !strings.Contains(w.Body.String(), `value="Bolt"`) {
+			t.Errorf("Expected the form again with the hook message, got %d", w.Code)
+		}
+		var n int64
+		if db.Model(&Widget{}).Where("name = ?", "Bolt").Count(&n); n != 0 {
+			t.Error("The aborted record should not be saved")
+		}
+		w = httptest.NewRecorder()
+		editor := &models.AdminUser{ID: 2, Email: "ed@example.com", Role: "editor"}
+		db.Create(&models.Permission{Role: "editor", ResourceName: "Widget", Action: "save"})
+		HandleSave(reg, res, w, postForm("/admin/Widget/save", url.Values{"ID": {"1"}, "Name": {"Gear"}, "Qty": {"50"}}), editor)
+		if !strings.Contains(w.Body.String(), "Only admins may stock more than 10") {
+			t.Error("Hooks should see the current user")
+		}
+	})
+
+	t.Run("DeleteAbort", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		HandleDelete(reg, res, w, postForm("/admin/Widget/delete", url.Values{"id": {"1"}}), user)
+		if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/admin/Widget/show?id=1" || !strings.Contains(cookieValue(w, "admin_flash"), "Gear is still in stock") {
+			t.Errorf("Expected a redirect to the record with the hook message, got %d %q", w.Code, w.Header().Get("Location"))
+		}
+		if err := db.First(&Widget{}, 1).Error; err != nil {
+			t.Error("The record should survive the aborted delete")
+		}
+	})
+
+	t.Run("BeforeList", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		RenderList(reg, res, w, httptest.NewRequest("GET", "/admin/Widget", nil), user)
+		if body := w.Body.String(); !strings.Contains(body, "Gear") || strings.Contains(body, "Sprocket") {
+			t.Error("The list should go through the BeforeList hook")
+		}
+	})
+
+	t.Run("API", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/admin/api/Widget", strings.NewReader(`{"Name": "Bolt", "Qty": -4}`))
+		w := httptest.NewRecorder()
+		HandleAPI(reg, w, req, "/Widget", user)
+		if w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), "Stock cannot be negative") {
+			t.Errorf("Expected 422 with the hook message, got %d %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("AfterLoadReadOnly", func(t *testing.T) {
+		res.AfterLoad(func(hc *resource.HookContext, item interface{}) error {
+			item.(*Widget).Name = strings.ToUpper(item.(*Widget).Name)
+			return nil
+		})
+		defer func() { res.Hooks.AfterLoad = nil }()
+		w := httptest.NewRecorder()
+		HandleAPI(reg, w, httptest.NewRequest("PATCH", "/admin/api/Widget/1", strings.NewReader(`{"Qty": 2}`)), "/Widget/1", user)
+		var saved Widget
+		if db.First(&saved, 1); w.Code != http.StatusOK || saved.Name != "Gear" || saved.Qty != 2 {
+			t.Errorf("Values changed for display should not be saved back, got %d %+v", w.Code, saved)
+		}
+		w = httptest.NewRecorder()
+		HandleAPI(reg, w, httptest.NewRequest("GET", "/admin/api/Widget/1", nil), "/Widget/1", user)
+		if !strings.Contains(w.Body.String(), `"GEAR"`) {
+			t.Errorf("AfterLoad should run on reads, got %s", w.Body.String())
+		}
+	})
+}
+
+func TestManyToMany(t *testing.T) {
+	db, reg := setupTestDB()
+	if err := db.AutoMigrate(&Gadget{}, &Part{}); err != nil {
+		t.Fatal(err)
+	}
+	user := &models.AdminUser{ID: 1, Email: "admin@example.com", Role: "admin"}
+	res := reg.Register(Gadget{}).
+		RegisterField("ID", "ID", true).
+		RegisterField("Name", "Name", false).
+		ManyToMany("Parts", "Parts", "Part", "gadget_parts")
+	reg.Register(Part{}).
+		RegisterField("ID", "ID", true).
+		RegisterField("Name", "Name", false).
+		SetPolicy(func(db *gorm.DB, user *models.AdminUser) *gorm.DB {
+			if user.Role == "admin" {
+				return db
+			}
+			return db.Where("secret = ?", false)
+		})
+	db.Create(&Part{Name: "Bolt"})
+	db.Create(&Part{Name: "Nut"})
+	db.Create(&Part{Name: "Cog", Secret: true})
+	db.Create(&Gadget{Name: "Clock", Parts: []Part{{ID: 1}, {ID: 3}}})
+	linked := func() []uint {
+		var ids []uint
+		db.Table("gadget_parts").Where("gadget_id = ?", 1).Order("part_id").Pluck("part_id", &ids)
+		return ids
+	}
+
+	t.Run("Show", func(t *testing.T) {
+		g, _ := internal.Get(context.Background(), reg, "Gadget", 1, user)
+		w := httptest.NewRecorder()
+		RenderShow(reg, res, g, w, httptest.NewRequest("GET", "/admin/Gadget/show?id=1", nil), user)
+		if body := w.Body.String(); !strings.Contains(body, "Parts (2)") || !strings.Contains(body, "Cog") || strings.Contains(body, "Nut") {
+			t.Error("The show page should list the linked parts")
+		}
+	})
+
+	t.Run("Form", func(t *testing.T) {
+		g, _ := internal.Get(context.Background(), reg, "Gadget", 1, user)
+		w := httptest.NewRecorder()
+		RenderForm(reg, res, g, w, httptest.NewRequest("GET", "/admin/Gadget/edit?id=1", nil), user)
+		body := w.Body.String()
+		if !strings.Contains(body, `name="Parts" value="1"`) || !strings.Contains(body, `name="Parts" value="3"`) || !strings.Contains(body, "/admin/Part/search?q=") {
+			t.Error("The form should show the linked parts in a searchable multi-select")
+		}
+	})
+
+	t.Run("Save", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		HandleSave(reg, res, w, postForm("/admin/Gadget/save", url.Values{"ID": {"1"}, "Name": {"Clock"}, "Parts": {"", "2", "3"}}), user)
+		if w.Code != http.StatusSeeOther {
+			t.Fatalf("Expected 303, got %d", w.Code)
+		}
+		if ids := linked(); fmt.Sprint(ids) != "[2 3]" {
+			t.Errorf("Expected the links to be replaced, got %v", ids)
+		}
+		var log models.AuditLog
+		db.Order("id desc").First(&log)
+		if d := log.Changeset(); len(d) != 1 || d[0].Field != "Parts" || d[0].Old != "1, 3" || d[0].New != "2, 3" {
+			t.Errorf("Unexpected audit diff %+v", d)
+		}
+
+		w = httptest.NewRecorder()
+		HandleSave(reg, res, w, postForm("/admin/Gadget/save", url.Values{"ID": {"1"}, "Name": {"Timer"}}), user)
+		if ids := linked(); fmt.Sprint(ids) != "[2 3]" {
+			t.Errorf("A form without the association should keep the links, got %v", ids)
+		}
+	})
+
+	t.Run("Policy", func(t *testing.T) {
+		editor := &models.AdminUser{ID: 2, Email: "ed@example.com", Role: "editor"}
+		db.Create(&models.Permission{Role: "editor", ResourceName: "Gadget", Action: "save"})
+		w := httptest.NewRecorder()
+		HandleSave(reg, res, w, postForm("/admin/Gadget/save", url.Values{"ID": {"1"}, "Name": {"Timer"}, "Parts": {"", "1"}}), editor)
+		if w.Code != http.StatusSeeOther {
+			t.Fatalf("Expected 303, got %d", w.Code)
+		}
+		if ids := linked(); fmt.Sprint(ids) != "[1 3]" {
+			t.Errorf("Links the editor cannot see should be kept, got %v", ids)
+		}
+
+		w = httptest.NewRecorder()
+		HandleSave(reg, res, w, postForm("/admin/Gadget/save", url.Values{"ID": {"1"}, "Name": {"Timer"}, "Parts": {"", "1", "3"}}), editor)
+		if w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), `name="Parts" value="1"`) {
+			t.Errorf("Linking a part outside the policy should re-render the form, got %d", w.Code)
+		}
+		if ids := linked(); fmt.Sprint(ids) != "[1 3]" {
+			t.Errorf("The refused save should keep the links, got %v", ids)
+		}
+	})
+}
+
+func TestInlineChildren(t *testing.T) {
+	db, reg := setupTestDB()
+	if err := db.AutoMigrate(&Gadget{}, &Spec{}, &Part{}); err != nil {
+		t.Fatal(err)
+	}
+	user := &models.AdminUser{ID: 1, Email: "admin@example.com", Role: "admin"}
+	res := reg.Register(Gadget{}).
+		RegisterField("ID", "ID", true).
+		RegisterField("Name", "Name", false).
+		HasMany("Specs", "Specifications", "Spec", "GadgetID").
+		SetInline("Specs")
+	reg.Register(Spec{}).
+		RegisterField("ID", "ID", true).
+		RegisterField("GadgetID", "Gadget", false).
+		RegisterField("Label", "Label", false).
+		RegisterField("Qty", "Quantity", false).
+		BeforeSave(func(hc *resource.HookContext, item interface{}) error {
+			if item.(*Spec).Qty < 0 {
+				return resource.Abort("Quantities cannot be negative")
+			}
+			return nil
+		})
+	db.Create(&Gadget{Name: "Clock"})
+	db.Create(&Spec{GadgetID: 1, Label: "Hands", Qty: 2})
+	db.Create(&Spec{GadgetID: 1, Label: "Bell", Qty: 1})
+	specs := func() string {
+		var all []Spec
+		db.Where("gadget_id = ?", 1).Order("id").Find(&all)
+		var s []string
+		for _, sp := range all {
+			s = append(s, fmt.Sprintf("%s:%d", sp.Label, sp.Qty))
+		}
+		return strings.Join(s, ",")
+	}
+	var gadget Gadget
+	db.First(&gadget, 1)
+
+	t.Run("Form", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		RenderForm(reg, res, &gadget, w, httptest.NewRequest("GET", "/admin/Gadget/edit?id=1", nil), user)
+		body := w.Body.String()
+		if !strings.Contains(body, `name="Specs[0].Label" value="Hands"`) || !strings.Contains(body, `name="Specs[1].ID" value="2"`) || !strings.Contains(body, `name="Specs[__INDEX__].Qty"`) {
+			t.Error("The form should have a row per child and a blank row to add")
+		}
+		if strings.Contains(body, "Specs[0].GadgetID") {
+			t.Error("The foreign key should not be editable in rows")
+		}
+	})
+
+	t.Run("Save", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		HandleSave(reg, res, w, postForm("/admin/Gadget/save", url.Values{
+			"ID": {"1"}, "Name": {"Clock"},
+			"Specs[0].ID": {"1"}, "Specs[0].Label": {"Hands"}, "Specs[0].Qty": {"3"},
+			"Specs[1].ID": {"2"}, "Specs[1]._destroy": {"1"},
+			"Specs[7].Label": {"Face"}, "Specs[7].Qty": {"1"},
+		}), user)
+		if w.Code != http.StatusSeeOther {
+			t.Fatalf("Expected 303, got %d: %s", w.Code, w.Body.String())
+		}
+		if got := specs(); got != "Hands:3,Face:1" {
+			t.Errorf("Unexpected children %s", got)
+		}
+		var actions []string
+		db.Model(&models.AuditLog{}).Where("resource_name = ?", "Spec").Order("id").Pluck("action", &actions)
+		if fmt.Sprint(actions) != "[Update Delete Create]" {
+			t.Errorf("Each changed child should be audited, got %v", actions)
+		}
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		HandleSave(reg, res, w, postForm("/admin/Gadget/save", url.Values{
+			"ID": {"1"}, "Name": {"Alarm"},
+			"Specs[0].ID": {"1"}, "Specs[0].Label": {"Hands"}, "Specs[0].Qty": {"lots"},
+			"Specs[4].Label": {""}, "Specs[4].Qty": {"1"},
+		}), user)
+		body := w.Body.String()
+		if w.Code != http.StatusUnprocessableEntity || !strings.Contains(body, "label is required") || !strings.Contains(body, `name="Specs[1].Qty" value="1"`) || !strings.Contains(body, `name="Specs[0].Qty" value="lots"`) {
+			t.Errorf("Expected the errors on their rows, got %d", w.Code)
+		}
+		var g Gadget
+		if db.First(&g, 1); g.Name != "Clock" || specs() != "Hands:3,Face:1" {
+			t.Error("Nothing should be saved when a row is invalid")
+		}
+	})
+
+	t.Run("Abort", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		HandleSave(reg, res, w, postForm("/admin/Gadget/save", url.Values{
+			"ID": {"1"}, "Name": {"Alarm"},
+			"Specs[0].ID": {"1"}, "Specs[0].Label": {"Hands"}, "Specs[0].Qty": {"4"},
+			"Specs[1].ID": {"3"}, "Specs[1].Label": {"Face"}, "Specs[1].Qty": {"-1"},
+		}), user)
+		if w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), "Quantities cannot be negative") {
+			t.Errorf("Expected the hook message on the row, got %d", w.Code)
+		}
+		var g Gadget
+		if db.First(&g, 1); g.Name != "Clock" || specs() != "Hands:3,Face:1" {
+			t.Error("The parent and its children should be rolled back together")
+		}
+	})
+
+	t.Run("Ownership", func(t *testing.T) {
+		db.Create(&Gadget{Name: "Radio"})
+		w := httptest.NewRecorder()
+		HandleSave(reg, res, w, postForm("/admin/Gadget/save", url.Values{"ID": {"2"}, "Name": {"Radio"}, "Specs[0].ID": {"1"}, "Specs[0].Label": {"Stolen"}}), user)
+		if w.Code != http.StatusNotFound || specs() != "Hands:3,Face:1" {
+			t.Errorf("Children of another record should not be editable, got %d", w.Code)
+		}
+	})
+
+	t.Run("New", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		HandleSave(reg, res, w, postForm("/admin/Gadget/save", url.Values{"Name": {"Lamp"}, "Specs[0].Label": {"Bulb"}, "Specs[0].Qty": {"1"}}), user)
+		var lamp Gadget
+		db.Where("name = ?", "Lamp").First(&lamp)
+		var n int64
+		if db.Model(&Spec{}).Where("gadget_id = ? AND label = ?", lamp.ID, "Bulb").Count(&n); w.Code != http.StatusSeeOther || n != 1 {
+			t.Errorf("Children of a new record should be created with it, got %d", w.Code)
+		}
+	})
+}
+
+func TestSingleAssociations(t *testing.T) {
+	db, reg := setupTestDB()
+	if err := db.AutoMigrate(&Gadget{}, &Manual{}, &Note{}, &Part{}); err != nil {
+		t.Fatal(err)
+	}
+	user := &models.AdminUser{ID: 1, Email: "admin@example.com", Role: "admin"}
+	gadgets := reg.Register(Gadget{}).
+		RegisterField("ID", "ID", true).
+		RegisterField("Name", "Name", false).
+		HasOne("Manual", "Manual", "Manual", "GadgetID")
+	reg.Register(Manual{}).
+		RegisterField("ID", "ID", true).
+		RegisterField("GadgetID", "Gadget", false).
+		RegisterField("Title", "Title", false)
+	reg.Register(Part{}).
+		RegisterField("ID", "ID", true).
+		RegisterField("Name", "Name", false)
+	notes := reg.Register(Note{}).
+		RegisterField("ID", "ID", true).
+		RegisterField("Body", "Body", false).
+		RegisterField("NotableType", "About", false).
+		RegisterField("NotableID", "About ID", false).
+		Polymorphic("Notable", "About", "NotableType", "NotableID", "Gadget", "Part").
+		SetIndexFields("Body", "Notable")
+	db.Create(&Gadget{Name: "Clock"})
+	db.Create(&Gadget{Name: "Radio"})
+	db.Create(&Manual{GadgetID: 1, Title: "Winding Guide"})
+	db.Create(&Part{Name: "Spring"})
+	db.Create(&Note{Body: "Runs late", NotableType: "Gadget", NotableID: 1})
+	db.Create(&Note{Body: "Rusty", NotableType: "Part", NotableID: 1})
+
+	t.Run("Show", func(t *testing.T) {
+		g, _ := internal.Get(context.Background(), reg, "Gadget", 1, user)
+		w := httptest.NewRecorder()
+		RenderShow(reg, gadgets, g, w, httptest.NewRequest("GET", "/admin/Gadget/show?id=1", nil), user)
+		if body := w.Body.String(); !strings.Contains(body, "Winding Guide") || !strings.Contains(body, "/admin/Manual/show?id=1") {
+			t.Error("The show page should embed the HasOne record")
+		}
+		n, _ := internal.Get(context.Background(), reg, "Note", 2, user)
+		w = httptest.NewRecorder()
+		RenderShow(reg, notes, n, w, httptest.NewRequest("GET", "/admin/Note/show?id=2", nil), user)
+		if body := w.Body.String(); !strings.Contains(body, "/admin/Part/show?id=1") || !strings.Contains(body, "Part: Spring") {
+			t.Error("The show page should link to the polymorphic target")
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		RenderList(reg, notes, w, httptest.NewRequest("GET", "/admin/Note", nil), user)
+		body := w.Body.String()
+		if !strings.Contains(body, `<a href="/admin/Gadget/show?id=1" style="color: var(--primary); text-decoration: none;">Clock</a>`) || !strings.Contains(body, `/admin/Part/show?id=1`) {
+			t.Error("The list should link each note to its target")
+		}
+		w = httptest.NewRecorder()
+		RenderList(reg, gadgets, w, httptest.NewRequest("GET", "/admin/Gadget", nil), user)
+		if body := w.Body.String(); !strings.Contains(body, `<a href="/admin/Manual/show?id=1"`) {
+			t.Error("The list should show the HasOne record")
+		}
+	})
+
+	t.Run("Form", func(t *testing.T) {
+		n, _ := internal.Get(context.Background(), reg, "Note", 1, user)
+		w := httptest.NewRecorder()
+		RenderForm(reg, notes, n, w, httptest.NewRequest("GET", "/admin/Note/edit?id=1", nil), user)
+		body := w.Body.String()
+		if !strings.Contains(body, `<option value="Gadget" selected>`) || !strings.Contains(body, `name="NotableID" id="hidden-NotableType" value="1"`) || !strings.Contains(body, `value="Clock"`) {
+			t.Error("The form should have a type and record picker")
+		}
+		if strings.Count(body, `name="NotableID"`) != 1 {
+			t.Error("The ID field should only be posted by the picker")
+		}
+		g, _ := internal.Get(context.Background(), reg, "Gadget", 1, user)
+		w = httptest.NewRecorder()
+		RenderForm(reg, gadgets, g, w, httptest.NewRequest("GET", "/admin/Gadget/edit?id=1", nil), user)
+		if !strings.Contains(w.Body.String(), "/admin/Manual/edit?id=1") {
+			t.Error("The form should link to the HasOne record")
+		}
+	})
+
+	t.Run("Save", func(t *testing.T) {
+		for _, c := range []struct{ typ, id, msg string }{
+			{"AdminUser", "1", "must be one of Gadget, Part"},
+			{"Part", "9", "pick an existing Part"},
+		} {
+			w := httptest.NewRecorder()
+			HandleSave(reg, notes, w, postForm("/admin/Note/save", url.Values{"ID": {"1"}, "Body": {"Runs late"}, "NotableType": {c.typ}, "NotableID": {c.id}}), user)
+			if w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), c.msg) {
+				t.Errorf("Expected %q for %s #%s, got %d", c.msg, c.typ, c.id, w.Code)
+			}
+		}
+		w := httptest.NewRecorder()
+		HandleSave(reg, notes, w, postForm("/admin/Note/save", url.Values{"ID": {"1"}, "Body": {"Runs late"}, "NotableType": {"Gadget"}, "NotableID": {"2"}}), user)
+		var n Note
+		if db.First(&n, 1); w.Code != http.StatusSeeOther || n.NotableID != 2 {
+			t.Errorf("Expected the note to move to Radio, got %d %+v", w.Code, n)
+		}
+	})
+
+	t.Run("Permissions", func(t *testing.T) {
+		editor := &models.AdminUser{ID: 2, Email: "ed@example.com", Role: "editor"}
+		for _, p := range []models.Permission{
+			{Role: "editor", ResourceName: "Gadget", Action: "list"}, {Role: "editor", ResourceName: "Gadget", Action: "show"},
+			{Role: "editor", ResourceName: "Note", Action: "list"}, {Role: "editor", ResourceName: "Note", Action: "show"},
+			{Role: "editor", ResourceName: "Part", Action: "list"},
+		} {
+			db.Create(&p)
+		}
+		g, _ := internal.Get(context.Background(), reg, "Gadget", 1, editor)
+		w := httptest.NewRecorder()
+		RenderShow(reg, gadgets, g, w, httptest.NewRequest("GET", "/admin/Gadget/show?id=1", nil), editor)
+		if strings.Contains(w.Body.String(), "Winding Guide") {
+			t.Error("HasOne records of resources the user cannot show should not be embedded")
+		}
+		w = httptest.NewRecorder()
+		RenderList(reg, gadgets, w, httptest.NewRequest("GET", "/admin/Gadget", nil), editor)
+		if strings.Contains(w.Body.String(), "/admin/Manual/show") {
+			t.Error("The list should not link HasOne records the user cannot show")
+		}
+		w = httptest.NewRecorder()
+		RenderList(reg, notes, w, httptest.NewRequest("GET", "/admin/Note", nil), editor)
+		if body := w.Body.String(); strings.Contains(body, "/admin/Part/show") || !strings.Contains(body, "/admin/Gadget/show") {
+			t.Error("The list should only link polymorphic targets the user can show")
+		}
+
+		saved := notes.Associations
+		notes.Associations = []resource.Association{saved[0]}
+		notes.Associations[0].Targets = nil
+		defer func() { notes.Associations = saved }()
+		n, _ := internal.Get(context.Background(), reg, "Note", 2, editor)
+		w = httptest.NewRecorder()
+		RenderForm(reg, notes, n, w, httptest.NewRequest("GET", "/admin/Note/edit?id=2", nil), editor)
+		body := w.Body.String()
+		if !strings.Contains(body, `<option value="Gadget"`) || strings.Contains(body, `<option value="Part"`) || strings.Contains(body, `<option value="AdminUser"`) || strings.Contains(body, "Spring") {
+			t.Error("Without Targets the picker should only offer resources the user can list and show")
+		}
+		w = httptest.NewRecorder()
+		HandleSave(reg, notes, w, postForm("/admin/Note/save", url.Values{"ID": {"2"}, "Body": {"Rusty"}, "NotableType": {"AdminUser"}, "NotableID": {"1"}}), editor)
+		if w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), "must be one of Gadget, Note") {
+			t.Errorf("Expected resources the user cannot see to be refused, got %d", w.Code)
+		}
+	})
+}
+
+func TestAssociationPaths(t *testing.T) {
+	db, reg := setupTestDB()
+	if err := db.AutoMigrate(&Gadget{}, &Spec{}); err != nil {
+		t.Fatal(err)
+	}
+	user := &models.AdminUser{ID: 1, Email: "admin@example.com", Role: "admin"}
+	reg.Register(Gadget{}).
+		RegisterField("ID", "ID", true).
+		RegisterField("Name", "Gadget Name", false).
+		SetPolicy(func(db *gorm.DB, user *models.AdminUser) *gorm.DB { return db.Where("name <> ?", "Hidden") })
+	specs := reg.Register(Spec{}).
+		RegisterField("ID", "ID", true).
+		RegisterField("GadgetID", "Gadget", false).
+		RegisterField("Label", "Label", false).
+		BelongsTo("GadgetID", "Gadget", "Gadget", "ID").
+		SetIndexFields("Label", "Gadget.Name")
+	db.Create(&Gadget{Name: "Radio"})
+	db.Create(&Gadget{Name: "Clock"})
+	db.Create(&Gadget{Name: "Hidden"})
+	db.Create(&Spec{GadgetID: 1, Label: "Antenna"})
+	db.Create(&Spec{GadgetID: 2, Label: "Bell"})
+	db.Create(&Spec{GadgetID: 3, Label: "Secret"})
+
+	list := func(query string) string {
+		w := httptest.NewRecorder()
+		RenderList(reg, specs, w, httptest.NewRequest("GET", "/admin/Spec?"+query, nil), user)
+		return w.Body.String()
+	}
+	queries := func(query string) int {
+		n := 0
+		db.Callback().Query().After("gorm:query").Register("count_queries", func(*gorm.DB) { n++ })
+		defer db.Callback().Query().Remove("count_queries")
+		list(query)
+		return n
+	}
+
+	t.Run("Column", func(t *testing.T) {
+		body := list("")
+		if !strings.Contains(body, "Gadget Name") || !strings.Contains(body, `<a href="/admin/Gadget/show?id=1" style="color: var(--primary); text-decoration: none;">Radio</a>`) {
+			t.Error("The list should link each spec to its gadget")
+		}
+		if strings.Contains(body, ">Hidden</a>") {
+			t.Error("Gadgets outside the policy should not be shown")
+		}
+		before := queries("")
+		for i := 0; i < 5; i++ {
+			db.Create(&Spec{GadgetID: uint(i%2 + 1), Label: fmt.Sprintf("Extra %d", i)})
+		}
+		if after := queries(""); after != before {
+			t.Errorf("Expected one lookup whatever the number of rows, got %d queries instead of %d", after, before)
+		}
+		db.Where("label LIKE ?", "Extra%").Delete(&Spec{})
+	})
+
+	t.Run("Sort", func(t *testing.T) {
+		body := list("sort=Gadget.Name&order=asc")
+		if strings.Index(body, "Bell") > strings.Index(body, "Antenna") {
+			t.Error("Clock's spec should come before Radio's")
+		}
+		body = list("sort=Gadget.Name&order=desc")
+		if strings.Index(body, "Antenna") > strings.Index(body, "Bell") {
+			t.Error("Radio's spec should come before Clock's")
+		}
+	})
+
+	t.Run("Filter", func(t *testing.T) {
+		body := list("q_Gadget.Name=adi")
+		if !strings.Contains(body, "Antenna") || strings.Contains(body, "Bell") {
+			t.Error("The filter should match the gadget name")
+		}
+		if body := list("q_Gadget.Name=Hid"); strings.Contains(body, "Secret") {
+			t.Error("The filter should not match gadgets outside the policy")
+		}
+		if !strings.Contains(body, `name="q_Gadget.Name"`) {
+			t.Error("The sidebar should offer the path as a filter")
+		}
+		w := httptest.NewRecorder()
+		RenderList(reg, specs, w, httptest.NewRequest("GET", "/admin/Spec?q_Gadget.Parts=1", nil), user)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "q_Gadget.Parts: unknown filter") {
+			t.Errorf("Expected an unknown filter error, got %d %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("OpenAPI", func(t *testing.T) {
+		raw, _ := json.Marshal(internal.OpenAPI(reg, user))
+		for _, want := range []string{`"name":"q_Gadget.Name"`, `"Gadget.Name"`} {
+			if !strings.Contains(string(raw), want) {
+				t.Errorf("The OpenAPI document should offer %s", want)
+			}
+		}
+	})
+
+	t.Run("SensitiveTarget", func(t *testing.T) {
+		gadgets, _ := reg.GetResource("Gadget")
+		gadgets.SetSensitive("Name")
+		defer func() { gadgets.SensitiveFields = nil }()
+		for _, query := range []string{"q_Gadget.Name=adi", "sort=Gadget.Name"} {
+			w := httptest.NewRecorder()
+			RenderList(reg, specs, w, httptest.NewRequest("GET", "/admin/Spec?"+query, nil), user)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("%s: paths to sensitive fields should be refused, got %d", query, w.Code)
+			}
+		}
+		if body := list(""); strings.Contains(body, ">Radio</a>") {
+			t.Error("The list should not show path columns of sensitive fields")
+		}
+	})
+
+	t.Run("TargetPermission", func(t *testing.T) {
+		editor := &models.AdminUser{ID: 2, Email: "ed@example.com", Role: "editor"}
+		db.Create(&models.Permission{Role: "editor", ResourceName: "Spec", Action: "list"})
+		w := httptest.NewRecorder()
+		RenderList(reg, specs, w, httptest.NewRequest("GET", "/admin/Spec?q_Gadget.Name=adi", nil), editor)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "q_Gadget.Name: unknown filter") {
+			t.Errorf("Paths into resources the user cannot list should be refused, got %d", w.Code)
+		}
+		w = httptest.NewRecorder()
+		RenderList(reg, specs, w, httptest.NewRequest("GET", "/admin/Spec", nil), editor)
+		if body := w.Body.String(); strings.Contains(body, "Gadget Name") || strings.Contains(body, ">Radio</a>") {
+			t.Error("The list should not show path columns of resources the user cannot list")
+		}
+	})
+}
+
+func TestTokenHandlers(t *testing.T) {
+	db, reg := setupTestDB()
+	user := &models.AdminUser{Email: "dev@example.com", Role: "admin"}
+	db.Create(user)
+
+	w := httptest.NewRecorder()
+	HandleTokens(reg, w, postForm("/admin/tokens", url.Values{"name": {"ci"}, "scopes": {"list"}, "expires_days": {"30"}}), "/tokens", user)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), models.APITokenPrefix) {
+		t.Fatalf("Expected new token to be shown, got %d", w.Code)
+	}
+	var tok models.APIToken
+	if err := db.First(&tok).Error; err != nil || tok.UserID != user.ID || tok.ExpiresAt == nil {
+		t.Fatalf("Token not stored: %+v %v", tok, err)
+	}
+
+	other := &models.AdminUser{ID: 99, Role: "admin"}
+	HandleTokens(reg, httptest.NewRecorder(), postForm("/admin/tokens/revoke", url.Values{"id": {"1"}}), "/tokens/revoke", other)
+	var count int64
+	db.Model(&models.APIToken{}).Count(&count)
+	if count != 1 {
+		t.Error("Users must not revoke other users' tokens")
+	}
+	HandleTokens(reg, httptest.NewRecorder(), postForm("/admin/tokens/revoke", url.Values{"id": {"1"}}), "/tokens/revoke", user)
+	db.Model(&models.APIToken{}).Count(&count)
+	if count != 0 {
+		t.Error("Token not revoked")
+	}
+}
+
+func cookieValue(w *httptest.ResponseRecorder, name string) string {
+	for _, c := range w.Result().Cookies() {
+		if c.Name == name && c.MaxAge >= 0 {
+			return c.Value
+		}
+	}
+	return ""
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	db, reg := setupTestDB()
+	reg.Config.Require2FARoles = []string{"admin"}
+
+	login := func(email string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		Login(reg)(w, postForm("/admin/login", url.Values{"email": {email}, "password": {"password123"}}))
+		return w
+	}
+	step2 := func(challenge, code string) *httptest.ResponseRecorder {
+		req := postForm("/admin/login/2fa", url.Values{"code": {code}})
+		req.AddCookie(&http.Cookie{Name: "admin_2fa", Value: challenge})
+		w := httptest.NewRecorder()
+		TwoFactorLogin(reg)(w, req)
+		return w
+	}
+
+	t.Run("EnrolledUser", func(t *testing.T) {
+		secret, _ := models.GenerateTOTPSecret()
+		user := &models.AdminUser{Email: "editor@example.com", Role: "editor", TOTPSecret: secret, TOTPEnabled: true}
+		_ = user.SetPassword("password123")
+		db.Create(user)
+
+		w := login(user.Email)
+		challenge := cookieValue(w, "admin_2fa")
+		if w.Header().Get("Location") != "/admin/login/2fa" || challenge == "" || cookieValue(w, "admin_session") != "" {
+			t.Fatalf("Password step should issue a challenge, not a session")
+		}
+		if w := step2(challenge, "000000"); cookieValue(w, "admin_session") != "" || !strings.Contains(w.Body.String(), "Invalid code") {
+			t.Error("Wrong code must not log in")
+		}
+		code, _ := models.TOTPCode(secret, time.Now())
+		w = step2(challenge, code)
+		if w.Code != 303 || cookieValue(w, "admin_session") == "" {
+			t.Errorf("Valid code should start a session, got %d", w.Code)
+		}
+		var n int64
+		db.Model(&models.LoginChallenge{}).Count(&n)
+		if n != 0 {
+			t.Error("Challenge should be consumed")
+		}
+	})
+
+	t.Run("RequiredRoleEnrolls", func(t *testing.T) {
+		user := &models.AdminUser{Email: "root@example.com", Role: "admin"}
+		_ = user.SetPassword("password123")
+		db.Create(user)
+
+		challenge := cookieValue(login(user.Email), "admin_2fa")
+		req := httptest.NewRequest("GET", "/admin/login/2fa", nil)
+		req.AddCookie(&http.Cookie{Name: "admin_2fa", Value: challenge})
+		w := httptest.NewRecorder()
+		TwoFactorLogin(reg)(w, req)
+		if body := w.Body.String(); !strings.Contains(body, `<svg xmlns="http://www.w3.org/2000/svg"`) || strings.Contains(body, "<script src=") {
+			t.Fatal("Enrollment page should show the provisioning URI as an inline QR code")
+		}
+		db.First(user, user.ID)
+		code, _ := models.TOTPCode(user.TOTPSecret, time.Now())
+		w = step2(challenge, code)
+		if cookieValue(w, "admin_session") == "" || !strings.Contains(w.Body.String(), "Recovery Codes") {
+			t.Error("Enrollment should start a session and show recovery codes")
+		}
+		db.First(user, user.ID)
+		if !user.TOTPEnabled || user.RemainingRecoveryCodes() != models.RecoveryCodeCount {
+			t.Error("2FA not enabled after enrollment")
+		}
+	})
+
+	t.Run("WrongCodesLockAccount", func(t *testing.T) {
+		reg.Config.LoginMaxAttempts = 3
+		secret, _ := models.GenerateTOTPSecret()
+		user := &models.AdminUser{Email: "guessed@example.com", Role: "editor", TOTPSecret: secret, TOTPEnabled: true}
+		_ = user.SetPassword("password123")
+		db.Create(user)
+
+		first := cookieValue(login(user.Email), "admin_2fa")
+		step2(first, "000000")
+		step2(first, "000000")
+		// Passing the password step again must not reset the count.
+		challenge := cookieValue(login(user.Email), "admin_2fa")
+		step2(challenge, "000000")
+		code, _ := models.TOTPCode(secret, time.Now())
+		if w := step2(challenge, code); w.Code != http.StatusTooManyRequests || cookieValue(w, "admin_session") != "" {
+			t.Fatalf("Wrong codes should lock the account, got %d", w.Code)
+		}
+	})
+}
+
+func TestLoginLockout(t *testing.T) {
+	db, reg := setupTestDB()
+	reg.Config.LoginMaxAttempts = 2
+	user := &models.AdminUser{Email: "locked@example.com", Role: "admin"}
+	_ = user.SetPassword("password123")
+	db.Create(user)
+
+	attempt := func(password string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		Login(reg)(w, postForm("/admin/login", url.Values{"email": {user.Email}, "password": {password}}))
+		return w
+	}
+	attempt("wrong")
+	attempt("wrong")
+	if w := attempt("password123"); w.Code != http.StatusTooManyRequests || cookieValue(w, "admin_session") != "" {
+		t.Fatalf("Locked account should be refused even with the right password, got %d", w.Code)
+	}
+
+	res := reg.Register(models.AdminUser{})
+	sess := &models.Session{ID: "root-sess", UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)}
+	db.Create(sess)
+	req := httptest.NewRequest("GET", fmt.Sprintf("/admin/AdminUser/action?name=unlock&id=%d", user.ID), nil)
+	req.AddCookie(&http.Cookie{Name: "admin_session", Value: sess.ID})
+	w := httptest.NewRecorder()
+	UnlockAction(reg)(res, w, req)
+	if w.Code != 303 {
+		t.Fatalf("Expected unlock redirect, got %d", w.Code)
+	}
+	if w := attempt("password123"); w.Code != 303 {
+		t.Errorf("Login should succeed after unlock, got %d", w.Code)
+	}
+}
+
+func TestFieldPermissions(t *testing.T) {
+	db, reg := setupTestDB()
+	res := reg.Register(Widget{}).
+		RegisterField("ID", "ID", true).
+		RegisterField("Name", "Name", false).
+		RegisterField("Email", "Email", false).
+		RegisterField("Qty", "Quantity", false)
+	for _, action := range []string{"list", "show"} {
+		db.Create(&models.Permission{Role: "editor", ResourceName: "Widget", Action: action})
+	}
+	db.Create(&models.Permission{Role: "editor", ResourceName: "Widget", FieldName: "Email", Action: "hide"})
+	db.Create(&models.Permission{Role: "editor", ResourceName: "Widget", FieldName: "Qty", Action: "readonly"})
+	db.Create(&Widget{Name: "Gear", Email: "secret@example.com", Qty: 3})
+	editor := &models.AdminUser{ID: 2, Email: "editor@example.com", Role: "editor"}
+
+	t.Run("Save", func(t *testing.T) {
+		data := url.Values{"ID": {"1"}, "Name": {"Cog"}, "Email": {"evil@example.com"}, "Qty": {"99"}}
+		w := httptest.NewRecorder()
+		HandleSave(reg, res, w, postForm("/admin/Widget/save", data), editor)
+		if w.Code != 303 {
+			t.Fatalf("Expected 303, got %d", w.Code)
+		}
+		var saved Widget
+		db.First(&saved, 1)
+		if saved.Name != "Cog" || saved.Email != "secret@example.com" || saved.Qty != 3 {
+			t.Errorf("Restricted fields should be left untouched, got %+v", saved)
+		}
+	})
+
+	t.Run("ReadPaths", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		HandleExport(reg, res, w, httptest.NewRequest("GET", "/admin/Widget/export", nil), editor)
+		if strings.Contains(w.Body.String(), "secret@example.com") || !strings.Contains(w.Body.String(), "Cog") {
+			t.Errorf("Export should omit hidden fields: %s", w.Body.String())
+		}
+
+		w = httptest.NewRecorder()
+		HandleSearchAPI(reg, "Widget", w, httptest.NewRequest("GET", "/admin/Widget/search?q=secret", nil), editor)
+		if strings.Contains(w.Body.String(), "Cog") {
+			t.Errorf("Search should not match on hidden fields: %s", w.Body.String())
+		}
+
+		w = httptest.NewRecorder()
+		HandleAPI(reg, w, httptest.NewRequest("GET", "/admin/api/Widget/1", nil), "/Widget/1", editor)
+		if strings.Contains(w.Body.String(), "Email") {
+			t.Errorf("API should omit hidden fields: %s", w.Body.String())
+		}
+
+		w = httptest.NewRecorder()
+		HandleAPI(reg, w, httptest.NewRequest("GET", "/admin/api/Widget?q_Email=secret", nil), "/Widget", editor)
+		if !strings.Contains(w.Body.String(), `"total_count":1`) {
+			t.Errorf("Filters on hidden fields should be ignored: %s", w.Body.String())
+		}
+
+		item, _ := internal.Get(context.Background(), reg, "Widget", "1", editor)
+		w = httptest.NewRecorder()
+		RenderShow(reg, res, item, w, httptest.NewRequest("GET", "/admin/Widget/show?id=1", nil), editor)
+		if strings.Contains(w.Body.String(), "secret@example.com") {
+			t.Error("Show page should omit hidden fields")
+		}
+	})
+}
+
+func TestRowPolicy(t *testing.T) {
+	db, reg := setupTestDB()
+	var batched []string
+	res := reg.Register(Widget{}).
+		RegisterField("ID", "ID", true).
+		RegisterField("Name", "Name", false).
+		SetPolicy(func(db *gorm.DB, user *models.AdminUser) *gorm.DB {
+			return db.Where("email = ?", user.Email)
+		}).
+		AddBatchAction("touch", "Touch", func(res *resource.Resource, ids []string, w http.ResponseWriter, r *http.Request) {
+			batched = ids
+		})
+	db.Create(&Widget{Name: "Mine", Email: "north@example.com"})
+	db.Create(&Widget{Name: "Theirs", Email: "south@example.com"})
+	user := &models.AdminUser{ID: 1, Email: "north@example.com", Role: "admin"}
+
+	w := httptest.NewRecorder()
+	RenderList(reg, res, w, httptest.NewRequest("GET", "/admin/Widget", nil), user)
+	if !strings.Contains(w.Body.String(), "Mine") || strings.Contains(w.Body.String(), "Theirs") {
+		t.Error("List should only show records within the policy")
+	}
+
+	w = httptest.NewRecorder()
+	HandleExport(reg, res, w, httptest.NewRequest("GET", "/admin/Widget/export", nil), user)
+	if strings.Contains(w.Body.String(), "Theirs") {
+		t.Error("Export should only include records within the policy")
+	}
+
+	w = httptest.NewRecorder()
+	HandleBatchAction(reg, res, w, postForm("/admin/Widget/batch_action", url.Values{"action_name": {"touch"}, "ids": {"1", "2"}}), user)
+	if w.Code != http.StatusForbidden || batched != nil {
+		t.Errorf("Batch actions on records outside the policy should be refused, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	HandleSave(reg, res, w, postForm("/admin/Widget/save", url.Values{"ID": {"2"}, "Name": {"Hijacked"}}), user)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Saving a record outside the policy should 404, got %d", w.Code)
+	}
+}
+
+func TestAuditDiff(t *testing.T) {
+	db, reg := setupTestDB()
+	res := reg.Register(Widget{}).
+		RegisterField("ID", "ID", true).
+		RegisterField("Name", "Name", false).
+		RegisterField("Qty", "Quantity", false).
+		SetSensitive("Email").
+		AddMemberAction("bump", "Bump", func(res *resource.Resource, w http.ResponseWriter, r *http.Request) {
+			db.Model(&Widget{}).Where("id = ?", r.URL.Query().Get("id")).Update("qty", gorm.Expr("qty + 1"))
+			http.Redirect(w, r, "/admin/Widget", 303)
+		})
+	db.Create(&Widget{Name: "Gear", Email: "old@example.com", Qty: 1})
+	user := &models.AdminUser{ID: 1, Email: "admin@example.com", Role: "admin"}
+	lastDiff := func() []models.FieldChange {
+		var log models.AuditLog
+		db.Order("id desc").First(&log)
+		return log.Changeset()
+	}
+
+	w := httptest.NewRecorder()
+	HandleSave(reg, res, w, postForm("/admin/Widget/save", url.Values{"ID": {"1"}, "Name": {"Cog"}, "Qty": {"1"}}), user)
+	if w.Code != 303 {
+		t.Fatalf("Expected 303, got %d", w.Code)
+	}
+	if d := lastDiff(); len(d) != 1 || d[0].Field != "Name" || d[0].Old != "Gear" || d[0].New != "Cog" {
+		t.Errorf("Unexpected save diff %+v", d)
+	}
+
+	w = httptest.NewRecorder()
+	HandleCustomAction(reg, res, w, postForm("/admin/Widget/action?name=bump&id=1", nil), user, false)
+	if d := lastDiff(); len(d) != 1 || d[0].Field != "Qty" || d[0].New != float64(2) {
+		t.Errorf("Unexpected action diff %+v", d)
+	}
+
+	w = httptest.NewRecorder()
+	HandleDelete(reg, res, w, postForm("/admin/Widget/delete", url.Values{"id": {"1"}}), user)
+	for _, c := range lastDiff() {
+		if c.New != nil || (c.Field == "Email" && c.Old != resource.Redacted) {
+			t.Errorf("Unexpected delete change %+v", c)
+		}
+	}
+}
+
+func TestSensitiveFields(t *testing.T) {
+	db, reg := setupTestDB()
+	res := reg.Register(Widget{}).
+		RegisterField("ID", "ID", true).
+		RegisterField("Name", "Name", false).
+		RegisterField("Email", "Email", false).
+		SetSensitive("Email")
+	db.Create(&Widget{Name: "Gear", Email: "secret@example.com"})
+	user := &models.AdminUser{ID: 1, Email: "admin@example.com", Role: "admin"}
+	var widget Widget
+	db.First(&widget)
+
+	for name, render := range map[string]func(w http.ResponseWriter){
+		"Show": func(w http.ResponseWriter) {
+			RenderShow(reg, res, &widget, w, httptest.NewRequest("GET", "/admin/Widget/show?id=1", nil), user)
+		},
+		"Edit": func(w http.ResponseWriter) {
+			RenderForm(reg, res, &widget, w, httptest.NewRequest("GET", "/admin/Widget/edit?id=1", nil), user)
+		},
+		"Index": func(w http.ResponseWriter) {
+			RenderList(reg, res, w, httptest.NewRequest("GET", "/admin/Widget", nil), user)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			render(w)
+			if body := w.Body.String(); strings.Contains(body, "secret@example.com") || (name != "Edit" && !strings.Contains(body, resource.Redacted)) {
+				t.Errorf("The sensitive value leaked: %s", body)
+			}
+		})
+	}
+
+	t.Run("EditInput", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		RenderForm(reg, res, &widget, w, httptest.NewRequest("GET", "/admin/Widget/edit?id=1", nil), user)
+		if !strings.Contains(w.Body.String(), `<input type="password" name="Email" value=""`) {
+			t.Error("Expected an empty password input on the edit form")
+		}
+		w = httptest.NewRecorder()
+		RenderForm(reg, res, nil, w, httptest.NewRequest("GET", "/admin/Widget/new", nil), user)
+		if !strings.Contains(w.Body.String(), `<input type="text" name="Email"`) {
+			t.Error("Expected a plain input on the new form")
+		}
+	})
+
+	t.Run("BlankKeeps", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		HandleSave(reg, res, w, postForm("/admin/Widget/save", url.Values{"ID": {"1"}, "Name": {"Cog"}, "Email": {""}}), user)
+		var saved Widget
+		db.First(&saved, 1)
+		if w.Code != 303 || saved.Name != "Cog" || saved.Email != "secret@example.com" {
+			t.Errorf("Expected a blank input to keep the value, got %d %+v", w.Code, saved)
+		}
+		w = httptest.NewRecorder()
+		HandleSave(reg, res, w, postForm("/admin/Widget/save", url.Values{"ID": {"1"}, "Name": {"Cog"}, "Email": {"new@example.com"}}), user)
+		if db.First(&saved, 1); saved.Email != "new@example.com" {
+			t.Errorf("Expected the value to change, got %+v", saved)
+		}
+	})
+}
+
+func TestRevertHistory(t *testing.T) {
+	db, reg := setupTestDB()
+	res := reg.Register(Widget{}).
+		RegisterField("ID", "ID", true).
+		RegisterField("Name", "Name", false).
+		RegisterField("Qty", "Quantity", false)
+	user := &models.AdminUser{ID: 1, Email: "admin@example.com", Role: "admin"}
+	save := func(name string) {
+		w := httptest.NewRecorder()
+		HandleSave(reg, res, w, postForm("/admin/Widget/save", url.Values{"ID": {"1"}, "Name": {name}, "Qty": {"1"}}), user)
+		if w.Code != 303 {
+			t.Fatalf("Save %s: expected 303, got %d", name, w.Code)
+		}
+	}
+	db.Create(&Widget{Name: "Gear", Qty: 1})
+	save("Cog")
+	save("forbidden-ish")
+	var first models.AuditLog
+	db.Order("id").First(&first)
+
+	w := httptest.NewRecorder()
+	item, _ := internal.Get(context.Background(), reg, "Widget", 1, user)
+	RenderShow(reg, res, item, w, httptest.NewRequest("GET", "/admin/Widget/show?id=1&tab=history", nil), user)
+	if body := w.Body.String(); !strings.Contains(body, "/admin/Widget/revert") || strings.Count(body, `name="version"`) != 2 {
+		t.Errorf("History tab should list both versions with restore buttons")
+	}
+
+	w = httptest.NewRecorder()
+	HandleRevert(reg, w, postForm("/admin/Widget/revert", url.Values{"version": {fmt.Sprint(first.ID)}}), user)
+	if w.Code != 303 || w.Header().Get("Location") != "/admin/Widget/show?id=1&tab=history" {
+		t.Fatalf("Expected redirect to history, got %d %s", w.Code, w.Header().Get("Location"))
+	}
+	var widget Widget
+	db.First(&widget, 1)
+	if widget.Name != "Cog" {
+		t.Errorf("Expected revert to Cog, got %q", widget.Name)
+	}
+
+	db.Create(&models.AuditLog{ResourceName: "Widget", RecordID: "1", Snapshot: `{"ID":1,"Name":"forbidden","Qty":1}`})
+	var bad models.AuditLog
+	db.Order("id desc").First(&bad)
+	w = httptest.NewRecorder()
+	HandleRevert(reg, w, postForm("/admin/Widget/revert", url.Values{"version": {fmt.Sprint(bad.ID)}}), user)
+	db.First(&widget, 1)
+	if w.Code != 303 || widget.Name != "Cog" {
+		t.Errorf("Invalid version should be refused, got %d %q", w.Code, widget.Name)
+	}
+
+	w = httptest.NewRecorder()
+	HandleRevert(reg, w, postForm("/admin/Widget/revert", url.Values{"version": {fmt.Sprint(first.ID)}}), &models.AdminUser{Role: "viewer"})
+	if w.Code != 403 {
+		t.Errorf("Expected 403 without revert permission, got %d", w.Code)
+	}
+
+	t.Run("HiddenFields", func(t *testing.T) {
+		logs := reg.Register(models.AuditLog{}).
+			RegisterField("ID", "ID", true).
+			RegisterField("ResourceName", "Resource", true).
+			RegisterField("Diff", "Changes", true)
+		for _, p := range []models.Permission{
+			{Role: "editor", ResourceName: "Widget", Action: "show"},
+			{Role: "editor", ResourceName: "Widget", FieldName: "Name", Action: resource.FieldHidden},
+			{Role: "editor", ResourceName: "AuditLog", Action: "list"},
+		} {
+			db.Create(&p)
+		}
+		editor := &models.AdminUser{ID: 2, Email: "editor@example.com", Role: "editor"}
+
+		w := httptest.NewRecorder()
+		RenderShow(reg, res, item, w, httptest.NewRequest("GET", "/admin/Widget/show?id=1&tab=history", nil), editor)
+		if strings.Contains(w.Body.String(), "Cog") {
+			t.Error("History should not show hidden fields")
+		}
+		w = httptest.NewRecorder()
+		RenderList(reg, logs, w, httptest.NewRequest("GET", "/admin/AuditLog", nil), editor)
+		if body := w.Body.String(); strings.Contains(body, "Cog") || !strings.Contains(body, "Widget") {
+			t.Error("Audit log diffs should not show hidden fields")
+		}
+		entry, _ := internal.Get(context.Background(), reg, "AuditLog", first.ID, editor)
+		if e := entry.(*models.AuditLog); strings.Contains(e.Snapshot, "Cog") || !strings.Contains(e.Snapshot, "Qty") {
+			t.Errorf("Snapshot should drop hidden fields, got %s", e.Snapshot)
+		}
+	})
+}
+
+func TestAuditLogIntegrity(t *testing.T) {
+	db, reg := setupTestDB()
+	res := reg.Register(models.AuditLog{}).SetReadOnly(false).RegisterField("Action", "Action", false)
+	user := &models.AdminUser{ID: 1, Email: "admin@example.com", Role: "admin"}
+	internal.RecordAction(reg, user, "Widget", "1", "Update", "change")
+
+	w := httptest.NewRecorder()
+	HandleSave(reg, res, w, postForm("/admin/AuditLog/save", url.Values{"ID": {"1"}, "Action": {"Forged"}}), user)
+	if w.Code != 403 {
+		t.Errorf("Saving an audit entry should be refused, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	HandleDelete(reg, res, w, postForm("/admin/AuditLog/delete", url.Values{"id": {"1"}}), user)
+	if w.Code != 403 {
+		t.Errorf("Deleting an audit entry should be refused, got %d", w.Code)
+	}
+	req := httptest.NewRequest("DELETE", "/admin/api/AuditLog/1", nil)
+	w = httptest.NewRecorder()
+	HandleAPI(reg, w, req, "/AuditLog/1", user)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("API delete of an audit entry should be refused, got %d", w.Code)
+	}
+	var count int64
+	db.Model(&models.AuditLog{}).Count(&count)
+	if count != 1 {
+		t.Errorf("Audit log should be untouched, got %d entries", count)
+	}
+
+	w = httptest.NewRecorder()
+	HandleAuditVerify(reg, w, httptest.NewRequest("GET", "/admin/audit/verify", nil), user)
+	if w.Code != 200 || !strings.Contains(w.Body.String(), "The audit chain is intact") {
+		t.Errorf("Expected an intact chain report, got %d", w.Code)
+	}
+	db.Model(&models.AuditLog{}).Where("id = 1").Update("action", "Forged")
+	w = httptest.NewRecorder()
+	HandleAuditVerify(reg, w, httptest.NewRequest("GET", "/admin/audit/verify", nil), user)
+	if !strings.Contains(w.Body.String(), "broken at entry") {
+		t.Error("Expected the edited entry to be reported")
+	}
+	w = httptest.NewRecorder()
+	HandleAuditVerify(reg, w, httptest.NewRequest("GET", "/admin/audit/verify", nil), &models.AdminUser{Role: "viewer"})
+	if w.Code != 403 {
+		t.Errorf("Expected 403 without the verify permission, got %d", w.Code)
+	}
+}
+
+func TestImport(t *testing.T) {
+	db, reg := setupTestDB()
+	res := reg.Register(Widget{}).
+		RegisterField("ID", "ID", true).
+		RegisterField("Name", "Name", false).
+		RegisterField("Email", "Email", false).
+		RegisterField("Qty", "Quantity", false).
+		SetImportKey("Email")
+	user := &models.AdminUser{ID: 1, Email: "admin@example.com", Role: "admin"}
+	db.Create(&Widget{Name: "Gear", Email: "g@example.com", Qty: 1})
+	post := func(csv, key string, commit bool) *httptest.ResponseRecorder {
+		form := url.Values{"csv": {csv}, "key": {key}}
+		if commit {
+			form.Set("commit", "1")
+		}
+		w := httptest.NewRecorder()
+		HandleImport(reg, res, w, postForm("/admin/Widget/import", form), user)
+		return w
+	}
+	count := func() int64 {
+		var n int64
+		db.Model(&Widget{}).Count(&n)
+		return n
+	}
+
+	good := "Name,email,Quantity,Colour\nCog,g@example.com,5,red\nSprocket,s@example.com,2,blue\n"
+	w := post(good, "Email", false)
+	if body := w.Body.String(); !strings.Contains(body, "1 to create, 1 to update, 0 with errors") || !strings.Contains(body, "Colour") {
+		t.Errorf("Unexpected preview: %s", body)
+	}
+	if count() != 1 {
+		t.Fatal("Preview must not save anything")
+	}
+	if w := post(good, "Email", true); w.Code != 303 {
+		t.Fatalf("Expected commit to redirect, got %d: %s", w.Code, w.Body.String())
+	}
+	var gear Widget
+	db.First(&gear, "email = ?", "g@example.com")
+	if count() != 2 || gear.Name != "Cog" || gear.Qty != 5 {
+		t.Errorf("Import not applied: %d %+v", count(), gear)
+	}
+	var audited int64
+	db.Model(&models.AuditLog{}).Where("changes = ?", "Imported from CSV").Count(&audited)
+	if audited != 2 {
+		t.Errorf("Expected 2 audit entries, got %d", audited)
+	}
+	if report, _ := internal.VerifyAuditChain(reg); !report.OK() {
+		t.Errorf("Import audit entries should extend the chain: %v", report)
+	}
+
+	bad := "ID,Name,Quantity\n1,Wheel,lots\n99,Axle,1\n,forbidden,1\n"
+	w = post(bad, "ID", true)
+	body := w.Body.String()
+	if !strings.Contains(body, "Nothing was imported") || !strings.Contains(body, "0 to create, 0 to update, 3 with errors") {
+		t.Errorf("Invalid rows should block the import: %s", body)
+	}
+	for _, want := range []string{"Qty: must be a whole number", "ID: does not match an existing record", "this name is reserved"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Preview missing %q", want)
+		}
+	}
+	db.First(&gear, 1)
+	if gear.Name != "Cog" || count() != 2 {
+		t.Error("A refused import must not change anything")
+	}
+
+	db.Create(&models.Permission{Role: "importer", ResourceName: "Widget", Action: "import"})
+	w = httptest.NewRecorder()
+	HandleImport(reg, res, w, postForm("/admin/Widget/import", url.Values{"csv": {good}, "commit": {"1"}}), &models.AdminUser{ID: 3, Role: "importer"})
+	if w.Code != 403 {
+		t.Errorf("Import without the save permission should be refused, got %d", w.Code)
+	}
+
+	var flag bool
+	for val, want := range map[string]bool{"TRUE": true, "False": false, "1": true, "on": true, "": false} {
+		if msg := coerce(reflect.ValueOf(&flag).Elem(), val); msg != "" || flag != want {
+			t.Errorf("coerce(%q) = %v %q, want %v", val, flag, msg, want)
+		}
+	}
+	if msg := coerce(reflect.ValueOf(&flag).Elem(), "maybe"); msg == "" {
+		t.Error("Unrecognised booleans should be rejected")
+	}
+}
+
+func TestExport(t *testing.T) {
+	db, reg := setupTestDB()
+	res := reg.Register(Widget{}).
+		RegisterField("ID", "ID", true).
+		RegisterField("Name", "Name", false).
+		RegisterField("Email", "Email", false).
+		RegisterField("Qty", "Quantity", false).
+		SetIndexFields("ID", "Name", "Qty").
+		SetDecorator("Name", func(val interface{}) template.HTML {
+			return template.HTML("<b>" + template.HTMLEscapeString(val.(string)) + " &amp; co</b>")
+		}).
+		AddScope("big", "Big", func(db *gorm.DB) *gorm.DB { return db.Where("qty >= ?", 600) })
+	user := &models.AdminUser{ID: 1, Email: "admin@example.com", Role: "admin"}
+	var widgets []Widget
+	for i := 1; i <= 1200; i++ {
+		widgets = append(widgets, Widget{Name: fmt.Sprintf("W%04d", i), Email: fmt.Sprintf("w%d@example.com", i), Qty: i})
+	}
+	db.CreateInBatches(widgets, 200)
+	export := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		HandleExport(reg, res, w, httptest.NewRequest("GET", "/admin/Widget/export?"+query, nil), user)
+		return w
+	}
+
+	t.Run("CSV", func(t *testing.T) {
+		w := export("")
+		rows, err := csv.NewReader(w.Body).ReadAll()
+		if err != nil || len(rows) != 1201 {
+			t.Fatalf("Expected a header and 1200 rows, got %d (%v)", len(rows), err)
+		}
+		if strings.Join(rows[0], ",") != "ID,Name,Quantity" || strings.Join(rows[1], ",") != "1,W0001,1" || rows[1200][0] != "1200" {
+			t.Errorf("Unexpected rows: %v %v %v", rows[0], rows[1], rows[1200])
+		}
+		if w.Header().Get("Content-Disposition") != "attachment;filename=Widget_export.csv" {
+			t.Errorf("Unexpected headers: %v", w.Header())
+		}
+	})
+
+	t.Run("ListQuery", func(t *testing.T) {
+		rows, _ := csv.NewReader(export("scope=big&q_Name=W1&min_Qty=1050&sort=Qty&order=desc").Body).ReadAll()
+		if len(rows) != 152 || rows[1][0] != "1200" || rows[151][0] != "1050" {
+			t.Fatalf("Export should follow scope, filters and sort, got %d rows", len(rows))
+		}
+		for i := 2; i < len(rows); i++ {
+			if rows[i-1][0] <= rows[i][0] {
+				t.Fatalf("Rows out of order at %d: %v %v", i, rows[i-1], rows[i])
+			}
+		}
+		rows, _ = csv.NewReader(export("ids=7&ids=3&ids=999").Body).ReadAll()
+		if len(rows) != 4 || rows[1][0] != "3" || rows[3][0] != "999" {
+			t.Errorf("Export should be limited to the selected ids: %v", rows)
+		}
+	})
+
+	t.Run("Decorate", func(t *testing.T) {
+		rows, _ := csv.NewReader(export("ids=5&decorate=1").Body).ReadAll()
+		if len(rows) != 2 || rows[1][1] != "W0005 & co" {
+			t.Errorf("Decorators should be applied as plain text: %v", rows)
+		}
+	})
+
+	t.Run("JSONL", func(t *testing.T) {
+		w := export("format=jsonl&max_Qty=2")
+		lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+		if len(lines) != 2 || lines[0] != `{"ID":1,"Name":"W0001","Qty":1}` {
+			t.Errorf("Unexpected JSON Lines: %q", lines)
+		}
+		if w.Header().Get("Content-Type") != "application/x-ndjson" {
+			t.Errorf("Unexpected content type %q", w.Header().Get("Content-Type"))
+		}
+	})
+
+	t.Run("XLSX", func(t *testing.T) {
+		body := export("format=xlsx&max_Qty=600").Body.Bytes()
+		zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+		if err != nil {
+			t.Fatalf("Export is not a zip file: %v", err)
+		}
+		parts := map[string]string{}
+		for _, f := range zr.File {
+			rc, _ := f.Open()
+			b, _ := io.ReadAll(rc)
+			_ = rc.Close()
+			parts[f.Name] = string(b)
+		}
+		for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels"} {
+			if err := xml.Unmarshal([]byte(parts[name]), new(struct{})); err != nil {
+				t.Errorf("%s is not valid XML: %v", name, err)
+			}
+		}
+		var sheet struct {
+			Rows []struct {
+				Cells []struct {
+					Ref    string `xml:"r,attr"`
+					Type   string `xml:"t,attr"`
+					Value  string `xml:"v"`
+					Inline string `xml:"is>t"`
+				} `xml:"c"`
+			} `xml:"sheetData>row"`
+		}
+		if err := xml.Unmarshal([]byte(parts["xl/worksheets/sheet1.xml"]), &sheet); err != nil {
+			t.Fatalf("Sheet is not valid XML: %v", err)
+		}
+		if len(sheet.Rows) != 601 {
+			t.Fatalf("Expected 601 rows, got %d", len(sheet.Rows))
+		}
+		header, last := sheet.Rows[0].Cells, sheet.Rows[600].Cells
+		if header[2].Inline != "Quantity" || last[1].Ref != "B601" || last[1].Inline != "W0600" || last[2].Type != "" || last[2].Value != "600" {
+			t.Errorf("Unexpected cells: %+v %+v", header, last)
+		}
+	})
+
+	t.Run("UnknownFormat", func(t *testing.T) {
+		if w := export("format=pdf"); w.Code != 400 {
+			t.Errorf("Expected 400, got %d", w.Code)
+		}
+	})
+
+	t.Run("PostedIDs", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		HandleExport(reg, res, w, postForm("/admin/Widget/export?sort=Qty&order=desc", url.Values{"ids": {"3", "7"}, "format": {"csv"}}), user)
+		rows, _ := csv.NewReader(w.Body).ReadAll()
+		if len(rows) != 3 || rows[1][0] != "7" || rows[2][0] != "3" {
+			t.Errorf("Export should be limited to the posted ids: %v", rows)
+		}
+	})
+
+	t.Run("Sensitive", func(t *testing.T) {
+		res.SetSensitive("Name")
+		defer func() { res.SensitiveFields = nil }()
+		for _, format := range []string{"csv", "jsonl"} {
+			body := export("format=" + format + "&min_Qty=1&max_Qty=1").Body.String()
+			if strings.Contains(body, "W0001") || !strings.Contains(body, resource.Redacted) {
+				t.Errorf("%s: sensitive values should be masked, got %s", format, body)
+			}
+		}
+	})
+
+	t.Run("FormulaCells", func(t *testing.T) {
+		db.Create(&Widget{Name: "=HYPERLINK(\"http://evil\")", Email: "f@example.com", Qty: -5})
+		rows, _ := csv.NewReader(export("q_Email=f@example.com").Body).ReadAll()
+		if len(rows) != 2 || rows[1][1] != `'=HYPERLINK("http://evil")` || rows[1][2] != "-5" {
+			t.Errorf("Text that looks like a formula should be quoted, numbers left alone: %v", rows)
+		}
+	})
+
+	t.Run("AbortOnFailure", func(t *testing.T) {
+		loads := 0
+		res.AfterLoad(func(hc *resource.HookContext, item interface{}) error {
+			if loads++; loads > exportBatchSize {
+				return errors.New("boom")
+			}
+			return nil
+		})
+		defer func() { res.Hooks.AfterLoad = nil }()
+		defer func() {
+			if p := recover(); p != http.ErrAbortHandler {
+				t.Errorf("A failure after the response started should abort it, got %v", p)
+			}
+		}()
+		export("")
+	})
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-packs/go-admin"
+	"github.com/go-packs/go-admin/internal"
+	"github.com/go-packs/go-admin/models"
+	"github.com/go-packs/go-admin/resource"
+	"github.com/go-packs/go-admin/view"
+	"gorm.io/gorm"
+)
+
+// historyLimit caps the number of versions listed on the History tab.
+const historyLimit = 50
+
+// HandleRevert restores a record to the audit entry posted as "version" and
+// redirects to the record's History tab.
+func HandleRevert(reg *admin.Registry, w http.ResponseWriter, r *http.Request, user *models.AdminUser) {
+	revert(reg, w, r, user, r.FormValue("version"))
+}
+
+// RevertAction is a member action for the AuditLog resource that restores the
+// audited record to the version stored with the entry:
+//
+//	adm.Register(admin.AuditLog{}).AddMemberAction("revert", "Revert to this version", handlers.RevertAction(adm))
+func RevertAction(reg *admin.Registry) resource.ActionHandler {
+	return func(res *resource.Resource, w http.ResponseWriter, r *http.Request) {
+		user, _ := internal.GetUserFromRequest(reg, r)
+		revert(reg, w, r, user, r.URL.Query().Get("id"))
+	}
+}
+
+func revert(reg *admin.Registry, w http.ResponseWriter, r *http.Request, user *models.AdminUser, versionID string) {
+	if user == nil {
+		http.Error(w, "Unauthorized", 401)
+		return
+	}
+	res, id, err := internal.Revert(r.Context(), reg, versionID, user)
+	switch {
+	case err == nil:
+		reg.SetFlash(w, fmt.Sprintf("%s #%s reverted to version #%s", res.Name, id, versionID))
+		http.Redirect(w, r, fmt.Sprintf("/admin/%s/show?id=%s&tab=history", res.Name, id), 303)
+	case errors.Is(err, internal.ErrForbidden):
+		http.Error(w, "Forbidden", 403)
+	case errors.Is(err, gorm.ErrRecordNotFound) || res == nil:
+		http.NotFound(w, r)
+	default:
+		reg.SetFlash(w, fmt.Sprintf("Could not revert %s #%s: %v", res.Name, id, err))
+		back := "/admin/" + res.Name
+		if _, gerr := internal.Get(r.Context(), reg, res.Name, id, user); gerr == nil {
+			back = fmt.Sprintf("/admin/%s/show?id=%s&tab=history", res.Name, id)
+		}
+		http.Redirect(w, r, back, 303)
+	}
+}
+
+// loadHistory returns the audited versions of the record id of res, newest
+// first, without the fields hidden by fr. Revert buttons are offered when
+// canRevert is set.
+func loadHistory(reg *admin.Registry, res *resource.Resource, id interface{}, fr resource.FieldRestrictions, canRevert bool) []view.VersionData {
+	var logs []models.AuditLog
+	reg.DB.Where("resource_name = ? AND record_id = ?", res.Name, fmt.Sprint(id)).Order("id desc").Limit(historyLimit).Find(&logs)
+	versions := make([]view.VersionData, len(logs))
+	for i, l := range logs {
+		revertible := l.Snapshot != ""
+		internal.RedactAuditLog(&l, fr)
+		versions[i] = view.VersionData{Log: l, Changes: view.DiffTable(l.Diff), CanRevert: canRevert && revertible}
+	}
+	return versions
+}
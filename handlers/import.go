@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/go-packs/go-admin"
+	"github.com/go-packs/go-admin/internal"
+	"github.com/go-packs/go-admin/models"
+	"github.com/go-packs/go-admin/resource"
+	"github.com/go-packs/go-admin/view"
+)
+
+// MaxImportSize caps the size of an uploaded CSV file. The router applies it
+// before the CSRF check reads the form.
+const MaxImportSize = 10 << 20
+
+// HandleImport serves the CSV import of res. GET shows the upload form. POST
+// previews the creates, updates and errors planned for each row, or, when
+// "commit" is set and every row is valid, saves all rows in one transaction.
+func HandleImport(reg *admin.Registry, res *resource.Resource, w http.ResponseWriter, r *http.Request, user *models.AdminUser) {
+	// Imports create and update records, so they need the save permission too.
+	if !internal.Can(reg, user, res.Name, "save") {
+		http.Error(w, "Forbidden", 403)
+		return
+	}
+	keys := importKeys(res)
+	if r.Method != "POST" {
+		renderImport(reg, res, w, r, user, &view.ImportData{Key: keys[0], Keys: keys}, "")
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, MaxImportSize)
+	if err := r.ParseMultipartForm(MaxImportSize); err != nil && err != http.ErrNotMultipart {
+		renderImport(reg, res, w, r, user, &view.ImportData{Key: keys[0], Keys: keys}, "The file is too large or could not be read")
+		return
+	}
+	data := r.FormValue("csv")
+	if file, _, err := r.FormFile("file"); err == nil {
+		b, rerr := io.ReadAll(file)
+		_ = file.Close()
+		if rerr != nil {
+			renderImport(reg, res, w, r, user, &view.ImportData{Key: keys[0], Keys: keys}, "The file could not be read")
+			return
+		}
+		data = string(b)
+	}
+	key := keys[0]
+	for _, k := range keys {
+		if k == r.FormValue("key") {
+			key = k
+		}
+	}
+
+	plan, err := planImport(reg, res, user, data, key)
+	if err != nil {
+		renderImport(reg, res, w, r, user, &view.ImportData{Key: key, Keys: keys}, err.Error())
+		return
+	}
+	plan.data.Keys = keys
+	if r.FormValue("commit") == "" {
+		renderImport(reg, res, w, r, user, plan.data, "")
+		return
+	}
+	if plan.data.Failed > 0 {
+		renderImport(reg, res, w, r, user, plan.data, "Nothing was imported. Fix the rows with errors and upload the file again.")
+		return
+	}
+	if err := internal.SaveBatch(r.Context(), reg, res, plan.changes, user, "Imported from CSV"); err != nil {
+		msg := fmt.Sprintf("Nothing was imported: %v", err)
+		if errors.Is(err, internal.ErrForbidden) {
+			msg = "Nothing was imported: some records would fall outside your access policy"
+		}
+		renderImport(reg, res, w, r, user, plan.data, msg)
+		return
+	}
+	reg.SetFlash(w, fmt.Sprintf("Imported %s: %d created, %d updated", res.Name, plan.data.Creates, plan.data.Updates))
+	http.Redirect(w, r, "/admin/"+res.Name, 303)
+}
+
+// importKeys lists the fields imports can match existing records on.
+func importKeys(res *resource.Resource) []string {
+	if res.ImportKey != "" && res.ImportKey != "ID" {
+		return []string{"ID", res.ImportKey}
+	}
+	return []string{"ID"}
+}
+
+type importPlan struct {
+	data    *view.ImportData
+	changes []internal.Change
+}
+
+// planImport parses data and works out, without saving anything, what each
+// row would do. Columns are matched to the fields user may see by label or
+// name; rows are matched to existing records by key.
+func planImport(reg *admin.Registry, res *resource.Resource, user *models.AdminUser, data, key string) (*importPlan, error) {
+	rd := csv.NewReader(strings.NewReader(strings.TrimPrefix(data, "\ufeff")))
+	rd.FieldsPerRecord = -1
+	header, err := rd.Read()
+	if err == io.EOF {
+		return nil, errors.New("The file is empty")
+	} else if err != nil {
+		return nil, fmt.Errorf("Could not read the header row: %v", err)
+	}
+
+	fields := internal.FieldRestrictions(reg, user, res.Name).Apply(res.Fields)
+	plan := &importPlan{data: &view.ImportData{CSV: data, Key: key}}
+	cols := make([]*resource.Field, len(header))
+	keyCol, mapped := -1, make(map[string]string)
+	for i, h := range header {
+		h = strings.TrimSpace(h)
+		f := matchImportField(fields, h)
+		switch {
+		case f == nil:
+			plan.data.Ignored = append(plan.data.Ignored, h)
+			continue
+		case mapped[f.Name] != "":
+			return nil, fmt.Errorf("Columns %q and %q both map to %s", mapped[f.Name], h, f.Label)
+		}
+		mapped[f.Name] = h
+		if f.Name == key {
+			keyCol = i
+		}
+		if f.Readonly || f.Type == "image" || f.Type == "file" {
+			if f.Name != key {
+				plan.data.Ignored = append(plan.data.Ignored, h+" (read-only)")
+			}
+			continue
+		}
+		cols[i] = f
+		plan.data.Columns = append(plan.data.Columns, f.Label)
+	}
+	if len(plan.data.Columns) == 0 {
+		return nil, fmt.Errorf("None of the columns match a field of %s", res.Name)
+	}
+	if keyCol < 0 && key != "ID" {
+		return nil, fmt.Errorf("The file has no %s column to match records on", key)
+	}
+	keyColumn, ok := internal.ColumnName(reg, res, key)
+	if !ok {
+		return nil, fmt.Errorf("%s has no %s column", res.Name, key)
+	}
+
+	modelType := reflect.TypeOf(res.Model)
+	seenKeys := make(map[string]int)
+	for {
+		rec, err := rd.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			row := view.ImportRow{Action: "error", Error: fmt.Sprintf("could not be parsed: %v", err)}
+			var perr *csv.ParseError
+			if errors.As(err, &perr) {
+				row.Line = perr.StartLine
+			}
+			plan.data.Rows = append(plan.data.Rows, row)
+			plan.data.Failed++
+			continue
+		}
+		line, _ := rd.FieldPos(0)
+		row := view.ImportRow{Line: line}
+		if strings.TrimSpace(strings.Join(rec, "")) == "" {
+			continue
+		}
+
+		errs := resource.ValidationErrors{}
+		model := reflect.New(modelType).Interface()
+		var before map[string]interface{}
+		if keyCol >= 0 && keyCol < len(rec) {
+			row.ID = strings.TrimSpace(rec[keyCol])
+		}
+		if row.ID != "" {
+			if prev, dup := seenKeys[row.ID]; dup {
+				errs.Add(resource.BaseError, fmt.Sprintf("repeats the %s of line %d", key, prev))
+			}
+			seenKeys[row.ID] = line
+			existing := reflect.New(modelType).Interface()
+			if q := res.ApplyPolicy(reg.DB, user).Where(keyColumn+" = ?", row.ID).Limit(1).Find(existing); q.Error != nil {
+				errs.Add(resource.BaseError, q.Error.Error())
+			} else if q.RowsAffected > 0 {
+				if !res.CanEdit(existing, user) {
+					errs.Add(resource.BaseError, "you are not allowed to edit this record")
+				}
+				model, before = existing, res.Snapshot(existing)
+			} else if key == "ID" {
+				errs.Add("ID", "does not match an existing record")
+			}
+		}
+
+		elem := reflect.ValueOf(model).Elem()
+		for i, f := range cols {
+			if f == nil {
+				continue
+			}
+			val := ""
+			if i < len(rec) {
+				val = rec[i]
+			}
+			row.Values = append(row.Values, val)
+			field := elem.FieldByName(f.Name)
+			if !field.IsValid() || !field.CanSet() {
+				continue
+			}
+			if field.Kind() != reflect.String {
+				val = strings.TrimSpace(val)
+			}
+			if msg := coerce(field, val); msg != "" {
+				errs.Add(f.Name, msg)
+			}
+		}
+		errs.Merge(res.Validate(model))
+
+		switch {
+		case len(errs) > 0:
+			row.Action, row.Error = "error", errs.Error()
+			plan.data.Failed++
+		case before == nil:
+			row.Action = "create"
+			plan.data.Creates++
+		default:
+			row.Action = "update"
+			plan.data.Updates++
+		}
+		if len(errs) == 0 {
+			plan.changes = append(plan.changes, internal.Change{Model: model, Before: before})
+		}
+		plan.data.Rows = append(plan.data.Rows, row)
+	}
+	if len(plan.data.Rows) == 0 {
+		return nil, errors.New("The file has no data rows")
+	}
+	return plan, nil
+}
+
+// matchImportField finds the field whose label or name equals header, ignoring case.
+func matchImportField(fields []resource.Field, header string) *resource.Field {
+	for i := range fields {
+		if strings.EqualFold(fields[i].Label, header) || strings.EqualFold(fields[i].Name, header) {
+			return &fields[i]
+		}
+	}
+	return nil
+}
+
+func renderImport(reg *admin.Registry, res *resource.Resource, w http.ResponseWriter, r *http.Request, user *models.AdminUser, data *view.ImportData, errorMsg string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	styleContent, _ := admin.TemplateFS.ReadFile("templates/style.css")
+	tmpl := view.LoadTemplates("templates/import.html")
+	pd := view.PageData{
+		SiteTitle: reg.Config.SiteTitle, Resources: reg.Resources, GroupedResources: reg.GetGroupedResources(), GroupedPages: reg.GetGroupedPages(),
+		CurrentResource: res, User: user, CSRFToken: user.CSRFToken, CSS: template.CSS(styleContent), Flash: reg.GetFlash(w, r),
+		Error: errorMsg, Import: data,
+	}
+	if err := tmpl.ExecuteTemplate(w, "import.html", pd); err != nil {
+		http.Error(w, "Template error", 500)
+		return
+	}
+}
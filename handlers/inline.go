@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-packs/go-admin"
+	"github.com/go-packs/go-admin/internal"
+	"github.com/go-packs/go-admin/models"
+	"github.com/go-packs/go-admin/resource"
+	"github.com/go-packs/go-admin/view"
+	"gorm.io/gorm"
+)
+
+// inlineFields returns the fields edited in the rows of the inline HasMany
+// association assoc: the child's edit fields without the foreign key, which
+// is set from the parent.
+func inlineFields(reg *admin.Registry, childRes *resource.Resource, assoc resource.Association, user *models.AdminUser) []resource.Field {
+	return slices.DeleteFunc(slices.Clone(childRes.GetFieldsFor("edit", internal.FieldRestrictions(reg, user, childRes.Name))), func(f resource.Field) bool {
+		return f.Name == assoc.ForeignKey || f.Name == "ID"
+	})
+}
+
+// inlinePrefix names the inputs of row i of assoc.
+func inlinePrefix(assoc resource.Association, i string) string {
+	return fmt.Sprintf("%s[%s].", assoc.Name, i)
+}
+
+// inlineIndexes returns the row indexes submitted for assoc, in order.
+func inlineIndexes(assoc resource.Association, r *http.Request) []int {
+	var idx []int
+	for key := range r.PostForm {
+		rest, ok := strings.CutPrefix(key, assoc.Name+"[")
+		if !ok {
+			continue
+		}
+		end := strings.Index(rest, "].")
+		if end < 0 {
+			continue
+		}
+		if i, err := strconv.Atoi(rest[:end]); err == nil && i >= 0 && !slices.Contains(idx, i) {
+			idx = append(idx, i)
+		}
+	}
+	slices.Sort(idx)
+	return idx
+}
+
+// bindInline reads the rows submitted for the inline HasMany association
+// assoc of the record parentID, empty for a new record. Rows are numbered in
+// submission order, and the errors of row i are keyed by
+// "<association>[<i>].<field>". Existing children are loaded within the
+// child's policy; the error is gorm.ErrRecordNotFound for one that is not a
+// child of the record and internal.ErrForbidden when the user may not edit
+// or delete it.
+func bindInline(reg *admin.Registry, assoc resource.Association, parentID string, r *http.Request, user *models.AdminUser) ([]internal.Child, []view.InlineRow, resource.ValidationErrors, error) {
+	childRes, _ := reg.GetResource(assoc.ResourceName)
+	fields := inlineFields(reg, childRes, assoc, user)
+	canDelete := internal.Can(reg, user, childRes.Name, "delete")
+	var children []internal.Child
+	var rows []view.InlineRow
+	errs := resource.ValidationErrors{}
+	for _, idx := range inlineIndexes(assoc, r) {
+		prefix := inlinePrefix(assoc, strconv.Itoa(idx))
+		id, destroy := r.PostForm.Get(prefix+"ID"), r.PostForm.Get(prefix+"_destroy") == "1"
+		child := internal.Child{Model: reflect.New(reflect.TypeOf(childRes.Model)).Interface(), Delete: destroy}
+		if id != "" {
+			if parentID == "" {
+				return nil, nil, nil, gorm.ErrRecordNotFound
+			}
+			item, err := internal.Find(reg, childRes.Name, id, user)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			if fk := reflect.Indirect(reflect.ValueOf(item)).FieldByName(assoc.ForeignKey); !fk.IsValid() || fmt.Sprint(fk.Interface()) != parentID {
+				return nil, nil, nil, gorm.ErrRecordNotFound
+			}
+			if (destroy && !(canDelete && childRes.CanDelete(item, user))) || !childRes.CanEdit(item, user) {
+				return nil, nil, nil, internal.ErrForbidden
+			}
+			child.Model, child.Before = item, childRes.Snapshot(item)
+		} else if destroy {
+			continue
+		}
+		pos := len(rows)
+		row := view.InlineRow{Prefix: inlinePrefix(assoc, strconv.Itoa(pos)), Fields: fields, Destroy: destroy, CanDelete: canDelete}
+		var raw map[string]string
+		if !destroy {
+			var rowErrs resource.ValidationErrors
+			raw, rowErrs = bindFields(reg, fields, reflect.ValueOf(child.Model).Elem(), r, prefix)
+			rowErrs.Merge(childRes.Validate(child.Model))
+			for name, msg := range rowErrs {
+				errs.Add(row.Prefix+name, msg)
+			}
+			row.Errors = rowErrs
+		}
+		row.Values = view.ItemToMap(childRes, fields, reflect.ValueOf(child.Model))
+		for name, val := range raw {
+			row.Values[name] = val
+		}
+		children = append(children, child)
+		rows = append(rows, row)
+	}
+	return children, rows, errs, nil
+}
+
+// inlineData describes the rows of the inline HasMany association assoc on
+// the form of item: the submitted rows when a save was rejected, and
+// otherwise the children of item within the child's policy.
+func inlineData(reg *admin.Registry, assoc resource.Association, item interface{}, errs resource.ValidationErrors, r *http.Request, user *models.AdminUser) *view.AssociationData {
+	childRes, _ := reg.GetResource(assoc.ResourceName)
+	fields := inlineFields(reg, childRes, assoc, user)
+	canDelete := internal.Can(reg, user, childRes.Name, "delete")
+	data := &view.AssociationData{
+		Resource: childRes, Label: assoc.Label, Fields: fields, Inline: true,
+		Blank: view.InlineRow{Prefix: inlinePrefix(assoc, "__INDEX__"), Fields: fields, Values: view.ItemToMap(childRes, fields, reflect.New(reflect.TypeOf(childRes.Model))), CanDelete: true},
+	}
+	var parentID string
+	if item != nil {
+		if id := reflect.Indirect(reflect.ValueOf(item)).FieldByName("ID"); !id.IsZero() {
+			parentID = fmt.Sprint(id.Interface())
+		}
+	}
+	if r.Method == "POST" {
+		_, rows, _, err := bindInline(reg, assoc, parentID, r, user)
+		if err == nil {
+			data.Rows = rows
+			for i := range data.Rows {
+				// Errors raised while saving, such as an aborting hook, are
+				// only known to the caller.
+				if msg, ok := errs[data.Rows[i].Prefix+resource.BaseError]; ok {
+					if data.Rows[i].Errors == nil {
+						data.Rows[i].Errors = map[string]string{}
+					}
+					data.Rows[i].Errors[resource.BaseError] = msg
+				}
+			}
+			return data
+		}
+	}
+	if parentID == "" {
+		return data
+	}
+	col, ok := internal.ColumnName(reg, childRes, assoc.ForeignKey)
+	if !ok {
+		return data
+	}
+	dest := reflect.New(reflect.SliceOf(reflect.TypeOf(childRes.Model)))
+	childRes.ApplyPolicy(reg.DB, user).Where(col+" = ?", parentID).Order("id").Find(dest.Interface())
+	items := dest.Elem()
+	for i := 0; i < items.Len(); i++ {
+		data.Rows = append(data.Rows, view.InlineRow{
+			Prefix: inlinePrefix(assoc, strconv.Itoa(i)), Fields: fields, Values: view.ItemToMap(childRes, fields, items.Index(i)),
+			CanDelete: canDelete && childRes.CanDelete(items.Index(i).Addr().Interface(), user),
+		})
+	}
+	return data
+}
+
+// childErrorMessage describes why a child could not be saved or deleted.
+func childErrorMessage(err error) string {
+	if msg, ok := resource.AbortMessage(err); ok {
+		return msg
+	}
+	if errors.Is(err, internal.ErrForbidden) {
+		return "You are not allowed to change this row: it falls outside your access policy"
+	}
+	return err.Error()
+}
+
+// recordChildren audits the inline children saved with a record of res. Rows
+// saved without a change are skipped.
+func recordChildren(reg *admin.Registry, res *resource.Resource, children map[string][]internal.Child, user *models.AdminUser) {
+	for _, assoc := range res.Associations {
+		childRes, ok := reg.GetResource(assoc.ResourceName)
+		if !ok {
+			continue
+		}
+		summary := fmt.Sprintf("Saved from %s form", res.Name)
+		for _, c := range children[assoc.Name] {
+			id := fmt.Sprint(reflect.Indirect(reflect.ValueOf(c.Model)).FieldByName("ID").Interface())
+			switch {
+			case c.Delete:
+				internal.RecordChange(reg, user, childRes, id, "Delete", summary, c.Before, nil)
+			case c.Before == nil:
+				internal.RecordChange(reg, user, childRes, id, "Create", summary, nil, childRes.Snapshot(c.Model))
+			default:
+				if after := childRes.Snapshot(c.Model); len(childRes.Diff(c.Before, after)) > 0 {
+					internal.RecordChange(reg, user, childRes, id, "Update", summary, c.Before, after)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-packs/go-admin"
+	"github.com/go-packs/go-admin/internal"
+	"github.com/go-packs/go-admin/models"
+	"github.com/go-packs/go-admin/resource"
+	"github.com/go-packs/go-admin/view"
+	"gorm.io/gorm"
+)
+
+// jobPayload holds the arguments of the built-in job kinds.
+type jobPayload struct {
+	Action string   `json:"action,omitempty"`
+	IDs    []string `json:"ids,omitempty"`
+	// Query is the list query of an export.
+	Query string `json:"query,omitempty"`
+}
+
+// enqueueJob queues a job for user and sends them to the Jobs page.
+func enqueueJob(reg *admin.Registry, res *resource.Resource, w http.ResponseWriter, r *http.Request, user *models.AdminUser, kind, label string, payload jobPayload) {
+	job, err := internal.EnqueueJob(reg, user, kind, res.Name, label, payload)
+	if err != nil {
+		http.Error(w, "Could not queue the job", 500)
+		return
+	}
+	reg.SetFlash(w, fmt.Sprintf("%s queued as job #%d", label, job.ID))
+	http.Redirect(w, r, "/admin/jobs", 303)
+}
+
+// RunJob executes a claimed job as its owner, who must still have the
+// permission for its kind, and returns the job result.
+// Actions run their handler against a recorded response: error statuses fail
+// the job and a flash message becomes the result. Exports are written to a
+// file in Config.JobDir. ctx is passed to the handler through its request,
+// with ReportProgress updating the job.
+func RunJob(ctx context.Context, reg *admin.Registry, job *models.Job) (string, error) {
+	var user models.AdminUser
+	if err := reg.DB.First(&user, job.UserID).Error; err != nil {
+		return "", fmt.Errorf("job owner %d: %w", job.UserID, err)
+	}
+	res, ok := reg.GetResource(job.ResourceName)
+	if !ok {
+		return "", fmt.Errorf("unknown resource %s", job.ResourceName)
+	}
+	// The owner's role may have changed since the job was queued.
+	if !internal.Can(reg, &user, res.Name, job.Kind) {
+		return "", internal.ErrForbidden
+	}
+	var p jobPayload
+	if err := json.Unmarshal([]byte(job.Payload), &p); err != nil {
+		return "", fmt.Errorf("job payload: %w", err)
+	}
+	last := -1
+	ctx = admin.WithProgress(ctx, func(done, total int) {
+		if total > 0 && done*100/total != last {
+			last = done * 100 / total
+			_ = internal.SetJobProgress(reg, job.ID, done, total)
+		}
+	})
+
+	switch job.Kind {
+	case "batch_action":
+		for _, a := range res.BatchActions {
+			if a.Name == p.Action {
+				if _, err := internal.AuthorizeBatch(reg, res, p.IDs, &user, a.Deletes); err != nil {
+					return "", internal.ErrForbidden
+				}
+				form := url.Values{"action_name": {a.Name}, "ids": p.IDs}
+				r, _ := http.NewRequestWithContext(ctx, "POST", "/admin/"+res.Name+"/batch_action", strings.NewReader(form.Encode()))
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				w := &jobResponse{header: http.Header{}, body: &bytes.Buffer{}}
+				sw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+				runAudited(reg, res, &user, p.IDs, a.Label, func() int {
+					a.Handler(res, p.IDs, sw, r)
+					return sw.code
+				})
+				return w.outcome()
+			}
+		}
+	case "collection_action":
+		for _, a := range res.CollectionActions {
+			if a.Name == p.Action {
+				r, _ := http.NewRequestWithContext(ctx, "POST", "/admin/"+res.Name+"/collection_action?name="+url.QueryEscape(a.Name), nil)
+				w := &jobResponse{header: http.Header{}, body: &bytes.Buffer{}}
+				sw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+				runAudited(reg, res, &user, nil, a.Label, func() int {
+					a.Handler(res, sw, r)
+					return sw.code
+				})
+				return w.outcome()
+			}
+		}
+	case "export":
+		return runExportJob(ctx, reg, res, job, &user, p.Query)
+	default:
+		return "", fmt.Errorf("unknown job kind %s", job.Kind)
+	}
+	return "", fmt.Errorf("%s has no action %s", res.Name, p.Action)
+}
+
+// runExportJob writes the export described by query to the job's file and
+// returns the file name offered for download.
+func runExportJob(ctx context.Context, reg *admin.Registry, res *resource.Resource, job *models.Job, user *models.AdminUser, query string) (string, error) {
+	if err := os.MkdirAll(reg.Config.JobDir, 0750); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(reg.Config.JobDir, ".job-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+	r, _ := http.NewRequestWithContext(ctx, "GET", "/admin/"+res.Name+"/export?"+query, nil)
+	w := &jobResponse{header: http.Header{}, body: f}
+	aborted := func() (aborted bool) {
+		defer func() {
+			if p := recover(); p == http.ErrAbortHandler {
+				aborted = true
+			} else if p != nil {
+				panic(p)
+			}
+		}()
+		HandleExport(reg, res, w, r, user)
+		return false
+	}()
+	if cerr := f.Close(); cerr != nil && w.code < 400 && !aborted {
+		return "", cerr
+	}
+	if aborted {
+		return "", errors.New("the export failed partway through")
+	}
+	if w.code >= 400 {
+		return "", errors.New("the export failed")
+	}
+	name := strings.TrimPrefix(w.header.Get("Content-Disposition"), "attachment;filename=")
+	if err := os.Rename(f.Name(), jobFile(reg, job.ID, name)); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// jobFile is where the file produced by job id is kept.
+func jobFile(reg *admin.Registry, id uint, name string) string {
+	return filepath.Join(reg.Config.JobDir, fmt.Sprintf("job-%d%s", id, filepath.Ext(name)))
+}
+
+// jobResponse is the ResponseWriter handlers write to inside a job.
+type jobResponse struct {
+	header http.Header
+	code   int
+	body   io.Writer
+}
+
+func (j *jobResponse) Header() http.Header { return j.header }
+
+func (j *jobResponse) WriteHeader(code int) {
+	if j.code == 0 {
+		j.code = code
+	}
+}
+
+func (j *jobResponse) Write(b []byte) (int, error) {
+	j.WriteHeader(http.StatusOK)
+	return j.body.Write(b)
+}
+
+// outcome turns the response of an action into a job result: its flash
+// message, or "Done". Error statuses fail the job with the response body.
+func (j *jobResponse) outcome() (string, error) {
+	if j.code >= 400 {
+		msg := http.StatusText(j.code)
+		if b, ok := j.body.(*bytes.Buffer); ok && strings.TrimSpace(b.String()) != "" {
+			msg = strings.TrimSpace(b.String())
+		}
+		return "", errors.New(msg)
+	}
+	for _, c := range (&http.Response{Header: j.header}).Cookies() {
+		if c.Name == "admin_flash" && c.Value != "" {
+			return c.Value, nil
+		}
+	}
+	return "Done", nil
+}
+
+// HandleJobs serves the Jobs page at /admin/jobs, its JSON status feed at
+// /admin/jobs/status and the download of export results. Users see their own
+// jobs; those with the "list" permission on Job see everyone's. Export files
+// were built under their owner's field permissions and row policy, so only
+// the owner may download them.
+func HandleJobs(reg *admin.Registry, w http.ResponseWriter, r *http.Request, upath string, user *models.AdminUser) {
+	switch upath {
+	case "/jobs":
+		renderJobs(reg, w, r, user)
+	case "/jobs/status":
+		type status struct {
+			ID       uint   `json:"id"`
+			Status   string `json:"status"`
+			Progress int    `json:"progress"`
+		}
+		list := []status{}
+		for _, j := range visibleJobs(reg, user) {
+			list = append(list, status{ID: j.ID, Status: j.Status, Progress: j.Progress})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(list)
+	case "/jobs/download":
+		var job models.Job
+		q := reg.DB.Where("id = ? AND user_id = ? AND kind = ? AND status = ?", r.URL.Query().Get("id"), user.ID, "export", models.JobDone).Limit(1).Find(&job)
+		if q.Error != nil || q.RowsAffected == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Disposition", "attachment;filename="+job.Result)
+		http.ServeFile(w, r, jobFile(reg, job.ID, job.Result))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func jobScope(reg *admin.Registry, user *models.AdminUser) *gorm.DB {
+	if internal.Can(reg, user, "Job", "list") {
+		return reg.DB
+	}
+	return reg.DB.Where("user_id = ?", user.ID)
+}
+
+// visibleJobs returns the latest jobs user may see.
+func visibleJobs(reg *admin.Registry, user *models.AdminUser) []models.Job {
+	var jobs []models.Job
+	jobScope(reg, user).Order("id desc").Limit(50).Find(&jobs)
+	return jobs
+}
+
+func renderJobs(reg *admin.Registry, w http.ResponseWriter, r *http.Request, user *models.AdminUser) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	styleContent, _ := admin.TemplateFS.ReadFile("templates/style.css")
+	tmpl := view.LoadTemplates("templates/jobs.html")
+	pd := view.PageData{
+		SiteTitle: reg.Config.SiteTitle, GroupedResources: reg.GetGroupedResources(), GroupedPages: reg.GetGroupedPages(),
+		User: user, CSRFToken: user.CSRFToken, CSS: template.CSS(styleContent), Flash: reg.GetFlash(w, r),
+		Jobs: visibleJobs(reg, user), AllJobs: internal.Can(reg, user, "Job", "list"),
+	}
+	if err := tmpl.ExecuteTemplate(w, "jobs.html", pd); err != nil {
+		http.Error(w, "Template error", 500)
+		return
+	}
+}
@@ -1,14 +1,17 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"math"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"reflect"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -18,19 +21,37 @@ import (
 	"github.com/go-packs/go-admin/models"
 	"github.com/go-packs/go-admin/resource"
 	"github.com/go-packs/go-admin/view"
+	"gorm.io/gorm"
 )
 
-// RenderList renders the index (list) view for a given resource.
-func RenderList(reg *admin.Registry, res *resource.Resource, w http.ResponseWriter, r *http.Request, user *models.AdminUser) {
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	fields := res.GetFieldsFor("index")
+// listQuery is the parsed state of a list request: scope, filters, sort and page.
+// Query is Filtered with the sort order applied. Hook is passed to the
+// AfterLoad hooks of the records loaded.
+type listQuery struct {
+	Query, Filtered             *gorm.DB
+	Filters                     map[string]string
+	Scope, SortField, SortOrder string
+	Page, PerPage               int
+	Hook                        *resource.HookContext
+}
+
+// buildListQuery applies the scope, q_/min_/max_ filters and sort parameters of
+// r to a query over res. It is shared by the HTML list view, exports and the
+// JSON API. Filters and sort go through internal.QueryBuilder, and an
+// unknown filter or sort is returned as an *internal.FilterError. Only
+// records within the resource policy for user are included, and fields
+// hidden by fr cannot be sorted or filtered on. The BeforeList hooks of res
+// apply after the policy.
+func buildListQuery(reg *admin.Registry, res *resource.Resource, user *models.AdminUser, fr resource.FieldRestrictions, r *http.Request) (listQuery, error) {
 	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
 	if page < 1 {
 		page = 1
 	}
 	perPage := reg.Config.DefaultPerPage
 	currentScope := r.URL.Query().Get("scope")
-	query := reg.DB.Model(res.Model)
+	hook := &resource.HookContext{Context: r.Context(), User: user}
+	query := res.ApplyListHooks(hook, res.ApplyPolicy(reg.DB.Model(res.Model), user))
+	hook.DB = reg.DB
 	if currentScope != "" {
 		for _, s := range res.Scopes {
 			if s.Name == currentScope {
@@ -39,30 +60,31 @@ func RenderList(reg *admin.Registry, res *resource.Resource, w http.ResponseWrit
 			}
 		}
 	}
-	sortField, sortOrder := r.URL.Query().Get("sort"), r.URL.Query().Get("order")
-	if sortField != "" {
-		if sortOrder != "desc" {
-			sortOrder = "asc"
-		}
-		query = query.Order(fmt.Sprintf("%s %s", sortField, sortOrder))
-	} else {
-		query = query.Order("id desc")
+	qb := internal.NewQueryBuilder(reg, res, user, fr)
+	query, filters, err := qb.Filter(query, r.URL.Query())
+	if err != nil {
+		return listQuery{}, err
 	}
-	filters := make(map[string]string)
-	for k, v := range r.URL.Query() {
-		val := v[0]
-		if val == "" {
-			continue
-		}
-		filters[k] = val
-		if strings.HasPrefix(k, "q_") {
-			query = query.Where(fmt.Sprintf("%s LIKE ?", strings.TrimPrefix(k, "q_")), "%"+val+"%")
-		} else if strings.HasPrefix(k, "min_") {
-			query = query.Where(fmt.Sprintf("%s >= ?", strings.TrimPrefix(k, "min_")), val)
-		} else if strings.HasPrefix(k, "max_") {
-			query = query.Where(fmt.Sprintf("%s <= ?", strings.TrimPrefix(k, "max_")), val)
-		}
+	filtered := query.Session(&gorm.Session{})
+	query, sortField, sortOrder, err := qb.Sort(filtered, r.URL.Query().Get("sort"), r.URL.Query().Get("order"))
+	if err != nil {
+		return listQuery{}, err
+	}
+	return listQuery{Query: query.Session(&gorm.Session{}), Filtered: filtered, Filters: filters, Scope: currentScope, SortField: sortField, SortOrder: sortOrder, Page: page, PerPage: perPage, Hook: hook}, nil
+}
+
+// RenderList renders the index (list) view for a given resource.
+func RenderList(reg *admin.Registry, res *resource.Resource, w http.ResponseWriter, r *http.Request, user *models.AdminUser) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fr := internal.FieldRestrictions(reg, user, res.Name)
+	fields, paths := indexFields(reg, res, fr, user)
+	lq, err := buildListQuery(reg, res, user, fr, r)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
 	}
+	query, page, perPage := lq.Query, lq.Page, lq.PerPage
+	currentScope, sortField, sortOrder, filters := lq.Scope, lq.SortField, lq.SortOrder, lq.Filters
 	var totalCount int64
 	query.Count(&totalCount)
 	totalPages := int(math.Ceil(float64(totalCount) / float64(perPage)))
@@ -70,14 +92,40 @@ func RenderList(reg *admin.Registry, res *resource.Resource, w http.ResponseWrit
 	destSlice := reflect.MakeSlice(reflect.SliceOf(modelType), 0, 0)
 	dest := reflect.New(destSlice.Type())
 	query.Offset((page - 1) * perPage).Limit(perPage).Find(dest.Interface())
+	if err := internal.Loaded(reg, res, lq.Hook, dest.Elem()); err != nil {
+		fmt.Printf("Loading %s failed: %v\n", res.Name, err)
+		http.Error(w, "Could not load records", 500)
+		return
+	}
 	data := view.SliceToMap(res, fields, dest.Elem())
+	for _, row := range data {
+		maskSensitive(res, row)
+	}
+	pathColumns(reg, paths, dest.Elem(), data, user)
+	fields = associationColumns(reg, res, fields, dest.Elem(), data, user)
+	filterFields := internal.NewQueryBuilder(reg, res, user, fr).FilterFields()
+	for _, p := range paths {
+		if f := p.Field; f.Filterable {
+			f.Name = p.Path
+			filterFields = append(filterFields, f)
+		}
+	}
+	export := url.Values{}
+	for k, v := range filters {
+		export.Set(k, v)
+	}
+	for k, v := range map[string]string{"scope": currentScope, "sort": sortField, "order": sortOrder} {
+		if v != "" {
+			export.Set(k, v)
+		}
+	}
 	styleContent, _ := admin.TemplateFS.ReadFile("templates/style.css")
 	tmpl := view.LoadTemplates("templates/index.html")
 	pd := view.PageData{
 		SiteTitle: reg.Config.SiteTitle, Resources: reg.Resources, GroupedResources: reg.GetGroupedResources(), GroupedPages: reg.GetGroupedPages(),
-		CurrentResource: res, Fields: fields, Data: data, Filters: filters, User: user, CSS: template.CSS(styleContent),
+		CurrentResource: res, Fields: fields, Data: data, Filters: filters, FilterFields: filterFields, User: user, CSRFToken: user.CSRFToken, CSS: template.CSS(styleContent),
 		Page: page, PerPage: perPage, TotalPages: totalPages, TotalCount: totalCount, HasPrev: page > 1, HasNext: page < totalPages, PrevPage: page - 1, NextPage: page + 1, Scopes: res.Scopes, CurrentScope: currentScope,
-		Flash: reg.GetFlash(w, r), SortField: sortField, SortOrder: sortOrder,
+		Flash: reg.GetFlash(w, r), SortField: sortField, SortOrder: sortOrder, ExportQuery: template.URL(export.Encode()),
 	}
 	if err := tmpl.ExecuteTemplate(w, "index.html", pd); err != nil {
 		http.Error(w, "Template error", 500)
@@ -88,30 +136,49 @@ func RenderList(reg *admin.Registry, res *resource.Resource, w http.ResponseWrit
 // RenderShow renders the detail view for a single resource record.
 func RenderShow(reg *admin.Registry, res *resource.Resource, item interface{}, w http.ResponseWriter, r *http.Request, user *models.AdminUser) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	fields := res.GetFieldsFor("show")
+	fr := internal.FieldRestrictions(reg, user, res.Name)
+	fields := res.GetFieldsFor("show", fr)
 	var itemMap map[string]interface{}
 	assocData := make(map[string]*view.AssociationData)
 	renderedSidebars := make(map[string]template.HTML)
 	if item != nil {
 		itemMap = view.ItemToMap(res, fields, reflect.ValueOf(item))
+		maskSensitive(res, itemMap)
 		for _, assoc := range res.Associations {
 			if assoc.Type == "HasMany" {
 				targetRes, _ := reg.GetResource(assoc.ResourceName)
-				targetFields := targetRes.GetFieldsFor("index")
+				targetFields := targetRes.GetFieldsFor("index", internal.FieldRestrictions(reg, user, targetRes.Name))
 				modelType := reflect.TypeOf(targetRes.Model)
 				destSlice := reflect.MakeSlice(reflect.SliceOf(modelType), 0, 0)
 				dest := reflect.New(destSlice.Type())
-				reg.DB.Where(fmt.Sprintf("%s = ?", assoc.ForeignKey), itemMap["ID"]).Find(dest.Interface())
+				targetRes.ApplyPolicy(reg.DB, user).Where(fmt.Sprintf("%s = ?", assoc.ForeignKey), itemMap["ID"]).Find(dest.Interface())
 				assocData[assoc.Name] = &view.AssociationData{Resource: targetRes, Fields: targetFields, Items: view.SliceToMap(targetRes, targetFields, dest.Elem())}
+			} else if assoc.Type == "ManyToMany" {
+				targetRes, _ := reg.GetResource(assoc.ResourceName)
+				targetFields := targetRes.GetFieldsFor("index", internal.FieldRestrictions(reg, user, targetRes.Name))
+				items, err := internal.Related(reg, res, assoc, reflect.Indirect(reflect.ValueOf(item)).FieldByName("ID").Interface(), user)
+				if err != nil {
+					fmt.Printf("Error loading %s.%s: %v\n", res.Name, assoc.Name, err)
+					continue
+				}
+				assocData[assoc.Name] = &view.AssociationData{Resource: targetRes, Label: assoc.Label, Fields: targetFields, Items: view.SliceToMap(targetRes, targetFields, items), Multiple: true}
+			} else if assoc.Type == "HasOne" || assoc.Type == "Polymorphic" {
+				assocData[assoc.Name] = singleData(reg, assoc, item, user)
 			}
 		}
 		for _, sb := range res.Sidebars {
 			renderedSidebars[sb.Label] = sb.Handler(res, item)
 		}
 	}
+	tab := r.URL.Query().Get("tab")
+	canRevert := item != nil && internal.Can(reg, user, res.Name, "revert") && res.CanEdit(item, user)
+	var history []view.VersionData
+	if tab == "history" && item != nil {
+		history = loadHistory(reg, res, itemMap["ID"], fr, canRevert)
+	}
 	styleContent, _ := admin.TemplateFS.ReadFile("templates/style.css")
 	tmpl := view.LoadTemplates("templates/show.html")
-	pd := view.PageData{SiteTitle: reg.Config.SiteTitle, Resources: reg.Resources, GroupedResources: reg.GetGroupedResources(), GroupedPages: reg.GetGroupedPages(), CurrentResource: res, Fields: fields, Item: itemMap, User: user, CSS: template.CSS(styleContent), Associations: assocData, Flash: reg.GetFlash(w, r), RenderedSidebars: renderedSidebars}
+	pd := view.PageData{SiteTitle: reg.Config.SiteTitle, Resources: reg.Resources, GroupedResources: reg.GetGroupedResources(), GroupedPages: reg.GetGroupedPages(), CurrentResource: res, Fields: fields, Item: itemMap, User: user, CSRFToken: user.CSRFToken, CSS: template.CSS(styleContent), Associations: assocData, Flash: reg.GetFlash(w, r), RenderedSidebars: renderedSidebars, CanEdit: item != nil && res.CanEdit(item, user), CanDelete: item != nil && res.CanDelete(item, user), Tab: tab, History: history, CanRevert: canRevert}
 	if err := tmpl.ExecuteTemplate(w, "show.html", pd); err != nil {
 		http.Error(w, "Template error", 500)
 		return
@@ -120,33 +187,71 @@ func RenderShow(reg *admin.Registry, res *resource.Resource, item interface{}, w
 
 // RenderForm renders the new/edit form for a resource.
 func RenderForm(reg *admin.Registry, res *resource.Resource, item interface{}, w http.ResponseWriter, r *http.Request, user *models.AdminUser) {
+	renderForm(reg, res, item, item == nil, nil, nil, w, r, user)
+}
+
+// renderForm renders form.html. raw holds submitted values that could not be
+// bound to the model and are shown back verbatim alongside errs.
+func renderForm(reg *admin.Registry, res *resource.Resource, item interface{}, isNew bool, raw map[string]string, errs resource.ValidationErrors, w http.ResponseWriter, r *http.Request, user *models.AdminUser) {
+	renderFormFlash(reg, res, item, isNew, raw, errs, reg.GetFlash(w, r), w, r, user)
+}
+
+// renderFormFlash is renderForm showing flash, such as the message of a hook
+// that aborted the save, instead of the pending flash message.
+func renderFormFlash(reg *admin.Registry, res *resource.Resource, item interface{}, isNew bool, raw map[string]string, errs resource.ValidationErrors, flash string, w http.ResponseWriter, r *http.Request, user *models.AdminUser) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 
 	viewType := "edit"
-	if item == nil {
+	if isNew {
 		viewType = "new"
 	}
-	fields := res.GetFieldsFor(viewType)
+	fields := res.GetFieldsFor(viewType, internal.FieldRestrictions(reg, user, res.Name))
+	if !isNew {
+		// Sensitive values are never sent back: the edit form shows an
+		// empty password input, and leaving it empty keeps the value.
+		fields = slices.Clone(fields)
+		for i := range fields {
+			fields[i].Sensitive = res.IsSensitive(fields[i].Name)
+		}
+	}
 
 	var itemMap map[string]interface{}
 	if item != nil {
 		itemMap = view.ItemToMap(res, fields, reflect.ValueOf(item))
+		if !isNew {
+			maskSensitive(res, itemMap)
+		}
 	}
 	assocData := make(map[string]*view.AssociationData)
 	for _, assoc := range res.Associations {
 		if assoc.Type == "BelongsTo" {
 			targetRes, _ := reg.GetResource(assoc.ResourceName)
 			var count int64
-			reg.DB.Model(targetRes.Model).Count(&count)
+			targetRes.ApplyPolicy(reg.DB.Model(targetRes.Model), user).Count(&count)
 			if count < reg.Config.SearchThreshold {
 				modelType := reflect.TypeOf(targetRes.Model)
 				destSlice := reflect.MakeSlice(reflect.SliceOf(modelType), 0, 0)
 				dest := reflect.New(destSlice.Type())
-				reg.DB.Find(dest.Interface())
-				assocData[assoc.Name] = &view.AssociationData{Resource: targetRes, Options: view.SliceToMap(targetRes, targetRes.Fields, dest.Elem())}
+				targetRes.ApplyPolicy(reg.DB, user).Find(dest.Interface())
+				assocData[assoc.Name] = &view.AssociationData{Resource: targetRes, Options: view.SliceToMap(targetRes, internal.FieldRestrictions(reg, user, targetRes.Name).Apply(targetRes.Fields), dest.Elem())}
 			} else {
 				assocData[assoc.Name] = &view.AssociationData{Resource: targetRes}
 			}
+		} else if assoc.Type == "ManyToMany" {
+			assocData[assoc.Name] = manyToManyData(reg, res, assoc, item, raw, user)
+		} else if assoc.Type == "HasMany" && assoc.Inline && internal.Can(reg, user, assoc.ResourceName, "save") {
+			assocData[assoc.Name] = inlineData(reg, assoc, item, errs, r, user)
+		} else if assoc.Type == "HasOne" && item != nil {
+			assocData[assoc.Name] = singleData(reg, assoc, item, user)
+		} else if assoc.Type == "Polymorphic" && slices.ContainsFunc(fields, func(f resource.Field) bool { return f.Name == assoc.TypeField && !f.Readonly }) {
+			// The type field turns into the type and record picker, which
+			// also posts the ID field.
+			data := &view.AssociationData{Label: assoc.Label, Single: true, Targets: polymorphicTargets(reg, assoc, user), IDField: assoc.ForeignKey}
+			if item != nil {
+				data = singleData(reg, assoc, item, user)
+			}
+			assocData[assoc.TypeField] = data
+			fields = slices.DeleteFunc(slices.Clone(fields), func(f resource.Field) bool { return f.Name == assoc.ForeignKey })
 		}
 	}
 	for _, f := range fields {
@@ -155,25 +260,86 @@ func RenderForm(reg *admin.Registry, res *resource.Resource, item interface{}, w
 			assocData[f.Name] = &view.AssociationData{Resource: targetRes}
 		}
 	}
+	for name, val := range raw {
+		if a, ok := assocData[name]; ok && (a.Options != nil || a.Multiple) {
+			continue
+		}
+		if itemMap != nil && !(res.IsSensitive(name) && !isNew) {
+			itemMap[name] = val
+		}
+	}
 	styleContent, _ := admin.TemplateFS.ReadFile("templates/style.css")
 	tmpl := view.LoadTemplates("templates/form.html")
-	pd := view.PageData{SiteTitle: reg.Config.SiteTitle, Resources: reg.Resources, GroupedResources: reg.GetGroupedResources(), GroupedPages: reg.GetGroupedPages(), CurrentResource: res, Fields: fields, Item: itemMap, User: user, CSS: template.CSS(styleContent), Associations: assocData, Flash: reg.GetFlash(w, r)}
+	pd := view.PageData{SiteTitle: reg.Config.SiteTitle, Resources: reg.Resources, GroupedResources: reg.GetGroupedResources(), GroupedPages: reg.GetGroupedPages(), CurrentResource: res, Fields: fields, Item: itemMap, User: user, CSRFToken: user.CSRFToken, CSS: template.CSS(styleContent), Associations: assocData, Flash: flash, Errors: errs}
+	// A form rendered in answer to a POST is a rejected save.
+	if len(errs) > 0 || r.Method == "POST" {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
 	if err := tmpl.ExecuteTemplate(w, "form.html", pd); err != nil {
 		http.Error(w, "Template error", 500)
 		return
 	}
 }
 
+// manyToManyData describes the multi-select of a ManyToMany association on a
+// form. The selection is the submitted one, kept in raw as comma-separated
+// IDs, when a save was rejected, and otherwise the records linked to item.
+func manyToManyData(reg *admin.Registry, res *resource.Resource, assoc resource.Association, item interface{}, raw map[string]string, user *models.AdminUser) *view.AssociationData {
+	targetRes, _ := reg.GetResource(assoc.ResourceName)
+	data := &view.AssociationData{Resource: targetRes, Label: assoc.Label, Multiple: true}
+	var items reflect.Value
+	if ids, ok := raw[assoc.Name]; ok {
+		dest := reflect.New(reflect.SliceOf(reflect.TypeOf(targetRes.Model)))
+		if ids != "" {
+			targetRes.ApplyPolicy(reg.DB, user).Where("id IN ?", strings.Split(ids, ",")).Order("id").Find(dest.Interface())
+		}
+		items = dest.Elem()
+	} else if item != nil {
+		id := reflect.Indirect(reflect.ValueOf(item)).FieldByName("ID")
+		if id.IsZero() {
+			return data
+		}
+		var err error
+		if items, err = internal.Related(reg, res, assoc, id.Interface(), user); err != nil {
+			fmt.Printf("Error loading %s.%s: %v\n", res.Name, assoc.Name, err)
+			return data
+		}
+	} else {
+		return data
+	}
+	fr := internal.FieldRestrictions(reg, user, targetRes.Name)
+	for i := 0; i < items.Len(); i++ {
+		it := reflect.Indirect(items.Index(i))
+		data.Selected = append(data.Selected, map[string]interface{}{"ID": it.FieldByName("ID").Interface(), "Text": searchText(it, fr)})
+	}
+	return data
+}
+
 // HandleSave processes form submissions to create or update resource records.
+// Invalid submissions re-render the form with the submitted values and per-field errors,
+// and so do saves aborted by a hook, with the hook's message as the flash.
 func HandleSave(reg *admin.Registry, res *resource.Resource, w http.ResponseWriter, r *http.Request, user *models.AdminUser) {
+	if res.IsReadOnly() {
+		http.Error(w, res.Name+" is read-only", 403)
+		return
+	}
 	if err := r.ParseMultipartForm(32 << 20); err != nil && err != http.ErrNotMultipart {
 		// non-fatal; continue without multipart data
 	}
 	model := reflect.New(reflect.TypeOf(res.Model)).Interface()
+	var before map[string]interface{}
 	isUpdate, id := false, r.FormValue("ID")
 	if id != "" && id != "0" {
-		reg.DB.First(model, id)
-		isUpdate = true
+		item, err := internal.Find(reg, res.Name, id, user)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if !res.CanEdit(item, user) {
+			http.Error(w, "Forbidden", 403)
+			return
+		}
+		model, isUpdate, before = item, true, res.Snapshot(item)
 	}
 
 	elem := reflect.ValueOf(model).Elem()
@@ -192,62 +358,71 @@ func HandleSave(reg *admin.Registry, res *resource.Resource, w http.ResponseWrit
 		}
 	}
 
-	for _, f := range res.Fields {
-		if f.Readonly {
-			continue
+	fields := internal.FieldRestrictions(reg, user, res.Name).Apply(res.Fields)
+	if isUpdate {
+		// The edit form leaves sensitive inputs empty; empty keeps the value.
+		fields = slices.DeleteFunc(slices.Clone(fields), func(f resource.Field) bool { return res.IsSensitive(f.Name) && r.FormValue(f.Name) == "" })
+	}
+	raw, errs := bindForm(reg, res, fields, elem, r)
+	related := make(map[string][]string)
+	children := make(map[string][]internal.Child)
+	for _, assoc := range res.Associations {
+		// The multi-select always submits an empty marker value, so a
+		// missing key means the form did not include the association.
+		if ids, ok := r.PostForm[assoc.Name]; ok && assoc.Type == "ManyToMany" {
+			related[assoc.Name] = slices.DeleteFunc(slices.Clone(ids), func(id string) bool { return id == "" })
+			raw[assoc.Name] = strings.Join(related[assoc.Name], ",")
 		}
-		field := elem.FieldByName(f.Name)
-		if !field.IsValid() || !field.CanSet() {
+		if assoc.Type != "HasMany" || !assoc.Inline || len(inlineIndexes(assoc, r)) == 0 {
 			continue
 		}
-		if f.Type == "image" || f.Type == "file" {
-			file, header, err := r.FormFile(f.Name)
-			if err == nil {
-				defer func() {
-					if cerr := file.Close(); cerr != nil {
-						fmt.Printf("file close error: %v\n", cerr)
-					}
-				}()
-				if err := os.MkdirAll(reg.Config.UploadDir, 0755); err != nil {
-					fmt.Printf("mkdir error: %v\n", err)
-				}
-				newName := fmt.Sprintf("%d%s", time.Now().UnixNano(), filepath.Ext(header.Filename))
-				dst, derr := os.Create(filepath.Join(reg.Config.UploadDir, newName))
-				if derr != nil {
-					fmt.Printf("create file error: %v\n", derr)
-				} else {
-					defer func() {
-						if derr := dst.Close(); derr != nil {
-							fmt.Printf("dst close error: %v\n", derr)
-						}
-					}()
-					if _, cerr := io.Copy(dst, file); cerr != nil {
-						fmt.Printf("copy error: %v\n", cerr)
-					}
-					field.SetString("/admin/uploads/" + newName)
-				}
-			}
-			continue
+		if !internal.Can(reg, user, assoc.ResourceName, "save") {
+			http.Error(w, "Forbidden", 403)
+			return
 		}
-		val := r.FormValue(f.Name)
-		switch field.Kind() {
-		case reflect.String:
-			field.SetString(val)
-		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-			uv, _ := strconv.ParseUint(val, 10, 64)
-			field.SetUint(uv)
-		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-			iv, _ := strconv.ParseInt(val, 10, 64)
-			field.SetInt(iv)
-		case reflect.Float32, reflect.Float64:
-			fv, _ := strconv.ParseFloat(val, 64)
-			field.SetFloat(fv)
-		case reflect.Bool:
-			field.SetBool(val == "true" || val == "on" || val == "1")
-		}
-	}
-	if err := reg.DB.Save(model).Error; err != nil {
-		http.Error(w, "Database error", 500)
+		parentID := ""
+		if isUpdate {
+			parentID = id
+		}
+		rows, _, rowErrs, err := bindInline(reg, assoc, parentID, r, user)
+		if errors.Is(err, internal.ErrForbidden) {
+			http.Error(w, "Forbidden", 403)
+			return
+		} else if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		children[assoc.Name] = rows
+		errs.Merge(rowErrs)
+	}
+	errs.Merge(res.Validate(model))
+	errs.Merge(checkPolymorphic(reg, res, model, user))
+	if len(errs) > 0 {
+		renderForm(reg, res, model, !isUpdate, raw, errs, w, r, user)
+		return
+	}
+	if isUpdate && len(related) > 0 {
+		if err := internal.SnapshotRelated(reg, res, before, id); err != nil {
+			errs.Add(resource.BaseError, fmt.Sprintf("Could not save %s: %v", res.Name, err))
+			renderForm(reg, res, model, !isUpdate, raw, errs, w, r, user)
+			return
+		}
+	}
+	var childErr *internal.ChildError
+	if err := internal.SaveNested(r.Context(), reg, res, model, user, &internal.Nested{Related: related, Children: children}); errors.As(err, &childErr) {
+		errs.Add(fmt.Sprintf("%s[%d].%s", childErr.Association, childErr.Row, resource.BaseError), childErrorMessage(childErr.Err))
+		renderForm(reg, res, model, !isUpdate, raw, errs, w, r, user)
+		return
+	} else if errors.Is(err, internal.ErrForbidden) {
+		errs.Add(resource.BaseError, "You are not allowed to save this record: it falls outside your access policy")
+		renderForm(reg, res, model, !isUpdate, raw, errs, w, r, user)
+		return
+	} else if msg, ok := resource.AbortMessage(err); ok {
+		renderFormFlash(reg, res, model, !isUpdate, raw, errs, msg, w, r, user)
+		return
+	} else if err != nil {
+		errs.Add(resource.BaseError, fmt.Sprintf("Could not save %s: %v", res.Name, err))
+		renderForm(reg, res, model, !isUpdate, raw, errs, w, r, user)
 		return
 	}
 	newID := fmt.Sprintf("%v", elem.FieldByName("ID").Interface())
@@ -255,19 +430,182 @@ func HandleSave(reg *admin.Registry, res *resource.Resource, w http.ResponseWrit
 	if isUpdate {
 		act = "Update"
 	}
-	internal.RecordAction(reg, user, res.Name, newID, act, "Saved from form")
+	after := res.Snapshot(model)
+	if len(related) > 0 {
+		if err := internal.SnapshotRelated(reg, res, after, newID); err != nil {
+			fmt.Printf("Error reading %s %s links: %v\n", res.Name, newID, err)
+		}
+	}
+	internal.RecordChange(reg, user, res, newID, act, "Saved from form", before, after)
+	recordChildren(reg, res, children, user)
 	reg.SetFlash(w, fmt.Sprintf("%s saved successfully", res.Name))
 	http.Redirect(w, r, "/admin/"+res.Name, 303)
 }
 
-// HandleDelete removes a resource record.
+// bindForm copies submitted values onto the editable fields of elem. It returns
+// the raw values that failed to parse together with their errors.
+func bindForm(reg *admin.Registry, res *resource.Resource, fields []resource.Field, elem reflect.Value, r *http.Request) (map[string]string, resource.ValidationErrors) {
+	return bindFields(reg, fields, elem, r, "")
+}
+
+// bindFields is bindForm for inputs named prefix followed by the field name.
+// The raw values and errors are keyed by field name alone.
+func bindFields(reg *admin.Registry, fields []resource.Field, elem reflect.Value, r *http.Request, prefix string) (map[string]string, resource.ValidationErrors) {
+	raw := make(map[string]string)
+	errs := resource.ValidationErrors{}
+	for _, f := range fields {
+		if f.Readonly {
+			continue
+		}
+		field := elem.FieldByName(f.Name)
+		if !field.IsValid() || !field.CanSet() {
+			continue
+		}
+		if f.Type == "image" || f.Type == "file" {
+			input := f
+			input.Name = prefix + f.Name
+			saveUpload(reg, input, field, r)
+			continue
+		}
+		if field.Kind() == reflect.String {
+			field.SetString(r.FormValue(prefix + f.Name))
+			continue
+		}
+		if field.Kind() == reflect.Struct {
+			continue
+		}
+		val := strings.TrimSpace(r.FormValue(prefix + f.Name))
+		if msg := coerce(field, val); msg != "" {
+			errs.Add(f.Name, msg)
+			raw[f.Name] = val
+		}
+	}
+	return raw, errs
+}
+
+// timeLayouts are the formats accepted for time fields: datetime-local inputs,
+// RFC 3339, dates and the default formatting used by exports.
+var timeLayouts = []string{"2006-01-02T15:04", time.RFC3339, "2006-01-02 15:04:05", "2006-01-02", "2006-01-02 15:04:05.999999999 -0700 MST"}
+
+// coerce parses val into field according to its kind. It returns a message
+// describing the problem when val does not fit. Empty time values leave the
+// field unchanged.
+func coerce(field reflect.Value, val string) string {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(val)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var uv uint64
+		if val != "" {
+			var err error
+			if uv, err = strconv.ParseUint(val, 10, field.Type().Bits()); err != nil {
+				return "must be a positive whole number"
+			}
+		}
+		field.SetUint(uv)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var iv int64
+		if val != "" {
+			var err error
+			if iv, err = strconv.ParseInt(val, 10, field.Type().Bits()); err != nil {
+				return "must be a whole number"
+			}
+		}
+		field.SetInt(iv)
+	case reflect.Float32, reflect.Float64:
+		var fv float64
+		if val != "" {
+			var err error
+			if fv, err = strconv.ParseFloat(val, field.Type().Bits()); err != nil {
+				return "must be a number"
+			}
+		}
+		field.SetFloat(fv)
+	case reflect.Bool:
+		bv := val == "on"
+		if val != "" && !bv {
+			var err error
+			if bv, err = strconv.ParseBool(strings.ToLower(val)); err != nil {
+				return "must be true or false"
+			}
+		}
+		field.SetBool(bv)
+	case reflect.Struct:
+		if field.Type() != reflect.TypeOf(time.Time{}) || val == "" {
+			return ""
+		}
+		for _, layout := range timeLayouts {
+			if t, err := time.Parse(layout, val); err == nil {
+				field.Set(reflect.ValueOf(t))
+				return ""
+			}
+		}
+		return "must be a date or time"
+	}
+	return ""
+}
+
+// saveUpload stores an uploaded file for f in the upload directory and points field at it.
+func saveUpload(reg *admin.Registry, f resource.Field, field reflect.Value, r *http.Request) {
+	file, header, err := r.FormFile(f.Name)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if cerr := file.Close(); cerr != nil {
+			fmt.Printf("file close error: %v\n", cerr)
+		}
+	}()
+	if err := os.MkdirAll(reg.Config.UploadDir, 0755); err != nil {
+		fmt.Printf("mkdir error: %v\n", err)
+	}
+	newName := fmt.Sprintf("%d%s", time.Now().UnixNano(), filepath.Ext(header.Filename))
+	dst, derr := os.Create(filepath.Join(reg.Config.UploadDir, newName))
+	if derr != nil {
+		fmt.Printf("create file error: %v\n", derr)
+		return
+	}
+	defer func() {
+		if derr := dst.Close(); derr != nil {
+			fmt.Printf("dst close error: %v\n", derr)
+		}
+	}()
+	if _, cerr := io.Copy(dst, file); cerr != nil {
+		fmt.Printf("copy error: %v\n", cerr)
+	}
+	field.SetString("/admin/uploads/" + newName)
+}
+
+// HandleDelete removes a resource record. When a hook aborts the delete, the
+// user is sent back to the record with the hook's message.
 func HandleDelete(reg *admin.Registry, res *resource.Resource, w http.ResponseWriter, r *http.Request, user *models.AdminUser) {
-	id := r.URL.Query().Get("id")
-	if err := internal.Delete(reg, res.Name, id); err != nil {
+	id := r.FormValue("id")
+	item, _ := internal.Find(reg, res.Name, id, user)
+	if err := internal.Delete(r.Context(), reg, res.Name, id, user); errors.Is(err, gorm.ErrRecordNotFound) {
+		http.NotFound(w, r)
+		return
+	} else if errors.Is(err, internal.ErrForbidden) {
+		http.Error(w, "Forbidden", 403)
+		return
+	} else if msg, ok := resource.AbortMessage(err); ok {
+		reg.SetFlash(w, msg)
+		http.Redirect(w, r, fmt.Sprintf("/admin/%s/show?id=%s", res.Name, url.QueryEscape(id)), 303)
+		return
+	} else if err != nil {
 		http.Error(w, "Delete failed", 500)
 		return
 	}
-	internal.RecordAction(reg, user, res.Name, id, "Delete", "Record deleted")
+	internal.RecordChange(reg, user, res, id, "Delete", "Record deleted", res.Snapshot(item), nil)
 	reg.SetFlash(w, fmt.Sprintf("%s deleted successfully", res.Name))
 	http.Redirect(w, r, "/admin/"+res.Name, 303)
 }
+
+// maskSensitive replaces the non-empty values of sensitive fields in m with
+// resource.Redacted, so secrets are only ever seen when they are entered.
+func maskSensitive(res *resource.Resource, m map[string]interface{}) {
+	for name, v := range m {
+		if res.IsSensitive(name) && v != nil && !reflect.ValueOf(v).IsZero() {
+			m[name] = resource.Redacted
+		}
+	}
+}
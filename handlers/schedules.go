@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-packs/go-admin"
+	"github.com/go-packs/go-admin/internal"
+	"github.com/go-packs/go-admin/models"
+	"github.com/go-packs/go-admin/view"
+)
+
+// HandleSchedules serves the scheduled tasks page at /admin/schedules, which
+// needs the "list" permission on Schedule, and the run-now action at
+// /admin/schedules/run, which needs "run". ?name= shows a task's run history.
+func HandleSchedules(reg *admin.Registry, w http.ResponseWriter, r *http.Request, upath string, user *models.AdminUser) {
+	switch {
+	case upath == "/schedules" && r.Method == "GET":
+		if !internal.Can(reg, user, "Schedule", "list") {
+			http.Error(w, "Forbidden", 403)
+			return
+		}
+		renderSchedules(reg, w, r, user)
+	case upath == "/schedules/run" && r.Method == "POST":
+		if !internal.Can(reg, user, "Schedule", "run") {
+			http.Error(w, "Forbidden", 403)
+			return
+		}
+		s, ok := reg.GetSchedule(r.FormValue("name"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		run, err := internal.ClaimSchedule(reg, s, nil, user.Email)
+		switch {
+		case err != nil:
+			reg.SetFlash(w, fmt.Sprintf("Could not start %s: %v", s.Name, err))
+		case run == nil:
+			reg.SetFlash(w, s.Name+" is already running")
+		default:
+			go func() { _ = internal.ExecuteSchedule(context.Background(), reg, s, run) }()
+			internal.RecordAction(reg, user, "Schedule", s.Name, "Run", "Started "+s.Name+" by hand")
+			reg.SetFlash(w, "Started "+s.Name)
+		}
+		http.Redirect(w, r, "/admin/schedules?name="+url.QueryEscape(s.Name), 303)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func renderSchedules(reg *admin.Registry, w http.ResponseWriter, r *http.Request, user *models.AdminUser) {
+	now := time.Now()
+	var schedules []view.ScheduleData
+	for _, s := range reg.Schedules {
+		sd := view.ScheduleData{Name: s.Name, Spec: s.Spec, Next: s.Cron.Next(now), Running: internal.ScheduleRunning(reg, s)}
+		var last, failed models.ScheduleRun
+		if reg.DB.Where("name = ?", s.Name).Order("id desc").Limit(1).Find(&last).RowsAffected > 0 {
+			sd.Last = &last
+		}
+		if reg.DB.Where("name = ? AND status = ?", s.Name, models.JobFailed).Order("id desc").Limit(1).Find(&failed).RowsAffected > 0 {
+			sd.LastError = &failed
+		}
+		schedules = append(schedules, sd)
+	}
+	name := r.URL.Query().Get("name")
+	var runs []models.ScheduleRun
+	if name != "" {
+		reg.DB.Where("name = ?", name).Order("id desc").Limit(50).Find(&runs)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	styleContent, _ := admin.TemplateFS.ReadFile("templates/style.css")
+	tmpl := view.LoadTemplates("templates/schedules.html")
+	pd := view.PageData{
+		SiteTitle: reg.Config.SiteTitle, GroupedResources: reg.GetGroupedResources(), GroupedPages: reg.GetGroupedPages(),
+		User: user, CSRFToken: user.CSRFToken, CSS: template.CSS(styleContent), Flash: reg.GetFlash(w, r),
+		Schedules: schedules, ScheduleName: name, Runs: runs, CanRun: internal.Can(reg, user, "Schedule", "run"),
+	}
+	if err := tmpl.ExecuteTemplate(w, "schedules.html", pd); err != nil {
+		http.Error(w, "Template error", 500)
+		return
+	}
+}
@@ -5,52 +5,40 @@ import (
 	"fmt"
 	"net/http"
 	"reflect"
-	"strings"
 
 	"github.com/go-packs/go-admin"
+	"github.com/go-packs/go-admin/internal"
+	"github.com/go-packs/go-admin/models"
+	"github.com/go-packs/go-admin/resource"
 )
 
-func HandleSearchAPI(reg *admin.Registry, resourceName string, w http.ResponseWriter, r *http.Request) {
+func HandleSearchAPI(reg *admin.Registry, resourceName string, w http.ResponseWriter, r *http.Request, user *models.AdminUser) {
 	res, ok := reg.GetResource(resourceName)
 	if !ok {
 		http.Error(w, "Not found", 404)
 		return
 	}
+	fr := internal.FieldRestrictions(reg, user, res.Name)
 	query := r.URL.Query().Get("q")
-	db := reg.DB.Model(res.Model)
-	searchQuery := ""
-	for _, f := range res.Fields {
-		if f.Type == "text" {
-			if searchQuery != "" {
-				searchQuery += " OR "
-			}
-			searchQuery += fmt.Sprintf("%s LIKE ?", f.Name)
-		}
-	}
-	if searchQuery != "" {
-		args := make([]interface{}, strings.Count(searchQuery, "?"))
-		for i := range args {
-			args[i] = "%" + query + "%"
-		}
-		db = db.Where(searchQuery, args...)
-	}
+	hook := &resource.HookContext{Context: r.Context(), User: user}
+	db := res.ApplyListHooks(hook, res.ApplyPolicy(reg.DB.Model(res.Model), user))
+	db = internal.NewQueryBuilder(reg, res, user, fr).Search(db, query)
 	var results []map[string]interface{}
 	modelType := reflect.TypeOf(res.Model)
 	destSlice := reflect.MakeSlice(reflect.SliceOf(modelType), 0, 0)
 	dest := reflect.New(destSlice.Type())
 	db.Limit(10).Find(dest.Interface())
+	hook.DB = reg.DB
+	if err := internal.Loaded(reg, res, hook, dest.Elem()); err != nil {
+		http.Error(w, "Could not load records", 500)
+		return
+	}
 	items := dest.Elem()
 	for i := 0; i < items.Len(); i++ {
 		item := reflect.Indirect(items.Index(i))
 		m := make(map[string]interface{})
 		m["id"] = item.FieldByName("ID").Interface()
-		if f := item.FieldByName("Name"); f.IsValid() {
-			m["text"] = f.Interface()
-		} else if f := item.FieldByName("Email"); f.IsValid() {
-			m["text"] = f.Interface()
-		} else {
-			m["text"] = fmt.Sprintf("ID: %v", m["id"])
-		}
+		m["text"] = searchText(item, fr)
 		results = append(results, m)
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -59,3 +47,14 @@ func HandleSearchAPI(reg *admin.Registry, resourceName string, w http.ResponseWr
 		return
 	}
 }
+
+// searchText labels item in search results: its Name, else its Email, else
+// its ID, skipping fields hidden from the user.
+func searchText(item reflect.Value, fr resource.FieldRestrictions) interface{} {
+	if f := item.FieldByName("Name"); f.IsValid() && !fr.Hidden("Name") {
+		return f.Interface()
+	} else if f := item.FieldByName("Email"); f.IsValid() && !fr.Hidden("Email") {
+		return f.Interface()
+	}
+	return fmt.Sprintf("ID: %v", item.FieldByName("ID").Interface())
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-packs/go-admin"
+	"github.com/go-packs/go-admin/internal"
+	"github.com/go-packs/go-admin/models"
+	"github.com/go-packs/go-admin/view"
+)
+
+// HandleTokens serves the personal API token page at /admin/tokens, where a
+// logged-in user can mint and revoke their own tokens.
+func HandleTokens(reg *admin.Registry, w http.ResponseWriter, r *http.Request, upath string, user *models.AdminUser) {
+	if user.Token != nil {
+		http.Error(w, "API tokens cannot manage tokens", 403)
+		return
+	}
+	switch {
+	case upath == "/tokens" && r.Method == "POST":
+		name := strings.TrimSpace(r.FormValue("name"))
+		if name == "" {
+			RenderTokens(reg, w, r, user, "", "Token name is required")
+			return
+		}
+		var expiresAt *time.Time
+		if days, err := strconv.Atoi(r.FormValue("expires_days")); err == nil && days > 0 {
+			t := time.Now().AddDate(0, 0, days)
+			expiresAt = &t
+		}
+		tok, secret, err := models.NewAPIToken(user.ID, name, strings.TrimSpace(r.FormValue("scopes")), expiresAt)
+		if err == nil {
+			err = reg.DB.Create(tok).Error
+		}
+		if err != nil {
+			RenderTokens(reg, w, r, user, "", "Could not create token")
+			return
+		}
+		internal.RecordAction(reg, user, "APIToken", strconv.Itoa(int(tok.ID)), "Create", "Minted token "+name)
+		RenderTokens(reg, w, r, user, secret, "")
+	case upath == "/tokens/revoke" && r.Method == "POST":
+		id := r.FormValue("id")
+		res := reg.DB.Where("id = ? AND user_id = ?", id, user.ID).Delete(&models.APIToken{})
+		if res.Error == nil && res.RowsAffected > 0 {
+			internal.RecordAction(reg, user, "APIToken", id, "Delete", "Revoked token")
+			reg.SetFlash(w, "Token revoked")
+		}
+		http.Redirect(w, r, "/admin/tokens", 303)
+	case upath == "/tokens":
+		RenderTokens(reg, w, r, user, "", "")
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// RenderTokens lists the user's API tokens. secret is shown once after minting.
+func RenderTokens(reg *admin.Registry, w http.ResponseWriter, r *http.Request, user *models.AdminUser, secret, errorMsg string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	var tokens []models.APIToken
+	reg.DB.Where("user_id = ?", user.ID).Order("id desc").Find(&tokens)
+	styleContent, _ := admin.TemplateFS.ReadFile("templates/style.css")
+	tmpl := view.LoadTemplates("templates/tokens.html")
+	pd := view.PageData{
+		SiteTitle: reg.Config.SiteTitle, GroupedResources: reg.GetGroupedResources(), GroupedPages: reg.GetGroupedPages(),
+		User: user, CSRFToken: user.CSRFToken, CSS: template.CSS(styleContent), Flash: reg.GetFlash(w, r), Error: errorMsg,
+		Tokens: tokens, NewToken: secret,
+	}
+	if err := tmpl.ExecuteTemplate(w, "tokens.html", pd); err != nil {
+		http.Error(w, "Template error", 500)
+		return
+	}
+}
@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-packs/go-admin"
+	"github.com/go-packs/go-admin/internal"
+	"github.com/go-packs/go-admin/models"
+	"github.com/go-packs/go-admin/qr"
+	"github.com/go-packs/go-admin/view"
+	"github.com/google/uuid"
+)
+
+const (
+	challengeCookie = "admin_2fa"
+	challengeTTL    = 5 * time.Minute
+	// maxChallengeAttempts is how many wrong codes end a pending login.
+	maxChallengeAttempts = 5
+)
+
+// startChallenge records a pending login for user and sends them to the second step.
+func startChallenge(reg *admin.Registry, w http.ResponseWriter, r *http.Request, user *models.AdminUser) {
+	ch := models.LoginChallenge{ID: uuid.New().String(), UserID: user.ID, ExpiresAt: time.Now().Add(challengeTTL)}
+	if err := reg.DB.Create(&ch).Error; err != nil {
+		RenderLogin(reg, w, r, "Could not start two-factor login")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: challengeCookie, Value: ch.ID, Path: "/admin", HttpOnly: true, Expires: ch.ExpiresAt})
+	http.Redirect(w, r, "/admin/login/2fa", 303)
+}
+
+// TwoFactorLogin serves /admin/login/2fa: it verifies a TOTP or recovery code
+// for a pending login, or walks users whose role requires 2FA through enrollment.
+func TwoFactorLogin(reg *admin.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var ch models.LoginChallenge
+		cookie, err := r.Cookie(challengeCookie)
+		if err != nil || reg.DB.Where("id = ? AND expires_at > ?", cookie.Value, time.Now()).First(&ch).Error != nil {
+			http.Redirect(w, r, "/admin/login", 303)
+			return
+		}
+		var user models.AdminUser
+		if err := reg.DB.First(&user, ch.UserID).Error; err != nil {
+			http.Redirect(w, r, "/admin/login", 303)
+			return
+		}
+		td := &view.TwoFactorData{Mode: "verify"}
+		if !user.TOTPEnabled {
+			if user.TOTPSecret == "" {
+				if user.TOTPSecret, err = models.GenerateTOTPSecret(); err != nil {
+					http.Error(w, "Internal error", 500)
+					return
+				}
+				reg.DB.Model(&user).Update("totp_secret", user.TOTPSecret)
+			}
+			td = enrollmentData(reg, &user)
+		}
+		if r.Method != "POST" {
+			renderTwoFactorLogin(reg, w, td, "")
+			return
+		}
+
+		ip, now := internal.ClientIP(r), time.Now()
+		if until, locked := internal.LoginLockedUntil(reg, user.Email, ip, now); locked {
+			reg.DB.Delete(&ch)
+			clearChallenge(w)
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.WriteHeader(http.StatusTooManyRequests)
+			RenderLogin(reg, w, r, fmt.Sprintf("Too many failed attempts. Try again in %s.", until.Sub(now).Round(time.Second)))
+			return
+		}
+		code := r.FormValue("code")
+		ok := user.CheckTOTP(code, now)
+		if !ok && user.TOTPEnabled {
+			ok = user.UseRecoveryCode(code)
+		}
+		if !ok {
+			internal.RecordLoginFailure(reg, user.Email, ip, now)
+			ch.Attempts++
+			if ch.Attempts >= maxChallengeAttempts {
+				reg.DB.Delete(&ch)
+				clearChallenge(w)
+				RenderLogin(reg, w, r, "Too many invalid codes. Please sign in again.")
+				return
+			}
+			reg.DB.Model(&ch).Update("attempts", ch.Attempts)
+			renderTwoFactorLogin(reg, w, td, "Invalid code")
+			return
+		}
+		var codes []string
+		if !user.TOTPEnabled {
+			user.TOTPEnabled = true
+			if codes, err = user.GenerateRecoveryCodes(); err != nil {
+				http.Error(w, "Internal error", 500)
+				return
+			}
+		}
+		reg.DB.Model(&user).Select("totp_enabled", "totp_last_step", "recovery_codes").Updates(&user)
+		internal.ResetLoginFailures(reg, user.Email)
+		reg.DB.Delete(&ch)
+		clearChallenge(w)
+		startSession(reg, w, &user)
+		if codes != nil {
+			internal.RecordAction(reg, &user, "AdminUser", strconv.Itoa(int(user.ID)), "Update", "Enabled two-factor authentication")
+			renderTwoFactorLogin(reg, w, &view.TwoFactorData{Mode: "codes", RecoveryCodes: codes}, "")
+			return
+		}
+		reg.SetFlash(w, "Login successful! Welcome back.")
+		http.Redirect(w, r, "/admin", 303)
+	}
+}
+
+// HandleTwoFactor serves the /admin/2fa settings page where a logged-in user
+// enrolls, regenerates recovery codes or disables two-factor authentication.
+func HandleTwoFactor(reg *admin.Registry, w http.ResponseWriter, r *http.Request, upath string, user *models.AdminUser) {
+	if user.Token != nil {
+		http.Error(w, "API tokens cannot manage two-factor authentication", 403)
+		return
+	}
+	if r.Method != "POST" {
+		if upath != "/2fa" {
+			http.NotFound(w, r)
+			return
+		}
+		if !user.TOTPEnabled && user.TOTPSecret == "" {
+			secret, err := models.GenerateTOTPSecret()
+			if err != nil {
+				http.Error(w, "Internal error", 500)
+				return
+			}
+			user.TOTPSecret = secret
+			reg.DB.Model(user).Update("totp_secret", secret)
+		}
+		RenderTwoFactor(reg, w, r, user, nil, "")
+		return
+	}
+
+	now := time.Now()
+	id := strconv.Itoa(int(user.ID))
+	switch upath {
+	case "/2fa/enable":
+		if user.TOTPEnabled {
+			http.Redirect(w, r, "/admin/2fa", 303)
+			return
+		}
+		if !user.CheckTOTP(r.FormValue("code"), now) {
+			RenderTwoFactor(reg, w, r, user, nil, "Invalid code")
+			return
+		}
+		user.TOTPEnabled = true
+		codes, err := user.GenerateRecoveryCodes()
+		if err != nil {
+			http.Error(w, "Internal error", 500)
+			return
+		}
+		reg.DB.Model(user).Select("totp_enabled", "totp_last_step", "recovery_codes").Updates(user)
+		internal.RecordAction(reg, user, "AdminUser", id, "Update", "Enabled two-factor authentication")
+		RenderTwoFactor(reg, w, r, user, codes, "")
+	case "/2fa/recovery":
+		if !user.TOTPEnabled || !user.CheckTOTP(r.FormValue("code"), now) {
+			RenderTwoFactor(reg, w, r, user, nil, "Invalid code")
+			return
+		}
+		codes, err := user.GenerateRecoveryCodes()
+		if err != nil {
+			http.Error(w, "Internal error", 500)
+			return
+		}
+		reg.DB.Model(user).Select("totp_last_step", "recovery_codes").Updates(user)
+		internal.RecordAction(reg, user, "AdminUser", id, "Update", "Regenerated recovery codes")
+		RenderTwoFactor(reg, w, r, user, codes, "")
+	case "/2fa/disable":
+		if reg.Config.Requires2FA(user.Role) {
+			RenderTwoFactor(reg, w, r, user, nil, "Two-factor authentication is required for your role")
+			return
+		}
+		if !user.TOTPEnabled || !user.CheckTOTP(r.FormValue("code"), now) {
+			RenderTwoFactor(reg, w, r, user, nil, "Invalid code")
+			return
+		}
+		reg.DB.Model(user).Select("totp_enabled", "totp_secret", "totp_last_step", "recovery_codes").
+			Updates(&models.AdminUser{})
+		internal.RecordAction(reg, user, "AdminUser", id, "Update", "Disabled two-factor authentication")
+		reg.SetFlash(w, "Two-factor authentication disabled")
+		http.Redirect(w, r, "/admin/2fa", 303)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// RenderTwoFactor renders the 2FA settings page. codes are freshly issued recovery codes, shown once.
+func RenderTwoFactor(reg *admin.Registry, w http.ResponseWriter, r *http.Request, user *models.AdminUser, codes []string, errorMsg string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	td := &view.TwoFactorData{Mode: "settings", Enabled: user.TOTPEnabled, Required: reg.Config.Requires2FA(user.Role),
+		Remaining: user.RemainingRecoveryCodes(), RecoveryCodes: codes}
+	if !user.TOTPEnabled {
+		td = enrollmentData(reg, user)
+		td.Mode = "settings"
+	}
+	styleContent, _ := admin.TemplateFS.ReadFile("templates/style.css")
+	tmpl := template.Must(view.LoadTemplates("templates/two_factor.html").ParseFS(admin.TemplateFS, "templates/totp_qr.html"))
+	pd := view.PageData{
+		SiteTitle: reg.Config.SiteTitle, GroupedResources: reg.GetGroupedResources(), GroupedPages: reg.GetGroupedPages(),
+		User: user, CSRFToken: user.CSRFToken, CSS: template.CSS(styleContent), Flash: reg.GetFlash(w, r), Error: errorMsg, TwoFactor: td,
+	}
+	if err := tmpl.ExecuteTemplate(w, "two_factor.html", pd); err != nil {
+		http.Error(w, "Template error", 500)
+		return
+	}
+}
+
+func renderTwoFactorLogin(reg *admin.Registry, w http.ResponseWriter, td *view.TwoFactorData, errorMsg string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	tmpl := template.Must(template.ParseFS(admin.TemplateFS, "templates/login_2fa.html", "templates/totp_qr.html"))
+	styleContent, _ := admin.TemplateFS.ReadFile("templates/style.css")
+	pd := view.PageData{SiteTitle: reg.Config.SiteTitle, Error: errorMsg, CSS: template.CSS(styleContent), TwoFactor: td}
+	if err := tmpl.Execute(w, pd); err != nil {
+		http.Error(w, "Template error", 500)
+		return
+	}
+}
+
+func enrollmentData(reg *admin.Registry, user *models.AdminUser) *view.TwoFactorData {
+	td := &view.TwoFactorData{
+		Mode: "enroll", Secret: user.TOTPSecret, Required: reg.Config.Requires2FA(user.Role),
+		URI: models.TOTPProvisioningURI(user.TOTPSecret, reg.Config.SiteTitle, user.Email),
+	}
+	// The QR code is drawn here rather than by a script, so the secret never
+	// leaves the page. Without it the manual entry key still works.
+	if code, err := qr.Encode(td.URI); err == nil {
+		td.QR = template.HTML(code.SVG(4))
+	}
+	return td
+}
+
+func clearChallenge(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{Name: challengeCookie, Value: "", Path: "/admin", MaxAge: -1, HttpOnly: true})
+}
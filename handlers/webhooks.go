@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+
+	"github.com/go-packs/go-admin"
+	"github.com/go-packs/go-admin/internal"
+	"github.com/go-packs/go-admin/models"
+	"github.com/go-packs/go-admin/view"
+)
+
+// HandleWebhooks serves the webhook delivery log at /admin/webhooks, which
+// needs the "list" permission on WebhookDelivery, and the replay action at
+// /admin/webhooks/replay, which needs "replay". ?webhook= and ?status= filter
+// the log. The log shows delivery metadata only, never payloads: a payload
+// carries record fields of resources the viewer may not be allowed to see.
+func HandleWebhooks(reg *admin.Registry, w http.ResponseWriter, r *http.Request, upath string, user *models.AdminUser) {
+	switch {
+	case upath == "/webhooks" && r.Method == "GET":
+		if !internal.Can(reg, user, "WebhookDelivery", "list") {
+			http.Error(w, "Forbidden", 403)
+			return
+		}
+		renderWebhooks(reg, w, r, user)
+	case upath == "/webhooks/replay" && r.Method == "POST":
+		if !internal.Can(reg, user, "WebhookDelivery", "replay") {
+			http.Error(w, "Forbidden", 403)
+			return
+		}
+		id := r.FormValue("id")
+		d, err := internal.ReplayDelivery(reg, id)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		internal.RecordAction(reg, user, "WebhookDelivery", id, "Replay", fmt.Sprintf("Replayed as delivery #%d", d.ID))
+		reg.SetFlash(w, fmt.Sprintf("Delivery #%s queued again as #%d", id, d.ID))
+		http.Redirect(w, r, fmt.Sprintf("/admin/webhooks?webhook=%d", d.WebhookID), 303)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func renderWebhooks(reg *admin.Registry, w http.ResponseWriter, r *http.Request, user *models.AdminUser) {
+	filters := map[string]string{"webhook": r.URL.Query().Get("webhook"), "status": r.URL.Query().Get("status")}
+	q := reg.DB.Order("id desc").Limit(100)
+	if filters["webhook"] != "" {
+		q = q.Where("webhook_id = ?", filters["webhook"])
+	}
+	if filters["status"] != "" {
+		q = q.Where("status = ?", filters["status"])
+	}
+	var deliveries []models.WebhookDelivery
+	q.Omit("payload").Find(&deliveries)
+	var hooks []models.Webhook
+	reg.DB.Order("name").Find(&hooks)
+	names := make(map[uint]string, len(hooks))
+	for _, h := range hooks {
+		names[h.ID] = h.Name
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	styleContent, _ := admin.TemplateFS.ReadFile("templates/style.css")
+	tmpl := view.LoadTemplates("templates/webhooks.html")
+	pd := view.PageData{
+		SiteTitle: reg.Config.SiteTitle, GroupedResources: reg.GetGroupedResources(), GroupedPages: reg.GetGroupedPages(),
+		User: user, CSRFToken: user.CSRFToken, CSS: template.CSS(styleContent), Flash: reg.GetFlash(w, r),
+		Filters: filters, Webhooks: hooks, WebhookNames: names, Deliveries: deliveries,
+		CanReplay: internal.Can(reg, user, "WebhookDelivery", "replay"),
+	}
+	if err := tmpl.ExecuteTemplate(w, "webhooks.html", pd); err != nil {
+		http.Error(w, "Template error", 500)
+		return
+	}
+}
@@ -0,0 +1,213 @@
+package internal
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/go-packs/go-admin"
+	"github.com/go-packs/go-admin/models"
+	"github.com/go-packs/go-admin/resource"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// joinColumns returns the join table of the ManyToMany association assoc of
+// res with its columns holding the owner's and the target's IDs. It fails
+// when the model field is not a many2many relation through assoc.JoinTable.
+func joinColumns(db *gorm.DB, res *resource.Resource, assoc resource.Association) (table, owner, target string, err error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(res.Model); err != nil {
+		return "", "", "", err
+	}
+	rel, ok := stmt.Schema.Relationships.Relations[assoc.Name]
+	if !ok || rel.Type != schema.Many2Many || rel.JoinTable == nil {
+		return "", "", "", fmt.Errorf("%s.%s is not a many2many relation", res.Name, assoc.Name)
+	}
+	if rel.JoinTable.Table != assoc.JoinTable {
+		return "", "", "", fmt.Errorf("%s.%s joins through %s, not %s", res.Name, assoc.Name, rel.JoinTable.Table, assoc.JoinTable)
+	}
+	for _, ref := range rel.References {
+		if ref.OwnPrimaryKey {
+			owner = ref.ForeignKey.DBName
+		} else {
+			target = ref.ForeignKey.DBName
+		}
+	}
+	return rel.JoinTable.Table, owner, target, nil
+}
+
+// linkedIDs returns the IDs of the target records linked to record id through
+// assoc, in ascending order.
+func linkedIDs(db *gorm.DB, res *resource.Resource, assoc resource.Association, id interface{}) ([]string, error) {
+	table, owner, target, err := joinColumns(db, res, assoc)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	err = db.Table(table).Where(owner+" = ?", id).Order(target).Pluck(target, &ids).Error
+	return ids, err
+}
+
+// Related returns the records of the target resource linked to record id of
+// res through the ManyToMany association assoc, limited to those user may
+// access, as a slice of target models.
+func Related(reg *admin.Registry, res *resource.Resource, assoc resource.Association, id interface{}, user *models.AdminUser) (reflect.Value, error) {
+	targetRes, ok := reg.GetResource(assoc.ResourceName)
+	if !ok {
+		return reflect.Value{}, fmt.Errorf("unknown resource %s", assoc.ResourceName)
+	}
+	table, owner, target, err := joinColumns(reg.DB, res, assoc)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	dest := reflect.New(reflect.SliceOf(reflect.TypeOf(targetRes.Model)))
+	linked := reg.DB.Table(table).Select(target).Where(owner+" = ?", id)
+	err = targetRes.ApplyPolicy(reg.DB, user).Where("id IN (?)", linked).Order("id").Find(dest.Interface()).Error
+	return dest.Elem(), err
+}
+
+// SnapshotRelated adds the linked IDs of every ManyToMany association of res
+// to snap, the snapshot of record id, so that audit diffs show link changes.
+func SnapshotRelated(reg *admin.Registry, res *resource.Resource, snap map[string]interface{}, id interface{}) error {
+	for _, assoc := range res.Associations {
+		if assoc.Type != "ManyToMany" {
+			continue
+		}
+		ids, err := linkedIDs(reg.DB, res, assoc, id)
+		if err != nil {
+			return err
+		}
+		snap[assoc.Name] = strings.Join(ids, ", ")
+	}
+	return nil
+}
+
+// replaceRelated links model to exactly the target records ids through the
+// ManyToMany association assoc, using GORM's association API within tx. It
+// returns ErrForbidden when an ID is unknown or outside the target policy for
+// user. Links to records the user cannot see are kept, since the form could
+// not show them.
+func replaceRelated(tx *gorm.DB, reg *admin.Registry, res *resource.Resource, assoc resource.Association, model interface{}, ids []string, user *models.AdminUser) error {
+	targetRes, ok := reg.GetResource(assoc.ResourceName)
+	if !ok {
+		return fmt.Errorf("unknown resource %s", assoc.ResourceName)
+	}
+	var wanted []string
+	for _, id := range ids {
+		if id != "" && !slices.Contains(wanted, id) {
+			wanted = append(wanted, id)
+		}
+	}
+	targets := reflect.New(reflect.SliceOf(reflect.TypeOf(targetRes.Model)))
+	if len(wanted) > 0 {
+		if err := targetRes.ApplyPolicy(tx, user).Where("id IN ?", wanted).Find(targets.Interface()).Error; err != nil {
+			return err
+		}
+		if targets.Elem().Len() != len(wanted) {
+			return fmt.Errorf("%s: %w", assoc.Label, ErrForbidden)
+		}
+	}
+	if targetRes.Policy != nil && user != nil {
+		id := reflect.Indirect(reflect.ValueOf(model)).FieldByName("ID").Interface()
+		current, err := linkedIDs(tx, res, assoc, id)
+		if err != nil {
+			return err
+		}
+		if len(current) > 0 {
+			hidden := reflect.New(targets.Elem().Type())
+			visible := targetRes.ApplyPolicy(tx.Model(targetRes.Model), user).Select("id")
+			if err := tx.Where("id IN ? AND id NOT IN (?)", current, visible).Find(hidden.Interface()).Error; err != nil {
+				return err
+			}
+			targets.Elem().Set(reflect.AppendSlice(targets.Elem(), hidden.Elem()))
+		}
+	}
+	return tx.Model(model).Association(assoc.Name).Replace(targets.Elem().Interface())
+}
+
+// AssociationPath is an index field such as "Product.Name": a field of the
+// record that a BelongsTo association of a resource points to. The prefix
+// names the association or its target resource.
+type AssociationPath struct {
+	Path        string
+	Association resource.Association
+	Target      *resource.Resource
+	Field       resource.Field
+
+	table, column, targetTable, targetKey, targetColumn string
+	targetType                                          schema.DataType
+}
+
+// ResolvePath resolves path on res for user. It fails when the association
+// or field is unknown, when user may not list the target resource, when user
+// may not read the foreign key or the target field, or when the target field
+// is sensitive.
+func ResolvePath(reg *admin.Registry, res *resource.Resource, path string, user *models.AdminUser) (AssociationPath, bool) {
+	prefix, name, ok := strings.Cut(path, ".")
+	if !ok {
+		return AssociationPath{}, false
+	}
+	p := AssociationPath{Path: path}
+	i := slices.IndexFunc(res.Associations, func(a resource.Association) bool {
+		return a.Type == "BelongsTo" && (a.Name == prefix || a.ResourceName == prefix)
+	})
+	if i < 0 || FieldRestrictions(reg, user, res.Name).Hidden(res.Associations[i].Name) {
+		return AssociationPath{}, false
+	}
+	p.Association = res.Associations[i]
+	if p.Target, ok = reg.GetResource(p.Association.ResourceName); !ok || !Can(reg, user, p.Target.Name, "list") ||
+		p.Target.IsSensitive(name) || FieldRestrictions(reg, user, p.Target.Name).Hidden(name) {
+		return AssociationPath{}, false
+	}
+	j := slices.IndexFunc(p.Target.Fields, func(f resource.Field) bool { return f.Name == name })
+	if j < 0 {
+		return AssociationPath{}, false
+	}
+	p.Field = p.Target.Fields[j]
+	var okColumn, okKey bool
+	p.column, okColumn = ColumnName(reg, res, p.Association.Name)
+	p.targetKey, okKey = ColumnName(reg, p.Target, p.Association.ForeignKey)
+	target, okTarget := schemaField(reg, p.Target, name)
+	p.table, p.targetTable = tableName(reg, res), tableName(reg, p.Target)
+	if !okColumn || !okKey || !okTarget || p.table == "" || p.targetTable == "" {
+		return AssociationPath{}, false
+	}
+	p.targetColumn, p.targetType = target.DBName, target.DataType
+	return p, true
+}
+
+// tableName returns the database table of res, or "" if its model cannot be
+// parsed.
+func tableName(reg *admin.Registry, res *resource.Resource) string {
+	stmt := &gorm.Statement{DB: reg.DB}
+	if err := stmt.Parse(res.Model); err != nil {
+		return ""
+	}
+	return stmt.Schema.Table
+}
+
+// Where limits query, over the records of the path's resource, to those
+// whose target record, within its policy for user, has a field matching op
+// (one of the filter operators) against arg.
+func (p AssociationPath) Where(reg *admin.Registry, query *gorm.DB, user *models.AdminUser, op string, arg interface{}) *gorm.DB {
+	targets := p.Target.ApplyPolicy(reg.DB.Model(p.Target.Model), user).Select(p.targetKey).Where(compare(p.targetColumn, op, arg))
+	return query.Where(p.column+" IN (?)", targets)
+}
+
+// Order sorts query, over the records of the path's resource, by the field
+// of their target record. Records without a target visible to user sort as
+// NULL.
+func (p AssociationPath) Order(reg *admin.Registry, query *gorm.DB, user *models.AdminUser, desc bool) *gorm.DB {
+	value := p.Target.ApplyPolicy(reg.DB.Model(p.Target.Model), user).
+		Select(p.targetTable + "." + p.targetColumn).
+		Where(fmt.Sprintf("%s.%s = %s.%s", p.targetTable, p.targetKey, p.table, p.column)).
+		Limit(1)
+	sql := "(?) ASC"
+	if desc {
+		sql = "(?) DESC"
+	}
+	return query.Order(clause.OrderBy{Expression: clause.Expr{SQL: sql, Vars: []interface{}{value}}})
+}
@@ -2,16 +2,137 @@
 package internal
 
 import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
 	"time"
 
 	"github.com/go-packs/go-admin"
 	"github.com/go-packs/go-admin/models"
+	"github.com/go-packs/go-admin/resource"
 )
 
 // RecordAction logs an audit record for a user action.
 func RecordAction(reg *admin.Registry, user *models.AdminUser, resName, recordID, action, changes string) {
-	reg.DB.Create(&models.AuditLog{
+	record(reg, newEntry(user, resName, recordID, action, changes))
+}
+
+// RecordChange logs an audit record for an action on a record of res together
+// with the field diff between its before and after snapshots and the version
+// of the record they leave behind. before is nil for created records and after
+// is nil for deleted ones, whose last state is kept so they can be restored.
+func RecordChange(reg *admin.Registry, user *models.AdminUser, res *resource.Resource, recordID, action, summary string, before, after map[string]interface{}) {
+	record(reg, changeEntry(user, res, recordID, action, summary, before, after))
+}
+
+func changeEntry(user *models.AdminUser, res *resource.Resource, recordID, action, summary string, before, after map[string]interface{}) *models.AuditLog {
+	entry := newEntry(user, res.Name, recordID, action, summary)
+	if changes := res.Diff(before, after); len(changes) > 0 {
+		if b, err := json.Marshal(changes); err == nil {
+			entry.Diff = string(b)
+		} else {
+			fmt.Printf("audit diff error: %v\n", err)
+		}
+	}
+	state := after
+	if state == nil {
+		state = before
+	}
+	if version := res.Version(state); version != nil {
+		if b, err := json.Marshal(version); err == nil {
+			entry.Snapshot = string(b)
+		} else {
+			fmt.Printf("audit snapshot error: %v\n", err)
+		}
+	}
+	return entry
+}
+
+// Snapshots loads the records of res with the given ids and returns their
+// snapshots keyed by ID.
+func Snapshots(reg *admin.Registry, res *resource.Resource, ids []string) map[string]map[string]interface{} {
+	snaps := make(map[string]map[string]interface{})
+	if len(ids) == 0 {
+		return snaps
+	}
+	dest := reflect.New(reflect.SliceOf(reflect.TypeOf(res.Model)))
+	reg.DB.Where("id IN ?", ids).Find(dest.Interface())
+	items := dest.Elem()
+	for i := 0; i < items.Len(); i++ {
+		snap := res.Snapshot(items.Index(i).Interface())
+		snaps[fmt.Sprint(snap["ID"])] = snap
+	}
+	return snaps
+}
+
+// Loaded runs the AfterLoad hooks of res on items, a slice of models or of
+// pointers to them, and redacts audit log entries for hc.User: entries of
+// resources the user may not show lose their diff and snapshot, and fields
+// hidden from the user are dropped from the rest.
+func Loaded(reg *admin.Registry, res *resource.Resource, hc *resource.HookContext, items reflect.Value) error {
+	if err := res.Loaded(hc, items); err != nil {
+		return err
+	}
+	if hc.User == nil || items.Type().Elem() != reflect.TypeOf(models.AuditLog{}) && items.Type().Elem() != reflect.TypeOf(&models.AuditLog{}) {
+		return nil
+	}
+	restrictions := make(map[string]resource.FieldRestrictions)
+	for i := 0; i < items.Len(); i++ {
+		item := items.Index(i)
+		if item.Kind() != reflect.Ptr {
+			item = item.Addr()
+		}
+		entry := item.Interface().(*models.AuditLog)
+		fr, ok := restrictions[entry.ResourceName]
+		if !ok {
+			fr = FieldRestrictions(reg, hc.User, entry.ResourceName)
+			restrictions[entry.ResourceName] = fr
+		}
+		if !Can(reg, hc.User, entry.ResourceName, "show") {
+			entry.Diff, entry.Snapshot = "", ""
+			continue
+		}
+		RedactAuditLog(entry, fr)
+	}
+	return nil
+}
+
+// RedactAuditLog drops the fields hidden by fr from the diff and snapshot of entry.
+func RedactAuditLog(entry *models.AuditLog, fr resource.FieldRestrictions) {
+	if len(fr) == 0 {
+		return
+	}
+	if changes := entry.Changeset(); changes != nil {
+		visible := slices.DeleteFunc(changes, func(c models.FieldChange) bool { return fr.Hidden(c.Field) })
+		entry.Diff = ""
+		if len(visible) > 0 {
+			b, _ := json.Marshal(visible)
+			entry.Diff = string(b)
+		}
+	}
+	if state := entry.State(); state != nil {
+		for name := range state {
+			if fr.Hidden(name) {
+				delete(state, name)
+			}
+		}
+		b, _ := json.Marshal(state)
+		entry.Snapshot = string(b)
+	}
+}
+
+func newEntry(user *models.AdminUser, resName, recordID, action, changes string) *models.AuditLog {
+	return &models.AuditLog{
 		UserID: user.ID, UserEmail: user.Email, ResourceName: resName,
-		RecordID: recordID, Action: action, Changes: changes, CreatedAt: time.Now(),
-	})
+		RecordID: recordID, Action: action, Changes: changes,
+		// Millisecond precision survives every supported database, keeping hashes stable.
+		CreatedAt: time.Now().UTC().Truncate(time.Millisecond),
+	}
+}
+
+func record(reg *admin.Registry, entry *models.AuditLog) {
+	if err := appendAudit(reg, entry); err != nil {
+		fmt.Printf("audit log error: %v\n", err)
+	}
 }
@@ -0,0 +1,149 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/go-packs/go-admin"
+	"github.com/go-packs/go-admin/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// chainMu serialises appends within one process, so that its writers queue
+// here rather than on the database lock taken by lockChain.
+var chainMu sync.Mutex
+
+// appendAudit links entry to the newest audit entry and stores it.
+func appendAudit(reg *admin.Registry, entry *models.AuditLog) error {
+	chainMu.Lock()
+	defer chainMu.Unlock()
+	locked := hasAuditHead(reg)
+	return reg.DB.Transaction(func(tx *gorm.DB) error {
+		if locked {
+			if err := lockChain(tx); err != nil {
+				return err
+			}
+		}
+		return appendLocked(tx, entry)
+	})
+}
+
+// hasAuditHead reports whether the audit_heads table exists. Without it only
+// chainMu protects the chain, so servers sharing the database may fork it.
+func hasAuditHead(reg *admin.Registry) bool {
+	return reg.DB.Migrator().HasTable(&models.AuditHead{})
+}
+
+// lockChain locks the AuditHead row until tx ends, creating it on first use,
+// so that servers sharing the database append one at a time. It must be the
+// first statement of tx: SQLite cannot upgrade a transaction that has read.
+func lockChain(tx *gorm.DB) error {
+	for i := 0; i < 2; i++ {
+		q := tx.Model(&models.AuditHead{}).Where("id = ?", 1).Update("seq", gorm.Expr("seq + 1"))
+		if q.Error != nil || q.RowsAffected > 0 {
+			return q.Error
+		}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.AuditHead{ID: 1}).Error; err != nil {
+			return err
+		}
+	}
+	return errors.New("audit head row is missing")
+}
+
+// appendLocked appends entry within tx, queueing the webhook deliveries it
+// raises. The caller must hold chainMu, and lockChain in tx.
+func appendLocked(tx *gorm.DB, entry *models.AuditLog) error {
+	var last models.AuditLog
+	if err := tx.Select("hash").Order("id desc").Limit(1).Find(&last).Error; err != nil {
+		return err
+	}
+	entry.PrevHash = last.Hash
+	entry.Hash = entry.ComputeHash()
+	if err := tx.Create(entry).Error; err != nil {
+		return err
+	}
+	return queueWebhooks(tx, entry)
+}
+
+// AuditChainReport is the result of VerifyAuditChain.
+type AuditChainReport struct {
+	// Checked counts the chained entries that were verified.
+	Checked int
+	// Unchained counts the leading entries written before hashing was
+	// introduced. They are reported but cannot be verified.
+	Unchained int
+	// BrokenID is the ID of the first entry that fails verification, or 0.
+	BrokenID uint
+	Reason   string
+	// Head is the hash of the newest verified entry. Recording it elsewhere
+	// lets later checks detect entries removed from the end of the log.
+	Head string
+}
+
+// OK reports whether the whole chain verified.
+func (r AuditChainReport) OK() bool { return r.BrokenID == 0 }
+
+func (r AuditChainReport) String() string {
+	if !r.OK() {
+		return fmt.Sprintf("audit chain broken at entry #%d: %s (%d entries verified before it)", r.BrokenID, r.Reason, r.Checked)
+	}
+	s := fmt.Sprintf("audit chain intact: %d entries verified", r.Checked)
+	if r.Head != "" {
+		s += ", head " + r.Head
+	}
+	if r.Unchained > 0 {
+		s += fmt.Sprintf(", %d older entries predate hashing", r.Unchained)
+	}
+	return s
+}
+
+// VerifyAuditChain walks the audit log in ID order and reports the first entry
+// whose hash does not match its content or whose PrevHash does not match the
+// entry before it. Entries without a hash are only accepted before the first
+// chained entry, which may follow the start of the chain or a recorded prune.
+func VerifyAuditChain(reg *admin.Registry) (AuditChainReport, error) {
+	var report AuditChainReport
+	anchors := map[string]bool{"": true}
+	if reg.DB.Migrator().HasTable(&models.AuditPrune{}) {
+		var prunes []models.AuditPrune
+		if err := reg.DB.Find(&prunes).Error; err != nil {
+			return report, err
+		}
+		for _, p := range prunes {
+			anchors[p.LastHash] = true
+		}
+	}
+	var logs []models.AuditLog
+	prev, chained := "", false
+	err := reg.DB.Order("id").FindInBatches(&logs, 500, func(tx *gorm.DB, batch int) error {
+		for i := range logs {
+			l := &logs[i]
+			switch {
+			case l.Hash == "" && !chained:
+				report.Unchained++
+				continue
+			case l.Hash == "":
+				report.Reason = "entry has no hash"
+			case !chained && !anchors[l.PrevHash], chained && l.PrevHash != prev:
+				report.Reason = "previous hash does not match; an entry before it was changed, removed or inserted"
+			case l.ComputeHash() != l.Hash:
+				report.Reason = "hash does not match the entry's content"
+			}
+			if report.Reason != "" {
+				report.BrokenID = l.ID
+				return errStopWalk
+			}
+			chained, prev = true, l.Hash
+			report.Checked, report.Head = report.Checked+1, l.Hash
+		}
+		return nil
+	}).Error
+	if err == errStopWalk {
+		err = nil
+	}
+	return report, err
+}
+
+var errStopWalk = errors.New("stop walking the audit chain")
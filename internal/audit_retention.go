@@ -0,0 +1,142 @@
+package internal
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-packs/go-admin"
+	"github.com/go-packs/go-admin/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// systemUser attributes audit entries written by background maintenance.
+var systemUser = &models.AdminUser{Email: "system"}
+
+// ApplyAuditRetention prunes the audit log according to the registry config:
+// entries older than AuditRetentionDays are removed, archived first when
+// AuditArchiveDir is set. It does nothing when retention is disabled.
+func ApplyAuditRetention(reg *admin.Registry) (int, string, error) {
+	if reg.Config.AuditRetentionDays <= 0 {
+		return 0, "", nil
+	}
+	cutoff := time.Now().AddDate(0, 0, -reg.Config.AuditRetentionDays)
+	return PruneAuditLog(reg, cutoff, reg.Config.AuditArchiveDir)
+}
+
+// PruneAuditLog removes the audit entries created before cutoff. When
+// archiveDir is set the entries are first written there as a gzipped JSON
+// Lines file and only deleted once the file is complete. Entries are removed
+// as a prefix of the chain and the run is recorded as an AuditPrune, so
+// VerifyAuditChain keeps verifying what remains. It returns the number of
+// entries removed and the archive path, if any.
+func PruneAuditLog(reg *admin.Registry, cutoff time.Time, archiveDir string) (int, string, error) {
+	var through models.AuditLog
+	err := reg.DB.Where("created_at < ?", cutoff).Order("id desc").Limit(1).Find(&through).Error
+	if err != nil || through.ID == 0 {
+		return 0, "", err
+	}
+	var count int64
+	if err := reg.DB.Model(&models.AuditLog{}).Where("id <= ?", through.ID).Count(&count).Error; err != nil {
+		return 0, "", err
+	}
+
+	var archive string
+	if archiveDir != "" {
+		if archive, err = archiveAuditLog(reg, through.ID, archiveDir); err != nil {
+			return 0, "", err
+		}
+	}
+	err = reg.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id <= ?", through.ID).Delete(&models.AuditLog{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.AuditPrune{ThroughID: through.ID, Count: int(count), LastHash: through.Hash, Archive: archive}).Error
+	})
+	if err != nil {
+		return 0, archive, err
+	}
+	summary := fmt.Sprintf("Pruned %d entries through #%d", count, through.ID)
+	if archive != "" {
+		summary += " to " + archive
+	}
+	RecordAction(reg, systemUser, "AuditLog", "", "Prune", summary)
+	return int(count), archive, nil
+}
+
+// archiveAuditLog writes the entries up to throughID to a new gzipped JSON
+// Lines file in dir and returns its path.
+func archiveAuditLog(reg *admin.Registry, throughID uint, dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return "", err
+	}
+	name := filepath.Join(dir, fmt.Sprintf("audit-%s-through-%d.jsonl.gz", time.Now().UTC().Format("20060102T150405Z"), throughID))
+	tmp, err := os.CreateTemp(dir, ".audit-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	zw := gzip.NewWriter(tmp)
+	enc := json.NewEncoder(zw)
+	var logs []models.AuditLog
+	err = reg.DB.Where("id <= ?", throughID).Order("id").FindInBatches(&logs, 500, func(tx *gorm.DB, batch int) error {
+		for i := range logs {
+			if err := enc.Encode(&logs[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+	if err == nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), name)
+	}
+	if err != nil {
+		return "", fmt.Errorf("archive audit log: %w", err)
+	}
+	return name, nil
+}
+
+// ImportAuditArchive loads an archive written by PruneAuditLog into the
+// audit_logs table of reg, keeping the original IDs and hashes so the entries
+// can be inspected and verified. Entries whose ID already exists are skipped.
+// It returns the number of entries read.
+func ImportAuditArchive(reg *admin.Registry, r io.Reader) (int, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = zr.Close() }()
+	sc := bufio.NewScanner(zr)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	n := 0
+	err = reg.DB.Transaction(func(tx *gorm.DB) error {
+		for sc.Scan() {
+			var entry models.AuditLog
+			if err := json.Unmarshal(sc.Bytes(), &entry); err != nil {
+				return fmt.Errorf("line %d: %w", n+1, err)
+			}
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&entry).Error; err != nil {
+				return fmt.Errorf("line %d: %w", n+1, err)
+			}
+			n++
+		}
+		return sc.Err()
+	})
+	return n, err
+}
@@ -3,10 +3,12 @@ package internal
 
 import (
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/go-packs/go-admin"
 	"github.com/go-packs/go-admin/models"
+	"github.com/go-packs/go-admin/resource"
 )
 
 // IsAllowed checks if a role has permission to perform an action on a resource.
@@ -19,19 +21,88 @@ func IsAllowed(reg *admin.Registry, role, resource, action string) bool {
 	return count > 0
 }
 
-// GetUserFromRequest retrieves the admin user and role from the session cookie.
+// Can reports whether user may perform action on resource. It applies the
+// role permissions and, for token-authenticated requests, the token's scopes.
+func Can(reg *admin.Registry, user *models.AdminUser, resource, action string) bool {
+	if user == nil || !IsAllowed(reg, user.Role, resource, action) {
+		return false
+	}
+	return user.Token == nil || user.Token.Allows(resource, action)
+}
+
+// FieldRestrictions returns the fields of resourceName that user's role may not
+// see or change. The admin role is never restricted.
+func FieldRestrictions(reg *admin.Registry, user *models.AdminUser, resourceName string) resource.FieldRestrictions {
+	if user == nil || user.Role == "admin" {
+		return nil
+	}
+	var rules []models.Permission
+	reg.DB.Where("role = ? AND resource_name = ? AND field_name <> ''", user.Role, resourceName).Find(&rules)
+	fr := make(resource.FieldRestrictions)
+	for _, p := range rules {
+		if p.Action == resource.FieldHidden || (p.Action == resource.FieldReadonly && fr[p.FieldName] == "") {
+			fr[p.FieldName] = p.Action
+		}
+	}
+	return fr
+}
+
+// BearerToken returns the token from an "Authorization: Bearer" header, if any.
+func BearerToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if len(h) > 7 && strings.EqualFold(h[:7], "Bearer ") {
+		return strings.TrimSpace(h[7:])
+	}
+	return ""
+}
+
+// GetUserFromRequest retrieves the admin user and role from the session cookie,
+// or from a personal API token or session ID passed as a bearer token.
 func GetUserFromRequest(reg *admin.Registry, r *http.Request) (*models.AdminUser, string) {
+	sessionID := BearerToken(r)
+	if strings.HasPrefix(sessionID, models.APITokenPrefix) {
+		return userFromAPIToken(reg, sessionID)
+	}
 	cookie, err := r.Cookie("admin_session")
-	if err != nil {
+	if err == nil {
+		sessionID = cookie.Value
+	}
+	if sessionID == "" {
 		return nil, "guest"
 	}
 	var sess models.Session
-	if err := reg.DB.Where("id = ? AND expires_at > ?", cookie.Value, time.Now()).First(&sess).Error; err != nil {
+	if err := reg.DB.Where("id = ? AND expires_at > ?", sessionID, time.Now()).First(&sess).Error; err != nil {
 		return nil, "guest"
 	}
 	var user models.AdminUser
 	if err := reg.DB.First(&user, sess.UserID).Error; err != nil {
 		return nil, "guest"
 	}
+	if cookie != nil {
+		if sess.CSRFToken == "" {
+			sess.CSRFToken = NewCSRFToken()
+			reg.DB.Model(&sess).Update("csrf_token", sess.CSRFToken)
+		}
+		user.CSRFToken = sess.CSRFToken
+	}
+	return &user, user.Role
+}
+
+func userFromAPIToken(reg *admin.Registry, secret string) (*models.AdminUser, string) {
+	var tok models.APIToken
+	if err := reg.DB.Where("secret_hash = ?", models.HashAPIToken(secret)).First(&tok).Error; err != nil {
+		return nil, "guest"
+	}
+	now := time.Now()
+	if tok.Expired(now) {
+		return nil, "guest"
+	}
+	var user models.AdminUser
+	if err := reg.DB.First(&user, tok.UserID).Error; err != nil {
+		return nil, "guest"
+	}
+	reg.DB.Model(&tok).Update("last_used_at", now)
+	tok.LastUsedAt = &now
+	user.Token = &tok
 	return &user, user.Role
 }
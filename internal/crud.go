@@ -2,12 +2,24 @@
 package internal
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"reflect"
 
 	"github.com/go-packs/go-admin"
+	"github.com/go-packs/go-admin/models"
+	"github.com/go-packs/go-admin/resource"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
 )
 
-// List returns all records for a registered resource.
+// ErrForbidden is returned when a resource policy or record check denies a write.
+var ErrForbidden = errors.New("you are not allowed to modify this record")
+
+// List returns all records for a registered resource, passed through its
+// BeforeList and AfterLoad hooks. Like Create and Update it is a trusted
+// helper: hooks run without a user and policies do not apply.
 func List(reg *admin.Registry, resourceName string) (interface{}, error) {
 	res, ok := reg.GetResource(resourceName)
 	if !ok {
@@ -16,37 +28,338 @@ func List(reg *admin.Registry, resourceName string) (interface{}, error) {
 	modelType := reflect.TypeOf(res.Model)
 	destSlice := reflect.MakeSlice(reflect.SliceOf(modelType), 0, 0)
 	dest := reflect.New(destSlice.Type())
-	err := reg.DB.Find(dest.Interface()).Error
-	return dest.Elem().Interface(), err
+	hc := &resource.HookContext{Context: context.Background(), DB: reg.DB}
+	if err := res.ApplyListHooks(hc, reg.DB).Find(dest.Interface()).Error; err != nil {
+		return dest.Elem().Interface(), err
+	}
+	hc.DB = reg.DB
+	return dest.Elem().Interface(), res.Loaded(hc, dest.Elem())
 }
 
-// Create persists a new record for the given model.
+// Create persists a new record for the given model. Models of a registered
+// resource are saved through Save, so its save hooks run.
 func Create(reg *admin.Registry, data interface{}) error {
+	if res, ok := resourceOf(reg, data); ok {
+		return Save(context.Background(), reg, res, data, nil)
+	}
 	return reg.DB.Create(data).Error
 }
 
-// Get fetches a single record by ID for the named resource.
-func Get(reg *admin.Registry, resourceName string, id interface{}) (interface{}, error) {
+// Get fetches a single record by ID for the named resource to be shown, and
+// passes it through Loaded. Records outside the resource policy for user are
+// reported as not found.
+func Get(ctx context.Context, reg *admin.Registry, resourceName string, id interface{}, user *models.AdminUser) (interface{}, error) {
+	res, ok := reg.GetResource(resourceName)
+	if !ok {
+		return nil, nil
+	}
+	model, err := Find(reg, resourceName, id, user)
+	if err != nil {
+		return model, err
+	}
+	items := reflect.Append(reflect.MakeSlice(reflect.SliceOf(reflect.TypeOf(model)), 0, 1), reflect.ValueOf(model))
+	return model, Loaded(reg, res, &resource.HookContext{Context: ctx, User: user, DB: reg.DB}, items)
+}
+
+// Find is Get without the AfterLoad hooks, for records that are about to be
+// edited, saved or deleted: values a hook changes for display must not be
+// written back.
+func Find(reg *admin.Registry, resourceName string, id interface{}, user *models.AdminUser) (interface{}, error) {
 	res, ok := reg.GetResource(resourceName)
 	if !ok {
 		return nil, nil
 	}
 	model := reflect.New(reflect.TypeOf(res.Model)).Interface()
-	err := reg.DB.First(model, id).Error
-	return model, err
+	return model, res.ApplyPolicy(reg.DB, user).First(model, "id = ?", id).Error
 }
 
-// Update saves changes to an existing record.
+// Update saves changes to an existing record. Models of a registered resource
+// are saved through Save, so its save hooks run.
 func Update(reg *admin.Registry, data interface{}) error {
+	if res, ok := resourceOf(reg, data); ok {
+		return Save(context.Background(), reg, res, data, nil)
+	}
 	return reg.DB.Save(data).Error
 }
 
-// Delete removes a record by ID for the named resource.
-func Delete(reg *admin.Registry, resourceName string, id interface{}) error {
+// resourceOf returns the registered resource whose model data points to.
+func resourceOf(reg *admin.Registry, data interface{}) (*resource.Resource, bool) {
+	t := reflect.Indirect(reflect.ValueOf(data)).Type()
+	for _, res := range reg.Resources {
+		if reflect.TypeOf(res.Model) == t {
+			return res, true
+		}
+	}
+	return nil, false
+}
+
+// Save creates or updates model for user in a transaction that also runs the
+// BeforeSave and AfterSave hooks. It returns ErrForbidden, and rolls back,
+// when the saved record falls outside the resource policy, and the error of a
+// hook that aborts.
+func Save(ctx context.Context, reg *admin.Registry, res *resource.Resource, model interface{}, user *models.AdminUser) error {
+	return SaveNested(ctx, reg, res, model, user, nil)
+}
+
+// Nested holds the association edits saved together with a record.
+type Nested struct {
+	// Related lists the target IDs to link, per ManyToMany association.
+	// Associations missing from it keep their links.
+	Related map[string][]string
+	// Children lists the rows edited inline, per HasMany association.
+	Children map[string][]Child
+}
+
+// Child is a row of an inline HasMany association: a new or changed child
+// to save, or a loaded one to delete. Before is the snapshot of an existing
+// child before the change.
+type Child struct {
+	Model  interface{}
+	Before map[string]interface{}
+	Delete bool
+}
+
+// ChildError is the error of the child at position Row of an inline
+// association.
+type ChildError struct {
+	Association string
+	Row         int
+	Err         error
+}
+
+func (e *ChildError) Error() string {
+	return fmt.Sprintf("%s row %d: %v", e.Association, e.Row+1, e.Err)
+}
+
+func (e *ChildError) Unwrap() error { return e.Err }
+
+// SaveNested is Save that also applies nested in the same transaction: it
+// replaces the links of ManyToMany associations, then deletes and saves the
+// inline children, with their foreign key set to the record's ID. Children go
+// through the hooks, policy and delete check of their own resource; their
+// errors are ChildErrors.
+func SaveNested(ctx context.Context, reg *admin.Registry, res *resource.Resource, model interface{}, user *models.AdminUser, nested *Nested) error {
+	if nested == nil {
+		nested = &Nested{}
+	}
+	return reg.DB.Transaction(func(tx *gorm.DB) error {
+		if err := saveIn(ctx, tx, res, model, user); err != nil {
+			return err
+		}
+		for _, assoc := range res.Associations {
+			if ids, ok := nested.Related[assoc.Name]; ok && assoc.Type == "ManyToMany" {
+				if err := replaceRelated(tx, reg, res, assoc, model, ids, user); err != nil {
+					return err
+				}
+			}
+			if rows, ok := nested.Children[assoc.Name]; ok && assoc.Type == "HasMany" {
+				if err := saveChildren(ctx, tx, reg, assoc, model, rows, user); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// saveChildren applies the inline rows of the HasMany association assoc of
+// parent within tx, deletions first so that a replaced child may reuse a
+// unique value.
+func saveChildren(ctx context.Context, tx *gorm.DB, reg *admin.Registry, assoc resource.Association, parent interface{}, rows []Child, user *models.AdminUser) error {
+	childRes, ok := reg.GetResource(assoc.ResourceName)
+	if !ok {
+		return fmt.Errorf("unknown resource %s", assoc.ResourceName)
+	}
+	parentID := reflect.Indirect(reflect.ValueOf(parent)).FieldByName("ID")
+	for i, c := range rows {
+		if !c.Delete {
+			continue
+		}
+		if !childRes.CanDelete(c.Model, user) {
+			return &ChildError{Association: assoc.Name, Row: i, Err: ErrForbidden}
+		}
+		if err := deleteIn(ctx, tx, childRes, c.Model, user); err != nil {
+			return &ChildError{Association: assoc.Name, Row: i, Err: err}
+		}
+	}
+	for i, c := range rows {
+		if c.Delete {
+			continue
+		}
+		fk := reflect.Indirect(reflect.ValueOf(c.Model)).FieldByName(assoc.ForeignKey)
+		if !fk.IsValid() || !parentID.Type().ConvertibleTo(fk.Type()) {
+			return fmt.Errorf("%s has no foreign key %s for %s", childRes.Name, assoc.ForeignKey, assoc.Name)
+		}
+		fk.Set(parentID.Convert(fk.Type()))
+		if err := saveIn(ctx, tx, childRes, c.Model, user); err != nil {
+			return &ChildError{Association: assoc.Name, Row: i, Err: err}
+		}
+	}
+	return nil
+}
+
+// Change is one record written by SaveBatch. Before is the snapshot of the
+// record before the change and is nil for new records.
+type Change struct {
+	Model  interface{}
+	Before map[string]interface{}
+}
+
+// SaveBatch saves every change for user in a single transaction together with
+// their audit entries, recorded as Create or Update with summary. Nothing is
+// saved if any record fails to save or falls outside the resource policy; the
+// error then names the failing change by its index.
+func SaveBatch(ctx context.Context, reg *admin.Registry, res *resource.Resource, changes []Change, user *models.AdminUser, summary string) error {
+	chainMu.Lock()
+	defer chainMu.Unlock()
+	locked := hasAuditHead(reg)
+	return reg.DB.Transaction(func(tx *gorm.DB) error {
+		if locked {
+			if err := lockChain(tx); err != nil {
+				return err
+			}
+		}
+		for i, c := range changes {
+			if err := saveIn(ctx, tx, res, c.Model, user); err != nil {
+				return fmt.Errorf("record %d: %w", i+1, err)
+			}
+			action := "Update"
+			if c.Before == nil {
+				action = "Create"
+			}
+			id := fmt.Sprint(reflect.Indirect(reflect.ValueOf(c.Model)).FieldByName("ID").Interface())
+			if err := appendLocked(tx, changeEntry(user, res, id, action, summary, c.Before, res.Snapshot(c.Model))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ColumnName returns the database column of the named model field of res.
+func ColumnName(reg *admin.Registry, res *resource.Resource, field string) (string, bool) {
+	f, ok := schemaField(reg, res, field)
+	if !ok {
+		return "", false
+	}
+	return f.DBName, true
+}
+
+// schemaField returns the GORM schema of the named model field of res, if it
+// is stored in a column.
+func schemaField(reg *admin.Registry, res *resource.Resource, field string) (*schema.Field, bool) {
+	stmt := &gorm.Statement{DB: reg.DB}
+	if err := stmt.Parse(res.Model); err != nil {
+		return nil, false
+	}
+	f := stmt.Schema.LookUpField(field)
+	if f == nil || f.DBName == "" {
+		return nil, false
+	}
+	return f, true
+}
+
+// saveIn saves model within tx, running the save hooks, and checks that it
+// stays inside the policy. A record with a zero ID is new.
+func saveIn(ctx context.Context, tx *gorm.DB, res *resource.Resource, model interface{}, user *models.AdminUser) error {
+	idField := reflect.Indirect(reflect.ValueOf(model)).FieldByName("ID")
+	hc := &resource.HookContext{Context: ctx, User: user, DB: tx, IsNew: idField.IsValid() && idField.IsZero()}
+	if err := resource.RunHooks(res.Hooks.BeforeSave, hc, model); err != nil {
+		return err
+	}
+	if err := tx.Save(model).Error; err != nil {
+		return err
+	}
+	if res.Policy != nil && user != nil {
+		var count int64
+		if err := res.ApplyPolicy(tx.Model(res.Model), user).Where("id = ?", idField.Interface()).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrForbidden
+		}
+	}
+	return resource.RunHooks(res.Hooks.AfterSave, hc, model)
+}
+
+// Delete removes a record by ID for the named resource in a transaction that
+// also runs the BeforeDelete and AfterDelete hooks. It returns
+// gorm.ErrRecordNotFound for records outside the policy, ErrForbidden when
+// the resource's delete check refuses and the error of a hook that aborts.
+func Delete(ctx context.Context, reg *admin.Registry, resourceName string, id interface{}, user *models.AdminUser) error {
 	res, ok := reg.GetResource(resourceName)
 	if !ok {
 		return nil
 	}
+	item, err := Find(reg, resourceName, id, user)
+	if err != nil {
+		return err
+	}
+	if !res.CanDelete(item, user) {
+		return ErrForbidden
+	}
+	return reg.DB.Transaction(func(tx *gorm.DB) error {
+		return deleteIn(ctx, tx, res, item, user)
+	})
+}
+
+// deleteIn deletes the loaded record item within tx, running the delete hooks.
+func deleteIn(ctx context.Context, tx *gorm.DB, res *resource.Resource, item interface{}, user *models.AdminUser) error {
+	hc := &resource.HookContext{Context: ctx, User: user, DB: tx}
+	if err := resource.RunHooks(res.Hooks.BeforeDelete, hc, item); err != nil {
+		return err
+	}
+	id := reflect.Indirect(reflect.ValueOf(item)).FieldByName("ID").Interface()
 	model := reflect.New(reflect.TypeOf(res.Model)).Interface()
-	return reg.DB.Delete(model, id).Error
+	if err := tx.Delete(model, "id = ?", id).Error; err != nil {
+		return err
+	}
+	return resource.RunHooks(res.Hooks.AfterDelete, hc, item)
+}
+
+// VisibleIDs returns the subset of ids that user may access under the resource
+// policy, without duplicates.
+func VisibleIDs(reg *admin.Registry, res *resource.Resource, ids []string, user *models.AdminUser) ([]string, error) {
+	ids = uniqueIDs(ids)
+	if res.Policy == nil || user == nil || len(ids) == 0 {
+		return ids, nil
+	}
+	var found []string
+	err := res.ApplyPolicy(reg.DB.Model(res.Model), user).Where("id IN ?", ids).Pluck("id", &found).Error
+	return found, err
+}
+
+// AuthorizeBatch returns ids without duplicates if user may run a batch action
+// on every one of them: each record must exist within the resource policy and
+// pass CanEdit, or CanDelete when the action deletes. Otherwise it returns
+// ErrForbidden.
+func AuthorizeBatch(reg *admin.Registry, res *resource.Resource, ids []string, user *models.AdminUser, deletes bool) ([]string, error) {
+	ids = uniqueIDs(ids)
+	items := reflect.New(reflect.SliceOf(reflect.PointerTo(reflect.TypeOf(res.Model))))
+	if err := res.ApplyPolicy(reg.DB.Model(res.Model), user).Where("id IN ?", ids).Find(items.Interface()).Error; err != nil {
+		return nil, err
+	}
+	if items.Elem().Len() != len(ids) {
+		return nil, ErrForbidden
+	}
+	for i := 0; i < items.Elem().Len(); i++ {
+		item := items.Elem().Index(i).Interface()
+		if deletes && !res.CanDelete(item, user) || !deletes && !res.CanEdit(item, user) {
+			return nil, ErrForbidden
+		}
+	}
+	return ids, nil
+}
+
+// uniqueIDs returns ids in their original order with duplicates removed.
+func uniqueIDs(ids []string) []string {
+	seen := make(map[string]bool, len(ids))
+	out := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return out
 }
@@ -1,12 +1,28 @@
 package internal
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/go-packs/go-admin"
 	"github.com/go-packs/go-admin/models"
+	"github.com/go-packs/go-admin/resource"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
 )
 
 type MockModel struct {
@@ -19,7 +35,7 @@ func setupTestDB() (*gorm.DB, *admin.Registry) {
 	if err != nil {
 		panic(err)
 	}
-	if err := db.AutoMigrate(&models.AdminUser{}, &models.Permission{}, &models.AuditLog{}, &MockModel{}); err != nil {
+	if err := db.AutoMigrate(&models.AdminUser{}, &models.Permission{}, &models.AuditLog{}, &models.Session{}, &models.APIToken{}, &models.LoginThrottle{}, &models.AuditPrune{}, &models.Job{}, &models.ScheduleRun{}, &models.ScheduleLease{}, &models.Webhook{}, &models.WebhookDelivery{}, &MockModel{}); err != nil {
 		panic(err)
 	}
 	reg := admin.NewRegistry(db)
@@ -41,6 +57,108 @@ func TestAuthLogic(t *testing.T) {
 			t.Error("Editor should not be allowed delete on User")
 		}
 	})
+
+	t.Run("FieldRestrictions", func(t *testing.T) {
+		reg.DB.Create(&models.Permission{Role: "editor", ResourceName: "User", FieldName: "Role", Action: "readonly"})
+		reg.DB.Create(&models.Permission{Role: "editor", ResourceName: "User", FieldName: "Role", Action: "hide"})
+		reg.DB.Create(&models.Permission{Role: "editor", ResourceName: "User", FieldName: "Email", Action: "readonly"})
+		fr := FieldRestrictions(reg, &models.AdminUser{Role: "editor"}, "User")
+		if !fr.Hidden("Role") || fr.Writable("Email") || !fr.Writable("Name") {
+			t.Errorf("Unexpected restrictions %v", fr)
+		}
+		if fr := FieldRestrictions(reg, &models.AdminUser{Role: "admin"}, "User"); len(fr) != 0 {
+			t.Error("Admin should not be restricted")
+		}
+	})
+}
+
+func TestAPITokenAuth(t *testing.T) {
+	db, reg := setupTestDB()
+	user := &models.AdminUser{Email: "bot@example.com", Role: "editor"}
+	db.Create(user)
+	db.Create(&models.Permission{Role: "editor", ResourceName: "User", Action: "list"})
+	db.Create(&models.Permission{Role: "editor", ResourceName: "User", Action: "delete"})
+	tok, secret, _ := models.NewAPIToken(user.ID, "ci", "User:list", nil)
+	db.Create(tok)
+
+	req := httptest.NewRequest("GET", "/admin/api/User", nil)
+	req.Header.Set("Authorization", "Bearer "+secret)
+	got, role := GetUserFromRequest(reg, req)
+	if got == nil || got.ID != user.ID || role != "editor" || got.Token == nil {
+		t.Fatalf("Token authentication failed: %+v", got)
+	}
+	if !Can(reg, got, "User", "list") {
+		t.Error("Token scope should allow list")
+	}
+	if Can(reg, got, "User", "delete") {
+		t.Error("Token scope should restrict delete even though the role allows it")
+	}
+	var stored models.APIToken
+	db.First(&stored, tok.ID)
+	if stored.LastUsedAt == nil {
+		t.Error("LastUsedAt not recorded")
+	}
+
+	req.Header.Set("Authorization", "Bearer "+models.APITokenPrefix+"bogus")
+	if u, _ := GetUserFromRequest(reg, req); u != nil {
+		t.Error("Unknown token should not authenticate")
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	db, reg := setupTestDB()
+	reg.Config.LoginMaxAttempts, reg.Config.LoginIPMaxAttempts = 3, 100
+	reg.Config.LockoutSeconds, reg.Config.MaxLockoutSeconds = 60, 200
+	user := &models.AdminUser{Email: "victim@example.com"}
+	db.Create(user)
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		RecordLoginFailure(reg, user.Email, "10.0.0.1", now)
+	}
+	if _, locked := LoginLockedUntil(reg, user.Email, "10.0.0.2", now); locked {
+		t.Fatal("Should not lock before the threshold")
+	}
+	RecordLoginFailure(reg, user.Email, "10.0.0.1", now)
+	until, locked := LoginLockedUntil(reg, "VICTIM@example.com ", "10.0.0.2", now)
+	if !locked || until.Sub(now) != 60*time.Second {
+		t.Fatalf("Expected 60s lockout, got %v %v", locked, until.Sub(now))
+	}
+	RecordLoginFailure(reg, user.Email, "10.0.0.1", now)
+	RecordLoginFailure(reg, user.Email, "10.0.0.1", now)
+	if until, _ := LoginLockedUntil(reg, user.Email, "", now); until.Sub(now) != 200*time.Second {
+		t.Errorf("Expected back-off capped at 200s, got %v", until.Sub(now))
+	}
+	var locks int64
+	db.Model(&models.AuditLog{}).Where("action = ?", "Lock").Count(&locks)
+	if locks != 3 {
+		t.Errorf("Expected 3 lock audit entries, got %d", locks)
+	}
+
+	if err := UnlockAccount(reg, &models.AdminUser{Email: "root@example.com"}, user); err != nil {
+		t.Fatal(err)
+	}
+	if _, locked := LoginLockedUntil(reg, user.Email, "", now); locked {
+		t.Error("Unlock should lift the lockout")
+	}
+
+	reg.Config.LoginIPMaxAttempts = 5
+	for i := 0; i < 4; i++ {
+		RecordLoginFailure(reg, "nobody@example.com", "10.0.0.9", now)
+	}
+	var throttles int64
+	db.Model(&models.LoginThrottle{}).Where("subject = ?", emailSubject("nobody@example.com")).Count(&throttles)
+	if _, locked := LoginLockedUntil(reg, "nobody@example.com", "10.0.0.8", now); throttles != 0 || locked {
+		t.Errorf("Unknown emails should not be counted or locked, got %d rows", throttles)
+	}
+	RecordLoginFailure(reg, "nobody@example.com", "10.0.0.9", now)
+	if _, locked := LoginLockedUntil(reg, "anyone@example.com", "10.0.0.9", now); !locked {
+		t.Error("Failures for unknown emails should count toward the IP limit")
+	}
+	db.Model(&models.AuditLog{}).Where("action = ? AND user_email = ?", "Lock", "nobody@example.com").Count(&locks)
+	if locks != 0 {
+		t.Errorf("Lock entries should not name unknown emails, got %d", locks)
+	}
 }
 
 func TestAuditLogic(t *testing.T) {
@@ -69,7 +187,7 @@ func TestCRUDLogic(t *testing.T) {
 			t.Fatalf("Create failed: %v", err)
 		}
 
-		fetched, _ := Get(reg, "MockModel", item.ID)
+		fetched, _ := Get(context.Background(), reg, "MockModel", item.ID, nil)
 		if fetched.(*MockModel).Name != "Initial" {
 			t.Error("Get failed")
 		}
@@ -84,7 +202,7 @@ func TestCRUDLogic(t *testing.T) {
 			t.Error("List failed")
 		}
 
-		if err := Delete(reg, "MockModel", item.ID); err != nil {
+		if err := Delete(context.Background(), reg, "MockModel", item.ID, nil); err != nil {
 			t.Fatalf("Delete failed: %v", err)
 		}
 
@@ -93,4 +211,830 @@ func TestCRUDLogic(t *testing.T) {
 			t.Error("Delete did not remove item")
 		}
 	})
+
+	t.Run("Hooks", func(t *testing.T) {
+		res, _ := reg.GetResource("MockModel")
+		type key struct{}
+		ctx := context.WithValue(context.Background(), key{}, "req-1")
+		user := &models.AdminUser{ID: 1, Email: "admin@example.com", Role: "admin"}
+		var seen []string
+		res.BeforeSave(func(hc *resource.HookContext, item interface{}) error {
+			m := item.(*MockModel)
+			seen = append(seen, fmt.Sprintf("save new=%v ctx=%v user=%s", hc.IsNew, hc.Context.Value(key{}), hc.User.Email))
+			if m.Name == "reserved" {
+				return resource.Abort("%s is a reserved name", m.Name)
+			}
+			m.Name = strings.TrimSpace(m.Name)
+			return nil
+		}).AfterSave(func(hc *resource.HookContext, item interface{}) error {
+			if item.(*MockModel).Name == "rollback" {
+				return errors.New("downstream refused")
+			}
+			return nil
+		}).BeforeDelete(func(hc *resource.HookContext, item interface{}) error {
+			if item.(*MockModel).Name == "keep" {
+				return resource.Abort("keep cannot be deleted")
+			}
+			return nil
+		}).AfterLoad(func(hc *resource.HookContext, item interface{}) error {
+			item.(*MockModel).Name = strings.ToUpper(item.(*MockModel).Name)
+			return nil
+		})
+		defer func() { res.Hooks = resource.Hooks{} }()
+
+		item := &MockModel{Name: "  gear "}
+		if err := Save(ctx, reg, res, item, user); err != nil || item.Name != "gear" {
+			t.Fatalf("BeforeSave should clean the name, got %+v (%v)", item, err)
+		}
+		if err := Save(ctx, reg, res, item, user); err != nil || len(seen) != 2 || seen[0] != "save new=true ctx=req-1 user=admin@example.com" || seen[1] != "save new=false ctx=req-1 user=admin@example.com" {
+			t.Errorf("Unexpected hook calls %v (%v)", seen, err)
+		}
+		if err := Save(ctx, reg, res, &MockModel{Name: "reserved"}, user); err == nil || err.Error() != "reserved is a reserved name" {
+			t.Errorf("Expected BeforeSave to abort, got %v", err)
+		}
+		if err := Save(ctx, reg, res, &MockModel{Name: "rollback"}, user); err == nil {
+			t.Error("Expected AfterSave to fail the save")
+		}
+		var n int64
+		if reg.DB.Model(&MockModel{}).Where("name IN ?", []string{"reserved", "rollback"}).Count(&n); n != 0 {
+			t.Errorf("Aborted saves should be rolled back, found %d", n)
+		}
+		if fetched, _ := Get(ctx, reg, "MockModel", item.ID, user); fetched.(*MockModel).Name != "GEAR" {
+			t.Errorf("AfterLoad should run on Get, got %+v", fetched)
+		}
+
+		res.Hooks.AfterLoad = nil
+		keep := &MockModel{Name: "keep"}
+		reg.DB.Create(keep)
+		if msg, ok := resource.AbortMessage(Delete(ctx, reg, "MockModel", keep.ID, user)); !ok || msg != "keep cannot be deleted" {
+			t.Errorf("Expected BeforeDelete to abort, got %q", msg)
+		}
+		if _, err := Get(ctx, reg, "MockModel", keep.ID, user); err != nil {
+			t.Errorf("The aborted delete should keep the record: %v", err)
+		}
+	})
+
+	t.Run("TrustedHelpers", func(t *testing.T) {
+		res, _ := reg.GetResource("MockModel")
+		var saves []bool
+		res.BeforeSave(func(hc *resource.HookContext, item interface{}) error {
+			saves = append(saves, hc.IsNew && hc.User == nil)
+			return nil
+		}).BeforeList(func(hc *resource.HookContext, db *gorm.DB) *gorm.DB {
+			return db.Where("name <> ?", "hidden")
+		}).AfterLoad(func(hc *resource.HookContext, item interface{}) error {
+			item.(*MockModel).Name += "!"
+			return nil
+		})
+		defer func() { res.Hooks = resource.Hooks{} }()
+		item := &MockModel{Name: "shown"}
+		if err := Create(reg, item); err != nil {
+			t.Fatal(err)
+		}
+		Create(reg, &MockModel{Name: "hidden"})
+		if err := Update(reg, item); err != nil || len(saves) != 3 || !saves[0] || saves[2] {
+			t.Errorf("Create and Update should run the save hooks, got %v (%v)", saves, err)
+		}
+		list, _ := List(reg, "MockModel")
+		var names []string
+		for _, m := range list.([]MockModel) {
+			names = append(names, m.Name)
+		}
+		if !slices.Contains(names, "shown!") || slices.ContainsFunc(names, func(n string) bool { return strings.HasPrefix(n, "hidden") }) {
+			t.Errorf("List should run the list and load hooks, got %v", names)
+		}
+	})
+}
+
+func TestPolicy(t *testing.T) {
+	db, reg := setupTestDB()
+	res := reg.Register(MockModel{}).
+		SetPolicy(func(db *gorm.DB, user *models.AdminUser) *gorm.DB {
+			return db.Where("name LIKE ?", user.Email+"%")
+		}).
+		SetCanDelete(func(item interface{}, user *models.AdminUser) bool {
+			return item.(*MockModel).Name != user.Email+"-locked"
+		})
+	user := &models.AdminUser{Email: "north", Role: "manager"}
+	mine, theirs, locked := &MockModel{Name: "north-1"}, &MockModel{Name: "south-1"}, &MockModel{Name: "north-locked"}
+	db.Create(mine)
+	db.Create(theirs)
+	db.Create(locked)
+
+	if _, err := Get(context.Background(), reg, "MockModel", theirs.ID, user); err == nil {
+		t.Error("Records outside the policy should not be found")
+	}
+	if _, err := Get(context.Background(), reg, "MockModel", theirs.ID, nil); err != nil {
+		t.Error("Internal callers should not be restricted")
+	}
+	ids, _ := VisibleIDs(reg, res, []string{fmt.Sprint(mine.ID), fmt.Sprint(theirs.ID)}, user)
+	if len(ids) != 1 || ids[0] != fmt.Sprint(mine.ID) {
+		t.Errorf("Expected only the visible id, got %v", ids)
+	}
+	if ids, err := AuthorizeBatch(reg, res, []string{fmt.Sprint(mine.ID), fmt.Sprint(mine.ID), fmt.Sprint(locked.ID)}, user, false); err != nil || len(ids) != 2 {
+		t.Errorf("Duplicate ids should be allowed once, got %v, %v", ids, err)
+	}
+	if _, err := AuthorizeBatch(reg, res, []string{fmt.Sprint(mine.ID), fmt.Sprint(locked.ID)}, user, true); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Deleting batch should check CanDelete on every record, got %v", err)
+	}
+	if _, err := AuthorizeBatch(reg, res, []string{fmt.Sprint(mine.ID), fmt.Sprint(theirs.ID)}, user, false); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Batch outside the policy should be refused, got %v", err)
+	}
+
+	mine.Name = "south-2"
+	if err := Save(context.Background(), reg, res, mine, user); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Moving a record outside the policy should be refused, got %v", err)
+	}
+	var reloaded MockModel
+	db.First(&reloaded, mine.ID)
+	if reloaded.Name != "north-1" {
+		t.Error("Refused save should be rolled back")
+	}
+
+	if err := Delete(context.Background(), reg, "MockModel", locked.ID, user); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Delete check should refuse, got %v", err)
+	}
+	if err := Delete(context.Background(), reg, "MockModel", theirs.ID, user); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("Deleting outside the policy should report not found, got %v", err)
+	}
+}
+
+func TestRevert(t *testing.T) {
+	db, reg := setupTestDB()
+	res := reg.Register(MockModel{}).RegisterField("Name", "Name", false).SetLength("Name", 1, 20)
+	root := &models.AdminUser{Email: "admin@example.com", Role: "admin"}
+	item := &MockModel{Name: "First"}
+	db.Create(item)
+	id := fmt.Sprint(item.ID)
+	RecordChange(reg, root, res, id, "Create", "", nil, res.Snapshot(item))
+	var v1 models.AuditLog
+	db.Order("id desc").First(&v1)
+
+	before := res.Snapshot(item)
+	item.Name = "Second"
+	db.Save(item)
+	RecordChange(reg, root, res, id, "Update", "", before, res.Snapshot(item))
+
+	if _, _, err := Revert(context.Background(), reg, v1.ID, &models.AdminUser{Role: "viewer"}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Revert without permission should be forbidden, got %v", err)
+	}
+	if _, got, err := Revert(context.Background(), reg, v1.ID, root); err != nil || got != id {
+		t.Fatalf("Revert failed: %v", err)
+	}
+	var reloaded MockModel
+	db.First(&reloaded, item.ID)
+	if reloaded.Name != "First" {
+		t.Errorf("Expected reverted name, got %q", reloaded.Name)
+	}
+	var last models.AuditLog
+	db.Order("id desc").First(&last)
+	if last.Action != "Revert" || len(last.Changeset()) != 1 {
+		t.Errorf("Revert should be audited with its diff, got %+v", last)
+	}
+
+	if err := Delete(context.Background(), reg, "MockModel", item.ID, root); err != nil {
+		t.Fatal(err)
+	}
+	RecordChange(reg, root, res, id, "Delete", "", res.Snapshot(&reloaded), nil)
+	var deleted models.AuditLog
+	db.Order("id desc").First(&deleted)
+	if _, _, err := Revert(context.Background(), reg, deleted.ID, root); err != nil {
+		t.Fatalf("Undelete failed: %v", err)
+	}
+	if err := db.First(&reloaded, item.ID).Error; err != nil || reloaded.Name != "First" {
+		t.Errorf("Record should be restored with its ID, got %+v %v", reloaded, err)
+	}
+
+	bad := models.AuditLog{ResourceName: "MockModel", RecordID: id, Snapshot: `{"ID":` + id + `,"Name":""}`}
+	db.Create(&bad)
+	if _, _, err := Revert(context.Background(), reg, bad.ID, root); err == nil {
+		t.Error("Invalid versions should fail validation")
+	}
+	RecordAction(reg, root, "MockModel", id, "Action", "no snapshot")
+	var plain models.AuditLog
+	db.Order("id desc").First(&plain)
+	if _, _, err := Revert(context.Background(), reg, plain.ID, root); !errors.Is(err, ErrNoSnapshot) {
+		t.Errorf("Expected ErrNoSnapshot, got %v", err)
+	}
+}
+
+func TestAuditChain(t *testing.T) {
+	db, reg := setupTestDB()
+	db.Create(&models.AuditLog{Action: "Legacy"})
+	user := &models.AdminUser{Email: "admin@example.com"}
+	for i := 0; i < 4; i++ {
+		RecordAction(reg, user, "User", fmt.Sprint(i), "Update", "change")
+	}
+	report, err := VerifyAuditChain(reg)
+	if err != nil || !report.OK() || report.Checked != 4 || report.Unchained != 1 {
+		t.Fatalf("Expected an intact chain, got %+v %v", report, err)
+	}
+
+	db.Model(&models.AuditLog{}).Where("id = ?", 3).Update("changes", "tampered")
+	if report, _ := VerifyAuditChain(reg); report.BrokenID != 3 {
+		t.Errorf("Expected the edited entry to break the chain, got %+v", report)
+	}
+	db.Model(&models.AuditLog{}).Where("id = ?", 3).Update("changes", "change")
+
+	db.Delete(&models.AuditLog{}, 4)
+	if report, _ := VerifyAuditChain(reg); report.BrokenID != 5 {
+		t.Errorf("Expected the entry after a removed one to break the chain, got %+v", report)
+	}
+}
+
+func TestAuditChainServers(t *testing.T) {
+	// Two connections to one database stand in for two servers.
+	dsn := "file:" + t.TempDir() + "/audit.db?_busy_timeout=5000"
+	open := func() *admin.Registry {
+		db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return admin.NewRegistry(db)
+	}
+	first, second := open(), open()
+	if err := first.DB.AutoMigrate(&models.AuditLog{}, &models.AuditHead{}, &models.Webhook{}); err != nil {
+		t.Fatal(err)
+	}
+	user := &models.AdminUser{Email: "admin@example.com"}
+
+	// The first server has written its entry but not committed when the
+	// second starts an append.
+	tx := first.DB.Begin()
+	if err := lockChain(tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := appendLocked(tx, newEntry(user, "User", "1", "Update", "first")); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- appendAudit(second, newEntry(user, "User", "2", "Update", "second")) }()
+	time.Sleep(100 * time.Millisecond)
+	if err := tx.Commit().Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := VerifyAuditChain(first)
+	if err != nil || !report.OK() || report.Checked != 2 {
+		t.Errorf("Expected both appends to be chained, got %+v %v", report, err)
+	}
+	var head models.AuditHead
+	if first.DB.First(&head, 1); head.Seq != 2 {
+		t.Errorf("Expected the head row to count both appends, got %d", head.Seq)
+	}
+}
+
+func TestAuditRetention(t *testing.T) {
+	db, reg := setupTestDB()
+	user := &models.AdminUser{Email: "admin@example.com"}
+	for i := 0; i < 3; i++ {
+		RecordAction(reg, user, "User", fmt.Sprint(i), "Update", "old change")
+	}
+	dir := t.TempDir()
+	n, archive, err := PruneAuditLog(reg, time.Now().Add(time.Second), dir)
+	if err != nil || n != 3 || archive == "" {
+		t.Fatalf("Prune failed: %d %q %v", n, archive, err)
+	}
+	RecordAction(reg, user, "User", "9", "Update", "new change")
+	var left []models.AuditLog
+	db.Order("id").Find(&left)
+	if len(left) != 2 || left[0].Action != "Prune" {
+		t.Fatalf("Expected the prune entry and the new entry to remain, got %+v", left)
+	}
+	if report, _ := VerifyAuditChain(reg); !report.OK() || report.Checked != 2 {
+		t.Errorf("Chain should still verify after pruning, got %v", report)
+	}
+
+	reg.Config.AuditRetentionDays = 30
+	if n, _, _ := ApplyAuditRetention(reg); n != 0 {
+		t.Errorf("Recent entries should be kept, pruned %d", n)
+	}
+
+	_, scratch := setupTestDB()
+	f, err := os.Open(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	if n, err := ImportAuditArchive(scratch, f); err != nil || n != 3 {
+		t.Fatalf("Import failed: %d %v", n, err)
+	}
+	if report, _ := VerifyAuditChain(scratch); !report.OK() || report.Checked != 3 {
+		t.Errorf("Imported archive should verify, got %v", report)
+	}
+}
+
+func TestJobs(t *testing.T) {
+	db, reg := setupTestDB()
+	user := &models.AdminUser{ID: 1, Email: "admin@example.com", Role: "admin"}
+
+	first, err := EnqueueJob(reg, user, "batch_action", "MockModel", "Touch", map[string]interface{}{"ids": []string{"1"}})
+	if err != nil || first.Status != models.JobQueued || first.Payload != `{"ids":["1"]}` {
+		t.Fatalf("Unexpected job %+v (%v)", first, err)
+	}
+	select {
+	case <-JobQueued():
+	default:
+		t.Error("Enqueueing should wake a worker")
+	}
+	second, _ := EnqueueJob(reg, user, "export", "MockModel", "Export", nil)
+
+	claimed, err := ClaimJob(reg)
+	if err != nil || claimed == nil || claimed.ID != first.ID || claimed.Status != models.JobRunning || claimed.Attempts != 1 {
+		t.Fatalf("Expected to claim the oldest job, got %+v (%v)", claimed, err)
+	}
+	if err := SetJobProgress(reg, claimed.ID, 3, 4); err != nil {
+		t.Fatal(err)
+	}
+	var stored models.Job
+	db.First(&stored, claimed.ID)
+	if stored.Progress != 75 || stored.StartedAt == nil {
+		t.Errorf("Expected 75%% progress, got %+v", stored)
+	}
+	if err := FinishJob(reg, claimed, "Touched 1", nil); err != nil {
+		t.Fatal(err)
+	}
+	db.First(&stored, claimed.ID)
+	if stored.Status != models.JobDone || stored.Progress != 100 || stored.Result != "Touched 1" || stored.FinishedAt == nil {
+		t.Errorf("Unexpected finished job %+v", stored)
+	}
+
+	next, _ := ClaimJob(reg)
+	if next == nil || next.ID != second.ID {
+		t.Fatalf("Expected to claim job %d, got %+v", second.ID, next)
+	}
+	if none, err := ClaimJob(reg); none != nil || err != nil {
+		t.Errorf("Expected an empty queue, got %+v (%v)", none, err)
+	}
+	_ = FinishJob(reg, next, "", errors.New("disk full"))
+	var failed models.Job
+	db.First(&failed, next.ID)
+	if failed.Status != models.JobFailed || failed.Error != "disk full" {
+		t.Errorf("Unexpected failed job %+v", failed)
+	}
+
+	t.Run("Recover", func(t *testing.T) {
+		retry := &models.Job{Kind: "export", Status: models.JobRunning, Attempts: 1, Progress: 40}
+		stuck := &models.Job{Kind: "export", Status: models.JobRunning, Attempts: maxJobAttempts}
+		db.Create(retry)
+		db.Create(stuck)
+		requeued, failed, err := RecoverJobs(reg)
+		if err != nil || requeued != 1 || failed != 1 {
+			t.Fatalf("Expected 1 re-queued and 1 failed, got %d, %d (%v)", requeued, failed, err)
+		}
+		db.First(retry, retry.ID)
+		db.First(stuck, stuck.ID)
+		if retry.Status != models.JobQueued || retry.Progress != 0 || stuck.Status != models.JobFailed || stuck.Error == "" {
+			t.Errorf("Unexpected recovered jobs %+v %+v", retry, stuck)
+		}
+		if again, _ := ClaimJob(reg); again == nil || again.ID != retry.ID || again.Attempts != 2 {
+			t.Errorf("The re-queued job should run again, got %+v", again)
+		}
+
+		lease := time.Now().Add(time.Minute)
+		live := &models.Job{Kind: "export", Status: models.JobRunning, Attempts: 1, Instance: "other-server", LeaseExpiresAt: &lease}
+		db.Create(live)
+		if requeued, failed, _ := RecoverJobs(reg); requeued+failed != 0 {
+			t.Errorf("Jobs with a live lease should be left running, got %d, %d", requeued, failed)
+		}
+	})
+
+	t.Run("LostLease", func(t *testing.T) {
+		defer func(ttl time.Duration) { jobLeaseTTL = ttl }(jobLeaseTTL)
+		jobLeaseTTL = 30 * time.Millisecond
+		job, _ := EnqueueJob(reg, user, "export", "MockModel", "Export", nil)
+		claimed, _ := ClaimJob(reg)
+		if claimed == nil || claimed.ID != job.ID || claimed.Instance == "" {
+			t.Fatalf("Expected to claim job %d with a lease, got %+v", job.ID, claimed)
+		}
+		err := ExecuteJob(context.Background(), reg, claimed, func(ctx context.Context) (string, error) {
+			// Another server recovers the job after this one stalled.
+			db.Model(&models.Job{}).Where("id = ?", job.ID).Updates(map[string]interface{}{"status": models.JobQueued, "instance": "other-server"})
+			select {
+			case <-ctx.Done():
+				return "", ctx.Err()
+			case <-time.After(time.Second):
+				return "too late", nil
+			}
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var stored models.Job
+		db.First(&stored, job.ID)
+		if stored.Status != models.JobQueued {
+			t.Errorf("A job that lost its lease should be cancelled and left to its new server, got %+v", stored)
+		}
+	})
+}
+
+func TestSchedules(t *testing.T) {
+	db, reg := setupTestDB()
+	var calls int
+	failNext := false
+	if err := reg.AddSchedule("Cleanup", "0 3 * * *", func(ctx context.Context, tx *gorm.DB) error {
+		calls++
+		if failNext {
+			return errors.New("disk full")
+		}
+		return tx.Create(&MockModel{Name: "cleaned"}).Error
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.AddSchedule("Cleanup", "@daily", nil); err == nil {
+		t.Error("Duplicate schedule names should be refused")
+	}
+	if err := reg.AddSchedule("Broken", "0 25 * * *", nil); err == nil {
+		t.Error("Invalid cron specs should be refused")
+	}
+	s, _ := reg.GetSchedule("Cleanup")
+	slot := time.Date(2026, 3, 1, 3, 0, 0, 0, time.UTC)
+	lastRun := func() models.ScheduleRun {
+		var run models.ScheduleRun
+		db.Order("id desc").First(&run)
+		return run
+	}
+
+	if err := RunSchedule(context.Background(), reg, s, slot); err != nil {
+		t.Fatal(err)
+	}
+	if run := lastRun(); calls != 1 || run.Status != models.JobDone || run.Trigger != "schedule" || !run.ScheduledAt.Equal(slot) || run.FinishedAt == nil {
+		t.Fatalf("Unexpected run %+v after %d calls", run, calls)
+	}
+	if err := RunSchedule(context.Background(), reg, s, slot); err != nil || calls != 1 {
+		t.Errorf("A slot should only run once, got %d calls (%v)", calls, err)
+	}
+
+	failNext = true
+	if err := RunSchedule(context.Background(), reg, s, slot.AddDate(0, 0, 1)); err == nil || lastRun().Error != "disk full" || lastRun().Status != models.JobFailed {
+		t.Errorf("Failures should be recorded, got %+v", lastRun())
+	}
+
+	t.Run("Lease", func(t *testing.T) {
+		run, err := ClaimSchedule(reg, s, nil, "admin@example.com")
+		if err != nil || run == nil || run.Trigger != "admin@example.com" {
+			t.Fatalf("Expected a manual run, got %+v (%v)", run, err)
+		}
+		if !ScheduleRunning(reg, s) {
+			t.Error("The claimed task should be running")
+		}
+		if other, _ := ClaimSchedule(reg, s, nil, "other@example.com"); other != nil {
+			t.Error("A held lease should refuse other runs")
+		}
+		if late := slot.AddDate(0, 0, 2); RunSchedule(context.Background(), reg, s, late) != nil || lastRun().ID != run.ID {
+			t.Error("Scheduled runs should wait for the lease")
+		}
+
+		// The holder dies: once its lease expires the next claim takes over.
+		db.Model(&models.ScheduleLease{}).Where("name = ?", "Cleanup").Update("expires_at", time.Now().UTC().Add(-time.Second))
+		next, err := ClaimSchedule(reg, s, nil, "admin@example.com")
+		if err != nil || next == nil {
+			t.Fatalf("An expired lease should be taken over (%v)", err)
+		}
+		var dead models.ScheduleRun
+		db.First(&dead, run.ID)
+		if dead.Status != models.JobFailed || !strings.Contains(dead.Error, "interrupted") {
+			t.Errorf("The abandoned run should be marked failed, got %+v", dead)
+		}
+		failNext = false
+		if err := ExecuteSchedule(context.Background(), reg, s, next); err != nil || ScheduleRunning(reg, s) {
+			t.Errorf("The lease should be released after the run (%v)", err)
+		}
+	})
+
+	t.Run("Renewal", func(t *testing.T) {
+		defer func(ttl time.Duration) { scheduleLeaseTTL = ttl }(scheduleLeaseTTL)
+		scheduleLeaseTTL = 30 * time.Millisecond
+		sqlDB, _ := db.DB()
+		sqlDB.SetMaxOpenConns(1) // the heartbeat must share the in-memory database
+		started, release := make(chan struct{}), make(chan struct{})
+		slow := &admin.Schedule{Name: "Slow", Run: func(ctx context.Context, tx *gorm.DB) error {
+			close(started)
+			<-release
+			return nil
+		}}
+		run, _ := ClaimSchedule(reg, slow, nil, "admin@example.com")
+		done := make(chan error)
+		go func() { done <- ExecuteSchedule(context.Background(), reg, slow, run) }()
+		<-started
+		time.Sleep(100 * time.Millisecond)
+		if other, _ := ClaimSchedule(reg, slow, nil, "other@example.com"); other != nil {
+			t.Error("A running task should keep renewing its lease")
+		}
+		close(release)
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("Panic", func(t *testing.T) {
+		boom := &admin.Schedule{Name: "Boom", Run: func(ctx context.Context, tx *gorm.DB) error { panic("boom") }}
+		if err := RunSchedule(context.Background(), reg, boom, slot); err == nil || lastRun().Error != "panic: boom" {
+			t.Errorf("Panics should fail the run, got %+v", lastRun())
+		}
+	})
+}
+
+func TestWebhooks(t *testing.T) {
+	db, reg := setupTestDB()
+	reg.Config.WebhookAllowPrivate = true
+	res := reg.Register(MockModel{})
+	user := &models.AdminUser{ID: 1, Email: "admin@example.com", Role: "admin"}
+	var fails atomic.Int32
+	var received []*http.Request
+	var bodies [][]byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received, bodies = append(received, r), append(bodies, body)
+		if fails.Load() > 0 {
+			fails.Add(-1)
+			http.Error(w, "try later", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	defer srv.Close()
+	hook := &models.Webhook{Name: "Sync", URL: srv.URL, ResourceName: "MockModel", Events: "update, delete", Secret: "s3cret", Active: true}
+	db.Create(hook)
+	db.Create(&models.Webhook{Name: "Off", URL: srv.URL, Secret: "x", Active: false})
+	db.Create(&models.Webhook{Name: "Other", URL: srv.URL, ResourceName: "AdminUser", Secret: "x", Active: true})
+	lastDelivery := func() models.WebhookDelivery {
+		var d models.WebhookDelivery
+		db.Order("id desc").Limit(1).Find(&d)
+		return d
+	}
+
+	t.Run("Validate", func(t *testing.T) {
+		if err := (models.Webhook{URL: "ftp://example.com", Secret: "x"}).Validate(); err == nil {
+			t.Error("Expected non-HTTP URLs to be rejected")
+		}
+		if err := (models.Webhook{URL: srv.URL, Secret: "x", Events: "create,rename"}).Validate(); err == nil {
+			t.Error("Expected unknown events to be rejected")
+		}
+		if err := hook.Validate(); err != nil {
+			t.Errorf("Expected a valid webhook, got %v", err)
+		}
+	})
+
+	t.Run("Queue", func(t *testing.T) {
+		RecordChange(reg, user, res, "1", "Create", "Record created", nil, map[string]interface{}{"ID": 1, "Name": "a"})
+		var n int64
+		if db.Model(&models.WebhookDelivery{}).Count(&n); n != 0 {
+			t.Fatalf("Creates are not subscribed to, got %d deliveries", n)
+		}
+		RecordChange(reg, user, res, "1", "Update", "Record updated", map[string]interface{}{"ID": 1, "Name": "a"}, map[string]interface{}{"ID": 1, "Name": "b"})
+		if db.Model(&models.WebhookDelivery{}).Count(&n); n != 1 {
+			t.Fatalf("Expected one delivery, got %d", n)
+		}
+		d := lastDelivery()
+		var p webhookPayload
+		if err := json.Unmarshal([]byte(d.Payload), &p); err != nil {
+			t.Fatal(err)
+		}
+		if d.WebhookID != hook.ID || d.Status != models.DeliveryPending || p.Event != "update" || p.RecordID != "1" || p.User != user.Email ||
+			len(p.Diff) != 1 || p.Diff[0].Field != "Name" || p.Diff[0].New != "b" || p.AuditLogID != d.AuditLogID || d.AuditLogID == 0 {
+			t.Errorf("Unexpected delivery %+v with payload %+v", d, p)
+		}
+	})
+
+	t.Run("Deliver", func(t *testing.T) {
+		n, err := DeliverWebhooks(context.Background(), reg)
+		if err != nil || n != 1 {
+			t.Fatalf("Expected one attempt, got %d (%v)", n, err)
+		}
+		d := lastDelivery()
+		if d.Status != models.DeliveryDelivered || d.ResponseCode != 200 || d.Attempts != 1 || d.DeliveredAt == nil {
+			t.Errorf("Unexpected delivery %+v", d)
+		}
+		r := received[len(received)-1]
+		if r.Header.Get("X-Webhook-Signature") != hook.Sign(bodies[len(bodies)-1]) || r.Header.Get("X-Webhook-Event") != "update" ||
+			r.Header.Get("X-Webhook-Delivery") != fmt.Sprint(d.ID) || string(bodies[len(bodies)-1]) != d.Payload {
+			t.Errorf("Unexpected request headers %v", r.Header)
+		}
+	})
+
+	t.Run("Retry", func(t *testing.T) {
+		fails.Store(1)
+		RecordChange(reg, user, res, "1", "Delete", "Record deleted", map[string]interface{}{"ID": 1, "Name": "b"}, nil)
+		if n, _ := DeliverWebhooks(context.Background(), reg); n != 1 {
+			t.Fatalf("Expected one attempt, got %d", n)
+		}
+		d := lastDelivery()
+		if d.Status != models.DeliveryPending || d.ResponseCode != 503 || d.Error == "" || time.Until(d.NextAttemptAt) < 50*time.Second {
+			t.Fatalf("Expected a retry in a minute, got %+v", d)
+		}
+		if n, _ := DeliverWebhooks(context.Background(), reg); n != 0 {
+			t.Errorf("The retry is not due yet, got %d attempts", n)
+		}
+		db.Model(&d).Update("next_attempt_at", time.Now().UTC().Add(-time.Second))
+		DeliverWebhooks(context.Background(), reg)
+		if d = lastDelivery(); d.Status != models.DeliveryDelivered || d.Attempts != 2 || d.Error != "" {
+			t.Errorf("Expected the retry to succeed, got %+v", d)
+		}
+
+		saved := webhookBackoff
+		webhookBackoff = 0
+		defer func() { webhookBackoff = saved }()
+		fails.Store(maxWebhookAttempts + 1)
+		RecordChange(reg, user, res, "1", "Update", "Record updated", map[string]interface{}{"ID": 1, "Name": "b"}, map[string]interface{}{"ID": 1, "Name": "c"})
+		if n, _ := DeliverWebhooks(context.Background(), reg); n != maxWebhookAttempts {
+			t.Errorf("Expected %d attempts, got %d", maxWebhookAttempts, n)
+		}
+		if d = lastDelivery(); d.Status != models.DeliveryFailed || d.Attempts != maxWebhookAttempts {
+			t.Errorf("Expected the delivery to give up, got %+v", d)
+		}
+		fails.Store(0)
+	})
+
+	t.Run("Replay", func(t *testing.T) {
+		failed := lastDelivery()
+		replay, err := ReplayDelivery(reg, fmt.Sprint(failed.ID))
+		if err != nil || replay.Payload != failed.Payload || replay.Status != models.DeliveryPending || replay.Attempts != 0 {
+			t.Fatalf("Unexpected replay %+v (%v)", replay, err)
+		}
+		DeliverWebhooks(context.Background(), reg)
+		if d := lastDelivery(); d.ID != replay.ID || d.Status != models.DeliveryDelivered {
+			t.Errorf("Expected the replay to be delivered, got %+v", d)
+		}
+		db.Delete(hook)
+		ReplayDelivery(reg, fmt.Sprint(failed.ID))
+		DeliverWebhooks(context.Background(), reg)
+		if d := lastDelivery(); d.Status != models.DeliveryFailed || !strings.Contains(d.Error, "deleted") {
+			t.Errorf("Deliveries of deleted webhooks should fail, got %+v", d)
+		}
+		if _, err := ReplayDelivery(reg, "999"); err == nil {
+			t.Error("Expected an error for an unknown delivery")
+		}
+	})
+
+	t.Run("PrivateAddress", func(t *testing.T) {
+		reg.Config.WebhookAllowPrivate = false
+		defer func() { reg.Config.WebhookAllowPrivate = true }()
+		before := len(received)
+		local := &models.Webhook{Name: "Local", URL: srv.URL, ResourceName: "MockModel", Secret: "x", Active: true}
+		db.Create(local)
+		RecordChange(reg, user, res, "2", "Create", "Record created", nil, map[string]interface{}{"ID": 2, "Name": "d"})
+		DeliverWebhooks(context.Background(), reg)
+		if d := lastDelivery(); d.WebhookID != local.ID || d.Status != models.DeliveryPending || !strings.Contains(d.Error, "not public") || len(received) != before {
+			t.Errorf("Expected loopback endpoints to be refused, got %+v", d)
+		}
+		for _, ip := range []string{"10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254", "::1", "fe80::1", "fd00::1", "0.0.0.0"} {
+			if !internalIP(net.ParseIP(ip)) {
+				t.Errorf("Expected %s to be internal", ip)
+			}
+		}
+		if internalIP(net.ParseIP("93.184.216.34")) {
+			t.Error("Expected a public address to be allowed")
+		}
+	})
+}
+
+type Entry struct {
+	ID    uint `gorm:"primaryKey"`
+	Title string
+	Count int
+	Done  bool
+	Due   time.Time
+	Notes string
+}
+
+func TestQueryBuilder(t *testing.T) {
+	db, reg := setupTestDB()
+	if err := db.AutoMigrate(&Entry{}); err != nil {
+		t.Fatal(err)
+	}
+	res := reg.RegisterAuto(Entry{}).SetFilterable("Notes", false).SetSortable("Notes", false)
+	due := time.Date(2026, 3, 1, 0, 0, 0, 0, time.Local)
+	db.Create(&Entry{Title: "Alpha", Count: 5, Done: true, Due: due, Notes: "kept"})
+	db.Create(&Entry{Title: "Beta", Count: 50, Due: due.AddDate(0, 1, 0), Notes: "kept"})
+	db.Create(&Entry{Title: "Alphabet", Count: 500, Due: due.AddDate(0, 2, 0)})
+	qb := NewQueryBuilder(reg, res, nil, nil)
+	titles := func(q *gorm.DB) string {
+		var entries []Entry
+		q.Order("id").Find(&entries)
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Title)
+		}
+		return strings.Join(names, ",")
+	}
+
+	t.Run("Filter", func(t *testing.T) {
+		for query, want := range map[string]string{
+			"q_Title=alpha":             "Alpha,Alphabet",
+			"min_Count=10&max_Count=50": "Beta",
+			"q_Count=500":               "Alphabet",
+			"q_Done=true":               "Alpha",
+			"min_Due=2026-03-15":        "Beta,Alphabet",
+			"q_Title=&page=2&order=asc": "Alpha,Beta,Alphabet",
+		} {
+			params, _ := url.ParseQuery(query)
+			q, _, err := qb.Filter(db.Model(&Entry{}), params)
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", query, err)
+			} else if got := titles(q); got != want {
+				t.Errorf("%s: expected %s, got %s", query, want, got)
+			}
+		}
+	})
+
+	t.Run("Rejected", func(t *testing.T) {
+		for query, want := range map[string]string{
+			"q_Nope=1":                      "q_Nope: unknown filter",
+			"q_Notes=kept":                  "q_Notes: unknown filter",
+			"q_title) OR 1=1 --=x":          "unknown filter",
+			"min_Count=lots":                `"lots" is not a number`,
+			"max_Due=soon":                  `"soon" is not a date`,
+			"min_Title=A":                   "min_ only applies to number and date fields",
+			"q_Done=maybe":                  `"maybe" is not true or false`,
+			"q_Title=a&q_Nope=1&min_Count=": "q_Nope: unknown filter",
+		} {
+			params, _ := url.ParseQuery(query)
+			_, _, err := qb.Filter(db.Model(&Entry{}), params)
+			var fe *FilterError
+			if !errors.As(err, &fe) || !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: expected %q, got %v", query, want, err)
+			}
+		}
+	})
+
+	t.Run("Sort", func(t *testing.T) {
+		q, field, order, err := qb.Sort(db.Model(&Entry{}), "Count", "desc")
+		var entries []Entry
+		if err != nil || field != "Count" || order != "desc" || q.Find(&entries).Error != nil || entries[0].Title != "Alphabet" {
+			t.Errorf("Expected entries by descending count, got %v %q %q", err, field, order)
+		}
+		for _, c := range [][2]string{{"Notes", "asc"}, {"id; DROP TABLE entries", "asc"}, {"Title", "sideways"}} {
+			if _, _, _, err := qb.Sort(db.Model(&Entry{}), c[0], c[1]); err == nil {
+				t.Errorf("Sorting on %q %q should fail", c[0], c[1])
+			}
+		}
+		if _, field, _, err := qb.Sort(db.Model(&Entry{}), "", ""); err != nil || field != "" {
+			t.Errorf("An empty sort should use the default order, got %v %q", err, field)
+		}
+	})
+
+	t.Run("Params", func(t *testing.T) {
+		values := map[schema.DataType]string{schema.Int: "5", schema.Uint: "5", schema.Bool: "true", schema.Time: "2026-03-01"}
+		var names []string
+		for _, p := range qb.FilterParams() {
+			names = append(names, p.Name)
+			val, ok := values[p.Type]
+			if !ok {
+				val = "a"
+			}
+			if _, _, err := qb.Filter(db.Model(&Entry{}), url.Values{p.Name: {val}}); err != nil {
+				t.Errorf("Filter should accept the parameter it offers: %v", err)
+			}
+		}
+		for _, want := range []string{"q_Title", "min_Count", "max_Due", "q_Done"} {
+			if !slices.Contains(names, want) {
+				t.Errorf("Expected %s among %v", want, names)
+			}
+		}
+		for _, unwanted := range []string{"q_Notes", "min_Title", "min_Done"} {
+			if slices.Contains(names, unwanted) {
+				t.Errorf("Did not expect %s among %v", unwanted, names)
+			}
+		}
+		for _, f := range qb.SortFields() {
+			if _, _, _, err := qb.Sort(db.Model(&Entry{}), f, "asc"); err != nil {
+				t.Errorf("Sort should accept the field it offers: %v", err)
+			}
+		}
+		if slices.Contains(qb.SortFields(), "Notes") {
+			t.Error("Fields that are not sortable should not be offered")
+		}
+	})
+
+	t.Run("Search", func(t *testing.T) {
+		if got := titles(qb.Search(db.Model(&Entry{}), "bet")); got != "Beta,Alphabet" {
+			t.Errorf("Expected a match on titles, got %s", got)
+		}
+		if got := titles(qb.Search(db.Model(&Entry{}), "kept")); got != "" {
+			t.Errorf("Search should skip fields that are not filterable, got %s", got)
+		}
+	})
+
+	t.Run("Sensitive", func(t *testing.T) {
+		res.SetSensitive("Title")
+		defer func() { res.SensitiveFields = nil }()
+		params, _ := url.ParseQuery("q_Title=alp")
+		if _, _, err := qb.Filter(db.Model(&Entry{}), params); err == nil || err.Error() != "q_Title: unknown filter" {
+			t.Errorf("Filters on sensitive fields should be rejected, got %v", err)
+		}
+		if _, _, _, err := qb.Sort(db.Model(&Entry{}), "Title", "asc"); err == nil {
+			t.Error("Sorting on sensitive fields should be rejected")
+		}
+		if got := titles(qb.Search(db.Model(&Entry{}), "bet")); got != "Alpha,Beta,Alphabet" {
+			t.Errorf("Search should skip sensitive fields, leaving none to match, got %s", got)
+		}
+		if slices.ContainsFunc(qb.FilterFields(), func(f resource.Field) bool { return f.Name == "Title" }) ||
+			slices.ContainsFunc(qb.FilterParams(), func(p FilterParam) bool { return p.Name == "q_Title" }) || slices.Contains(qb.SortFields(), "Title") {
+			t.Error("Sensitive fields should not be offered as filters or sorts")
+		}
+	})
 }
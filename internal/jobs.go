@@ -0,0 +1,169 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-packs/go-admin"
+	"github.com/go-packs/go-admin/models"
+	"gorm.io/gorm"
+)
+
+// maxJobAttempts is how often a job interrupted by a restart is started
+// before RecoverJobs gives up on it.
+const maxJobAttempts = 3
+
+// jobLeaseTTL is how long a running job's lease lasts without being renewed.
+// ExecuteJob renews it every third of that.
+var jobLeaseTTL = time.Minute
+
+var jobQueued = make(chan struct{}, 1)
+
+// JobQueued signals that a job was enqueued, so an idle worker can pick it
+// up without waiting for its next poll.
+func JobQueued() <-chan struct{} { return jobQueued }
+
+// EnqueueJob saves a queued job of kind on resName for user, with payload
+// stored as JSON, and wakes a worker.
+func EnqueueJob(reg *admin.Registry, user *models.AdminUser, kind, resName, label string, payload interface{}) (*models.Job, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	job := &models.Job{
+		Kind: kind, ResourceName: resName, Label: label, Payload: string(b),
+		Status: models.JobQueued, UserID: user.ID, UserEmail: user.Email,
+	}
+	if err := reg.DB.Create(job).Error; err != nil {
+		return nil, err
+	}
+	select {
+	case jobQueued <- struct{}{}:
+	default:
+	}
+	return job, nil
+}
+
+// ClaimJob marks the oldest queued job as running on this server, with a
+// fresh lease, and returns it, or nil when nothing is queued. The update only
+// succeeds while the job is still queued, so two workers never claim the same
+// job.
+func ClaimJob(reg *admin.Registry) (*models.Job, error) {
+	for {
+		var job models.Job
+		if err := reg.DB.Where("status = ?", models.JobQueued).Order("id").Limit(1).Find(&job).Error; err != nil {
+			return nil, err
+		}
+		if job.ID == 0 {
+			return nil, nil
+		}
+		now := time.Now()
+		lease := now.Add(jobLeaseTTL)
+		q := reg.DB.Model(&models.Job{}).Where("id = ? AND status = ?", job.ID, models.JobQueued).
+			Updates(map[string]interface{}{
+				"status": models.JobRunning, "started_at": now, "attempts": gorm.Expr("attempts + 1"),
+				"instance": instanceID, "lease_expires_at": lease,
+			})
+		if q.Error != nil {
+			return nil, q.Error
+		}
+		if q.RowsAffected == 1 {
+			job.Status, job.StartedAt, job.Attempts = models.JobRunning, &now, job.Attempts+1
+			job.Instance, job.LeaseExpiresAt = instanceID, &lease
+			return &job, nil
+		}
+	}
+}
+
+// SetJobProgress stores the progress of a running job as the percentage of
+// done out of total.
+func SetJobProgress(reg *admin.Registry, id uint, done, total int) error {
+	pct := 0
+	if total > 0 {
+		pct = min(max(done*100/total, 0), 100)
+	}
+	return reg.DB.Model(&models.Job{}).Where("id = ? AND status = ?", id, models.JobRunning).Update("progress", pct).Error
+}
+
+// FinishJob records the outcome of a running job: done with result, or
+// failed with err. Jobs whose lease passed to another server are left alone.
+func FinishJob(reg *admin.Registry, job *models.Job, result string, err error) error {
+	now := time.Now()
+	job.FinishedAt, job.Result = &now, result
+	if err != nil {
+		job.Status, job.Error = models.JobFailed, err.Error()
+	} else {
+		job.Status, job.Progress = models.JobDone, 100
+	}
+	return reg.DB.Model(&models.Job{}).Where("id = ? AND instance = ?", job.ID, job.Instance).Updates(map[string]interface{}{
+		"status": job.Status, "progress": job.Progress, "result": job.Result, "error": job.Error, "finished_at": now,
+	}).Error
+}
+
+// ExecuteJob runs a job claimed with ClaimJob through run, renewing its lease
+// until run returns, and records the outcome. The context passed to run is
+// cancelled when ctx is done or the lease is lost.
+func ExecuteJob(ctx context.Context, reg *admin.Registry, job *models.Job, run func(ctx context.Context) (string, error)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(jobLeaseTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				q := reg.DB.Model(&models.Job{}).Where("id = ? AND status = ? AND instance = ?", job.ID, models.JobRunning, instanceID).
+					Update("lease_expires_at", time.Now().Add(jobLeaseTTL))
+				if q.Error == nil && q.RowsAffected == 0 {
+					cancel()
+				}
+			}
+		}
+	}()
+
+	result, err := func() (result string, err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				err = fmt.Errorf("panic: %v", p)
+			}
+		}()
+		return run(ctx)
+	}()
+	cancel()
+	wg.Wait()
+	return FinishJob(reg, job, result, err)
+}
+
+// RecoverJobs deals with running jobs whose lease expired because their
+// server stopped. Jobs with attempts left are queued again, the others are
+// marked failed. Jobs another server is still running keep their lease, so
+// it is safe to call from every server, at start-up and periodically. It
+// returns how many jobs were re-queued and how many failed.
+func RecoverJobs(reg *admin.Registry) (int, int, error) {
+	var requeued, failed int64
+	err := reg.DB.Transaction(func(tx *gorm.DB) error {
+		expired := func() *gorm.DB {
+			return tx.Model(&models.Job{}).Where("status = ? AND (lease_expires_at IS NULL OR lease_expires_at < ?)", models.JobRunning, time.Now())
+		}
+		q := expired().Where("attempts < ?", maxJobAttempts).
+			Updates(map[string]interface{}{"status": models.JobQueued, "progress": 0})
+		if q.Error != nil {
+			return q.Error
+		}
+		requeued = q.RowsAffected
+		q = expired().Updates(map[string]interface{}{
+			"status": models.JobFailed, "finished_at": time.Now(),
+			"error": fmt.Sprintf("interrupted by a restart %d times", maxJobAttempts),
+		})
+		failed = q.RowsAffected
+		return q.Error
+	})
+	return int(requeued), int(failed), err
+}
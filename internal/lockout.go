@@ -0,0 +1,106 @@
+package internal
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-packs/go-admin"
+	"github.com/go-packs/go-admin/models"
+)
+
+// ClientIP returns the IP address of the client that sent r.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func emailSubject(email string) string { return "email:" + strings.ToLower(strings.TrimSpace(email)) }
+func ipSubject(ip string) string       { return "ip:" + ip }
+
+// LoginLockedUntil reports whether logins for email or from ip are locked out, and until when.
+func LoginLockedUntil(reg *admin.Registry, email, ip string, now time.Time) (time.Time, bool) {
+	var throttles []models.LoginThrottle
+	reg.DB.Where("subject IN ? AND locked_until > ?", []string{emailSubject(email), ipSubject(ip)}, now).Find(&throttles)
+	var until time.Time
+	for _, t := range throttles {
+		if t.LockedUntil.After(until) {
+			until = *t.LockedUntil
+		}
+	}
+	return until, len(throttles) > 0
+}
+
+// RecordLoginFailure counts a failed login against ip and, when email names
+// an account, against email, locking either once it reaches its configured
+// threshold. Every failure past the threshold doubles the lockout, up to
+// MaxLockoutSeconds. Unknown emails are left to the IP limit, so that
+// guessing addresses stores nothing per address.
+func RecordLoginFailure(reg *admin.Registry, email, ip string, now time.Time) {
+	var user models.AdminUser
+	reg.DB.Where("email = ?", email).Limit(1).Find(&user)
+	if user.ID != 0 {
+		if t, d := bumpThrottle(reg, emailSubject(email), reg.Config.LoginMaxAttempts, now); d > 0 {
+			RecordAction(reg, &user, "AdminUser", strconv.Itoa(int(user.ID)), "Lock",
+				fmt.Sprintf("Locked for %s after %d failed logins (last from %s)", d, t.Failures, ip))
+		}
+	}
+	if t, d := bumpThrottle(reg, ipSubject(ip), reg.Config.LoginIPMaxAttempts, now); d > 0 {
+		RecordAction(reg, &user, "LoginThrottle", ip, "Lock",
+			fmt.Sprintf("IP locked for %s after %d failed logins", d, t.Failures))
+	}
+}
+
+// ResetLoginFailures clears the failure count for email after a successful login.
+// IP counters are left to expire so one valid account cannot reset them.
+func ResetLoginFailures(reg *admin.Registry, email string) {
+	reg.DB.Where("subject = ?", emailSubject(email)).Delete(&models.LoginThrottle{})
+}
+
+// UnlockAccount lifts any lockout on email and records who did it.
+func UnlockAccount(reg *admin.Registry, actor *models.AdminUser, user *models.AdminUser) error {
+	if err := reg.DB.Where("subject = ?", emailSubject(user.Email)).Delete(&models.LoginThrottle{}).Error; err != nil {
+		return err
+	}
+	RecordAction(reg, actor, "AdminUser", strconv.Itoa(int(user.ID)), "Unlock", "Unlocked "+user.Email)
+	return nil
+}
+
+// bumpThrottle adds a failure to subject and returns the lockout it started, if any.
+func bumpThrottle(reg *admin.Registry, subject string, limit int, now time.Time) (*models.LoginThrottle, time.Duration) {
+	var t models.LoginThrottle
+	reg.DB.Where(models.LoginThrottle{Subject: subject}).FirstOrInit(&t)
+	maxLock := time.Duration(reg.Config.MaxLockoutSeconds) * time.Second
+	if (t.LockedUntil == nil || t.LockedUntil.Before(now)) && now.Sub(t.LastFailureAt) > maxLock {
+		t.Failures = 0
+	}
+	t.Failures++
+	t.LastFailureAt = now
+	var d time.Duration
+	if limit > 0 && t.Failures >= limit {
+		d = lockoutDuration(reg, t.Failures-limit)
+		until := now.Add(d)
+		t.LockedUntil = &until
+	}
+	reg.DB.Save(&t)
+	return &t, d
+}
+
+// lockoutDuration returns LockoutSeconds doubled n times, capped at MaxLockoutSeconds.
+func lockoutDuration(reg *admin.Registry, n int) time.Duration {
+	d := time.Duration(reg.Config.LockoutSeconds) * time.Second
+	maxLock := time.Duration(reg.Config.MaxLockoutSeconds) * time.Second
+	for i := 0; i < n && d < maxLock; i++ {
+		d *= 2
+	}
+	if maxLock > 0 && d > maxLock {
+		d = maxLock
+	}
+	return d
+}
@@ -0,0 +1,261 @@
+package internal
+
+import (
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/go-packs/go-admin"
+	"github.com/go-packs/go-admin/models"
+	"github.com/go-packs/go-admin/resource"
+	"gorm.io/gorm/schema"
+)
+
+// Shared component schemas are namespaced with a dot, which Go type names,
+// and so resource names, cannot contain.
+const (
+	errorSchema        = "admin.Error"
+	actionResultSchema = "admin.ActionResult"
+)
+
+// OpenAPI returns an OpenAPI 3.1 document describing the JSON API exposed for
+// every registered resource under /admin/api. It is served at
+// /admin/api/openapi.json. The list parameters are those the QueryBuilder
+// accepts from user.
+func OpenAPI(reg *admin.Registry, user *models.AdminUser) map[string]interface{} {
+	names := reg.ResourceNames()
+	sort.Strings(names)
+	paths := make(map[string]interface{})
+	schemas := map[string]interface{}{
+		errorSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"error": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"status":  map[string]interface{}{"type": "integer"},
+						"message": map[string]interface{}{"type": "string"},
+						"fields":  map[string]interface{}{"type": "object", "additionalProperties": map[string]interface{}{"type": "string"}},
+					},
+				},
+			},
+		},
+		actionResultSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"status":   map[string]interface{}{"type": "integer"},
+				"location": map[string]interface{}{"type": "string"},
+				"output":   map[string]interface{}{"type": "string"},
+				"result":   map[string]interface{}{},
+			},
+		},
+	}
+	for _, name := range names {
+		res := reg.Resources[name]
+		schemas[name] = resourceSchema(res)
+		base := "/admin/api/" + name
+		paths[base] = map[string]interface{}{
+			"get": operation(name, "list"+name, "List "+name+" records", listParameters(NewQueryBuilder(reg, res, user, FieldRestrictions(reg, user, name)), res), nil,
+				jsonResponse("200", "A page of records", map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"data": map[string]interface{}{"type": "array", "items": schemaRef(name)},
+						"meta": map[string]interface{}{
+							"type": "object",
+							"properties": map[string]interface{}{
+								"page": intSchema(), "per_page": intSchema(), "total_count": intSchema(), "total_pages": intSchema(),
+							},
+						},
+					},
+				})),
+			"post": operation(name, "create"+name, "Create a "+name, nil, requestBody(name), jsonResponse("201", "The created record", dataOf(name))),
+		}
+		idParam := []interface{}{pathParam("id")}
+		paths[base+"/{id}"] = map[string]interface{}{
+			"get":    operation(name, "get"+name, "Fetch a "+name, idParam, nil, jsonResponse("200", "The record", dataOf(name))),
+			"put":    operation(name, "replace"+name, "Replace a "+name, idParam, requestBody(name), jsonResponse("200", "The updated record", dataOf(name))),
+			"patch":  operation(name, "update"+name, "Partially update a "+name, idParam, requestBody(name), jsonResponse("200", "The updated record", dataOf(name))),
+			"delete": operation(name, "delete"+name, "Delete a "+name, idParam, nil, map[string]interface{}{"204": map[string]interface{}{"description": "Deleted"}}),
+		}
+		for _, a := range res.MemberActions {
+			paths[base+"/{id}/actions/"+a.Name] = map[string]interface{}{
+				"post": operation(name, name+"Action_"+a.Name, a.Label, idParam, nil, jsonResponse("200", "Action result", schemaRef(actionResultSchema))),
+			}
+		}
+		for _, a := range res.CollectionActions {
+			paths[base+"/actions/"+a.Name] = map[string]interface{}{
+				"post": operation(name, name+"CollectionAction_"+a.Name, a.Label, nil, nil, jsonResponse("200", "Action result", schemaRef(actionResultSchema))),
+			}
+		}
+		for _, a := range res.BatchActions {
+			body := map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{"application/json": map[string]interface{}{"schema": map[string]interface{}{
+					"type":       "object",
+					"required":   []string{"ids"},
+					"properties": map[string]interface{}{"ids": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": []string{"integer", "string"}}}},
+				}}},
+			}
+			paths[base+"/batch_actions/"+a.Name] = map[string]interface{}{
+				"post": operation(name, name+"BatchAction_"+a.Name, a.Label, nil, body, jsonResponse("200", "Action result", schemaRef(actionResultSchema))),
+			}
+		}
+	}
+	return map[string]interface{}{
+		"openapi": "3.1.0",
+		"info":    map[string]interface{}{"title": reg.Config.SiteTitle + " API", "version": "1.0.0"},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer"},
+				"cookieAuth": map[string]interface{}{"type": "apiKey", "in": "cookie", "name": "admin_session"},
+			},
+		},
+		"security": []interface{}{
+			map[string]interface{}{"bearerAuth": []string{}},
+			map[string]interface{}{"cookieAuth": []string{}},
+		},
+	}
+}
+
+// resourceSchema builds the JSON schema of a resource from its fields and model types.
+func resourceSchema(res *resource.Resource) map[string]interface{} {
+	t := reflect.TypeOf(res.Model)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	props := make(map[string]interface{})
+	if sf, ok := t.FieldByName("ID"); ok {
+		s := typeSchema(sf.Type)
+		s["readOnly"] = true
+		props["ID"] = s
+	}
+	for _, f := range res.Fields {
+		sf, ok := t.FieldByName(f.Name)
+		if !ok {
+			continue
+		}
+		s := typeSchema(sf.Type)
+		s["title"] = f.Label
+		if len(f.Options) > 0 {
+			s["enum"] = f.Options
+		}
+		if f.Readonly {
+			s["readOnly"] = true
+		}
+		props[f.Name] = s
+	}
+	return map[string]interface{}{"type": "object", "properties": props}
+}
+
+// typeSchema maps a Go type onto a JSON schema type.
+func typeSchema(t reflect.Type) map[string]interface{} {
+	nullable := false
+	if t.Kind() == reflect.Ptr {
+		t, nullable = t.Elem(), true
+	}
+	s := map[string]interface{}{}
+	switch {
+	case t == reflect.TypeOf(time.Time{}):
+		s["type"], s["format"] = "string", "date-time"
+	case t.Kind() == reflect.Bool:
+		s["type"] = "boolean"
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Int64:
+		s["type"], s["format"] = "integer", "int64"
+	case t.Kind() >= reflect.Uint && t.Kind() <= reflect.Uint64:
+		s["type"], s["format"], s["minimum"] = "integer", "int64", 0
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		s["type"] = "number"
+	case t.Kind() == reflect.String:
+		s["type"] = "string"
+	}
+	if nullable && s["type"] != nil {
+		s["type"] = []interface{}{s["type"], "null"}
+	}
+	return s
+}
+
+// listParameters describes the page, scope, sort and filter query parameters
+// of a list request, as b accepts them.
+func listParameters(b *QueryBuilder, res *resource.Resource) []interface{} {
+	params := []interface{}{
+		queryParam("page", intSchema()),
+		queryParam("per_page", map[string]interface{}{"type": "integer", "minimum": 1, "maximum": 100}),
+		queryParam("order", map[string]interface{}{"type": "string", "enum": []string{"asc", "desc"}}),
+	}
+	if len(res.Scopes) > 0 {
+		var scopes []string
+		for _, s := range res.Scopes {
+			scopes = append(scopes, s.Name)
+		}
+		params = append(params, queryParam("scope", map[string]interface{}{"type": "string", "enum": scopes}))
+	}
+	if sortable := b.SortFields(); len(sortable) > 0 {
+		params = append(params, queryParam("sort", map[string]interface{}{"type": "string", "enum": sortable}))
+	}
+	for _, p := range b.FilterParams() {
+		params = append(params, queryParam(p.Name, filterSchema(p)))
+	}
+	return params
+}
+
+// filterSchema returns the schema of the values of filter parameter p.
+func filterSchema(p FilterParam) map[string]interface{} {
+	switch {
+	case p.Type == schema.Int || p.Type == schema.Uint || p.Type == schema.Float:
+		return map[string]interface{}{"type": "number"}
+	case p.Type == schema.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case p.Type == schema.Time && p.Kind != "q":
+		return map[string]interface{}{"type": "string", "description": "An RFC 3339 time, or a date such as 2006-01-02"}
+	}
+	return map[string]interface{}{"type": "string"}
+}
+
+func operation(tag, id, summary string, params []interface{}, body map[string]interface{}, responses map[string]interface{}) map[string]interface{} {
+	responses["default"] = map[string]interface{}{
+		"description": "Error",
+		"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": schemaRef(errorSchema)}},
+	}
+	op := map[string]interface{}{"tags": []string{tag}, "operationId": id, "summary": summary, "responses": responses}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+	if body != nil {
+		op["requestBody"] = body
+	}
+	return op
+}
+
+func requestBody(name string) map[string]interface{} {
+	return map[string]interface{}{
+		"required": true,
+		"content":  map[string]interface{}{"application/json": map[string]interface{}{"schema": schemaRef(name)}},
+	}
+}
+
+func jsonResponse(code, desc string, schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{code: map[string]interface{}{
+		"description": desc,
+		"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}},
+	}}
+}
+
+func dataOf(name string) map[string]interface{} {
+	return map[string]interface{}{"type": "object", "properties": map[string]interface{}{"data": schemaRef(name)}}
+}
+
+func schemaRef(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+func pathParam(name string) map[string]interface{} {
+	return map[string]interface{}{"name": name, "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"}}
+}
+
+func queryParam(name string, schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"name": name, "in": "query", "schema": schema}
+}
+
+func intSchema() map[string]interface{} { return map[string]interface{}{"type": "integer"} }
@@ -0,0 +1,284 @@
+package internal
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-packs/go-admin"
+	"github.com/go-packs/go-admin/models"
+	"github.com/go-packs/go-admin/resource"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// FilterError reports a list query parameter that names no filter or sort of
+// the resource, or whose value does not suit the field.
+type FilterError struct {
+	Param, Message string
+}
+
+func (e *FilterError) Error() string { return fmt.Sprintf("%s: %s", e.Param, e.Message) }
+
+// dateLayouts are the accepted formats of min_ and max_ values of date
+// columns.
+var dateLayouts = []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02"}
+
+// QueryBuilder applies the filter, sort and search parameters of list
+// requests to queries over a resource. Parameters only reach SQL as the
+// column names of declared fields the user may read, and values are always
+// bound.
+type QueryBuilder struct {
+	reg  *admin.Registry
+	res  *resource.Resource
+	user *models.AdminUser
+	fr   resource.FieldRestrictions
+}
+
+// NewQueryBuilder returns the query builder of res for user, whose field
+// restrictions are fr.
+func NewQueryBuilder(reg *admin.Registry, res *resource.Resource, user *models.AdminUser, fr resource.FieldRestrictions) *QueryBuilder {
+	return &QueryBuilder{reg: reg, res: res, user: user, fr: fr}
+}
+
+// FilterFields returns the fields the list may be filtered on. Sensitive
+// fields never are, whatever their Filterable flag says: matching a value
+// piece by piece would reveal it.
+func (b *QueryBuilder) FilterFields() []resource.Field {
+	return slices.DeleteFunc(slices.Clone(b.fr.Apply(b.res.Fields)), func(f resource.Field) bool { return !f.Filterable || b.res.IsSensitive(f.Name) })
+}
+
+// FilterParam is a query parameter accepted by Filter. Kind is q, min or
+// max, and Type the data type of the column it compares.
+type FilterParam struct {
+	Name, Kind string
+	Type       schema.DataType
+}
+
+// FilterParams returns every parameter Filter accepts: those of
+// FilterFields, then those of the association paths to the filterable
+// fields of their targets that user may list.
+func (b *QueryBuilder) FilterParams() []FilterParam {
+	var params []FilterParam
+	add := func(name string, typ schema.DataType) {
+		for _, kind := range filterKinds(typ) {
+			params = append(params, FilterParam{Name: kind + "_" + name, Kind: kind, Type: typ})
+		}
+	}
+	for _, f := range b.FilterFields() {
+		if sf, ok := schemaField(b.reg, b.res, f.Name); ok {
+			add(f.Name, sf.DataType)
+		}
+	}
+	for _, path := range b.paths() {
+		if p, ok := ResolvePath(b.reg, b.res, path, b.user); ok && p.Field.Filterable {
+			add(path, p.targetType)
+		}
+	}
+	return params
+}
+
+// SortFields returns the names Sort accepts: the sortable fields the user
+// may read that are not sensitive, then the sortable association paths.
+func (b *QueryBuilder) SortFields() []string {
+	var names []string
+	for _, f := range b.fr.Apply(b.res.Fields) {
+		if _, ok := b.sortColumn(f.Name); ok {
+			names = append(names, f.Name)
+		}
+	}
+	for _, path := range b.paths() {
+		if p, ok := ResolvePath(b.reg, b.res, path, b.user); ok && p.Field.Sortable {
+			names = append(names, path)
+		}
+	}
+	return names
+}
+
+// sortColumn returns the column of the named field if it is sortable and
+// not sensitive.
+func (b *QueryBuilder) sortColumn(name string) (string, bool) {
+	col, ok := ColumnName(b.reg, b.res, name)
+	if !ok || b.res.IsSensitive(name) || !slices.ContainsFunc(b.res.Fields, func(f resource.Field) bool { return f.Name == name && f.Sortable }) {
+		return "", false
+	}
+	return col, true
+}
+
+// paths lists the association paths of the BelongsTo associations of the
+// resource, named after their target resources, without checking them.
+func (b *QueryBuilder) paths() []string {
+	var paths []string
+	for _, a := range b.res.Associations {
+		target, ok := b.reg.GetResource(a.ResourceName)
+		if a.Type != "BelongsTo" || !ok {
+			continue
+		}
+		for _, f := range target.Fields {
+			paths = append(paths, a.ResourceName+"."+f.Name)
+		}
+	}
+	return paths
+}
+
+// Filter applies the q_, min_ and max_ parameters of params to db and
+// returns them, keyed by parameter. q_ matches text and date columns by
+// substring and others by value; min_ and max_ bound number and date columns.
+// Filters on association paths such as "q_Product.Name" match the target
+// records. Empty values and fields hidden from the user are ignored; any
+// other filter must name a filterable field that is not sensitive and suit
+// its type, or a FilterError is returned.
+func (b *QueryBuilder) Filter(db *gorm.DB, params url.Values) (*gorm.DB, map[string]string, error) {
+	filters := make(map[string]string)
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		kind, name, ok := strings.Cut(k, "_")
+		if !ok || (kind != "q" && kind != "min" && kind != "max") {
+			continue
+		}
+		val := params.Get(k)
+		if val == "" || b.fr.Hidden(name) {
+			continue
+		}
+		if strings.Contains(name, ".") {
+			p, ok := ResolvePath(b.reg, b.res, name, b.user)
+			if !ok || !p.Field.Filterable {
+				return nil, nil, &FilterError{Param: k, Message: "unknown filter"}
+			}
+			op, arg, err := filterValue(p.targetType, kind, val)
+			if err != nil {
+				return nil, nil, &FilterError{Param: k, Message: err.Error()}
+			}
+			db = p.Where(b.reg, db, b.user, op, arg)
+		} else {
+			sf, ok := schemaField(b.reg, b.res, name)
+			if !ok || !slices.ContainsFunc(b.FilterFields(), func(f resource.Field) bool { return f.Name == name }) {
+				return nil, nil, &FilterError{Param: k, Message: "unknown filter"}
+			}
+			op, arg, err := filterValue(sf.DataType, kind, val)
+			if err != nil {
+				return nil, nil, &FilterError{Param: k, Message: err.Error()}
+			}
+			db = db.Where(compare(sf.DBName, op, arg))
+		}
+		filters[k] = val
+	}
+	return db, filters, nil
+}
+
+// Sort orders db by the named field, or by an association path, in order,
+// "asc" (the default) or "desc". It returns the sort applied, which is empty
+// for the default newest-first order used without a field or when the field
+// is hidden from the user. Any other field must be sortable and not
+// sensitive, or a FilterError is returned.
+func (b *QueryBuilder) Sort(db *gorm.DB, field, order string) (*gorm.DB, string, string, error) {
+	if field == "" || b.fr.Hidden(field) {
+		return db.Order(clause.OrderByColumn{Column: clause.Column{Name: "id"}, Desc: true}), "", "", nil
+	}
+	switch order {
+	case "":
+		order = "asc"
+	case "asc", "desc":
+	default:
+		return nil, "", "", &FilterError{Param: "order", Message: "must be asc or desc"}
+	}
+	if strings.Contains(field, ".") {
+		p, ok := ResolvePath(b.reg, b.res, field, b.user)
+		if !ok || !p.Field.Sortable {
+			return nil, "", "", &FilterError{Param: "sort", Message: fmt.Sprintf("cannot sort on %s", field)}
+		}
+		return p.Order(b.reg, db, b.user, order == "desc"), field, order, nil
+	}
+	col, ok := b.sortColumn(field)
+	if !ok {
+		return nil, "", "", &FilterError{Param: "sort", Message: fmt.Sprintf("cannot sort on %s", field)}
+	}
+	return db.Order(clause.OrderByColumn{Column: clause.Column{Name: col}, Desc: order == "desc"}), field, order, nil
+}
+
+// Search limits db to the records containing q in one of the filterable
+// text fields the user may read, which leaves out sensitive fields. db is
+// unchanged when there is none.
+func (b *QueryBuilder) Search(db *gorm.DB, q string) *gorm.DB {
+	var conds []clause.Expression
+	for _, f := range b.FilterFields() {
+		if col, ok := ColumnName(b.reg, b.res, f.Name); ok && f.Type == "text" {
+			conds = append(conds, compare(col, "LIKE", "%"+q+"%"))
+		}
+	}
+	if len(conds) == 0 {
+		return db
+	}
+	return db.Where(clause.Or(conds...))
+}
+
+// filterKinds returns the kinds of filter that apply to a column of type
+// typ: min and max bound only numbers and dates.
+func filterKinds(typ schema.DataType) []string {
+	switch typ {
+	case schema.Int, schema.Uint, schema.Float, schema.Time:
+		return []string{"q", "min", "max"}
+	}
+	return []string{"q"}
+}
+
+// filterValue checks the value of a filter of kind q, min or max on a column
+// of type typ and returns the operator and the bound value to compare the
+// column with.
+func filterValue(typ schema.DataType, kind, val string) (string, interface{}, error) {
+	if !slices.Contains(filterKinds(typ), kind) {
+		if typ == schema.Bool {
+			return "", nil, fmt.Errorf("%s_ does not apply to true/false fields", kind)
+		}
+		return "", nil, fmt.Errorf("%s_ only applies to number and date fields", kind)
+	}
+	ops := map[string]string{"q": "=", "min": ">=", "max": "<="}
+	switch typ {
+	case schema.Int, schema.Uint, schema.Float:
+		n, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return "", nil, fmt.Errorf("%q is not a number", val)
+		}
+		return ops[kind], n, nil
+	case schema.Time:
+		if kind == "q" {
+			return "LIKE", "%" + val + "%", nil
+		}
+		for _, layout := range dateLayouts {
+			if t, err := time.ParseInLocation(layout, val, time.Local); err == nil {
+				return ops[kind], t, nil
+			}
+		}
+		return "", nil, fmt.Errorf("%q is not a date", val)
+	case schema.Bool:
+		v, err := strconv.ParseBool(val)
+		if err != nil {
+			return "", nil, fmt.Errorf("%q is not true or false", val)
+		}
+		return "=", v, nil
+	}
+	return "LIKE", "%" + val + "%", nil
+}
+
+// compare returns the condition col op value, for one of the operators
+// returned by filterValue.
+func compare(col, op string, value interface{}) clause.Expression {
+	column := clause.Column{Name: col}
+	switch op {
+	case "LIKE":
+		return clause.Like{Column: column, Value: value}
+	case ">=":
+		return clause.Gte{Column: column, Value: value}
+	case "<=":
+		return clause.Lte{Column: column, Value: value}
+	}
+	return clause.Eq{Column: column, Value: value}
+}
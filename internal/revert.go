@@ -0,0 +1,96 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/go-packs/go-admin"
+	"github.com/go-packs/go-admin/models"
+	"github.com/go-packs/go-admin/resource"
+	"gorm.io/gorm"
+)
+
+// ErrNoSnapshot is returned when reverting to an audit entry that holds no
+// record snapshot, such as collection actions or entries written before
+// snapshots were recorded.
+var ErrNoSnapshot = errors.New("this audit entry has no snapshot to revert to")
+
+// Revert restores the record referenced by the audit entry versionID to the
+// snapshot stored with it. The record goes through the normal save path: user
+// needs the "revert" permission on the resource and must be able to edit the
+// record, the result is validated, saved under the resource policy and
+// recorded as a new audit entry. A record deleted since is recreated with its
+// original ID. Sensitive fields, and fields user may not write, keep their
+// current values. It returns the resource and ID of the reverted record.
+func Revert(ctx context.Context, reg *admin.Registry, versionID interface{}, user *models.AdminUser) (*resource.Resource, string, error) {
+	var version models.AuditLog
+	if err := reg.DB.First(&version, "id = ?", versionID).Error; err != nil {
+		return nil, "", err
+	}
+	res, ok := reg.GetResource(version.ResourceName)
+	if !ok {
+		return nil, "", fmt.Errorf("unknown resource %q", version.ResourceName)
+	}
+	state := version.State()
+	if state == nil || version.RecordID == "" {
+		return res, version.RecordID, ErrNoSnapshot
+	}
+	if !Can(reg, user, res.Name, "revert") {
+		return res, version.RecordID, ErrForbidden
+	}
+
+	var model interface{}
+	var before map[string]interface{}
+	var keep func(string) bool
+	undelete, softDeleted := false, false
+	item, err := Find(reg, res.Name, version.RecordID, user)
+	switch {
+	case err == nil:
+		if !res.CanEdit(item, user) {
+			return res, version.RecordID, ErrForbidden
+		}
+		model, before = item, res.Snapshot(item)
+		fr := FieldRestrictions(reg, user, res.Name)
+		keep = func(name string) bool { return !fr.Writable(name) }
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		var live, all int64
+		reg.DB.Model(res.Model).Where("id = ?", version.RecordID).Count(&live)
+		reg.DB.Unscoped().Model(res.Model).Where("id = ?", version.RecordID).Count(&all)
+		if live > 0 {
+			// The record exists but lies outside user's policy.
+			return res, version.RecordID, gorm.ErrRecordNotFound
+		}
+		model, undelete, softDeleted = reflect.New(reflect.TypeOf(res.Model)).Interface(), true, all > 0
+	default:
+		return res, version.RecordID, err
+	}
+
+	if err := res.Restore(model, state, keep); err != nil {
+		return res, version.RecordID, err
+	}
+	if undelete && !res.CanEdit(model, user) {
+		return res, version.RecordID, ErrForbidden
+	}
+	if errs := res.Validate(model); len(errs) > 0 {
+		return res, version.RecordID, errs
+	}
+	err = reg.DB.Transaction(func(tx *gorm.DB) error {
+		if softDeleted {
+			if err := tx.Unscoped().Model(reflect.New(reflect.TypeOf(res.Model)).Interface()).Where("id = ?", version.RecordID).Update("deleted_at", nil).Error; err != nil {
+				return err
+			}
+		}
+		return saveIn(ctx, tx, res, model, user)
+	})
+	if err != nil {
+		return res, version.RecordID, err
+	}
+	summary := fmt.Sprintf("Reverted to version #%d", version.ID)
+	if undelete {
+		summary = fmt.Sprintf("Restored deleted record from version #%d", version.ID)
+	}
+	RecordChange(reg, user, res, version.RecordID, "Revert", summary, before, res.Snapshot(model))
+	return res, version.RecordID, nil
+}
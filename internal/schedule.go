@@ -0,0 +1,130 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-packs/go-admin"
+	"github.com/go-packs/go-admin/models"
+	"gorm.io/gorm/clause"
+)
+
+// scheduleLeaseTTL is how long a lease lasts without being renewed. A running
+// task renews it every third of that, so a crashed server releases its tasks
+// within a minute.
+var scheduleLeaseTTL = time.Minute
+
+// instanceID identifies this process as a lease holder.
+var instanceID = func() string {
+	host, _ := os.Hostname()
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(b))
+}()
+
+// ClaimSchedule takes the lease of task s and records a new run. Scheduled
+// runs pass the slot they are due at, and are refused when that slot or a
+// later one was already claimed; manual runs pass nil and trigger, the email
+// of the user. It returns nil without error when the lease is held by a run
+// in progress or the slot was taken, possibly by another server.
+func ClaimSchedule(reg *admin.Registry, s *admin.Schedule, slot *time.Time, trigger string) (*models.ScheduleRun, error) {
+	now := time.Now().UTC()
+	if err := reg.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.ScheduleLease{Name: s.Name}).Error; err != nil {
+		return nil, err
+	}
+	q := reg.DB.Model(&models.ScheduleLease{}).Where("name = ? AND expires_at < ?", s.Name, now)
+	updates := map[string]interface{}{"holder": instanceID, "expires_at": now.Add(scheduleLeaseTTL)}
+	run := &models.ScheduleRun{Name: s.Name, Trigger: trigger, ScheduledAt: now, Status: models.JobRunning, Instance: instanceID, StartedAt: now}
+	if slot != nil {
+		q = q.Where("slot < ?", slot.UTC())
+		updates["slot"] = slot.UTC()
+		run.Trigger, run.ScheduledAt = "schedule", slot.UTC()
+	}
+	res := q.Updates(updates)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return nil, res.Error
+	}
+	// Runs still marked running lost their server along with its lease.
+	reg.DB.Model(&models.ScheduleRun{}).Where("name = ? AND status = ?", s.Name, models.JobRunning).
+		Updates(map[string]interface{}{"status": models.JobFailed, "error": "interrupted: the server running it stopped", "finished_at": now})
+	if err := reg.DB.Create(run).Error; err != nil {
+		releaseSchedule(reg, s)
+		return nil, err
+	}
+	return run, nil
+}
+
+// ExecuteSchedule runs task s for a run claimed with ClaimSchedule, renewing
+// the lease until it returns, and records the outcome. The task's context is
+// cancelled when ctx is done or the lease is lost.
+func ExecuteSchedule(ctx context.Context, reg *admin.Registry, s *admin.Schedule, run *models.ScheduleRun) error {
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(scheduleLeaseTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				q := reg.DB.Model(&models.ScheduleLease{}).Where("name = ? AND holder = ?", s.Name, instanceID).
+					Update("expires_at", time.Now().UTC().Add(scheduleLeaseTTL))
+				if q.Error == nil && q.RowsAffected == 0 {
+					cancel()
+				}
+			}
+		}
+	}()
+
+	err := func() (err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				err = fmt.Errorf("panic: %v", p)
+			}
+		}()
+		return s.Run(ctx, reg.DB.WithContext(ctx))
+	}()
+	cancel()
+	wg.Wait()
+
+	now := time.Now().UTC()
+	run.Status, run.FinishedAt = models.JobDone, &now
+	if err != nil {
+		run.Status, run.Error = models.JobFailed, err.Error()
+	}
+	if serr := reg.DB.Save(run).Error; serr != nil {
+		fmt.Printf("schedule %s: %v\n", s.Name, serr)
+	}
+	releaseSchedule(reg, s)
+	return err
+}
+
+// RunSchedule claims the run of task s due at slot and executes it. It does
+// nothing when the slot is taken.
+func RunSchedule(ctx context.Context, reg *admin.Registry, s *admin.Schedule, slot time.Time) error {
+	run, err := ClaimSchedule(reg, s, &slot, "")
+	if err != nil || run == nil {
+		return err
+	}
+	return ExecuteSchedule(ctx, reg, s, run)
+}
+
+// ScheduleRunning reports whether a run of task s holds an unexpired lease.
+func ScheduleRunning(reg *admin.Registry, s *admin.Schedule) bool {
+	var n int64
+	reg.DB.Model(&models.ScheduleLease{}).Where("name = ? AND expires_at >= ?", s.Name, time.Now().UTC()).Count(&n)
+	return n > 0
+}
+
+func releaseSchedule(reg *admin.Registry, s *admin.Schedule) {
+	reg.DB.Model(&models.ScheduleLease{}).Where("name = ? AND holder = ?", s.Name, instanceID).
+		Update("expires_at", time.Time{})
+}
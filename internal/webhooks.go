@@ -0,0 +1,291 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/go-packs/go-admin"
+	"github.com/go-packs/go-admin/models"
+	"gorm.io/gorm"
+)
+
+// webhookTimeout bounds each delivery attempt.
+const webhookTimeout = 10 * time.Second
+
+// maxWebhookAttempts is how often a delivery is tried before it is marked
+// failed.
+const maxWebhookAttempts = 8
+
+// webhookResponseLimit caps how much of an endpoint's answer is read, so
+// the connection can be reused. The answer itself is not kept.
+const webhookResponseLimit = 1024
+
+// webhookBackoff is the delay before the first retry. Each later retry waits
+// twice as long as the one before, so the last of maxWebhookAttempts comes
+// about two hours after the first.
+var webhookBackoff = time.Minute
+
+// webhookClient refuses to connect to internal addresses. The check runs on
+// the resolved address of every connection, redirects included, so neither
+// a hostname nor a redirect can point a webhook into the server's network.
+var webhookClient = &http.Client{
+	Timeout: webhookTimeout,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{Timeout: webhookTimeout, Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || internalIP(ip) {
+				return fmt.Errorf("webhook address %s is not public", host)
+			}
+			return nil
+		}}).DialContext,
+		TLSHandshakeTimeout: webhookTimeout,
+	},
+}
+
+// privateWebhookClient is used when Config.WebhookAllowPrivate is set.
+var privateWebhookClient = &http.Client{Timeout: webhookTimeout}
+
+// internalIP reports whether ip is loopback, link-local, private,
+// unspecified or multicast, or in the shared and benchmarking ranges.
+func internalIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsPrivate() ||
+		ip.IsUnspecified() || ip.IsMulticast() || ip.IsInterfaceLocalMulticast() {
+		return true
+	}
+	for _, cidr := range []string{"100.64.0.0/10", "198.18.0.0/15", "0.0.0.0/8", "64:ff9b::/96"} {
+		if _, n, _ := net.ParseCIDR(cidr); n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+var webhookQueued = make(chan struct{}, 1)
+
+// WebhookQueued signals that deliveries were queued, so the delivery worker
+// can send them without waiting for its next poll.
+func WebhookQueued() <-chan struct{} { return webhookQueued }
+
+// webhookEvents maps the audit actions that raise webhook events onto them.
+var webhookEvents = map[string]string{
+	"Create": models.WebhookCreate,
+	"Update": models.WebhookUpdate,
+	"Revert": models.WebhookUpdate,
+	"Delete": models.WebhookDelete,
+	"Action": models.WebhookAction,
+}
+
+// webhookPayload is the JSON body sent to webhooks.
+type webhookPayload struct {
+	Event    string `json:"event"`
+	Resource string `json:"resource"`
+	RecordID string `json:"record_id"`
+	Action   string `json:"action"`
+	Summary  string `json:"summary"`
+	User     string `json:"user"`
+	// Diff is the audit diff, with sensitive values redacted.
+	Diff []models.FieldChange `json:"diff"`
+	// Record is the non-sensitive state of the record after the change, or
+	// before it for deletes.
+	Record     json.RawMessage `json:"record,omitempty"`
+	AuditLogID uint            `json:"audit_log_id"`
+	Time       time.Time       `json:"time"`
+}
+
+// queueWebhooks stores a pending delivery of entry for every active webhook
+// subscribed to its event. It runs in the transaction appending entry to the
+// audit log, so deliveries exist exactly for the changes that were recorded.
+// Nothing is queued when the webhook tables have not been migrated.
+func queueWebhooks(tx *gorm.DB, entry *models.AuditLog) error {
+	event, ok := webhookEvents[entry.Action]
+	if !ok || !tx.Migrator().HasTable(&models.Webhook{}) {
+		return nil
+	}
+	var hooks []models.Webhook
+	if err := tx.Where("active = ?", true).Find(&hooks).Error; err != nil {
+		return err
+	}
+	var body []byte
+	for i := range hooks {
+		if !hooks[i].Subscribes(entry.ResourceName, event) {
+			continue
+		}
+		if body == nil {
+			p := webhookPayload{
+				Event: event, Resource: entry.ResourceName, RecordID: entry.RecordID, Action: entry.Action,
+				Summary: entry.Changes, User: entry.UserEmail, Diff: entry.Changeset(),
+				AuditLogID: entry.ID, Time: entry.CreatedAt,
+			}
+			if p.Diff == nil {
+				p.Diff = []models.FieldChange{}
+			}
+			if entry.Snapshot != "" {
+				p.Record = json.RawMessage(entry.Snapshot)
+			}
+			var err error
+			if body, err = json.Marshal(p); err != nil {
+				return err
+			}
+		}
+		d := &models.WebhookDelivery{
+			WebhookID: hooks[i].ID, Event: event, ResourceName: entry.ResourceName, RecordID: entry.RecordID,
+			AuditLogID: entry.ID, Payload: string(body), Status: models.DeliveryPending, NextAttemptAt: time.Now().UTC(),
+		}
+		if err := tx.Create(d).Error; err != nil {
+			return err
+		}
+	}
+	if body != nil {
+		signalWebhooks()
+	}
+	return nil
+}
+
+func signalWebhooks() {
+	select {
+	case webhookQueued <- struct{}{}:
+	default:
+	}
+}
+
+// DeliverWebhooks sends the pending deliveries that are due, oldest first,
+// until none is left or ctx is done, and returns how many it attempted.
+func DeliverWebhooks(ctx context.Context, reg *admin.Registry) (int, error) {
+	n := 0
+	for ctx.Err() == nil {
+		d, err := claimDelivery(reg)
+		if err != nil || d == nil {
+			return n, err
+		}
+		if err := deliverWebhook(ctx, reg, d); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// claimDelivery takes the oldest due delivery. Claiming counts the attempt
+// and moves NextAttemptAt past the attempt's timeout, so servers sharing the
+// database do not send it twice and a delivery interrupted by a crash is
+// retried later.
+func claimDelivery(reg *admin.Registry) (*models.WebhookDelivery, error) {
+	for {
+		now := time.Now().UTC()
+		var d models.WebhookDelivery
+		if err := reg.DB.Where("status = ? AND next_attempt_at <= ?", models.DeliveryPending, now).
+			Order("next_attempt_at, id").Limit(1).Find(&d).Error; err != nil {
+			return nil, err
+		}
+		if d.ID == 0 {
+			return nil, nil
+		}
+		retry := now.Add(2 * webhookTimeout)
+		q := reg.DB.Model(&models.WebhookDelivery{}).Where("id = ? AND status = ? AND attempts = ?", d.ID, models.DeliveryPending, d.Attempts).
+			Updates(map[string]interface{}{"attempts": d.Attempts + 1, "next_attempt_at": retry})
+		if q.Error != nil {
+			return nil, q.Error
+		}
+		if q.RowsAffected == 1 {
+			d.Attempts, d.NextAttemptAt = d.Attempts+1, retry
+			return &d, nil
+		}
+	}
+}
+
+// deliverWebhook makes one attempt at sending d and records the outcome: d
+// is delivered on a 2xx answer, failed once it is out of attempts or its
+// webhook is gone or inactive, and otherwise retried after a delay doubling
+// with each attempt.
+func deliverWebhook(ctx context.Context, reg *admin.Registry, d *models.WebhookDelivery) error {
+	var hook models.Webhook
+	q := reg.DB.Limit(1).Find(&hook, d.WebhookID)
+	if q.Error != nil {
+		return q.Error
+	}
+	var err error
+	switch {
+	case q.RowsAffected == 0:
+		d.Status, err = models.DeliveryFailed, fmt.Errorf("webhook %d was deleted", d.WebhookID)
+	case !hook.Active:
+		d.Status, err = models.DeliveryFailed, fmt.Errorf("webhook %s is inactive", hook.Name)
+	default:
+		client := webhookClient
+		if reg.Config.WebhookAllowPrivate {
+			client = privateWebhookClient
+		}
+		d.ResponseCode, err = sendWebhook(ctx, client, &hook, d)
+	}
+	now := time.Now().UTC()
+	d.Error = ""
+	switch {
+	case err == nil:
+		d.Status, d.DeliveredAt = models.DeliveryDelivered, &now
+	case d.Attempts >= maxWebhookAttempts:
+		d.Status = models.DeliveryFailed
+	case d.Status != models.DeliveryFailed:
+		d.NextAttemptAt = now.Add(webhookBackoff << (d.Attempts - 1))
+	}
+	if err != nil {
+		d.Error = err.Error()
+	}
+	return reg.DB.Model(&models.WebhookDelivery{}).Where("id = ?", d.ID).Updates(map[string]interface{}{
+		"status": d.Status, "next_attempt_at": d.NextAttemptAt, "response_code": d.ResponseCode,
+		"error": d.Error, "delivered_at": d.DeliveredAt,
+	}).Error
+}
+
+// sendWebhook posts the payload of d to hook with client, signed with its
+// secret, and returns the status code. Answers other than 2xx are errors.
+// The response body is discarded: it is the endpoint's to keep.
+func sendWebhook(ctx context.Context, client *http.Client, hook *models.Webhook, d *models.WebhookDelivery) (int, error) {
+	body := []byte(d.Payload)
+	req, err := http.NewRequestWithContext(ctx, "POST", hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "go-admin-webhooks")
+	req.Header.Set("X-Webhook-Event", d.Event)
+	req.Header.Set("X-Webhook-Delivery", strconv.Itoa(int(d.ID)))
+	req.Header.Set("X-Webhook-Signature", hook.Sign(body))
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, webhookResponseLimit))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// ReplayDelivery queues a new delivery with the payload of delivery id, to be
+// sent right away to the webhook's current URL.
+func ReplayDelivery(reg *admin.Registry, id string) (*models.WebhookDelivery, error) {
+	var orig models.WebhookDelivery
+	if err := reg.DB.First(&orig, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	d := &models.WebhookDelivery{
+		WebhookID: orig.WebhookID, Event: orig.Event, ResourceName: orig.ResourceName, RecordID: orig.RecordID,
+		AuditLogID: orig.AuditLogID, Payload: orig.Payload, Status: models.DeliveryPending, NextAttemptAt: time.Now().UTC(),
+	}
+	if err := reg.DB.Create(d).Error; err != nil {
+		return nil, err
+	}
+	signalWebhooks()
+	return d, nil
+}
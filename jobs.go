@@ -0,0 +1,31 @@
+package admin
+
+import "context"
+
+// ProgressFunc receives the progress of a background job as done out of
+// total units of work.
+type ProgressFunc func(done, total int)
+
+type progressKey struct{}
+
+// WithProgress returns a context whose ReportProgress calls go to fn.
+func WithProgress(ctx context.Context, fn ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+// ProgressFrom returns the progress receiver of ctx, if it belongs to a
+// background job.
+func ProgressFrom(ctx context.Context) (ProgressFunc, bool) {
+	fn, ok := ctx.Value(progressKey{}).(ProgressFunc)
+	return fn, ok
+}
+
+// ReportProgress records that done of total units of the current background
+// job are complete. Action handlers run as jobs receive the job context
+// through their request. Outside a job it does nothing, so handlers can call
+// it unconditionally.
+func ReportProgress(ctx context.Context, done, total int) {
+	if fn, ok := ProgressFrom(ctx); ok {
+		fn(done, total)
+	}
+}
@@ -9,8 +9,17 @@ import (
 type AdminUser struct {
 	ID           uint   `gorm:"primaryKey"`
 	Email        string `gorm:"uniqueIndex"`
-	PasswordHash string
+	PasswordHash string `admin:"sensitive"`
 	Role         string
+	// TOTPSecret is set during enrollment; TOTPEnabled once the user confirmed a code.
+	TOTPSecret    string `admin:"sensitive"`
+	TOTPEnabled   bool
+	TOTPLastStep  int64
+	RecoveryCodes string `admin:"sensitive"`
+	// Token is set when the request was authenticated with a personal API token.
+	Token *APIToken `gorm:"-"`
+	// CSRFToken is the session's CSRF token when the request was authenticated with the session cookie.
+	CSRFToken string `gorm:"-"`
 }
 
 func (u *AdminUser) SetPassword(password string) error {
@@ -0,0 +1,70 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// APITokenPrefix marks bearer tokens that are personal API tokens rather than session IDs.
+const APITokenPrefix = "gat_"
+
+// APIToken is a personal access token that lets scripts authenticate as an AdminUser.
+// Only a SHA-256 hash of the secret is stored.
+type APIToken struct {
+	ID         uint `gorm:"primaryKey"`
+	UserID     uint `gorm:"index"`
+	Name       string
+	Prefix     string
+	SecretHash string `gorm:"uniqueIndex" admin:"sensitive"`
+	// Scopes is a comma-separated list of "action", "Resource:action" or
+	// "Resource:*" entries. An empty list or "*" grants everything the owner's role allows.
+	Scopes     string
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	CreatedAt  time.Time
+}
+
+// NewAPIToken creates an unsaved token for a user and returns it with its plain-text secret.
+func NewAPIToken(userID uint, name, scopes string, expiresAt *time.Time) (*APIToken, string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, "", err
+	}
+	secret := APITokenPrefix + hex.EncodeToString(buf)
+	return &APIToken{
+		UserID: userID, Name: name, Prefix: secret[:len(APITokenPrefix)+6],
+		SecretHash: HashAPIToken(secret), Scopes: scopes, ExpiresAt: expiresAt,
+	}, secret, nil
+}
+
+// HashAPIToken returns the stored hash of a token secret.
+func HashAPIToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Expired reports whether the token is past its expiry time.
+func (t *APIToken) Expired(now time.Time) bool {
+	return t.ExpiresAt != nil && !now.Before(*t.ExpiresAt)
+}
+
+// Allows reports whether the token's scopes cover action on resource.
+func (t *APIToken) Allows(resource, action string) bool {
+	if strings.TrimSpace(t.Scopes) == "" {
+		return true
+	}
+	for _, s := range strings.Split(t.Scopes, ",") {
+		s = strings.TrimSpace(s)
+		res, act, scoped := strings.Cut(s, ":")
+		if !scoped {
+			res, act = "*", s
+		}
+		if (res == "*" || res == resource) && (act == "*" || act == action) {
+			return true
+		}
+	}
+	return false
+}
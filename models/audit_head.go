@@ -0,0 +1,9 @@
+package models
+
+// AuditHead is the single row that writers lock while they append to the
+// audit chain, so that servers sharing a database append one at a time.
+// Seq counts the appends; incrementing it is what takes the lock.
+type AuditHead struct {
+	ID  uint `gorm:"primaryKey"`
+	Seq uint64
+}
@@ -1,8 +1,15 @@
 package models
 
-import "time"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
 
-// AuditLog records every change made in the admin panel.
+// AuditLog records every change made in the admin panel. Entries form a hash
+// chain: Hash covers the entry's content and PrevHash, the Hash of the entry
+// before it, so editing or removing a row breaks the chain from that point.
 type AuditLog struct {
 	ID           uint `gorm:"primaryKey"`
 	UserID       uint `gorm:"index"`
@@ -11,5 +18,54 @@ type AuditLog struct {
 	RecordID     string `gorm:"index"`
 	Action       string
 	Changes      string
-	CreatedAt    time.Time `gorm:"index"`
+	// Diff is a JSON array of FieldChange describing what the action changed.
+	Diff string `gorm:"type:text"`
+	// Snapshot is a JSON object with the record's non-sensitive field values
+	// after the action, or just before it for deletes. Reverts restore it.
+	Snapshot  string    `gorm:"type:text"`
+	CreatedAt time.Time `gorm:"index"`
+	PrevHash  string
+	Hash      string `gorm:"index"`
+}
+
+// ComputeHash returns the SHA-256 hex digest of the entry's PrevHash and
+// content. The ID is not covered; the chain itself fixes each entry's position.
+func (a *AuditLog) ComputeHash() string {
+	content, _ := json.Marshal([]interface{}{
+		a.PrevHash, a.UserID, a.UserEmail, a.ResourceName, a.RecordID, a.Action,
+		a.Changes, a.Diff, a.Snapshot, a.CreatedAt.UTC().Format(time.RFC3339Nano),
+	})
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// FieldChange is one entry of an AuditLog diff. Old is nil for created records
+// and New is nil for deleted ones.
+type FieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+// Changeset decodes the Diff column. It returns nil when there is no diff.
+func (a *AuditLog) Changeset() []FieldChange {
+	var changes []FieldChange
+	if a.Diff != "" {
+		if err := json.Unmarshal([]byte(a.Diff), &changes); err != nil {
+			return nil
+		}
+	}
+	return changes
+}
+
+// State decodes the Snapshot column into raw values keyed by field name. It
+// returns nil when the entry has no snapshot.
+func (a *AuditLog) State() map[string]json.RawMessage {
+	var state map[string]json.RawMessage
+	if a.Snapshot != "" {
+		if err := json.Unmarshal([]byte(a.Snapshot), &state); err != nil {
+			return nil
+		}
+	}
+	return state
 }
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// AuditPrune records one retention run that removed the oldest audit entries.
+// LastHash, the hash of the last removed entry, lets the chain verification
+// accept the new first entry.
+type AuditPrune struct {
+	ID        uint `gorm:"primaryKey"`
+	ThroughID uint
+	Count     int
+	LastHash  string
+	// Archive is the file the entries were written to, if any.
+	Archive   string
+	CreatedAt time.Time
+}
@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// Job statuses.
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// Job is a long-running admin action executed by the server's worker pool.
+// It is persisted so its progress can be followed and so queued work
+// survives a restart.
+type Job struct {
+	ID uint `gorm:"primaryKey"`
+	// Kind selects how the job runs: batch_action, collection_action or export.
+	Kind         string
+	ResourceName string
+	Label        string
+	// Payload holds the JSON arguments of the job.
+	Payload string `gorm:"type:text"`
+	Status  string `gorm:"index"`
+	// Progress is the completed share of the job in percent.
+	Progress int
+	Result   string `gorm:"type:text"`
+	Error    string `gorm:"type:text"`
+	Attempts int
+	// Instance is the server running the job. It renews LeaseExpiresAt while
+	// the job runs, so a running job with an expired lease lost its server.
+	Instance       string
+	LeaseExpiresAt *time.Time
+	UserID         uint `gorm:"index"`
+	UserEmail      string
+	CreatedAt      time.Time
+	StartedAt      *time.Time
+	FinishedAt     *time.Time
+}
+
+// Active reports whether the job is still waiting or running.
+func (j *Job) Active() bool {
+	return j.Status == JobQueued || j.Status == JobRunning
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// LoginChallenge is a pending login that passed the password check but still
+// needs a second factor before a Session is issued.
+type LoginChallenge struct {
+	ID        string `gorm:"primaryKey"`
+	UserID    uint   `gorm:"index"`
+	Attempts  int
+	ExpiresAt time.Time `gorm:"index"`
+}
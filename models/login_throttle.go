@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// LoginThrottle tracks failed logins for one subject ("email:<addr>" or "ip:<addr>")
+// and the lockout they triggered.
+type LoginThrottle struct {
+	ID            uint   `gorm:"primaryKey"`
+	Subject       string `gorm:"uniqueIndex"`
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   *time.Time
+}
@@ -1,7 +1,9 @@
 package models
 
 import (
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestAdminUser(t *testing.T) {
@@ -22,3 +24,77 @@ func TestAdminUser(t *testing.T) {
 		}
 	})
 }
+
+func TestAPIToken(t *testing.T) {
+	tok, secret, err := NewAPIToken(1, "ci", "list, Product:*", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(secret, APITokenPrefix) || tok.SecretHash != HashAPIToken(secret) || strings.Contains(tok.SecretHash, secret) {
+		t.Error("Token secret not hashed correctly")
+	}
+	if !tok.Allows("Order", "list") || !tok.Allows("Product", "delete") || tok.Allows("Order", "delete") {
+		t.Error("Scope check failed")
+	}
+	past := time.Now().Add(-time.Minute)
+	tok.ExpiresAt = &past
+	if !tok.Expired(time.Now()) {
+		t.Error("Token should be expired")
+	}
+}
+
+func TestTOTP(t *testing.T) {
+	// RFC 6238 appendix B test vectors, truncated to 6 digits.
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	for ts, want := range map[int64]string{59: "287082", 1111111109: "081804", 2000000000: "279037"} {
+		got, err := TOTPCode(secret, time.Unix(ts, 0))
+		if err != nil || got != want {
+			t.Errorf("TOTPCode(%d) = %s, want %s (%v)", ts, got, want, err)
+		}
+	}
+
+	user := &AdminUser{TOTPSecret: secret}
+	now := time.Unix(1111111109, 0)
+	if !user.CheckTOTP("081804", now) {
+		t.Error("Valid code rejected")
+	}
+	if user.CheckTOTP("081804", now) {
+		t.Error("Replayed code accepted")
+	}
+	prev, _ := TOTPCode(secret, now.Add(-TOTPPeriod*time.Second))
+	if user.CheckTOTP(prev, now) {
+		t.Error("Code older than the last used one accepted")
+	}
+
+	codes, err := user.GenerateRecoveryCodes()
+	if err != nil || len(codes) != RecoveryCodeCount || strings.Contains(user.RecoveryCodes, codes[0]) {
+		t.Fatalf("Recovery codes not generated/hashed: %v", err)
+	}
+	if !user.UseRecoveryCode(strings.ToUpper(codes[3])) || user.UseRecoveryCode(codes[3]) {
+		t.Error("Recovery code should work exactly once")
+	}
+	if user.RemainingRecoveryCodes() != RecoveryCodeCount-1 {
+		t.Errorf("Expected %d remaining codes, got %d", RecoveryCodeCount-1, user.RemainingRecoveryCodes())
+	}
+	if uri := TOTPProvisioningURI(secret, "Go Admin", "a@b.c"); !strings.HasPrefix(uri, "otpauth://totp/Go%20Admin:a@b.c?") {
+		t.Errorf("Unexpected provisioning URI %s", uri)
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	w := &Webhook{ResourceName: "Product", Events: "Create, delete", Secret: "key", Active: true}
+	if !w.Subscribes("Product", WebhookCreate) || !w.Subscribes("Product", WebhookDelete) || w.Subscribes("Product", WebhookUpdate) || w.Subscribes("User", WebhookCreate) {
+		t.Errorf("Unexpected subscriptions for %+v", w)
+	}
+	all := &Webhook{Active: true}
+	if !all.Subscribes("User", WebhookAction) {
+		t.Error("Webhooks without resource or events should get everything")
+	}
+	if all.Active = false; all.Subscribes("User", WebhookAction) {
+		t.Error("Inactive webhooks should get nothing")
+	}
+	// HMAC-SHA256 of "{}" keyed with "key".
+	if sig := w.Sign([]byte("{}")); sig != "sha256=a777724d943eb48dc69bca8a4a6d57a04db3f9ec7e1de4e581e860265bdf3032" {
+		t.Errorf("Unexpected signature %s", sig)
+	}
+}
@@ -1,9 +1,22 @@
 package models
 
-// Permission defines what a role can do with a resource.
+import "errors"
+
+// Permission defines what a role can do with a resource. When FieldName is set
+// the row instead restricts that field for the role, and Action is "hide" or
+// "readonly".
 type Permission struct {
 	ID           uint   `gorm:"primaryKey"`
 	Role         string `gorm:"index"`
 	ResourceName string `gorm:"index"`
+	FieldName    string
 	Action       string
 }
+
+// Validate rejects field rules whose action is not "hide" or "readonly".
+func (p Permission) Validate() error {
+	if p.FieldName != "" && p.Action != "hide" && p.Action != "readonly" {
+		return errors.New(`field permissions must use the "hide" or "readonly" action`)
+	}
+	return nil
+}
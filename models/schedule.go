@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// ScheduleRun records one execution of a scheduled task. Status is
+// JobRunning, JobDone or JobFailed.
+type ScheduleRun struct {
+	ID   uint   `gorm:"primaryKey"`
+	Name string `gorm:"index"`
+	// Trigger is "schedule", or the email of the user who started the run by hand.
+	Trigger string
+	// ScheduledAt is the time the run was due, or when it was started by hand.
+	ScheduledAt time.Time
+	Status      string
+	// Instance identifies the server process that executed the run.
+	Instance   string
+	Error      string `gorm:"type:text"`
+	StartedAt  time.Time
+	FinishedAt *time.Time
+}
+
+// Duration is how long a finished run took.
+func (r *ScheduleRun) Duration() time.Duration {
+	if r.FinishedAt == nil {
+		return 0
+	}
+	return r.FinishedAt.Sub(r.StartedAt).Round(time.Millisecond)
+}
+
+// ScheduleLease coordinates servers sharing a database. The holder of an
+// unexpired lease is executing the task, and Slot is the last scheduled time
+// claimed, so that each due time runs only once.
+type ScheduleLease struct {
+	Name      string `gorm:"primaryKey"`
+	Holder    string
+	Slot      time.Time
+	ExpiresAt time.Time
+}
@@ -7,4 +7,6 @@ type Session struct {
 	ID        string    `gorm:"primaryKey"`
 	UserID    uint      `gorm:"index"`
 	ExpiresAt time.Time `gorm:"index"`
+	// CSRFToken must accompany every state-changing request made with this session.
+	CSRFToken string
 }
@@ -0,0 +1,149 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters (RFC 6238 defaults understood by every authenticator app).
+const (
+	TOTPDigits = 6
+	TOTPPeriod = 30
+	// TOTPSkew is the number of periods either side of now that are accepted.
+	TOTPSkew = 1
+	// RecoveryCodeCount is how many recovery codes are issued on enrollment.
+	RecoveryCodeCount = 10
+)
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret returns a new random base32 TOTP secret.
+func GenerateTOTPSecret() (string, error) {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return b32.EncodeToString(buf), nil
+}
+
+// TOTPCode computes the code for secret at time t.
+func TOTPCode(secret string, t time.Time) (string, error) {
+	return totpAt(secret, t.Unix()/TOTPPeriod)
+}
+
+func totpAt(secret string, step int64) (string, error) {
+	key, err := b32.DecodeString(strings.ToUpper(strings.TrimSpace(secret)))
+	if err != nil {
+		return "", fmt.Errorf("invalid TOTP secret: %w", err)
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	off := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[off:off+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", TOTPDigits, code%1000000), nil
+}
+
+// ValidateTOTP checks code against secret within TOTPSkew periods of t and
+// returns the matching time step, so callers can reject replays.
+func ValidateTOTP(secret, code string, t time.Time) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != TOTPDigits {
+		return 0, false
+	}
+	now := t.Unix() / TOTPPeriod
+	for step := now - TOTPSkew; step <= now+TOTPSkew; step++ {
+		want, err := totpAt(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(want), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// TOTPProvisioningURI returns the otpauth:// URI encoded in enrollment QR codes.
+func TOTPProvisioningURI(secret, issuer, account string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(TOTPDigits))
+	v.Set("period", fmt.Sprint(TOTPPeriod))
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// CheckTOTP verifies a code from the user's authenticator and records its time
+// step so the same code cannot be used twice. The caller must persist the user.
+func (u *AdminUser) CheckTOTP(code string, now time.Time) bool {
+	if u.TOTPSecret == "" {
+		return false
+	}
+	step, ok := ValidateTOTP(u.TOTPSecret, code, now)
+	if !ok || step <= u.TOTPLastStep {
+		return false
+	}
+	u.TOTPLastStep = step
+	return true
+}
+
+// GenerateRecoveryCodes replaces the user's recovery codes and returns the
+// plain-text codes, which are only stored hashed.
+func (u *AdminUser) GenerateRecoveryCodes() ([]string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	hashes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		buf := make([]byte, 10)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		c := strings.ToLower(b32.EncodeToString(buf))
+		codes[i] = c[:8] + "-" + c[8:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	u.RecoveryCodes = strings.Join(hashes, ",")
+	return codes, nil
+}
+
+// UseRecoveryCode consumes a recovery code if it matches one of the stored
+// hashes. The caller must persist the user.
+func (u *AdminUser) UseRecoveryCode(code string) bool {
+	h := hashRecoveryCode(code)
+	hashes := strings.Split(u.RecoveryCodes, ",")
+	for i, stored := range hashes {
+		if stored != "" && subtle.ConstantTimeCompare([]byte(stored), []byte(h)) == 1 {
+			u.RecoveryCodes = strings.Join(append(hashes[:i], hashes[i+1:]...), ",")
+			return true
+		}
+	}
+	return false
+}
+
+// RemainingRecoveryCodes returns how many unused recovery codes the user has.
+func (u *AdminUser) RemainingRecoveryCodes() int {
+	if u.RecoveryCodes == "" {
+		return 0
+	}
+	return len(strings.Split(u.RecoveryCodes, ","))
+}
+
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), " ", ""))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
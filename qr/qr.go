@@ -0,0 +1,420 @@
+// Package qr encodes text as a QR code and renders it as SVG, so that pages
+// such as two-factor enrollment need no third-party script.
+package qr
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Code is a QR code: Size rows of Size modules, true for dark.
+type Code struct {
+	Size    int
+	Modules [][]bool
+	// function marks the finder, timing, alignment, format and version
+	// modules, which carry no data and are never masked.
+	function [][]bool
+}
+
+// version describes the byte capacity at error correction level M of a
+// symbol version: ecLen error correction codewords for each block, and
+// the data codewords of its short and long blocks.
+type version struct {
+	ecLen                  int
+	shortBlocks, shortData int
+	longBlocks, longData   int
+	align                  []int
+}
+
+// versions are the symbol versions 1 to 10 at level M, which hold up to 213
+// bytes: plenty for provisioning URIs.
+var versions = []version{
+	{10, 1, 16, 0, 0, nil},
+	{16, 1, 28, 0, 0, []int{6, 18}},
+	{26, 1, 44, 0, 0, []int{6, 22}},
+	{18, 2, 32, 0, 0, []int{6, 26}},
+	{24, 2, 43, 0, 0, []int{6, 30}},
+	{16, 4, 27, 0, 0, []int{6, 34}},
+	{18, 4, 31, 0, 0, []int{6, 22, 38}},
+	{22, 2, 38, 2, 39, []int{6, 24, 42}},
+	{22, 3, 36, 2, 37, []int{6, 26, 46}},
+	{26, 4, 43, 1, 44, []int{6, 28, 50}},
+}
+
+// ErrTooLong is returned for text that does not fit in the largest
+// supported version.
+var ErrTooLong = errors.New("qr: text too long")
+
+// Encode returns the smallest QR code holding text in byte mode at error
+// correction level M, with the mask of lowest penalty.
+func Encode(text string) (*Code, error) {
+	for i, v := range versions {
+		countBits := 8
+		if i+1 >= 10 {
+			countBits = 16
+		}
+		capacity := v.shortBlocks*v.shortData + v.longBlocks*v.longData
+		if 4+countBits+8*len(text) > 8*capacity {
+			continue
+		}
+		c := newCode(i + 1)
+		c.place(v.interleave(encodeData(text, countBits, capacity)))
+		c.applyBestMask()
+		return c, nil
+	}
+	return nil, ErrTooLong
+}
+
+// SVG renders the code with a quiet zone of four modules, scale pixels a
+// module.
+func (c *Code) SVG(scale int) string {
+	size := c.Size + 8
+	var path strings.Builder
+	for y, row := range c.Modules {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&path, "M%d,%dh1v1h-1z", x+4, y+4)
+			}
+		}
+	}
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges"><rect width="%d" height="%d" fill="#fff"/><path d="%s" fill="#000"/></svg>`,
+		size*scale, size*scale, size, size, size, size, path.String())
+}
+
+// encodeData returns the data codewords of text: the byte mode header, the
+// bytes, a terminator and padding up to capacity.
+func encodeData(text string, countBits, capacity int) []byte {
+	var bits []bool
+	appendBits := func(val, n int) {
+		for i := n - 1; i >= 0; i-- {
+			bits = append(bits, val>>i&1 == 1)
+		}
+	}
+	appendBits(0b0100, 4)
+	appendBits(len(text), countBits)
+	for i := 0; i < len(text); i++ {
+		appendBits(int(text[i]), 8)
+	}
+	appendBits(0, min(4, 8*capacity-len(bits)))
+	appendBits(0, (8-len(bits)%8)%8)
+	data := make([]byte, 0, capacity)
+	for i := 0; i < len(bits); i += 8 {
+		var b byte
+		for _, bit := range bits[i : i+8] {
+			b <<= 1
+			if bit {
+				b |= 1
+			}
+		}
+		data = append(data, b)
+	}
+	for pad := byte(0xEC); len(data) < capacity; pad ^= 0xEC ^ 0x11 {
+		data = append(data, pad)
+	}
+	return data
+}
+
+// interleave splits data into the blocks of v, adds their error correction
+// codewords and interleaves the result.
+func (v version) interleave(data []byte) []byte {
+	divisor := rsDivisor(v.ecLen)
+	var blocks, ecs [][]byte
+	for i := 0; i < v.shortBlocks+v.longBlocks; i++ {
+		n := v.shortData
+		if i >= v.shortBlocks {
+			n = v.longData
+		}
+		blocks = append(blocks, data[:n])
+		ecs = append(ecs, rsRemainder(data[:n], divisor))
+		data = data[n:]
+	}
+	var out []byte
+	for i := 0; i < max(v.shortData, v.longData); i++ {
+		for _, b := range blocks {
+			if i < len(b) {
+				out = append(out, b[i])
+			}
+		}
+	}
+	for i := 0; i < v.ecLen; i++ {
+		for _, ec := range ecs {
+			out = append(out, ec[i])
+		}
+	}
+	return out
+}
+
+// gfMul multiplies in GF(256) modulo x^8 + x^4 + x^3 + x^2 + 1.
+func gfMul(x, y byte) byte {
+	var z int
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int(y>>i&1) * int(x)
+	}
+	return byte(z)
+}
+
+// rsDivisor returns the Reed-Solomon generator polynomial of degree n,
+// highest coefficient first and the leading 1 left out.
+func rsDivisor(n int) []byte {
+	result := make([]byte, n)
+	result[n-1] = 1
+	root := byte(1)
+	for i := 0; i < n; i++ {
+		for j := range result {
+			result[j] = gfMul(result[j], root)
+			if j+1 < n {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMul(root, 2)
+	}
+	return result
+}
+
+// rsRemainder returns the error correction codewords of data.
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, d := range divisor {
+			result[i] ^= gfMul(d, factor)
+		}
+	}
+	return result
+}
+
+// newCode returns an empty code of version ver with its function patterns
+// drawn and the format and version areas reserved.
+func newCode(ver int) *Code {
+	size := 4*ver + 17
+	c := &Code{Size: size, Modules: make([][]bool, size), function: make([][]bool, size)}
+	for i := range c.Modules {
+		c.Modules[i], c.function[i] = make([]bool, size), make([]bool, size)
+	}
+	for i := 0; i < size; i++ {
+		c.set(6, i, i%2 == 0)
+		c.set(i, 6, i%2 == 0)
+	}
+	for _, p := range [][2]int{{3, 3}, {size - 4, 3}, {3, size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := p[0]+dx, p[1]+dy
+				if x >= 0 && x < size && y >= 0 && y < size {
+					d := max(abs(dx), abs(dy))
+					c.set(x, y, d != 2 && d != 4)
+				}
+			}
+		}
+	}
+	align := versions[ver-1].align
+	for i, ax := range align {
+		for j, ay := range align {
+			if (i == 0 && j == 0) || (i == 0 && j == len(align)-1) || (i == len(align)-1 && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					c.set(ax+dx, ay+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+	c.drawFormat(0)
+	if ver >= 7 {
+		rem := ver
+		for i := 0; i < 12; i++ {
+			rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+		}
+		bits := ver<<12 | rem
+		for i := 0; i < 18; i++ {
+			a, b := size-11+i%3, i/3
+			c.set(a, b, bits>>i&1 == 1)
+			c.set(b, a, bits>>i&1 == 1)
+		}
+	}
+	return c
+}
+
+// set draws the function module at column x, row y.
+func (c *Code) set(x, y int, dark bool) {
+	c.Modules[y][x] = dark
+	c.function[y][x] = true
+}
+
+// formatBits returns the format information of level M with mask.
+func formatBits(mask int) int {
+	data := mask // level M is 00
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+// drawFormat draws both copies of the format information for mask, and the
+// dark module.
+func (c *Code) drawFormat(mask int) {
+	bits := formatBits(mask)
+	bit := func(i int) bool { return bits>>i&1 == 1 }
+	for i := 0; i <= 5; i++ {
+		c.set(8, i, bit(i))
+	}
+	c.set(8, 7, bit(6))
+	c.set(8, 8, bit(7))
+	c.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		c.set(14-i, 8, bit(i))
+	}
+	for i := 0; i < 8; i++ {
+		c.set(c.Size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		c.set(8, c.Size-15+i, bit(i))
+	}
+	c.set(8, c.Size-8, true)
+}
+
+// place draws the codewords in the zigzag order of the standard, leaving
+// remainder modules light.
+func (c *Code) place(codewords []byte) {
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < c.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if (right+1)&2 == 0 {
+					y = c.Size - 1 - vert
+				}
+				if !c.function[y][x] && i < len(codewords)*8 {
+					c.Modules[y][x] = codewords[i>>3]>>(7-i&7)&1 == 1
+					i++
+				}
+			}
+		}
+	}
+}
+
+// masks are the eight data masks: a module at column x, row y is inverted
+// when its mask returns true.
+var masks = []func(x, y int) bool{
+	func(x, y int) bool { return (x+y)%2 == 0 },
+	func(x, y int) bool { return y%2 == 0 },
+	func(x, y int) bool { return x%3 == 0 },
+	func(x, y int) bool { return (x+y)%3 == 0 },
+	func(x, y int) bool { return (x/3+y/2)%2 == 0 },
+	func(x, y int) bool { return x*y%2+x*y%3 == 0 },
+	func(x, y int) bool { return (x*y%2+x*y%3)%2 == 0 },
+	func(x, y int) bool { return ((x+y)%2+x*y%3)%2 == 0 },
+}
+
+// applyMask inverts the data modules selected by mask. Applying it twice
+// restores them.
+func (c *Code) applyMask(mask int) {
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if !c.function[y][x] && masks[mask](x, y) {
+				c.Modules[y][x] = !c.Modules[y][x]
+			}
+		}
+	}
+}
+
+// applyBestMask applies the mask of lowest penalty with its format bits.
+func (c *Code) applyBestMask() {
+	best, bestPenalty := 0, -1
+	for mask := range masks {
+		c.applyMask(mask)
+		c.drawFormat(mask)
+		if p := c.penalty(); bestPenalty < 0 || p < bestPenalty {
+			best, bestPenalty = mask, p
+		}
+		c.applyMask(mask)
+	}
+	c.applyMask(best)
+	c.drawFormat(best)
+}
+
+// penalty scores the code by the four rules of the standard: runs of five or
+// more modules, 2x2 blocks, finder-like patterns and dark balance.
+func (c *Code) penalty() int {
+	at := func(x, y int, transpose bool) bool {
+		if transpose {
+			return c.Modules[x][y]
+		}
+		return c.Modules[y][x]
+	}
+	finder := []bool{true, false, true, true, true, false, true}
+	p := 0
+	for _, transpose := range []bool{false, true} {
+		for y := 0; y < c.Size; y++ {
+			run := 1
+			for x := 1; x <= c.Size; x++ {
+				if x < c.Size && at(x, y, transpose) == at(x-1, y, transpose) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					p += 3 + run - 5
+				}
+				run = 1
+			}
+			for x := 0; x+len(finder) <= c.Size; x++ {
+				match := true
+				for k, dark := range finder {
+					if at(x+k, y, transpose) != dark {
+						match = false
+						break
+					}
+				}
+				if match && (c.light(x-4, x, y, transpose) || c.light(x+7, x+11, y, transpose)) {
+					p += 40
+				}
+			}
+		}
+	}
+	dark := 0
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.Modules[y][x] {
+				dark++
+			}
+			if x+1 < c.Size && y+1 < c.Size {
+				v := c.Modules[y][x]
+				if c.Modules[y][x+1] == v && c.Modules[y+1][x] == v && c.Modules[y+1][x+1] == v {
+					p += 3
+				}
+			}
+		}
+	}
+	total := c.Size * c.Size
+	p += (abs(dark*20-total*10)+total-1)/total*10 - 10
+	return p
+}
+
+// light reports whether the modules from column from up to to of row y are
+// light, counting those outside the symbol as light.
+func (c *Code) light(from, to, y int, transpose bool) bool {
+	for x := from; x < to; x++ {
+		if x < 0 || x >= c.Size {
+			continue
+		}
+		if (transpose && c.Modules[x][y]) || (!transpose && c.Modules[y][x]) {
+			return false
+		}
+	}
+	return true
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
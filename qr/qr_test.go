@@ -0,0 +1,149 @@
+package qr
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	t.Run("ErrorCorrection", func(t *testing.T) {
+		// Version 1-M codewords of "HELLO WORLD" from the standard's worked example.
+		data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+		want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+		if got := rsRemainder(data, rsDivisor(10)); !bytes.Equal(got, want) {
+			t.Errorf("Expected %v, got %v", want, got)
+		}
+	})
+
+	t.Run("FormatAndVersion", func(t *testing.T) {
+		if got := formatBits(5); got != 0b100000011001110 {
+			t.Errorf("Unexpected format bits %015b", got)
+		}
+		c := newCode(7)
+		var bits int
+		for i := 17; i >= 0; i-- {
+			bits <<= 1
+			if c.Modules[i/3][c.Size-11+i%3] {
+				bits |= 1
+			}
+		}
+		if bits != 0x07C94 {
+			t.Errorf("Unexpected version bits %018b", bits)
+		}
+	})
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		for _, text := range []string{"otpauth://totp/Go%20Admin:admin@example.com?secret=JBSWY3DPEHPK3PXP&issuer=Go%20Admin", "x", strings.Repeat("a", 200)} {
+			c, err := Encode(text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := decode(t, c); got != text {
+				t.Errorf("Expected %q back, got %q", text, got)
+			}
+		}
+		if _, err := Encode(strings.Repeat("a", 214)); err != ErrTooLong {
+			t.Errorf("Expected ErrTooLong, got %v", err)
+		}
+	})
+
+	t.Run("SVG", func(t *testing.T) {
+		c, _ := Encode("x")
+		if svg := c.SVG(4); !strings.HasPrefix(svg, `<svg xmlns="http://www.w3.org/2000/svg" width="116" height="116" viewBox="0 0 29 29"`) || !strings.Contains(svg, "M4,4h1v1h-1z") {
+			t.Errorf("Unexpected SVG %s", svg)
+		}
+	})
+}
+
+// decode reads c back: the mask from its format information, then the
+// codewords, which are checked and returned as text.
+func decode(t *testing.T, c *Code) string {
+	t.Helper()
+	var format int
+	for i := 14; i >= 0; i-- {
+		format <<= 1
+		if c.Modules[8][c.Size-1-i] && i < 8 || i >= 8 && c.Modules[c.Size-15+i][8] {
+			format |= 1
+		}
+	}
+	mask := -1
+	for m := range masks {
+		if formatBits(m) == format {
+			mask = m
+		}
+	}
+	if mask < 0 {
+		t.Fatalf("Unknown format bits %015b", format)
+	}
+	ver := (c.Size - 17) / 4
+	empty := newCode(ver)
+	v := versions[ver-1]
+	n := v.shortBlocks*v.shortData + v.longBlocks*v.longData + v.ecLen*(v.shortBlocks+v.longBlocks)
+	codewords := make([]byte, n)
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < c.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if (right+1)&2 == 0 {
+					y = c.Size - 1 - vert
+				}
+				if !empty.function[y][x] && i < n*8 {
+					if c.Modules[y][x] != masks[mask](x, y) {
+						codewords[i>>3] |= 1 << (7 - i&7)
+					}
+					i++
+				}
+			}
+		}
+	}
+	data := make([]byte, 0, n)
+	for b := 0; b < v.shortBlocks+v.longBlocks; b++ {
+		size := v.shortData
+		if b >= v.shortBlocks {
+			size = v.longData
+		}
+		block := make([]byte, 0, size+v.ecLen)
+		for k := 0; k < size; k++ {
+			idx := k*(v.shortBlocks+v.longBlocks) + b
+			if k == v.longData-1 && v.longBlocks > 0 {
+				idx = v.shortData*(v.shortBlocks+v.longBlocks) + b - v.shortBlocks
+			}
+			block = append(block, codewords[idx])
+		}
+		data = append(data, block...)
+		ecStart := v.shortBlocks*v.shortData + v.longBlocks*v.longData
+		ec := make([]byte, v.ecLen)
+		for k := range ec {
+			ec[k] = codewords[ecStart+k*(v.shortBlocks+v.longBlocks)+b]
+		}
+		if !bytes.Equal(rsRemainder(block, rsDivisor(v.ecLen)), ec) {
+			t.Fatalf("Block %d has wrong error correction", b)
+		}
+	}
+	if data[0]>>4 != 0b0100 {
+		t.Fatalf("Expected byte mode, got %04b", data[0]>>4)
+	}
+	bitAt := func(i int) int { return int(data[i>>3] >> (7 - i&7) & 1) }
+	read := func(from, n int) int {
+		v := 0
+		for i := from; i < from+n; i++ {
+			v = v<<1 | bitAt(i)
+		}
+		return v
+	}
+	countBits := 8
+	if ver >= 10 {
+		countBits = 16
+	}
+	length := read(4, countBits)
+	out := make([]byte, length)
+	for k := range out {
+		out[k] = byte(read(4+countBits+8*k, 8))
+	}
+	return string(out)
+}
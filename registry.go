@@ -2,11 +2,14 @@
 package admin
 
 import (
+	"context"
 	"embed"
 	"fmt"
 	"net/http"
+	"sort"
 
 	"github.com/go-packs/go-admin/config"
+	"github.com/go-packs/go-admin/cron"
 	"github.com/go-packs/go-admin/models"
 	"github.com/go-packs/go-admin/resource"
 	"gorm.io/gorm"
@@ -37,6 +40,39 @@ type Permission = models.Permission
 // AuditLog is an alias for models.AuditLog.
 type AuditLog = models.AuditLog
 
+// APIToken is an alias for models.APIToken.
+type APIToken = models.APIToken
+
+// LoginChallenge is an alias for models.LoginChallenge.
+type LoginChallenge = models.LoginChallenge
+
+// LoginThrottle is an alias for models.LoginThrottle.
+type LoginThrottle = models.LoginThrottle
+
+// AuditPrune is an alias for models.AuditPrune.
+type AuditPrune = models.AuditPrune
+
+// AuditHead is an alias for models.AuditHead.
+type AuditHead = models.AuditHead
+
+// Job is an alias for models.Job.
+type Job = models.Job
+
+// ScheduleRun is an alias for models.ScheduleRun.
+type ScheduleRun = models.ScheduleRun
+
+// ScheduleLease is an alias for models.ScheduleLease.
+type ScheduleLease = models.ScheduleLease
+
+// Webhook is an alias for models.Webhook.
+type Webhook = models.Webhook
+
+// WebhookDelivery is an alias for models.WebhookDelivery.
+type WebhookDelivery = models.WebhookDelivery
+
+// HookContext is an alias for resource.HookContext.
+type HookContext = resource.HookContext
+
 // Scope is an alias for resource.Scope.
 type Scope = resource.Scope
 
@@ -46,6 +82,7 @@ type Registry struct {
 	Resources map[string]*resource.Resource
 	Pages     map[string]*Page
 	Charts    []Chart
+	Schedules []*Schedule
 	Config    *config.Config
 }
 
@@ -62,6 +99,13 @@ type Chart struct {
 	Data  func(db *gorm.DB) (labels []string, values []float64)
 }
 
+// Schedule is a task the server runs on a cron schedule.
+type Schedule struct {
+	Name, Spec string
+	Cron       *cron.Schedule
+	Run        func(ctx context.Context, db *gorm.DB) error
+}
+
 // NewRegistry creates a new admin Registry.
 func NewRegistry(db *gorm.DB) *Registry {
 	return &Registry{
@@ -86,6 +130,31 @@ func (reg *Registry) AddChart(l, t string, p func(db *gorm.DB) ([]string, []floa
 	reg.Charts = append(reg.Charts, Chart{Label: l, Type: t, Data: p})
 }
 
+// AddSchedule registers a task that server.Server runs whenever spec, a cron
+// expression such as "0 3 * * *", fires in the server's local time. See
+// cron.Parse for the syntax. It returns an error if spec is invalid or name
+// is taken.
+func (reg *Registry) AddSchedule(name, spec string, fn func(ctx context.Context, db *gorm.DB) error) error {
+	if _, ok := reg.GetSchedule(name); ok {
+		return fmt.Errorf("schedule %s is already registered", name)
+	}
+	c, err := cron.Parse(spec)
+	if err != nil {
+		return err
+	}
+	reg.Schedules = append(reg.Schedules, &Schedule{Name: name, Spec: spec, Cron: c, Run: fn})
+	return nil
+}
+
+func (reg *Registry) GetSchedule(n string) (*Schedule, bool) {
+	for _, s := range reg.Schedules {
+		if s.Name == n {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
 func (reg *Registry) AddPage(n, g string, h http.HandlerFunc) {
 	reg.Pages[n] = &Page{Name: n, Group: g, Handler: h}
 }
@@ -97,11 +166,51 @@ func (reg *Registry) Register(m interface{}) *resource.Resource {
 	return res
 }
 
+// RegisterAuto registers a model and builds its fields from `admin` struct tags.
+func (reg *Registry) RegisterAuto(m interface{}) *resource.Resource {
+	return reg.Register(m).AutoFields()
+}
+
 func (reg *Registry) GetResource(n string) (*resource.Resource, bool) {
 	res, ok := reg.Resources[n]
 	return res, ok
 }
 
+// FieldNames returns the sorted, de-duplicated field names of all registered resources.
+func (reg *Registry) FieldNames() []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, r := range reg.Resources {
+		for _, f := range r.Fields {
+			if !seen[f.Name] {
+				seen[f.Name] = true
+				names = append(names, f.Name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ValidatePermission is a resource validator for Permission that checks a
+// field rule names a field of its resource.
+func (reg *Registry) ValidatePermission(item interface{}) error {
+	p, ok := item.(*Permission)
+	if !ok || p.FieldName == "" {
+		return nil
+	}
+	res, ok := reg.GetResource(p.ResourceName)
+	if !ok {
+		return nil
+	}
+	for _, f := range res.Fields {
+		if f.Name == p.FieldName {
+			return nil
+		}
+	}
+	return resource.ValidationErrors{"FieldName": fmt.Sprintf("is not a field of %s", p.ResourceName)}
+}
+
 func (reg *Registry) ResourceNames() []string {
 	names := make([]string, 0, len(reg.Resources))
 	for n := range reg.Resources {
@@ -0,0 +1,189 @@
+package resource
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/go-packs/go-admin/models"
+)
+
+// Redacted replaces the values of sensitive fields in audit diffs.
+const Redacted = "[REDACTED]"
+
+// SetSensitive marks model fields whose values are redacted from audit diffs.
+// Fields tagged `admin:"sensitive"` are sensitive without being listed here.
+func (r *Resource) SetSensitive(names ...string) *Resource {
+	r.SensitiveFields = append(r.SensitiveFields, names...)
+	return r
+}
+
+// IsSensitive reports whether values of the named model field must be redacted.
+func (r *Resource) IsSensitive(name string) bool {
+	for _, n := range r.SensitiveFields {
+		if n == name {
+			return true
+		}
+	}
+	for _, f := range r.Fields {
+		if f.Name == name && f.Sensitive {
+			return true
+		}
+	}
+	if sf, ok := r.modelType().FieldByName(name); ok {
+		for _, part := range splitTag(sf.Tag.Get(TagName)) {
+			key, val, hasVal := strings.Cut(part, "=")
+			if strings.TrimSpace(key) == "sensitive" && tagBool(val, hasVal) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Snapshot returns the scalar field values of item keyed by field name, or nil
+// for a nil item. Values are not redacted; Diff does that.
+func (r *Resource) Snapshot(item interface{}) map[string]interface{} {
+	if item == nil {
+		return nil
+	}
+	v := reflect.Indirect(reflect.ValueOf(item))
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	snap := make(map[string]interface{})
+	for _, sf := range snapshotFields(v.Type()) {
+		fv := v.FieldByIndex(sf.Index)
+		if fv.Kind() == reflect.Ptr {
+			if fv.IsNil() {
+				snap[sf.Name] = nil
+				continue
+			}
+			fv = fv.Elem()
+		}
+		snap[sf.Name] = fv.Interface()
+	}
+	return snap
+}
+
+// Diff compares two snapshots and returns the fields whose values differ, in
+// model field order, with sensitive values replaced by Redacted. A nil before
+// describes a created record and a nil after a deleted one. ManyToMany
+// associations follow the fields when the snapshots hold their linked IDs.
+func (r *Resource) Diff(before, after map[string]interface{}) []models.FieldChange {
+	var changes []models.FieldChange
+	for _, sf := range snapshotFields(r.modelType()) {
+		old, hadOld := before[sf.Name]
+		cur, hasNew := after[sf.Name]
+		if !hadOld && !hasNew || sameValue(old, cur) {
+			continue
+		}
+		if r.IsSensitive(sf.Name) {
+			old, cur = redact(old), redact(cur)
+		}
+		changes = append(changes, models.FieldChange{Field: sf.Name, Old: old, New: cur})
+	}
+	for _, a := range r.Associations {
+		if a.Type != "ManyToMany" {
+			continue
+		}
+		old, _ := before[a.Name].(string)
+		cur, _ := after[a.Name].(string)
+		if old != cur {
+			changes = append(changes, models.FieldChange{Field: a.Name, Old: old, New: cur})
+		}
+	}
+	return changes
+}
+
+// Version returns snap without its sensitive fields. This is the state stored
+// with audit entries so that records can be reverted to it.
+func (r *Resource) Version(snap map[string]interface{}) map[string]interface{} {
+	if snap == nil {
+		return nil
+	}
+	version := make(map[string]interface{}, len(snap))
+	for name, val := range snap {
+		if !r.IsSensitive(name) {
+			version[name] = val
+		}
+	}
+	return version
+}
+
+// Restore copies the values of a stored version onto item, which must be a
+// pointer to the resource model. Sensitive fields, fields missing from state
+// and fields for which keep returns true are left unchanged, and so are the
+// links of ManyToMany associations.
+func (r *Resource) Restore(item interface{}, state map[string]json.RawMessage, keep func(name string) bool) error {
+	v := reflect.ValueOf(item)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("restore %s: expected a pointer to the model, got %T", r.Name, item)
+	}
+	v = v.Elem()
+	for _, sf := range snapshotFields(v.Type()) {
+		raw, ok := state[sf.Name]
+		if !ok || r.IsSensitive(sf.Name) || (keep != nil && keep(sf.Name)) {
+			continue
+		}
+		fv := v.FieldByIndex(sf.Index)
+		if !fv.CanSet() {
+			continue
+		}
+		dest := reflect.New(fv.Type())
+		if err := json.Unmarshal(raw, dest.Interface()); err != nil {
+			return fmt.Errorf("restore %s.%s: %w", r.Name, sf.Name, err)
+		}
+		fv.Set(dest.Elem())
+	}
+	return nil
+}
+
+func (r *Resource) modelType() reflect.Type {
+	t := reflect.TypeOf(r.Model)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// snapshotFields lists the exported scalar fields of t, including those of
+// embedded structs, with Index set to their full path.
+func snapshotFields(t reflect.Type) []reflect.StructField {
+	var fields []reflect.StructField
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() || sf.Tag.Get("gorm") == "-" {
+			continue
+		}
+		if sf.Anonymous && sf.Type.Kind() == reflect.Struct && sf.Type != timeType {
+			for _, inner := range snapshotFields(sf.Type) {
+				inner.Index = append([]int{i}, inner.Index...)
+				fields = append(fields, inner)
+			}
+			continue
+		}
+		if isScalar(sf.Type) {
+			fields = append(fields, sf)
+		}
+	}
+	return fields
+}
+
+func sameValue(a, b interface{}) bool {
+	ta, okA := a.(time.Time)
+	tb, okB := b.(time.Time)
+	if okA && okB {
+		return ta.Equal(tb)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func redact(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	return Redacted
+}
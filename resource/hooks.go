@@ -0,0 +1,139 @@
+package resource
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/go-packs/go-admin/models"
+	"gorm.io/gorm"
+)
+
+// HookContext describes the operation a lifecycle hook runs in.
+type HookContext struct {
+	// Context is the context of the request being served.
+	Context context.Context
+	// User performs the operation. It is nil for trusted internal callers.
+	User *models.AdminUser
+	// DB is the handle of the operation: the transaction of a save or delete,
+	// so that hook writes commit or roll back with it, or the list query.
+	DB *gorm.DB
+	// IsNew is set while saving a record that does not exist yet.
+	IsNew bool
+}
+
+// HookFunc is a save, delete or load hook. item is a pointer to the record.
+// Returning an error aborts the operation; use Abort for a message meant for
+// the user.
+type HookFunc func(hc *HookContext, item interface{}) error
+
+// ListHookFunc modifies the query listing the records of a resource.
+type ListHookFunc func(hc *HookContext, db *gorm.DB) *gorm.DB
+
+// Hooks are the lifecycle hooks of a resource, run in the order they were added.
+type Hooks struct {
+	BeforeSave, AfterSave     []HookFunc
+	BeforeDelete, AfterDelete []HookFunc
+	AfterLoad                 []HookFunc
+	BeforeList                []ListHookFunc
+}
+
+// AbortError stops a save or delete with a message that is shown to the user.
+type AbortError struct{ Message string }
+
+func (e *AbortError) Error() string { return e.Message }
+
+// Abort returns an AbortError with the formatted message.
+func Abort(format string, args ...interface{}) error {
+	return &AbortError{Message: fmt.Sprintf(format, args...)}
+}
+
+// AbortMessage returns the message of an AbortError in err's chain.
+func AbortMessage(err error) (string, bool) {
+	var abort *AbortError
+	if errors.As(err, &abort) {
+		return abort.Message, true
+	}
+	return "", false
+}
+
+// BeforeSave adds a hook run in the save transaction before a record is
+// created or updated.
+func (r *Resource) BeforeSave(fn HookFunc) *Resource {
+	r.Hooks.BeforeSave = append(r.Hooks.BeforeSave, fn)
+	return r
+}
+
+// AfterSave adds a hook run in the save transaction once the record is
+// written. An error rolls the save back.
+func (r *Resource) AfterSave(fn HookFunc) *Resource {
+	r.Hooks.AfterSave = append(r.Hooks.AfterSave, fn)
+	return r
+}
+
+// BeforeDelete adds a hook run in the delete transaction before a record is
+// deleted.
+func (r *Resource) BeforeDelete(fn HookFunc) *Resource {
+	r.Hooks.BeforeDelete = append(r.Hooks.BeforeDelete, fn)
+	return r
+}
+
+// AfterDelete adds a hook run in the delete transaction once the record is
+// deleted. An error rolls the delete back.
+func (r *Resource) AfterDelete(fn HookFunc) *Resource {
+	r.Hooks.AfterDelete = append(r.Hooks.AfterDelete, fn)
+	return r
+}
+
+// AfterLoad adds a hook run on every record loaded to be read: for a list, a
+// show page, search or an export. Records loaded to be edited, saved or
+// deleted skip it, so values it changes for display are never written back.
+func (r *Resource) AfterLoad(fn HookFunc) *Resource {
+	r.Hooks.AfterLoad = append(r.Hooks.AfterLoad, fn)
+	return r
+}
+
+// BeforeList adds a query modifier applied, after the policy, to the list
+// view, the JSON API list, exports and search.
+func (r *Resource) BeforeList(fn ListHookFunc) *Resource {
+	r.Hooks.BeforeList = append(r.Hooks.BeforeList, fn)
+	return r
+}
+
+// RunHooks calls hooks on item in order and stops at the first error.
+func RunHooks(hooks []HookFunc, hc *HookContext, item interface{}) error {
+	for _, fn := range hooks {
+		if err := fn(hc, item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ApplyListHooks passes db through the BeforeList hooks.
+func (r *Resource) ApplyListHooks(hc *HookContext, db *gorm.DB) *gorm.DB {
+	for _, fn := range r.Hooks.BeforeList {
+		hc.DB = db
+		db = fn(hc, db)
+	}
+	return db
+}
+
+// Loaded runs the AfterLoad hooks on every element of items, a slice of
+// models or of pointers to them.
+func (r *Resource) Loaded(hc *HookContext, items reflect.Value) error {
+	if len(r.Hooks.AfterLoad) == 0 {
+		return nil
+	}
+	for i := 0; i < items.Len(); i++ {
+		item := items.Index(i)
+		if item.Kind() != reflect.Ptr {
+			item = item.Addr()
+		}
+		if err := RunHooks(r.Hooks.AfterLoad, hc, item.Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
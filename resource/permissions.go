@@ -0,0 +1,39 @@
+package resource
+
+// Field access levels a Permission rule can restrict a role to.
+const (
+	FieldHidden   = "hide"
+	FieldReadonly = "readonly"
+)
+
+// FieldRestrictions maps field names to FieldHidden or FieldReadonly for the
+// current user. A nil value restricts nothing.
+type FieldRestrictions map[string]string
+
+// Hidden reports whether the field may not be read.
+func (fr FieldRestrictions) Hidden(name string) bool {
+	return fr[name] == FieldHidden
+}
+
+// Writable reports whether the field may be changed.
+func (fr FieldRestrictions) Writable(name string) bool {
+	return fr[name] == ""
+}
+
+// Apply drops hidden fields and marks read-only fields Readonly. fields is not modified.
+func (fr FieldRestrictions) Apply(fields []Field) []Field {
+	if len(fr) == 0 {
+		return fields
+	}
+	result := make([]Field, 0, len(fields))
+	for _, f := range fields {
+		switch fr[f.Name] {
+		case FieldHidden:
+			continue
+		case FieldReadonly:
+			f.Readonly = true
+		}
+		result = append(result, f)
+	}
+	return result
+}
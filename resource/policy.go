@@ -0,0 +1,58 @@
+package resource
+
+import (
+	"reflect"
+
+	"github.com/go-packs/go-admin/models"
+	"gorm.io/gorm"
+)
+
+// PolicyFunc narrows a query to the records user may see and modify.
+type PolicyFunc func(db *gorm.DB, user *models.AdminUser) *gorm.DB
+
+// RecordCheckFunc reports whether user may act on a loaded record.
+type RecordCheckFunc func(item interface{}, user *models.AdminUser) bool
+
+// SetPolicy restricts every query for this resource to the rows fn allows.
+func (r *Resource) SetPolicy(fn PolicyFunc) *Resource { r.Policy = fn; return r }
+
+// SetCanEdit adds a per-record check run before a record is edited or saved.
+func (r *Resource) SetCanEdit(fn RecordCheckFunc) *Resource { r.EditCheck = fn; return r }
+
+// SetCanDelete adds a per-record check run before a record is deleted.
+func (r *Resource) SetCanDelete(fn RecordCheckFunc) *Resource { r.DeleteCheck = fn; return r }
+
+// ApplyPolicy narrows db with the resource policy. A nil user is a trusted
+// internal caller and is not restricted.
+func (r *Resource) ApplyPolicy(db *gorm.DB, user *models.AdminUser) *gorm.DB {
+	if r.Policy == nil || user == nil {
+		return db
+	}
+	return r.Policy(db, user)
+}
+
+// SetReadOnly makes the resource view-only: records cannot be created, edited,
+// deleted or batch-processed from the panel or the API.
+func (r *Resource) SetReadOnly(readOnly bool) *Resource { r.ReadOnly = readOnly; return r }
+
+// IsReadOnly reports whether the resource is view-only. The audit log is
+// always read-only, however it was registered.
+func (r *Resource) IsReadOnly() bool {
+	return r.ReadOnly || r.modelType() == reflect.TypeOf(models.AuditLog{})
+}
+
+// CanEdit reports whether user may edit item.
+func (r *Resource) CanEdit(item interface{}, user *models.AdminUser) bool {
+	if r.IsReadOnly() {
+		return false
+	}
+	return r.EditCheck == nil || user == nil || r.EditCheck(item, user)
+}
+
+// CanDelete reports whether user may delete item.
+func (r *Resource) CanDelete(item interface{}, user *models.AdminUser) bool {
+	if r.IsReadOnly() {
+		return false
+	}
+	return r.DeleteCheck == nil || user == nil || r.DeleteCheck(item, user)
+}
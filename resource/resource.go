@@ -5,6 +5,7 @@ import (
 	"html/template"
 	"net/http"
 	"reflect"
+	"slices"
 
 	"gorm.io/gorm"
 )
@@ -28,12 +29,18 @@ type SidebarHandler func(res *Resource, item interface{}) template.HTML
 type Action struct {
 	Name, Label string
 	Handler     ActionHandler
+	// Background runs the action as a job instead of inside the request.
+	Background bool
 }
 
 // BatchAction represents an action performed on multiple records.
 type BatchAction struct {
 	Name, Label string
 	Handler     BatchActionHandler
+	// Background runs the action as a job instead of inside the request.
+	Background bool
+	// Deletes checks CanDelete, rather than CanEdit, on every selected record.
+	Deletes bool
 }
 
 // Scope represents a predefined filter/tab for a resource.
@@ -48,8 +55,18 @@ type Sidebar struct {
 	Handler SidebarHandler
 }
 
-// Association defines a relation between resources.
-type Association struct{ Type, Name, ResourceName, ForeignKey, Label string }
+// Association defines a relation between resources. JoinTable is only set for
+// ManyToMany associations.
+type Association struct {
+	Type, Name, ResourceName, ForeignKey, Label, JoinTable string
+	// Inline edits the children of a HasMany association on the parent form.
+	Inline bool
+	// TypeField names the field holding the target resource of a Polymorphic
+	// association, whose ForeignKey holds the target's ID. Targets lists the
+	// resources it may point to.
+	TypeField string
+	Targets   []string
+}
 
 // Field describes a single model field exposed in the admin UI.
 type Field struct {
@@ -60,6 +77,9 @@ type Field struct {
 	SearchResource    string
 	Decorator         DecoratorFunc
 	Sortable          bool
+	Filterable        bool
+	Sensitive         bool
+	Validators        []ValidatorFunc
 }
 
 // Resource holds metadata for a GORM model managed by the admin.
@@ -77,6 +97,14 @@ type Resource struct {
 	Associations      []Association
 	Sidebars          []Sidebar
 	Attributes        map[string]interface{}
+	Validators        []ResourceValidatorFunc
+	Policy            PolicyFunc
+	EditCheck         RecordCheckFunc
+	DeleteCheck       RecordCheckFunc
+	SensitiveFields   []string
+	ReadOnly          bool
+	ImportKey         string
+	Hooks             Hooks
 }
 
 // NewResource creates a new Resource metadata object from a model value.
@@ -90,7 +118,14 @@ func NewResource(model interface{}) *Resource {
 
 func (r *Resource) SetGroup(group string) *Resource { r.Group = group; return r }
 func (r *Resource) RegisterField(name, label string, readonly bool) *Resource {
-	r.Fields = append(r.Fields, Field{Name: name, Label: label, Type: "text", Readonly: readonly, Sortable: true})
+	for i, f := range r.Fields {
+		if f.Name == name {
+			r.Fields[i].Label, r.Fields[i].Readonly = label, readonly
+			return r
+		}
+	}
+	sensitive := r.IsSensitive(name)
+	r.Fields = append(r.Fields, Field{Name: name, Label: label, Type: "text", Readonly: readonly, Sortable: !sensitive, Filterable: !sensitive})
 	return r
 }
 func (r *Resource) SetSortable(name string, sortable bool) *Resource {
@@ -102,6 +137,18 @@ func (r *Resource) SetSortable(name string, sortable bool) *Resource {
 	}
 	return r
 }
+
+// SetFilterable allows or forbids filtering the list on the named field.
+func (r *Resource) SetFilterable(name string, filterable bool) *Resource {
+	for i, f := range r.Fields {
+		if f.Name == name {
+			r.Fields[i].Filterable = filterable
+			break
+		}
+	}
+	return r
+}
+
 func (r *Resource) SetDecorator(name string, fn DecoratorFunc) *Resource {
 	for i, f := range r.Fields {
 		if f.Name == name {
@@ -127,6 +174,39 @@ func (r *Resource) AddBatchAction(n, l string, h BatchActionHandler) *Resource {
 	r.BatchActions = append(r.BatchActions, BatchAction{Name: n, Label: l, Handler: h})
 	return r
 }
+
+// SetBackground runs the named collection and batch actions as background
+// jobs. The request only queues the job; the handler runs later with a
+// recorded response, and can report progress with admin.ReportProgress.
+func (r *Resource) SetBackground(names ...string) *Resource {
+	for _, n := range names {
+		for i := range r.CollectionActions {
+			if r.CollectionActions[i].Name == n {
+				r.CollectionActions[i].Background = true
+			}
+		}
+		for i := range r.BatchActions {
+			if r.BatchActions[i].Name == n {
+				r.BatchActions[i].Background = true
+			}
+		}
+	}
+	return r
+}
+
+// SetDeleting marks the named batch actions as deleting records, so the
+// selection is checked with CanDelete instead of CanEdit.
+func (r *Resource) SetDeleting(names ...string) *Resource {
+	for _, n := range names {
+		for i := range r.BatchActions {
+			if r.BatchActions[i].Name == n {
+				r.BatchActions[i].Deletes = true
+			}
+		}
+	}
+	return r
+}
+
 func (r *Resource) AddScope(n, l string, h ScopeFunc) *Resource {
 	r.Scopes = append(r.Scopes, Scope{Name: n, Label: l, Handler: h})
 	return r
@@ -139,6 +219,43 @@ func (r *Resource) BelongsTo(n, l, tr, fk string) *Resource {
 	r.Associations = append(r.Associations, Association{Type: "BelongsTo", Name: n, Label: l, ResourceName: tr, ForeignKey: fk})
 	return r
 }
+
+// HasOne adds a one-to-one relation to the record of resource tr whose field
+// fk holds this record's ID.
+func (r *Resource) HasOne(n, l, tr, fk string) *Resource {
+	r.Associations = append(r.Associations, Association{Type: "HasOne", Name: n, Label: l, ResourceName: tr, ForeignKey: fk})
+	return r
+}
+
+// Polymorphic adds a relation to a record of any of the resources targets:
+// the field typeField holds the name of its resource and idField its ID.
+func (r *Resource) Polymorphic(n, l, typeField, idField string, targets ...string) *Resource {
+	r.Associations = append(r.Associations, Association{Type: "Polymorphic", Name: n, Label: l, ForeignKey: idField, TypeField: typeField, Targets: targets})
+	return r
+}
+
+// SetInline edits the children of the named HasMany associations as rows of
+// the parent form, saved in the parent's transaction.
+func (r *Resource) SetInline(names ...string) *Resource {
+	for _, n := range names {
+		for i := range r.Associations {
+			if r.Associations[i].Name == n && r.Associations[i].Type == "HasMany" {
+				r.Associations[i].Inline = true
+			}
+		}
+	}
+	return r
+}
+
+// ManyToMany adds a relation through joinTable to the records of resource tr.
+// n names a slice field of the model declared with a matching
+// gorm:"many2many:<joinTable>" tag; forms edit it with a searchable
+// multi-select and saves replace the whole set.
+func (r *Resource) ManyToMany(n, l, tr, joinTable string) *Resource {
+	r.Associations = append(r.Associations, Association{Type: "ManyToMany", Name: n, Label: l, ResourceName: tr, JoinTable: joinTable})
+	return r
+}
+
 func (r *Resource) SetSearchable(f, tr string) *Resource {
 	for i, field := range r.Fields {
 		if field.Name == f {
@@ -161,7 +278,35 @@ func (r *Resource) SetIndexFields(n ...string) *Resource { r.IndexFields = n; re
 func (r *Resource) SetShowFields(n ...string) *Resource  { r.ShowFields = n; return r }
 func (r *Resource) SetEditFields(n ...string) *Resource  { r.EditFields = n; return r }
 
-func (r *Resource) GetFieldsFor(view string) []Field {
+// SetImportKey names the natural key field that CSV imports may match existing
+// records on instead of ID.
+func (r *Resource) SetImportKey(name string) *Resource { r.ImportKey = name; return r }
+
+// GetFieldsFor returns the fields of a view, minus any hidden by restrict.
+func (r *Resource) GetFieldsFor(view string, restrict ...FieldRestrictions) []Field {
+	fields := r.fieldsFor(view)
+	for _, fr := range restrict {
+		fields = fr.Apply(fields)
+	}
+	return fields
+}
+
+// IndexAssociations returns the HasOne and Polymorphic associations shown as
+// list columns: those named in IndexFields, or all of them when it is empty.
+func (r *Resource) IndexAssociations() []Association {
+	var result []Association
+	for _, a := range r.Associations {
+		if a.Type != "HasOne" && a.Type != "Polymorphic" {
+			continue
+		}
+		if len(r.IndexFields) == 0 || slices.Contains(r.IndexFields, a.Name) {
+			result = append(result, a)
+		}
+	}
+	return result
+}
+
+func (r *Resource) fieldsFor(view string) []Field {
 	var names []string
 	switch view {
 	case "index":
@@ -1,7 +1,11 @@
 package resource
 
 import (
+	"errors"
+	"fmt"
+	"reflect"
 	"testing"
+	"time"
 )
 
 type MockModel struct {
@@ -9,6 +13,16 @@ type MockModel struct {
 	Name string
 }
 
+type TaggedModel struct {
+	ID        uint   `gorm:"primaryKey"`
+	Name      string `admin:"label=Product Name,index,edit"`
+	Status    string `admin:"options=draft|live,index"`
+	ProductID uint   `admin:"searchable=Product"`
+	Active    bool
+	Secret    string `admin:"-"`
+	Tags      []string
+}
+
 func TestResource(t *testing.T) {
 	t.Run("NewResource", func(t *testing.T) {
 		res := NewResource(MockModel{})
@@ -46,5 +60,150 @@ func TestResource(t *testing.T) {
 		if len(fields) != 2 {
 			t.Errorf("GetFieldsFor 'show' should return all fields, got %d", len(fields))
 		}
+
+		fields = res.GetFieldsFor("show", FieldRestrictions{"ID": FieldHidden, "Name": FieldReadonly})
+		if len(fields) != 1 || !fields[0].Readonly {
+			t.Errorf("Restrictions should hide ID and make Name read-only, got %+v", fields)
+		}
+		if res.Fields[1].Readonly {
+			t.Error("Restrictions must not modify the resource's fields")
+		}
+	})
+
+	t.Run("AutoFields", func(t *testing.T) {
+		res := NewResource(TaggedModel{}).AutoFields()
+		if len(res.Fields) != 5 {
+			t.Fatalf("Expected 5 fields, got %d", len(res.Fields))
+		}
+		byName := map[string]Field{}
+		for _, f := range res.Fields {
+			byName[f.Name] = f
+		}
+		if !byName["ID"].Readonly {
+			t.Error("Primary key should be readonly")
+		}
+		if byName["Name"].Label != "Product Name" {
+			t.Errorf("Expected tag label, got %s", byName["Name"].Label)
+		}
+		if byName["Status"].Type != "select" || len(byName["Status"].Options) != 2 {
+			t.Errorf("Options should produce a select, got %+v", byName["Status"])
+		}
+		if !byName["ProductID"].Searchable || byName["ProductID"].SearchResource != "Product" || byName["ProductID"].Label != "Product ID" {
+			t.Errorf("Searchable tag failed: %+v", byName["ProductID"])
+		}
+		if byName["Active"].Type != "checkbox" {
+			t.Errorf("Expected checkbox for bool, got %s", byName["Active"].Type)
+		}
+		if len(res.IndexFields) != 2 || len(res.EditFields) != 1 {
+			t.Errorf("View lists not populated: %v %v", res.IndexFields, res.EditFields)
+		}
+
+		res.RegisterField("Name", "Title", true).SetFieldType("Status", "text")
+		if len(res.Fields) != 5 {
+			t.Error("RegisterField should override an existing field, not append")
+		}
+		for _, f := range res.Fields {
+			if f.Name == "Name" && (f.Label != "Title" || !f.Readonly) {
+				t.Errorf("Builder override failed: %+v", f)
+			}
+			if f.Name == "Status" && f.Type != "text" {
+				t.Errorf("SetFieldType override failed: %+v", f)
+			}
+		}
+	})
+
+	t.Run("Diff", func(t *testing.T) {
+		type Account struct {
+			ID       uint
+			Email    string
+			Password string `admin:"sensitive"`
+			Token    string
+			Joined   time.Time
+		}
+		res := NewResource(Account{}).SetSensitive("Token")
+		joined := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		before := res.Snapshot(&Account{ID: 1, Email: "a@example.com", Password: "x", Token: "t1", Joined: joined})
+		after := res.Snapshot(Account{ID: 1, Email: "b@example.com", Password: "y", Token: "t1", Joined: joined.In(time.Local)})
+		changes := res.Diff(before, after)
+		if len(changes) != 2 {
+			t.Fatalf("Expected Email and Password to change, got %+v", changes)
+		}
+		if changes[0].Field != "Email" || changes[0].Old != "a@example.com" || changes[0].New != "b@example.com" {
+			t.Errorf("Unexpected change %+v", changes[0])
+		}
+		if changes[1].Field != "Password" || changes[1].Old != Redacted || changes[1].New != Redacted {
+			t.Errorf("Sensitive values should be redacted, got %+v", changes[1])
+		}
+		for _, r := range []*Resource{NewResource(Account{}).AutoFields(), NewResource(Account{}).RegisterField("Password", "Password", false)} {
+			for _, f := range r.Fields {
+				if f.Name == "Password" && (f.Sortable || f.Filterable) {
+					t.Errorf("Sensitive fields should not be sortable or filterable by default, got %+v", f)
+				}
+			}
+		}
+		deleted := res.Diff(before, nil)
+		for _, c := range deleted {
+			if c.New != nil || (c.Field == "Token" && c.Old != Redacted) {
+				t.Errorf("Unexpected change for a deleted record %+v", c)
+			}
+		}
+
+		res.ManyToMany("Groups", "Groups", "Group", "account_groups")
+		if a := res.Associations[0]; a.Type != "ManyToMany" || a.JoinTable != "account_groups" || a.ResourceName != "Group" {
+			t.Errorf("Unexpected association %+v", a)
+		}
+		before["Groups"], after["Groups"] = "1, 2", "2"
+		if changes := res.Diff(before, after); len(changes) != 3 || changes[2].Field != "Groups" || changes[2].Old != "1, 2" || changes[2].New != "2" {
+			t.Errorf("Expected the changed links after the fields, got %+v", changes)
+		}
+	})
+
+	t.Run("Validate", func(t *testing.T) {
+		res := NewResource(MockModel{})
+		res.RegisterField("Name", "Name", false).SetLength("Name", 2, 5).SetPattern("Name", "^[a-z]+$")
+		res.AddResourceValidator(func(item interface{}) error {
+			if item.(*MockModel).ID == 7 {
+				return ValidationErrors{"ID": "is unlucky"}
+			}
+			return nil
+		})
+		if errs := res.Validate(&MockModel{Name: "abc"}); errs != nil {
+			t.Errorf("Expected no errors, got %v", errs)
+		}
+		errs := res.Validate(&MockModel{ID: 7, Name: "a"})
+		if errs["Name"] != "must be at least 2 characters" || errs["ID"] != "is unlucky" {
+			t.Errorf("Unexpected errors: %v", errs)
+		}
+		if errs := res.Validate(&MockModel{Name: "ABC"}); errs["Name"] != "is not in the expected format" {
+			t.Errorf("Pattern validator failed: %v", errs)
+		}
+	})
+
+	t.Run("Hooks", func(t *testing.T) {
+		res := NewResource(MockModel{})
+		var calls []string
+		res.BeforeSave(func(hc *HookContext, item interface{}) error {
+			calls = append(calls, "first")
+			return Abort("%s is locked", item.(*MockModel).Name)
+		}).BeforeSave(func(hc *HookContext, item interface{}) error {
+			calls = append(calls, "second")
+			return nil
+		})
+		err := RunHooks(res.Hooks.BeforeSave, &HookContext{}, &MockModel{Name: "gear"})
+		if msg, ok := AbortMessage(fmt.Errorf("record 1: %w", err)); !ok || msg != "gear is locked" || len(calls) != 1 {
+			t.Errorf("Expected the first hook to abort, got %q %v", msg, calls)
+		}
+		if _, ok := AbortMessage(errors.New("plain")); ok {
+			t.Error("Plain errors carry no abort message")
+		}
+
+		res.AfterLoad(func(hc *HookContext, item interface{}) error {
+			item.(*MockModel).Name += "!"
+			return nil
+		})
+		items := []MockModel{{Name: "a"}, {Name: "b"}}
+		if err := res.Loaded(&HookContext{}, reflect.ValueOf(items)); err != nil || items[0].Name != "a!" || items[1].Name != "b!" {
+			t.Errorf("AfterLoad should run on every item, got %v (%v)", items, err)
+		}
 	})
 }
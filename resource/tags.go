@@ -0,0 +1,209 @@
+package resource
+
+import (
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// TagName is the struct tag key read by AutoFields.
+const TagName = "admin"
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// AutoFields reflects over the resource model and registers a Field for every
+// exported scalar struct field, honouring `admin:"..."` tags. Supported keys:
+//
+//	label=Text, type=select, options=a|b, readonly, sortable, filterable, searchable=Resource,
+//	sensitive, index, show, edit, required, email, min=N, max=N, minlen=N, maxlen=N
+//
+// A tag of "-" skips the field. Fields already registered are left untouched,
+// and builder calls made afterwards override whatever the tags produced.
+func (r *Resource) AutoFields() *Resource {
+	t := reflect.TypeOf(r.Model)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return r
+	}
+	r.autoFields(t)
+	return r
+}
+
+func (r *Resource) autoFields(t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		tag := sf.Tag.Get(TagName)
+		gormTag := sf.Tag.Get("gorm")
+		if tag == "-" || gormTag == "-" {
+			continue
+		}
+		if sf.Anonymous && sf.Type.Kind() == reflect.Struct && sf.Type != timeType {
+			r.autoFields(sf.Type)
+			continue
+		}
+		if !isScalar(sf.Type) || r.hasField(sf.Name) {
+			continue
+		}
+		f := defaultField(sf, gormTag)
+		var views []string
+		for _, part := range splitTag(tag) {
+			key, val, hasVal := strings.Cut(part, "=")
+			key = strings.TrimSpace(key)
+			switch key {
+			case "label":
+				f.Label = val
+			case "type":
+				f.Type = val
+			case "options":
+				f.Options = strings.Split(val, "|")
+			case "readonly":
+				f.Readonly = tagBool(val, hasVal)
+			case "sortable":
+				f.Sortable = tagBool(val, hasVal)
+			case "filterable":
+				f.Filterable = tagBool(val, hasVal)
+			case "sensitive":
+				f.Sensitive = tagBool(val, hasVal)
+			case "searchable":
+				f.Searchable, f.SearchResource = true, val
+			case "required":
+				if tagBool(val, hasVal) {
+					f.Validators = append(f.Validators, Required())
+				}
+			case "email":
+				if tagBool(val, hasVal) {
+					f.Validators = append(f.Validators, Email())
+				}
+			case "min", "max":
+				if n, err := strconv.ParseFloat(val, 64); err == nil {
+					lo, hi := math.Inf(-1), math.Inf(1)
+					if key == "min" {
+						lo = n
+					} else {
+						hi = n
+					}
+					f.Validators = append(f.Validators, Range(lo, hi))
+				}
+			case "minlen", "maxlen":
+				if n, err := strconv.Atoi(val); err == nil {
+					if key == "minlen" {
+						f.Validators = append(f.Validators, Length(n, 0))
+					} else {
+						f.Validators = append(f.Validators, Length(0, n))
+					}
+				}
+			case "index", "show", "edit":
+				if tagBool(val, hasVal) {
+					views = append(views, key)
+				}
+			}
+		}
+		if len(f.Options) > 0 && f.Type == "text" {
+			f.Type = "select"
+		}
+		r.Fields = append(r.Fields, f)
+		for _, v := range views {
+			switch v {
+			case "index":
+				r.IndexFields = append(r.IndexFields, f.Name)
+			case "show":
+				r.ShowFields = append(r.ShowFields, f.Name)
+			case "edit":
+				r.EditFields = append(r.EditFields, f.Name)
+			}
+		}
+	}
+}
+
+func (r *Resource) hasField(name string) bool {
+	for _, f := range r.Fields {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultField derives a Field from the Go kind and GORM tag of a struct field.
+// Sensitive fields are neither sortable nor filterable by default: both would
+// let a user guess the value.
+func defaultField(sf reflect.StructField, gormTag string) Field {
+	f := Field{Name: sf.Name, Label: humanize(sf.Name), Type: "text", Sortable: true, Filterable: true}
+	for _, part := range splitTag(sf.Tag.Get(TagName)) {
+		if key, val, hasVal := strings.Cut(part, "="); strings.TrimSpace(key) == "sensitive" && tagBool(val, hasVal) {
+			f.Sortable, f.Filterable = false, false
+		}
+	}
+	t := sf.Type
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		f.Type = "datetime"
+	case t.Kind() == reflect.Bool:
+		f.Type = "checkbox"
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Float64:
+		f.Type = "number"
+	}
+	lower := strings.ToLower(gormTag)
+	if sf.Name == "ID" || strings.Contains(lower, "primarykey") ||
+		strings.Contains(lower, "autocreatetime") || strings.Contains(lower, "autoupdatetime") ||
+		sf.Name == "CreatedAt" || sf.Name == "UpdatedAt" {
+		f.Readonly = true
+	}
+	return f
+}
+
+func isScalar(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func splitTag(tag string) []string {
+	if tag == "" {
+		return nil
+	}
+	return strings.Split(tag, ",")
+}
+
+func tagBool(val string, hasVal bool) bool {
+	return !hasVal || (val != "false" && val != "0")
+}
+
+// humanize turns a Go identifier such as "ProductID" into "Product ID".
+func humanize(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, c := range runes {
+		if i > 0 && unicode.IsUpper(c) {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteRune(' ')
+			}
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,208 @@
+package resource
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// BaseError is the ValidationErrors key used for errors not tied to a field.
+const BaseError = "base"
+
+// ValidatorFunc validates a single field value. item is a pointer to the model being saved.
+type ValidatorFunc func(value interface{}, item interface{}) error
+
+// ResourceValidatorFunc validates a whole record. Returning ValidationErrors maps
+// messages onto fields; any other error is reported under BaseError.
+type ResourceValidatorFunc func(item interface{}) error
+
+// ValidationErrors maps field names to error messages.
+type ValidationErrors map[string]string
+
+func (e ValidationErrors) Error() string {
+	keys := make([]string, 0, len(e))
+	for k := range e {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s: %s", k, e[k]))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Add records msg for field unless the field already has an error.
+func (e ValidationErrors) Add(field, msg string) {
+	if _, ok := e[field]; !ok {
+		e[field] = msg
+	}
+}
+
+// Merge folds err into e, spreading ValidationErrors across fields.
+func (e ValidationErrors) Merge(err error) {
+	if err == nil {
+		return
+	}
+	if ve, ok := err.(ValidationErrors); ok {
+		for k, v := range ve {
+			e.Add(k, v)
+		}
+		return
+	}
+	e.Add(BaseError, err.Error())
+}
+
+// Validator is implemented by models that validate themselves before saving.
+type Validator interface {
+	Validate() error
+}
+
+func (r *Resource) AddValidator(name string, fn ValidatorFunc) *Resource {
+	for i, f := range r.Fields {
+		if f.Name == name {
+			r.Fields[i].Validators = append(r.Fields[i].Validators, fn)
+			break
+		}
+	}
+	return r
+}
+func (r *Resource) AddResourceValidator(fn ResourceValidatorFunc) *Resource {
+	r.Validators = append(r.Validators, fn)
+	return r
+}
+func (r *Resource) SetRequired(name string) *Resource { return r.AddValidator(name, Required()) }
+func (r *Resource) SetRange(name string, min, max float64) *Resource {
+	return r.AddValidator(name, Range(min, max))
+}
+func (r *Resource) SetLength(name string, min, max int) *Resource {
+	return r.AddValidator(name, Length(min, max))
+}
+func (r *Resource) SetPattern(name, pattern string) *Resource {
+	return r.AddValidator(name, Pattern(pattern))
+}
+func (r *Resource) SetEmail(name string) *Resource { return r.AddValidator(name, Email()) }
+
+// Required rejects zero values and blank strings.
+func Required() ValidatorFunc {
+	return func(value interface{}, _ interface{}) error {
+		v := reflect.ValueOf(value)
+		if !v.IsValid() || v.IsZero() || (v.Kind() == reflect.String && strings.TrimSpace(v.String()) == "") {
+			return fmt.Errorf("is required")
+		}
+		return nil
+	}
+}
+
+// Range rejects numbers outside [min, max].
+func Range(min, max float64) ValidatorFunc {
+	return func(value interface{}, _ interface{}) error {
+		n, ok := toFloat(value)
+		if !ok {
+			return nil
+		}
+		if n < min {
+			return fmt.Errorf("must be at least %v", min)
+		}
+		if n > max {
+			return fmt.Errorf("must be at most %v", max)
+		}
+		return nil
+	}
+}
+
+// Length rejects strings shorter than min or longer than max characters. A max of 0 means no limit.
+func Length(min, max int) ValidatorFunc {
+	return func(value interface{}, _ interface{}) error {
+		s, ok := value.(string)
+		if !ok {
+			return nil
+		}
+		n := len([]rune(s))
+		if n < min {
+			return fmt.Errorf("must be at least %d characters", min)
+		}
+		if max > 0 && n > max {
+			return fmt.Errorf("must be at most %d characters", max)
+		}
+		return nil
+	}
+}
+
+// Pattern rejects non-empty strings that do not match the regular expression.
+func Pattern(pattern string) ValidatorFunc {
+	re := regexp.MustCompile(pattern)
+	return func(value interface{}, _ interface{}) error {
+		s, ok := value.(string)
+		if !ok || s == "" {
+			return nil
+		}
+		if !re.MatchString(s) {
+			return fmt.Errorf("is not in the expected format")
+		}
+		return nil
+	}
+}
+
+// Email rejects non-empty strings that are not a bare email address.
+func Email() ValidatorFunc {
+	return func(value interface{}, _ interface{}) error {
+		s, ok := value.(string)
+		if !ok || s == "" {
+			return nil
+		}
+		addr, err := mail.ParseAddress(s)
+		if err != nil || addr.Address != s {
+			return fmt.Errorf("is not a valid email address")
+		}
+		return nil
+	}
+}
+
+// Validate runs field validators, resource validators and the model's own
+// Validate method against item, which must be a pointer to the model.
+func (r *Resource) Validate(item interface{}) ValidationErrors {
+	errs := ValidationErrors{}
+	elem := reflect.Indirect(reflect.ValueOf(item))
+	for _, f := range r.Fields {
+		if len(f.Validators) == 0 {
+			continue
+		}
+		fv := elem.FieldByName(f.Name)
+		if !fv.IsValid() {
+			continue
+		}
+		for _, v := range f.Validators {
+			if err := v(fv.Interface(), item); err != nil {
+				errs.Add(f.Name, err.Error())
+				break
+			}
+		}
+	}
+	for _, v := range r.Validators {
+		errs.Merge(v(item))
+	}
+	if m, ok := item.(Validator); ok {
+		errs.Merge(m.Validate())
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
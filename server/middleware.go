@@ -14,10 +14,15 @@ func Logger(next http.Handler) http.Handler {
 	})
 }
 
+// Recovery answers 500 when a handler panics. http.ErrAbortHandler is passed
+// on, so that net/http drops the connection of a response cut short instead
+// of appending an error page to it.
 func Recovery(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
-			if err := recover(); err != nil {
+			if err := recover(); err == http.ErrAbortHandler {
+				panic(err)
+			} else if err != nil {
 				log.Printf("panic: %v", err)
 				http.Error(w, "Internal Server Error", 500)
 			}
@@ -21,35 +21,95 @@ func NewRouter(reg *admin.Registry) http.Handler {
 			return
 		}
 
-		user, role := internal.GetUserFromRequest(reg, r)
+		user, _ := internal.GetUserFromRequest(reg, r)
 
 		// 2. Authentication Routing
-		if upath == "/login" || upath == "/logout" {
-			routeAuth(reg, w, r, upath)
+		if upath == "/login" || upath == "/login/2fa" || upath == "/logout" {
+			routeAuth(reg, w, r, upath, user)
 			return
 		}
 
-		// 3. Auth Guard
+		// 3. JSON API Routing (answers 401 itself instead of redirecting)
+		if upath == "/api" || strings.HasPrefix(upath, "/api/") {
+			handlers.HandleAPI(reg, w, r, strings.TrimPrefix(upath, "/api"), user)
+			return
+		}
+
+		// 4. Auth Guard
 		if user == nil {
 			http.Redirect(w, r, "/admin/login", 303)
 			return
 		}
 
-		// 4. Dashboard Routing
+		// 5. CSRF Check for state-changing requests. Reading the token parses
+		// the form, so import uploads are capped before it.
+		if r.Method == "POST" && strings.HasSuffix(upath, "/import") {
+			r.Body = http.MaxBytesReader(w, r.Body, handlers.MaxImportSize)
+			if err := r.ParseMultipartForm(handlers.MaxImportSize); err != nil && err != http.ErrNotMultipart {
+				http.Error(w, "The file is too large or could not be read", http.StatusRequestEntityTooLarge)
+				return
+			}
+		}
+		if !internal.SafeMethod(r.Method) && !internal.VerifyCSRF(r, user) {
+			http.Error(w, "Invalid CSRF token", 403)
+			return
+		}
+
+		// 6. Personal API Tokens
+		if upath == "/tokens" || strings.HasPrefix(upath, "/tokens/") {
+			handlers.HandleTokens(reg, w, r, upath, user)
+			return
+		}
+
+		// 7. Two-Factor Settings
+		if upath == "/2fa" || strings.HasPrefix(upath, "/2fa/") {
+			handlers.HandleTwoFactor(reg, w, r, upath, user)
+			return
+		}
+
+		// 8. Background Jobs
+		if upath == "/jobs" || strings.HasPrefix(upath, "/jobs/") {
+			handlers.HandleJobs(reg, w, r, upath, user)
+			return
+		}
+
+		// 9. Scheduled Tasks
+		if upath == "/schedules" || strings.HasPrefix(upath, "/schedules/") {
+			handlers.HandleSchedules(reg, w, r, upath, user)
+			return
+		}
+
+		// 10. Webhook Deliveries
+		if upath == "/webhooks" || strings.HasPrefix(upath, "/webhooks/") {
+			handlers.HandleWebhooks(reg, w, r, upath, user)
+			return
+		}
+
+		// 11. Audit Log Integrity
+		if upath == "/audit/verify" {
+			handlers.HandleAuditVerify(reg, w, r, user)
+			return
+		}
+
+		// 12. Dashboard Routing
 		if upath == "" || upath == "/" {
 			view.RenderDashboard(reg, w, r, user)
 			return
 		}
 
-		// 5. Search API Routing
+		// 13. Search API Routing
 		if strings.HasSuffix(upath, "/search") {
 			parts := strings.Split(strings.TrimPrefix(upath, "/"), "/")
-			handlers.HandleSearchAPI(reg, parts[0], w, r)
+			if !internal.Can(reg, user, parts[0], "list") {
+				http.Error(w, "Forbidden", 403)
+				return
+			}
+			handlers.HandleSearchAPI(reg, parts[0], w, r, user)
 			return
 		}
 
-		// 6. Main Resource/Page Routing
-		routeMain(reg, w, r, upath, user, role)
+		// 14. Main Resource/Page Routing
+		routeMain(reg, w, r, upath, user)
 	})
 }
 
@@ -58,7 +118,11 @@ func handleStatic(reg *admin.Registry, w http.ResponseWriter, r *http.Request, u
 	http.ServeFile(w, r, filepath.Join(reg.Config.UploadDir, fileName))
 }
 
-func routeAuth(reg *admin.Registry, w http.ResponseWriter, r *http.Request, upath string) {
+func routeAuth(reg *admin.Registry, w http.ResponseWriter, r *http.Request, upath string, user *admin.AdminUser) {
+	if upath == "/login/2fa" {
+		handlers.TwoFactorLogin(reg)(w, r)
+		return
+	}
 	if upath == "/login" {
 		if r.Method == "POST" {
 			handlers.Login(reg)(w, r)
@@ -67,10 +131,14 @@ func routeAuth(reg *admin.Registry, w http.ResponseWriter, r *http.Request, upat
 		}
 		return
 	}
+	if r.Method != "POST" || !internal.VerifyCSRF(r, user) {
+		http.Redirect(w, r, "/admin", 303)
+		return
+	}
 	handlers.Logout(reg)(w, r)
 }
 
-func routeMain(reg *admin.Registry, w http.ResponseWriter, r *http.Request, upath string, user *admin.AdminUser, role string) {
+func routeMain(reg *admin.Registry, w http.ResponseWriter, r *http.Request, upath string, user *admin.AdminUser) {
 	parts := strings.Split(strings.TrimPrefix(upath, "/"), "/")
 	resourceName := parts[0]
 
@@ -92,37 +160,73 @@ func routeMain(reg *admin.Registry, w http.ResponseWriter, r *http.Request, upat
 		action = parts[1]
 	}
 
-	// Permission Check
-	if !internal.IsAllowed(reg, role, resourceName, action) &&
-		action != "export" && !strings.Contains(action, "action") {
+	if postOnlyActions[action] && r.Method != "POST" {
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+
+	// Permission Check, which also applies the scopes of API tokens
+	if !internal.Can(reg, user, resourceName, action) {
 		http.Error(w, "Forbidden", 403)
 		return
 	}
 
+	if res.IsReadOnly() && writeActions[action] {
+		http.Error(w, res.Name+" is read-only", 403)
+		return
+	}
+
 	handleResourceAction(reg, res, action, w, r, user)
 }
 
+// writeActions are refused on read-only resources.
+var writeActions = map[string]bool{
+	"new": true, "edit": true, "save": true, "delete": true, "batch_action": true, "import": true,
+}
+
+// postOnlyActions change data and are refused over GET so they cannot be
+// triggered by links or image tags.
+var postOnlyActions = map[string]bool{
+	"save": true, "delete": true, "revert": true, "action": true, "collection_action": true, "batch_action": true,
+}
+
 func handleResourceAction(reg *admin.Registry, res *admin.Resource, action string, w http.ResponseWriter, r *http.Request, user *admin.AdminUser) {
 	switch action {
 	case "export":
-		handlers.HandleExport(reg, res, w, r)
+		handlers.HandleExport(reg, res, w, r, user)
+	case "import":
+		handlers.HandleImport(reg, res, w, r, user)
 	case "action":
-		handlers.HandleCustomAction(reg, res, w, r, false)
+		handlers.HandleCustomAction(reg, res, w, r, user, false)
 	case "collection_action":
-		handlers.HandleCustomAction(reg, res, w, r, true)
+		handlers.HandleCustomAction(reg, res, w, r, user, true)
 	case "batch_action":
-		handlers.HandleBatchAction(reg, res, w, r)
+		handlers.HandleBatchAction(reg, res, w, r, user)
 	case "save":
 		handlers.HandleSave(reg, res, w, r, user)
+	case "revert":
+		handlers.HandleRevert(reg, w, r, user)
 	case "new":
 		handlers.RenderForm(reg, res, nil, w, r, user)
 	case "show":
 		id := r.URL.Query().Get("id")
-		item, _ := internal.Get(reg, res.Name, id)
+		item, err := internal.Get(r.Context(), reg, res.Name, id, user)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
 		handlers.RenderShow(reg, res, item, w, r, user)
 	case "edit":
 		id := r.URL.Query().Get("id")
-		item, _ := internal.Get(reg, res.Name, id)
+		item, err := internal.Find(reg, res.Name, id, user)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if !res.CanEdit(item, user) {
+			http.Error(w, "Forbidden", 403)
+			return
+		}
 		handlers.RenderForm(reg, res, item, w, r, user)
 	case "delete":
 		handlers.HandleDelete(reg, res, w, r, user)
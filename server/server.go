@@ -1,11 +1,28 @@
 package server
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/go-packs/go-admin"
+	"github.com/go-packs/go-admin/handlers"
+	"github.com/go-packs/go-admin/internal"
 )
 
+// jobPollInterval is how often idle workers look for queued jobs.
+const jobPollInterval = time.Second
+
+// jobRecoveryInterval is how often jobs abandoned by a stopped server are
+// looked for after start-up.
+const jobRecoveryInterval = time.Minute
+
+// webhookPollInterval is how often the delivery worker looks for deliveries
+// whose retry is due.
+const webhookPollInterval = 5 * time.Second
+
 type Server struct {
 	Registry *admin.Registry
 	Addr     string
@@ -15,12 +32,194 @@ func NewServer(reg *admin.Registry, addr string) *Server {
 	return &Server{Registry: reg, Addr: addr}
 }
 
+// Start serves the admin panel on Addr and runs its background workers until
+// the server stops.
 func (s *Server) Start() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.pruneAuditLog(ctx)
+	go s.runJobs(ctx)
+	go s.runSchedules(ctx)
+	go s.runWebhooks(ctx)
+
+	return http.ListenAndServe(s.Addr, s.Handler())
+}
+
+// Handler returns the admin panel mounted at /admin/, wrapped in the logging
+// and recovery middleware.
+func (s *Server) Handler() http.Handler {
 	mux := http.NewServeMux()
 	mux.Handle("/admin/", NewRouter(s.Registry))
 
 	handler := Logger(mux)
 	handler = Recovery(handler)
+	return handler
+}
+
+// pruneAuditLog applies the audit retention policy at start-up and then every
+// AuditPruneIntervalMinutes until ctx is done.
+func (s *Server) pruneAuditLog(ctx context.Context) {
+	conf := s.Registry.Config
+	if conf.AuditRetentionDays <= 0 {
+		return
+	}
+	interval := time.Duration(conf.AuditPruneIntervalMinutes) * time.Minute
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if n, archive, err := internal.ApplyAuditRetention(s.Registry); err != nil {
+			fmt.Printf("audit retention error: %v\n", err)
+		} else if n > 0 {
+			fmt.Printf("audit retention: pruned %d entries %s\n", n, archive)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runJobs executes queued jobs with JobWorkers workers until ctx is done.
+// Jobs whose server stopped while running them are recovered at start-up
+// and every jobRecoveryInterval.
+func (s *Server) runJobs(ctx context.Context) {
+	if !s.Registry.DB.Migrator().HasTable(&admin.Job{}) {
+		return
+	}
+	s.recoverJobs()
+	workers := max(s.Registry.Config.JobWorkers, 1)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.jobWorker(ctx)
+		}()
+	}
+	ticker := time.NewTicker(jobRecoveryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case <-ticker.C:
+			s.recoverJobs()
+		}
+	}
+}
+
+func (s *Server) recoverJobs() {
+	if requeued, failed, err := internal.RecoverJobs(s.Registry); err != nil {
+		fmt.Printf("job recovery error: %v\n", err)
+	} else if requeued+failed > 0 {
+		fmt.Printf("jobs: re-queued %d and failed %d interrupted jobs\n", requeued, failed)
+	}
+}
+
+func (s *Server) jobWorker(ctx context.Context) {
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+	for {
+		for ctx.Err() == nil && s.runNextJob(ctx) {
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-internal.JobQueued():
+		case <-ticker.C:
+		}
+	}
+}
+
+// runNextJob claims one queued job and runs it to completion. It reports
+// whether there was a job to run.
+func (s *Server) runNextJob(ctx context.Context) bool {
+	job, err := internal.ClaimJob(s.Registry)
+	if err != nil {
+		fmt.Printf("job queue error: %v\n", err)
+		return false
+	}
+	if job == nil {
+		return false
+	}
+	err = internal.ExecuteJob(ctx, s.Registry, job, func(ctx context.Context) (string, error) {
+		return handlers.RunJob(ctx, s.Registry, job)
+	})
+	if err != nil {
+		fmt.Printf("job %d: %v\n", job.ID, err)
+	}
+	return true
+}
+
+// runSchedules runs the registry's scheduled tasks when they are due, until
+// ctx is done. Each due time is claimed through a lease in the database, so
+// when several servers share it only one of them runs the task. Times missed
+// while no server was running are not caught up.
+func (s *Server) runSchedules(ctx context.Context) {
+	schedules := s.Registry.Schedules
+	if len(schedules) == 0 {
+		return
+	}
+	next := make(map[string]time.Time, len(schedules))
+	now := time.Now()
+	for _, sc := range schedules {
+		next[sc.Name] = sc.Cron.Next(now)
+	}
+	for {
+		var wake time.Time
+		for _, t := range next {
+			if !t.IsZero() && (wake.IsZero() || t.Before(wake)) {
+				wake = t
+			}
+		}
+		if wake.IsZero() {
+			return
+		}
+		timer := time.NewTimer(time.Until(wake))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		now = time.Now()
+		for _, sc := range schedules {
+			slot := next[sc.Name]
+			if slot.IsZero() || slot.After(now) {
+				continue
+			}
+			next[sc.Name] = sc.Cron.Next(now)
+			go func() {
+				if err := internal.RunSchedule(ctx, s.Registry, sc, slot); err != nil {
+					fmt.Printf("schedule %s: %v\n", sc.Name, err)
+				}
+			}()
+		}
+	}
+}
 
-	return http.ListenAndServe(s.Addr, handler)
+// runWebhooks sends queued webhook deliveries as they become due, until ctx
+// is done. Deliveries are sent one at a time.
+func (s *Server) runWebhooks(ctx context.Context) {
+	if !s.Registry.DB.Migrator().HasTable(&admin.WebhookDelivery{}) {
+		return
+	}
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := internal.DeliverWebhooks(ctx, s.Registry); err != nil {
+			fmt.Printf("webhook delivery error: %v\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-internal.WebhookQueued():
+		case <-ticker.C:
+		}
+	}
 }
@@ -1,11 +1,24 @@
 package server
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/go-packs/go-admin"
+	"github.com/go-packs/go-admin/handlers"
+	"github.com/go-packs/go-admin/internal"
 	"github.com/go-packs/go-admin/models"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
@@ -16,7 +29,7 @@ func setupTestDB() (*gorm.DB, *admin.Registry) {
 	if err != nil {
 		panic(err)
 	}
-	if err := db.AutoMigrate(&models.AdminUser{}, &models.Session{}, &models.Permission{}); err != nil {
+	if err := db.AutoMigrate(&models.AdminUser{}, &models.Session{}, &models.Permission{}, &models.AuditLog{}, &models.Job{}); err != nil {
 		panic(err)
 	}
 	reg := admin.NewRegistry(db)
@@ -48,3 +61,494 @@ func TestRouter(t *testing.T) {
 		}
 	})
 }
+
+func TestCSRF(t *testing.T) {
+	db, reg := setupTestDB()
+	reg.Register(models.Permission{})
+	router := NewRouter(reg)
+	user := &models.AdminUser{Email: "csrf@example.com", Role: "admin"}
+	db.Create(user)
+	db.Create(&models.Session{ID: "csrf-sess", UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)})
+	db.Create(&models.Permission{Role: "editor", ResourceName: "Permission", Action: "list"})
+
+	send := func(method, path string, form url.Values, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		if header["Authorization"] == "" {
+			req.AddCookie(&http.Cookie{Name: "admin_session", Value: "csrf-sess"})
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// The first authenticated request issues a token for sessions created without one.
+	if w := send("GET", "/admin/", nil, nil); w.Code != http.StatusOK {
+		t.Fatalf("Expected dashboard, got %d", w.Code)
+	}
+	var sess models.Session
+	db.First(&sess, "id = ?", "csrf-sess")
+	if sess.CSRFToken == "" {
+		t.Fatal("Expected a CSRF token on the session")
+	}
+
+	t.Run("DeleteRequiresPost", func(t *testing.T) {
+		if w := send("GET", "/admin/Permission/delete?id=1", nil, nil); w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("Expected 405, got %d", w.Code)
+		}
+	})
+
+	t.Run("MissingToken", func(t *testing.T) {
+		w := send("POST", "/admin/Permission/delete", url.Values{"id": {"1"}, "csrf_token": {"forged"}}, nil)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected 403, got %d", w.Code)
+		}
+		if w := send("POST", "/admin/logout", nil, nil); w.Header().Get("Location") != "/admin" {
+			t.Errorf("Logout without a token should be ignored, got %q", w.Header().Get("Location"))
+		}
+	})
+
+	t.Run("ValidToken", func(t *testing.T) {
+		w := send("POST", "/admin/Permission/delete", url.Values{"id": {"1"}}, map[string]string{"X-CSRF-Token": sess.CSRFToken})
+		if w.Code != http.StatusSeeOther {
+			t.Fatalf("Expected 303, got %d", w.Code)
+		}
+		var count int64
+		db.Model(&models.Permission{}).Count(&count)
+		if count != 0 {
+			t.Error("Record should have been deleted")
+		}
+	})
+
+	t.Run("BearerOptOut", func(t *testing.T) {
+		w := send("POST", "/admin/Permission/delete", url.Values{"id": {"1"}}, map[string]string{"Authorization": "Bearer csrf-sess"})
+		if w.Code == http.StatusForbidden {
+			t.Error("Requests authenticated without the session cookie should not need a CSRF token")
+		}
+	})
+
+	t.Run("ImportCappedBeforeToken", func(t *testing.T) {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		_ = mw.WriteField("csrf_token", sess.CSRFToken)
+		fw, _ := mw.CreateFormFile("file", "big.csv")
+		_, _ = fw.Write(bytes.Repeat([]byte("x"), handlers.MaxImportSize))
+		_ = mw.Close()
+		req := httptest.NewRequest("POST", "/admin/Permission/import", &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		req.AddCookie(&http.Cookie{Name: "admin_session", Value: "csrf-sess"})
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("Expected 413, got %d", w.Code)
+		}
+	})
+
+	t.Run("TokenScopes", func(t *testing.T) {
+		if err := db.AutoMigrate(&models.APIToken{}); err != nil {
+			t.Fatal(err)
+		}
+		tok, secret, err := models.NewAPIToken(user.ID, "ci", "Permission:list", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.Create(tok)
+		bearer := map[string]string{"Authorization": "Bearer " + secret}
+		db.Create(&models.Permission{Role: "editor", ResourceName: "Permission", Action: "list"})
+		for _, path := range []string{"/admin/Permission/batch_action?name=delete", "/admin/Permission/collection_action?name=x", "/admin/Permission/export"} {
+			if w := send("POST", path, url.Values{"ids": {"2"}}, bearer); w.Code != http.StatusForbidden {
+				t.Errorf("%s: a list-only token should be refused, got %d", path, w.Code)
+			}
+		}
+		if w := send("GET", "/admin/AdminUser/search?q=csrf", nil, bearer); w.Code != http.StatusForbidden {
+			t.Errorf("Search should need the list permission, got %d", w.Code)
+		}
+		if w := send("GET", "/admin/Permission/search?q=", nil, bearer); w.Code != http.StatusOK {
+			t.Errorf("Search within the token's scope should work, got %d", w.Code)
+		}
+	})
+
+	t.Run("Logout", func(t *testing.T) {
+		w := send("POST", "/admin/logout", url.Values{"csrf_token": {sess.CSRFToken}}, nil)
+		if w.Header().Get("Location") != "/admin/login" {
+			t.Errorf("Expected logout, got %q", w.Header().Get("Location"))
+		}
+	})
+}
+
+type Gadget struct {
+	ID   uint `gorm:"primaryKey"`
+	Name string
+}
+
+func TestJobs(t *testing.T) {
+	db, reg := setupTestDB()
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1) // workers must share the in-memory database
+	_ = db.AutoMigrate(&Gadget{})
+	reg.Config.JobDir = t.TempDir()
+	res := reg.Register(Gadget{}).
+		RegisterField("ID", "ID", true).
+		RegisterField("Name", "Name", false).
+		AddBatchAction("shout", "Shout", func(res *admin.Resource, ids []string, w http.ResponseWriter, r *http.Request) {
+			for i, id := range ids {
+				db.Model(&Gadget{}).Where("id = ?", id).Update("name", gorm.Expr("UPPER(name)"))
+				admin.ReportProgress(r.Context(), i+1, len(ids))
+			}
+			reg.SetFlash(w, fmt.Sprintf("Shouted %d", len(ids)))
+		}).
+		AddCollectionAction("fail", "Fail", func(res *admin.Resource, w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "nothing to do", 422)
+		}).
+		SetBackground("shout", "fail")
+	db.Create(&Gadget{Name: "dial"})
+	db.Create(&Gadget{Name: "knob"})
+	owner := &models.AdminUser{Email: "owner@example.com", Role: "admin", CSRFToken: "owner-csrf"}
+	other := &models.AdminUser{Email: "other@example.com", Role: "editor", CSRFToken: "other-csrf"}
+	db.Create(owner)
+	db.Create(other)
+	db.Create(&models.Session{ID: "owner-sess", UserID: owner.ID, CSRFToken: "owner-csrf", ExpiresAt: time.Now().Add(time.Hour)})
+	db.Create(&models.Session{ID: "other-sess", UserID: other.ID, CSRFToken: "other-csrf", ExpiresAt: time.Now().Add(time.Hour)})
+	router := NewRouter(reg)
+	send := func(sess, method, path string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: "admin_session", Value: sess})
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	s := NewServer(reg, "")
+	lastJob := func() models.Job {
+		var job models.Job
+		db.Order("id desc").First(&job)
+		return job
+	}
+
+	t.Run("BatchAction", func(t *testing.T) {
+		w := send("owner-sess", "POST", "/admin/Gadget/batch_action", url.Values{"csrf_token": {"owner-csrf"}, "action_name": {"shout"}, "ids": {"1", "2"}})
+		if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/admin/jobs" {
+			t.Fatalf("Expected a redirect to the jobs page, got %d %q", w.Code, w.Header().Get("Location"))
+		}
+		var g Gadget
+		db.First(&g, 1)
+		if job := lastJob(); job.Status != models.JobQueued || job.UserID != owner.ID || g.Name != "dial" {
+			t.Fatalf("The action should only be queued, got %+v", job)
+		}
+		if !s.runNextJob(context.Background()) {
+			t.Fatal("Expected a job to run")
+		}
+		db.First(&g, 1)
+		if job := lastJob(); job.Status != models.JobDone || job.Result != "Shouted 2" || job.Progress != 100 || g.Name != "DIAL" {
+			t.Errorf("Unexpected job %+v, gadget %+v", job, g)
+		}
+		var audit models.AuditLog
+		db.Where("action = ?", "Action").First(&audit)
+		if audit.UserEmail != owner.Email || audit.Changes != "Shout" {
+			t.Errorf("The job should be audited as its owner, got %+v", audit)
+		}
+	})
+
+	t.Run("FailedAction", func(t *testing.T) {
+		send("owner-sess", "POST", "/admin/Gadget/collection_action?name=fail", url.Values{"csrf_token": {"owner-csrf"}})
+		s.runNextJob(context.Background())
+		if job := lastJob(); job.Status != models.JobFailed || job.Error != "nothing to do" {
+			t.Errorf("Unexpected job %+v", job)
+		}
+	})
+
+	t.Run("Export", func(t *testing.T) {
+		w := send("owner-sess", "POST", "/admin/Gadget/export?q_Name=KNOB", url.Values{"csrf_token": {"owner-csrf"}, "background": {"1"}, "format": {"jsonl"}})
+		if w.Code != http.StatusSeeOther {
+			t.Fatalf("Expected 303, got %d", w.Code)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			s.runJobs(ctx)
+			close(done)
+		}()
+		deadline := time.Now().Add(5 * time.Second)
+		for job := lastJob(); job.Active() && time.Now().Before(deadline); job = lastJob() {
+			time.Sleep(10 * time.Millisecond)
+		}
+		cancel()
+		<-done
+		job := lastJob()
+		if job.Status != models.JobDone || job.Result != "Gadget_export.jsonl" {
+			t.Fatalf("Unexpected job %+v", job)
+		}
+		w = send("owner-sess", "GET", fmt.Sprintf("/admin/jobs/download?id=%d", job.ID), nil)
+		if body := strings.TrimSpace(w.Body.String()); body != `{"ID":2,"Name":"KNOB"}` {
+			t.Errorf("Unexpected export %q", body)
+		}
+		if w := send("other-sess", "GET", fmt.Sprintf("/admin/jobs/download?id=%d", job.ID), nil); w.Code != http.StatusNotFound {
+			t.Errorf("Other users should not download the export, got %d", w.Code)
+		}
+		db.Create(&models.Permission{Role: "editor", ResourceName: "Job", Action: "list"})
+		defer db.Where("role = ? AND resource_name = ?", "editor", "Job").Delete(&models.Permission{})
+		if w := send("other-sess", "GET", fmt.Sprintf("/admin/jobs/download?id=%d", job.ID), nil); w.Code != http.StatusNotFound {
+			t.Errorf("Seeing every job should not allow downloading other users' exports, got %d", w.Code)
+		}
+		if body := send("other-sess", "GET", "/admin/jobs", nil).Body.String(); !strings.Contains(body, "Gadget_export.jsonl") || strings.Contains(body, "/admin/jobs/download") {
+			t.Errorf("Other users' exports should be listed without a download link: %s", body)
+		}
+	})
+
+	t.Run("Page", func(t *testing.T) {
+		w := send("owner-sess", "GET", "/admin/jobs", nil)
+		if body := w.Body.String(); !strings.Contains(body, "Shouted 2") || !strings.Contains(body, "nothing to do") || !strings.Contains(body, "Gadget_export.jsonl") {
+			t.Errorf("Jobs page should list the jobs: %s", body)
+		}
+		w = send("other-sess", "GET", "/admin/jobs/status", nil)
+		if strings.TrimSpace(w.Body.String()) != "[]" {
+			t.Errorf("Users should only see their own jobs: %s", w.Body.String())
+		}
+	})
+
+	t.Run("PermissionRechecked", func(t *testing.T) {
+		if _, err := internal.EnqueueJob(reg, other, "batch_action", "Gadget", "Shout", map[string]interface{}{"action": "shout", "ids": []string{"1"}}); err != nil {
+			t.Fatal(err)
+		}
+		s.runNextJob(context.Background())
+		var g Gadget
+		db.First(&g, 1)
+		if job := lastJob(); job.Status != models.JobFailed || job.Error != internal.ErrForbidden.Error() || g.Name != "DIAL" {
+			t.Errorf("Jobs should not run once their owner lacks the permission, got %+v", job)
+		}
+	})
+
+	t.Run("ExportFailsPartway", func(t *testing.T) {
+		gadgets := make([]Gadget, 600)
+		for i := range gadgets {
+			gadgets[i].Name = fmt.Sprintf("g%d", i)
+		}
+		db.CreateInBatches(gadgets, 200)
+		loads := 0
+		res.AfterLoad(func(hc *admin.HookContext, item interface{}) error {
+			if loads++; loads > 600 {
+				return fmt.Errorf("disk error")
+			}
+			return nil
+		})
+		send("owner-sess", "POST", "/admin/Gadget/export", url.Values{"csrf_token": {"owner-csrf"}, "background": {"1"}})
+		s.runNextJob(context.Background())
+		job := lastJob()
+		if job.Status != models.JobFailed || job.Error != "the export failed partway through" {
+			t.Errorf("A broken export should fail its job, got %+v", job)
+		}
+		if _, err := os.Stat(filepath.Join(reg.Config.JobDir, fmt.Sprintf("job-%d.csv", job.ID))); !os.IsNotExist(err) {
+			t.Error("A broken export should leave no file behind")
+		}
+
+		// Streamed through the real middleware, the download must break off
+		// rather than end in an error page.
+		loads = 0
+		srv := httptest.NewServer(s.Handler())
+		defer srv.Close()
+		req, _ := http.NewRequest("GET", srv.URL+"/admin/Gadget/export", nil)
+		req.AddCookie(&http.Cookie{Name: "admin_session", Value: "owner-sess"})
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err == nil || resp.StatusCode != http.StatusOK || strings.Contains(string(body), "Internal Server Error") {
+			t.Errorf("Expected the download to be cut off, got %d %v with %d bytes", resp.StatusCode, err, len(body))
+		}
+	})
+}
+
+func TestJobsNotMigrated(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		NewServer(admin.NewRegistry(db), "").runJobs(context.Background())
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("runJobs should stop at once when the jobs table is missing")
+	}
+}
+
+func TestSchedules(t *testing.T) {
+	db, reg := setupTestDB()
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	_ = db.AutoMigrate(&models.ScheduleRun{}, &models.ScheduleLease{})
+	var ticks atomic.Int32
+	if err := reg.AddSchedule("Tick", "@every 1s", func(ctx context.Context, db *gorm.DB) error {
+		ticks.Add(1)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.AddSchedule("Nightly", "0 3 * * *", func(ctx context.Context, db *gorm.DB) error {
+		return fmt.Errorf("nothing to clean")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	user := &models.AdminUser{Email: "ops@example.com", Role: "admin", CSRFToken: "ops-csrf"}
+	db.Create(user)
+	db.Create(&models.Session{ID: "ops-sess", UserID: user.ID, CSRFToken: "ops-csrf", ExpiresAt: time.Now().Add(time.Hour)})
+	router := NewRouter(reg)
+	send := func(method, path string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: "admin_session", Value: "ops-sess"})
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	waitFor := func(cond func() bool) bool {
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if cond() {
+				return true
+			}
+		}
+		return false
+	}
+
+	t.Run("Scheduler", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			NewServer(reg, "").runSchedules(ctx)
+			close(done)
+		}()
+		ok := waitFor(func() bool { return ticks.Load() > 0 })
+		cancel()
+		<-done
+		if !ok {
+			t.Fatal("The scheduler should run due tasks")
+		}
+	})
+
+	t.Run("RunNow", func(t *testing.T) {
+		w := send("POST", "/admin/schedules/run", url.Values{"csrf_token": {"ops-csrf"}, "name": {"Nightly"}})
+		if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/admin/schedules?name=Nightly" {
+			t.Fatalf("Expected a redirect to the history, got %d %q", w.Code, w.Header().Get("Location"))
+		}
+		var run models.ScheduleRun
+		if !waitFor(func() bool {
+			return db.Where("name = ? AND status = ?", "Nightly", models.JobFailed).Limit(1).Find(&run).RowsAffected > 0
+		}) {
+			t.Fatal("The run should finish")
+		}
+		if run.Trigger != "ops@example.com" || run.Error != "nothing to clean" {
+			t.Errorf("Unexpected run %+v", run)
+		}
+		body := send("GET", "/admin/schedules?name=Nightly", nil).Body.String()
+		if !strings.Contains(body, "0 3 * * *") || !strings.Contains(body, "Run history of Nightly") || !strings.Contains(body, "nothing to clean") {
+			t.Errorf("Unexpected schedules page: %s", body)
+		}
+		if w := send("POST", "/admin/schedules/run", url.Values{"csrf_token": {"ops-csrf"}, "name": {"Missing"}}); w.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 for an unknown task, got %d", w.Code)
+		}
+	})
+}
+
+func TestWebhooks(t *testing.T) {
+	db, reg := setupTestDB()
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	_ = db.AutoMigrate(&Gadget{}, &models.Webhook{}, &models.WebhookDelivery{})
+	reg.Config.WebhookAllowPrivate = true
+	reg.Register(Gadget{}).RegisterField("ID", "ID", true).RegisterField("Name", "Name", false)
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	db.Create(&models.Webhook{Name: "Inventory", URL: srv.URL, ResourceName: "Gadget", Secret: "s3cret", Active: true})
+	owner := &models.AdminUser{Email: "ops@example.com", Role: "admin", CSRFToken: "ops-csrf"}
+	editor := &models.AdminUser{Email: "ed@example.com", Role: "editor", CSRFToken: "ed-csrf"}
+	db.Create(owner)
+	db.Create(editor)
+	db.Create(&models.Session{ID: "ops-sess", UserID: owner.ID, CSRFToken: "ops-csrf", ExpiresAt: time.Now().Add(time.Hour)})
+	db.Create(&models.Session{ID: "ed-sess", UserID: editor.ID, CSRFToken: "ed-csrf", ExpiresAt: time.Now().Add(time.Hour)})
+	router := NewRouter(reg)
+	send := func(sess, method, path string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: "admin_session", Value: sess})
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	deliver := func(want int32) bool {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			NewServer(reg, "").runWebhooks(ctx)
+			close(done)
+		}()
+		deadline := time.Now().Add(5 * time.Second)
+		for hits.Load() < want && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		time.Sleep(20 * time.Millisecond) // let the worker record the answer
+		cancel()
+		<-done
+		return hits.Load() >= want
+	}
+
+	w := send("ops-sess", "POST", "/admin/Gadget/save", url.Values{"csrf_token": {"ops-csrf"}, "Name": {"dial"}})
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("Expected the save to redirect, got %d", w.Code)
+	}
+	if !deliver(1) {
+		t.Fatal("The create should be delivered")
+	}
+	var d models.WebhookDelivery
+	db.Last(&d)
+	if d.Event != "create" || d.Status != models.DeliveryDelivered || d.ResponseCode != http.StatusNoContent {
+		t.Errorf("Unexpected delivery %+v", d)
+	}
+
+	t.Run("Page", func(t *testing.T) {
+		body := send("ops-sess", "GET", "/admin/webhooks?webhook=1", nil).Body.String()
+		if !strings.Contains(body, "Inventory") || !strings.Contains(body, "delivered") || !strings.Contains(body, "204") {
+			t.Errorf("Unexpected delivery log: %s", body)
+		}
+		if strings.Contains(body, `event&#34;:&#34;create`) {
+			t.Error("The delivery log must not show payloads")
+		}
+		if w := send("ed-sess", "GET", "/admin/webhooks", nil); w.Code != http.StatusForbidden {
+			t.Errorf("Editors should not see deliveries, got %d", w.Code)
+		}
+	})
+
+	t.Run("Replay", func(t *testing.T) {
+		if w := send("ed-sess", "POST", "/admin/webhooks/replay", url.Values{"csrf_token": {"ed-csrf"}, "id": {fmt.Sprint(d.ID)}}); w.Code != http.StatusForbidden {
+			t.Errorf("Editors should not replay deliveries, got %d", w.Code)
+		}
+		w := send("ops-sess", "POST", "/admin/webhooks/replay", url.Values{"csrf_token": {"ops-csrf"}, "id": {fmt.Sprint(d.ID)}})
+		if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/admin/webhooks?webhook=1" {
+			t.Fatalf("Expected a redirect to the log, got %d %q", w.Code, w.Header().Get("Location"))
+		}
+		if !deliver(2) {
+			t.Fatal("The replay should be delivered")
+		}
+		var replay models.WebhookDelivery
+		db.Last(&replay)
+		if replay.ID == d.ID || replay.Payload != d.Payload || replay.Status != models.DeliveryDelivered {
+			t.Errorf("Unexpected replay %+v", replay)
+		}
+		var audit models.AuditLog
+		db.Where("action = ?", "Replay").First(&audit)
+		if audit.RecordID != fmt.Sprint(d.ID) || audit.UserEmail != owner.Email {
+			t.Errorf("The replay should be audited, got %+v", audit)
+		}
+	})
+}
@@ -26,7 +26,7 @@ func RenderDashboard(reg *admin.Registry, w http.ResponseWriter, r *http.Request
 	tmpl := LoadTemplates("templates/dashboard.html")
 	pd := PageData{
 		SiteTitle: reg.Config.SiteTitle, GroupedResources: reg.GetGroupedResources(), GroupedPages: reg.GetGroupedPages(),
-		User: user, Stats: stats, CSS: template.CSS(styleContent), ChartData: widgets,
+		User: user, CSRFToken: user.CSRFToken, Stats: stats, CSS: template.CSS(styleContent), ChartData: widgets,
 		Flash: reg.GetFlash(w, r),
 	}
 	if err := tmpl.ExecuteTemplate(w, "dashboard.html", pd); err != nil {
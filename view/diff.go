@@ -0,0 +1,49 @@
+package view
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"strconv"
+
+	"github.com/go-packs/go-admin/models"
+)
+
+var diffTmpl = template.Must(template.New("diff").Parse(`<table class="diff-table">
+<thead><tr><th>Field</th><th>Before</th><th>After</th></tr></thead>
+<tbody>{{range .}}<tr><td>{{.Field}}</td><td class="diff-old">{{.Old}}</td><td class="diff-new">{{.New}}</td></tr>{{end}}</tbody>
+</table>`))
+
+// DiffTable renders the JSON diff stored in AuditLog.Diff as a before/after
+// table. Use it as the decorator of the Diff field:
+//
+//	adm.Register(admin.AuditLog{}).RegisterField("Diff", "Changes", true).SetDecorator("Diff", view.DiffTable)
+func DiffTable(val interface{}) template.HTML {
+	s, _ := val.(string)
+	changes := (&models.AuditLog{Diff: s}).Changeset()
+	if len(changes) == 0 {
+		return "-"
+	}
+	type row struct{ Field, Old, New string }
+	rows := make([]row, len(changes))
+	for i, c := range changes {
+		rows[i] = row{c.Field, diffValue(c.Old), diffValue(c.New)}
+	}
+	var buf bytes.Buffer
+	if err := diffTmpl.Execute(&buf, rows); err != nil {
+		return template.HTML(template.HTMLEscapeString(s))
+	}
+	return template.HTML(buf.String())
+}
+
+// diffValue formats a JSON-decoded diff value for display.
+func diffValue(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "—"
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	default:
+		return fmt.Sprint(x)
+	}
+}
@@ -19,6 +19,9 @@ func RenderCustomPage(reg *admin.Registry, w http.ResponseWriter, r *http.Reques
 		User: user, CSS: template.CSS(styleContent),
 		Flash: reg.GetFlash(w, r),
 	}
+	if user != nil {
+		pd.CSRFToken = user.CSRFToken
+	}
 	if err := tmpl.ExecuteTemplate(w, "layout", pd); err != nil {
 		http.Error(w, "Template error", 500)
 		return
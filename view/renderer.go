@@ -4,6 +4,7 @@ package view
 import (
 	"html/template"
 	"reflect"
+	"time"
 
 	"github.com/go-packs/go-admin"
 	"github.com/go-packs/go-admin/models"
@@ -21,7 +22,10 @@ type PageData struct {
 	Data               []map[string]interface{}
 	Item               map[string]interface{}
 	Filters            map[string]string
+	FilterFields       []resource.Field
 	User               *models.AdminUser
+	CSRFToken          string
+	CanEdit, CanDelete bool
 	Stats              []Stat
 	Error              string
 	Flash              string
@@ -38,6 +42,82 @@ type PageData struct {
 	SortField          string
 	SortOrder          string
 	RenderedSidebars   map[string]template.HTML
+	Errors             resource.ValidationErrors
+	Tokens             []models.APIToken
+	NewToken           string
+	TwoFactor          *TwoFactorData
+	Tab                string
+	History            []VersionData
+	CanRevert          bool
+	AuditChain         *AuditChainData
+	Import             *ImportData
+	ExportQuery        template.URL
+	Jobs               []models.Job
+	AllJobs            bool
+	Schedules          []ScheduleData
+	ScheduleName       string
+	Runs               []models.ScheduleRun
+	CanRun             bool
+	Webhooks           []models.Webhook
+	WebhookNames       map[uint]string
+	Deliveries         []models.WebhookDelivery
+	CanReplay          bool
+}
+
+// ScheduleData is one task on the schedules page.
+type ScheduleData struct {
+	Name, Spec string
+	Next       time.Time
+	Running    bool
+	// Last is the latest run and LastError the latest failed one, if any.
+	Last, LastError *models.ScheduleRun
+}
+
+// ImportData drives the CSV import form and its dry-run preview.
+type ImportData struct {
+	// CSV carries the uploaded file from the preview to the commit.
+	CSV                      string
+	Key                      string
+	Keys                     []string
+	Columns, Ignored         []string
+	Rows                     []ImportRow
+	Creates, Updates, Failed int
+}
+
+// ImportRow is the planned outcome of one CSV row.
+type ImportRow struct {
+	Line   int
+	Action string
+	ID     string
+	Values []string
+	Error  string
+}
+
+// AuditChainData drives the audit log integrity page.
+type AuditChainData struct {
+	OK                 bool
+	Checked, Unchained int
+	BrokenID           uint
+	Reason, Head       string
+}
+
+// VersionData is one audited version of a record on its History tab.
+type VersionData struct {
+	Log       models.AuditLog
+	Changes   template.HTML
+	CanRevert bool
+}
+
+// TwoFactorData drives the two-factor login step and settings page.
+type TwoFactorData struct {
+	// Mode is "verify", "enroll" or "codes" on the login step and "settings" on the settings page.
+	Mode              string
+	Secret, URI       string
+	RecoveryCodes     []string
+	Remaining         int
+	Enabled, Required bool
+	// QR is the provisioning URI as an SVG QR code.
+	QR template.HTML
 }
 
 // ChartWidget represents a chart's metadata and values.
@@ -48,11 +128,39 @@ type ChartWidget struct {
 }
 
 // AssociationData holds related resource items and options for form fields.
+// Multiple marks a ManyToMany association, whose form input picks Selected,
+// the linked records as maps with "ID" and "Text". Inline marks a HasMany
+// association edited on the parent form as Rows, with Blank as the pattern
+// of added rows. Single marks a HasOne or Polymorphic association, whose one
+// record, if any, is Item, labelled Text. A Polymorphic association is picked
+// on forms among its Targets, with the ID posted as IDField.
 type AssociationData struct {
 	Resource *resource.Resource
+	Label    string
 	Fields   []resource.Field
 	Items    []map[string]interface{}
 	Options  []map[string]interface{}
+	Multiple bool
+	Selected []map[string]interface{}
+	Inline   bool
+	Rows     []InlineRow
+	Blank    InlineRow
+	Single   bool
+	Item     map[string]interface{}
+	Text     interface{}
+	Targets  []string
+	IDField  string
+}
+
+// InlineRow is one child edited on its parent's form. Its inputs are named
+// Prefix followed by the field name, and Errors is keyed by field name.
+type InlineRow struct {
+	Prefix    string
+	Fields    []resource.Field
+	Values    map[string]interface{}
+	Errors    map[string]string
+	Destroy   bool
+	CanDelete bool
 }
 
 // Stat is a simple label/value stat displayed on the dashboard.
@@ -2,6 +2,7 @@ package view
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/go-packs/go-admin/resource"
@@ -43,4 +44,16 @@ func TestViewHelpers(t *testing.T) {
 			t.Errorf("SliceToMap failed")
 		}
 	})
+
+	t.Run("DiffTable", func(t *testing.T) {
+		html := string(DiffTable(`[{"field":"Name","old":"<b>A</b>","new":"B"},{"field":"Qty","old":null,"new":5}]`))
+		for _, want := range []string{"<td>Name</td>", "&lt;b&gt;A&lt;/b&gt;", "<td class=\"diff-old\">—</td>", "<td class=\"diff-new\">5</td>"} {
+			if !strings.Contains(html, want) {
+				t.Errorf("Expected %q in %s", want, html)
+			}
+		}
+		if DiffTable("") != "-" {
+			t.Error("Empty diff should render a dash")
+		}
+	})
 }